@@ -0,0 +1,399 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/trivago/gollum/core"
+)
+
+const (
+	adminTokenEnv        = "GOLLUM_ADMIN_TOKEN"
+	adminControlTimeout  = time.Second
+	adminTapDefaultCount = 10
+	adminTapMaxCount     = 1000
+	adminTapDefaultWait  = 10 * time.Second
+	adminTapMaxWait      = 5 * time.Minute
+)
+
+// adminService implements the admin HTTP API. It gives access to the plugins
+// managed by a coordinator.
+type adminService struct {
+	coordinator *Coordinator
+	token       string
+	server      *http.Server
+}
+
+// adminPluginInfo is the JSON representation of a plugin
+type adminPluginInfo struct {
+	ID        string   `json:"id"`
+	Kind      string   `json:"kind"`
+	Type      string   `json:"type"`
+	State     string   `json:"state,omitempty"`
+	Paused    bool     `json:"paused,omitempty"`
+	Saturated bool     `json:"saturated,omitempty"`
+	Streams   []string `json:"streams,omitempty"`
+	Queued    *int     `json:"queued,omitempty"`
+}
+
+// adminTapMessage is the JSON representation of a message read from a tap
+type adminTapMessage struct {
+	Stream   string            `json:"stream"`
+	Created  time.Time         `json:"created"`
+	Payload  string            `json:"payload"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type adminPluginWithID interface {
+	GetID() string
+}
+
+type adminPluginWithQueue interface {
+	GetNumQueued() int
+}
+
+type adminPluginWithPause interface {
+	IsPaused() bool
+}
+
+type adminPluginWithStreams interface {
+	Streams() []core.MessageStreamID
+}
+
+// startAdminService starts the admin API if requested.
+// The returned function should be deferred if not nil.
+func startAdminService(coordinator *Coordinator) func() {
+	if *flagAdminAddress == "" {
+		return nil
+	}
+	address, err := parseAddress(*flagAdminAddress)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to start admin service")
+		return nil
+	}
+
+	token := *flagAdminToken
+	if token == "" {
+		token = os.Getenv(adminTokenEnv)
+	}
+	if token == "" {
+		logrus.Warning("Admin service is running without authentication. Set -admin-token or " + adminTokenEnv + " to require a token.")
+	}
+
+	admin := &adminService{
+		coordinator: coordinator,
+		token:       token,
+	}
+	admin.server = &http.Server{
+		Addr:    address,
+		Handler: http.HandlerFunc(admin.serveHTTP),
+	}
+
+	logrus.WithField("address", address).Info("Starting admin service")
+	go func() {
+		if err := admin.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logrus.WithError(err).Error("Admin service failed")
+		}
+	}()
+
+	return func() { admin.server.Close() }
+}
+
+// serveHTTP dispatches requests to the following endpoints:
+//  GET  /plugins                  list all plugins
+//  GET  /plugins/<id>             show a single plugin
+//  POST /plugins/<id>/pause       pause a consumer
+//  POST /plugins/<id>/resume      resume a paused consumer
+//  POST /plugins/<id>/roll        send a roll command to a consumer or producer
+//  POST /plugins/<id>/drain       flush and stop a producer
+//  GET  /streams/<name>/tap       stream copies of messages as JSON lines
+func (admin *adminService) serveHTTP(resp http.ResponseWriter, req *http.Request) {
+	if !admin.isAuthorized(req) {
+		resp.Header().Set("WWW-Authenticate", "Bearer")
+		admin.writeError(resp, http.StatusUnauthorized, "Missing or invalid token")
+		return // ### return, not authorized ###
+	}
+
+	path := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case len(path) == 1 && path[0] == "plugins":
+		if admin.checkMethod(resp, req, http.MethodGet) {
+			admin.writeJSON(resp, http.StatusOK, admin.getPlugins())
+		}
+
+	case len(path) == 2 && path[0] == "plugins":
+		if admin.checkMethod(resp, req, http.MethodGet) {
+			if plugin := admin.getPlugin(path[1]); plugin != nil {
+				admin.writeJSON(resp, http.StatusOK, admin.getPluginInfo(plugin))
+			} else {
+				admin.writeError(resp, http.StatusNotFound, fmt.Sprintf("Plugin '%s' not found", path[1]))
+			}
+		}
+
+	case len(path) == 3 && path[0] == "plugins":
+		if admin.checkMethod(resp, req, http.MethodPost) {
+			admin.controlPlugin(resp, path[1], path[2])
+		}
+
+	case len(path) == 3 && path[0] == "streams" && path[2] == "tap":
+		if admin.checkMethod(resp, req, http.MethodGet) {
+			admin.tapStream(resp, req, path[1])
+		}
+
+	default:
+		admin.writeError(resp, http.StatusNotFound, "Unknown endpoint")
+	}
+}
+
+func (admin *adminService) isAuthorized(req *http.Request) bool {
+	if admin.token == "" {
+		return true
+	}
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(admin.token)) == 1
+}
+
+func (admin *adminService) checkMethod(resp http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method != method {
+		resp.Header().Set("Allow", method)
+		admin.writeError(resp, http.StatusMethodNotAllowed, "Use "+method)
+		return false
+	}
+	return true
+}
+
+// getPlugins returns all routers, producers and consumers with an ID
+func (admin *adminService) getPlugins() []adminPluginInfo {
+	plugins := []adminPluginInfo{}
+	for _, plugin := range admin.getAllPlugins() {
+		plugins = append(plugins, admin.getPluginInfo(plugin))
+	}
+	return plugins
+}
+
+func (admin *adminService) getAllPlugins() []interface{} {
+	plugins := []interface{}{}
+	for _, router := range admin.coordinator.routers {
+		plugins = append(plugins, router)
+	}
+	for _, producer := range admin.coordinator.producers {
+		plugins = append(plugins, producer)
+	}
+	for _, consumer := range admin.coordinator.consumers {
+		if _, hasID := consumer.(adminPluginWithID); hasID {
+			plugins = append(plugins, consumer) // skips the internal log consumer
+		}
+	}
+	return plugins
+}
+
+func (admin *adminService) getPlugin(pluginID string) interface{} {
+	for _, plugin := range admin.getAllPlugins() {
+		if withID, hasID := plugin.(adminPluginWithID); hasID && withID.GetID() == pluginID {
+			return plugin
+		}
+	}
+	return nil
+}
+
+func (admin *adminService) getPluginInfo(plugin interface{}) adminPluginInfo {
+	info := adminPluginInfo{
+		Type: strings.TrimPrefix(reflect.TypeOf(plugin).String(), "*"),
+	}
+	if withID, hasID := plugin.(adminPluginWithID); hasID {
+		info.ID = withID.GetID()
+	}
+
+	switch typedPlugin := plugin.(type) {
+	case core.Router:
+		info.Kind = "router"
+		info.Streams = []string{core.StreamRegistry.GetStreamName(typedPlugin.GetStreamID())}
+	case core.Producer:
+		info.Kind = "producer"
+	case core.Consumer:
+		info.Kind = "consumer"
+	}
+
+	if withState, hasState := plugin.(core.PluginWithState); hasState {
+		info.State = withState.GetState().String()
+	}
+	if withPause, hasPause := plugin.(adminPluginWithPause); hasPause {
+		info.Paused = withPause.IsPaused()
+	}
+	if source, isSource := plugin.(core.BackpressureSource); isSource {
+		info.Saturated = source.IsSaturated()
+	}
+	if withQueue, hasQueue := plugin.(adminPluginWithQueue); hasQueue {
+		queued := withQueue.GetNumQueued()
+		info.Queued = &queued
+	}
+	if withStreams, hasStreams := plugin.(adminPluginWithStreams); hasStreams {
+		for _, streamID := range withStreams.Streams() {
+			info.Streams = append(info.Streams, core.StreamRegistry.GetStreamName(streamID))
+		}
+	}
+	return info
+}
+
+// controlPlugin sends a command to the control channel of a plugin
+func (admin *adminService) controlPlugin(resp http.ResponseWriter, pluginID string, action string) {
+	plugin := admin.getPlugin(pluginID)
+	if plugin == nil {
+		admin.writeError(resp, http.StatusNotFound, fmt.Sprintf("Plugin '%s' not found", pluginID))
+		return // ### return, unknown plugin ###
+	}
+
+	var control chan<- core.PluginControl
+	var command core.PluginControl
+
+	switch typedPlugin := plugin.(type) {
+	case core.Consumer:
+		control = typedPlugin.Control()
+		switch action {
+		case "pause":
+			command = core.PluginControlPauseConsumer
+		case "resume":
+			command = core.PluginControlResumeConsumer
+		case "roll":
+			command = core.PluginControlRoll
+		default:
+			admin.writeError(resp, http.StatusBadRequest, fmt.Sprintf("Consumers do not support '%s'", action))
+			return // ### return, unknown action ###
+		}
+
+	case core.Producer:
+		control = typedPlugin.Control()
+		switch action {
+		case "roll":
+			command = core.PluginControlRoll
+		case "drain":
+			command = core.PluginControlStopProducer
+		default:
+			admin.writeError(resp, http.StatusBadRequest, fmt.Sprintf("Producers do not support '%s'", action))
+			return // ### return, unknown action ###
+		}
+
+	default:
+		admin.writeError(resp, http.StatusBadRequest, "Routers cannot be controlled")
+		return // ### return, not controllable ###
+	}
+
+	// The control loop of stopped plugins does not read the control channel
+	// anymore. Sending to it might block the shutdown.
+	if plugin.(core.PluginWithState).GetState() >= core.PluginStatePrepareStop {
+		admin.writeError(resp, http.StatusConflict, fmt.Sprintf("Plugin '%s' is stopping or stopped", pluginID))
+		return // ### return, stopped ###
+	}
+
+	select {
+	case control <- command:
+		logrus.Infof("Admin service sent %s to '%s'", action, pluginID)
+		admin.writeJSON(resp, http.StatusAccepted, admin.getPluginInfo(plugin))
+	case <-time.After(adminControlTimeout):
+		admin.writeError(resp, http.StatusServiceUnavailable, fmt.Sprintf("Plugin '%s' did not accept the command", pluginID))
+	}
+}
+
+// tapStream writes copies of the messages routed to a stream as JSON lines.
+// The request ends after "count" messages (default 10), after "timeout"
+// (default 10s, e.g. "30s") or when the client disconnects.
+func (admin *adminService) tapStream(resp http.ResponseWriter, req *http.Request, streamName string) {
+	streamID := core.StreamRegistry.GetStreamID(streamName)
+	if !core.StreamRegistry.IsStreamRegistered(streamID) {
+		admin.writeError(resp, http.StatusNotFound, fmt.Sprintf("Stream '%s' not found", streamName))
+		return // ### return, unknown stream ###
+	}
+
+	count := adminTapDefaultCount
+	if value := req.URL.Query().Get("count"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > adminTapMaxCount {
+			admin.writeError(resp, http.StatusBadRequest, fmt.Sprintf("count must be between 1 and %d", adminTapMaxCount))
+			return // ### return, invalid count ###
+		}
+		count = parsed
+	}
+
+	timeout := adminTapDefaultWait
+	if value := req.URL.Query().Get("timeout"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 || parsed > adminTapMaxWait {
+			admin.writeError(resp, http.StatusBadRequest, fmt.Sprintf("timeout must be a duration between 0 and %s", adminTapMaxWait))
+			return // ### return, invalid timeout ###
+		}
+		timeout = parsed
+	}
+
+	tap := core.TapStream(streamID, count)
+	defer tap.Close()
+
+	resp.Header().Set("Content-Type", "application/x-ndjson")
+	resp.WriteHeader(http.StatusOK)
+	flusher, canFlush := resp.(http.Flusher)
+	encoder := json.NewEncoder(resp)
+	deadline := time.After(timeout)
+
+	for written := 0; written < count; written++ {
+		select {
+		case msg := <-tap.Messages():
+			sample := adminTapMessage{
+				Stream:   streamName,
+				Created:  msg.GetCreationTime(),
+				Payload:  msg.String(),
+				Metadata: make(map[string]string),
+			}
+			for key, value := range msg.GetMetadata() {
+				sample.Metadata[key] = string(value)
+			}
+			if err := encoder.Encode(sample); err != nil {
+				return // ### return, client gone ###
+			}
+			if canFlush {
+				flusher.Flush()
+			}
+
+		case <-deadline:
+			return // ### return, timeout ###
+
+		case <-req.Context().Done():
+			return // ### return, client gone ###
+		}
+	}
+}
+
+func (admin *adminService) writeJSON(resp http.ResponseWriter, status int, value interface{}) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	if err := json.NewEncoder(resp).Encode(value); err != nil {
+		logrus.WithError(err).Warning("Failed to write admin response")
+	}
+}
+
+func (admin *adminService) writeError(resp http.ResponseWriter, status int, message string) {
+	admin.writeJSON(resp, status, map[string]string{"error": message})
+}
@@ -0,0 +1,220 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func newTestAdminPlugin(t *testing.T, pluginID string, typename string, settings map[string]interface{}) core.Plugin {
+	expect := ttesting.NewExpect(t)
+	config := core.NewPluginConfig(pluginID, typename)
+	for key, value := range settings {
+		config.Override(key, value)
+	}
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	return plugin
+}
+
+func newTestAdminService(token string) (*adminService, *Coordinator) {
+	coordinator := NewCoordinator()
+	return &adminService{coordinator: &coordinator, token: token}, &coordinator
+}
+
+func (admin *adminService) serveTestRequest(method string, url string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp := httptest.NewRecorder()
+	admin.serveHTTP(resp, req)
+	return resp
+}
+
+func waitForTestCondition(condition func() bool) bool {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	admin, _ := newTestAdminService("secret")
+
+	resp := admin.serveTestRequest(http.MethodGet, "/plugins", "")
+	expect.Equal(http.StatusUnauthorized, resp.Code)
+	expect.Equal("Bearer", resp.Header().Get("WWW-Authenticate"))
+
+	resp = admin.serveTestRequest(http.MethodGet, "/plugins", "wrong")
+	expect.Equal(http.StatusUnauthorized, resp.Code)
+
+	resp = admin.serveTestRequest(http.MethodGet, "/plugins", "secretsecret")
+	expect.Equal(http.StatusUnauthorized, resp.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/plugins", nil)
+	req.Header.Set("Authorization", "Basic secret")
+	expect.False(admin.isAuthorized(req))
+
+	resp = admin.serveTestRequest(http.MethodGet, "/plugins", "secret")
+	expect.Equal(http.StatusOK, resp.Code)
+	expect.Equal("[]\n", resp.Body.String())
+
+	// Without a token every request is accepted
+	admin.token = ""
+	resp = admin.serveTestRequest(http.MethodGet, "/plugins", "")
+	expect.Equal(http.StatusOK, resp.Code)
+}
+
+func TestAdminPauseResume(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	admin, coordinator := newTestAdminService("")
+
+	consumer := newTestAdminPlugin(t, "AdminPause", "consumer.Profiler", map[string]interface{}{
+		"Streams": "adminPause",
+		"DelayMs": 10,
+	}).(core.Consumer)
+	coordinator.consumers = append(coordinator.consumers, consumer)
+
+	go consumer.Consume(coordinator.consumerWorker)
+	defer func() {
+		consumer.Control() <- core.PluginControlStopConsumer
+		expect.True(tgo.ReturnAfter(5*time.Second, coordinator.consumerWorker.Wait))
+	}()
+	expect.True(waitForTestCondition(func() bool { return consumer.GetState() == core.PluginStateActive }))
+
+	paused := consumer.(adminPluginWithPause)
+
+	resp := admin.serveTestRequest(http.MethodPost, "/plugins/AdminPause/pause", "")
+	expect.Equal(http.StatusAccepted, resp.Code)
+	expect.True(waitForTestCondition(paused.IsPaused))
+
+	info := adminPluginInfo{}
+	resp = admin.serveTestRequest(http.MethodGet, "/plugins/AdminPause", "")
+	expect.Equal(http.StatusOK, resp.Code)
+	expect.NoError(json.Unmarshal(resp.Body.Bytes(), &info))
+	expect.Equal("consumer", info.Kind)
+	expect.True(info.Paused)
+
+	resp = admin.serveTestRequest(http.MethodPost, "/plugins/AdminPause/resume", "")
+	expect.Equal(http.StatusAccepted, resp.Code)
+	expect.True(waitForTestCondition(func() bool { return !paused.IsPaused() }))
+
+	resp = admin.serveTestRequest(http.MethodPost, "/plugins/AdminPause/drain", "")
+	expect.Equal(http.StatusBadRequest, resp.Code)
+
+	resp = admin.serveTestRequest(http.MethodGet, "/plugins/AdminPause/pause", "")
+	expect.Equal(http.StatusMethodNotAllowed, resp.Code)
+
+	resp = admin.serveTestRequest(http.MethodPost, "/plugins/Unknown/pause", "")
+	expect.Equal(http.StatusNotFound, resp.Code)
+}
+
+func TestAdminDrainShutdown(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	admin, coordinator := newTestAdminService("")
+
+	producer := newTestAdminPlugin(t, "AdminDrain", "producer.Null", map[string]interface{}{
+		"Streams": "adminDrain",
+	}).(core.Producer)
+	coordinator.producers = append(coordinator.producers, producer)
+
+	go producer.Produce(coordinator.producerWorker)
+	expect.True(waitForTestCondition(func() bool { return producer.GetState() == core.PluginStateActive }))
+
+	resp := admin.serveTestRequest(http.MethodPost, "/plugins/AdminDrain/drain", "")
+	expect.Equal(http.StatusAccepted, resp.Code)
+	expect.True(waitForTestCondition(func() bool { return producer.GetState() == core.PluginStateDead }))
+
+	resp = admin.serveTestRequest(http.MethodPost, "/plugins/AdminDrain/roll", "")
+	expect.Equal(http.StatusConflict, resp.Code)
+
+	// Roll and shutdown must not block on the drained producer
+	expect.True(tgo.ReturnAfter(5*time.Second, func() {
+		coordinator.rollPlugins()
+		coordinator.rollPlugins()
+		coordinator.shutdownProducers(coordinatorStateStartProducers)
+	}))
+}
+
+func TestAdminTap(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	admin, coordinator := newTestAdminService("")
+
+	consumer := newTestAdminPlugin(t, "AdminTap", "consumer.Profiler", map[string]interface{}{
+		"Streams": "adminTap",
+		"DelayMs": 1,
+	}).(core.Consumer)
+	coordinator.consumers = append(coordinator.consumers, consumer)
+	core.StreamRegistry.GetRouterOrFallback(core.StreamRegistry.GetStreamID("adminIdle"))
+
+	resp := admin.serveTestRequest(http.MethodGet, "/streams/adminUnknown/tap", "")
+	expect.Equal(http.StatusNotFound, resp.Code)
+
+	for _, query := range []string{"count=0", "count=1001", "count=x", "timeout=0s", "timeout=6m", "timeout=x"} {
+		resp = admin.serveTestRequest(http.MethodGet, "/streams/adminIdle/tap?"+query, "")
+		expect.Equal(http.StatusBadRequest, resp.Code)
+	}
+
+	// Timeout on a stream without messages
+	start := time.Now()
+	resp = admin.serveTestRequest(http.MethodGet, "/streams/adminIdle/tap?timeout=50ms", "")
+	expect.Equal(http.StatusOK, resp.Code)
+	expect.Equal("", resp.Body.String())
+	expect.Less(int64(time.Since(start)), int64(5*time.Second))
+
+	// Canceled requests end the tap before the timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/streams/adminIdle/tap?timeout=5m", nil).WithContext(ctx)
+	start = time.Now()
+	admin.serveHTTP(httptest.NewRecorder(), req)
+	expect.Less(int64(time.Since(start)), int64(5*time.Second))
+
+	// Count limits the number of messages
+	go consumer.Consume(coordinator.consumerWorker)
+	defer func() {
+		consumer.Control() <- core.PluginControlStopConsumer
+		expect.True(tgo.ReturnAfter(5*time.Second, coordinator.consumerWorker.Wait))
+	}()
+
+	resp = admin.serveTestRequest(http.MethodGet, "/streams/adminTap/tap?count=3&timeout=5s", "")
+	expect.Equal(http.StatusOK, resp.Code)
+	expect.Equal("application/x-ndjson", resp.Header().Get("Content-Type"))
+
+	lines := 0
+	scanner := bufio.NewScanner(strings.NewReader(resp.Body.String()))
+	for scanner.Scan() {
+		sample := adminTapMessage{}
+		expect.NoError(json.Unmarshal(scanner.Bytes(), &sample))
+		expect.Equal("adminTap", sample.Stream)
+		lines++
+	}
+	expect.Equal(3, lines)
+}
@@ -209,6 +209,8 @@ func (cons *AwsKinesis) processShard(shardID string) {
 	recordConfig := (*kinesis.GetRecordsInput)(nil)
 
 	for cons.running {
+		cons.WaitWhileSaturated()
+
 		if recordConfig == nil {
 			recordConfig = cons.createShardIteratorConfig(shardID)
 		}
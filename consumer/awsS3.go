@@ -0,0 +1,626 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/gollum/core/components"
+	"github.com/trivago/tgo/tio"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	s3BufferGrowSize      = 1024
+	s3CompressionAuto     = "auto"
+	s3CompressionGzip     = "gzip"
+	s3CompressionNone     = "none"
+	s3NotificationWaitSec = 10
+	s3OffsetStoreInterval = 10 * time.Second
+)
+
+// AwsS3 consumer plugin
+//
+// This consumer reads objects from Amazon S3 or S3 compatible services and
+// creates messages from their content. New objects are either detected by
+// listing a bucket prefix periodically or by receiving S3 event notifications
+// from an SQS queue. Objects are split into messages using the same
+// partitioner settings as consumer.Socket, gzip compressed objects are
+// decompressed automatically.
+//
+// Progress is stored in an offset file. When listing a prefix, all keys
+// processed are stored together with their ETag so that objects are read
+// again only if they changed. Keys that are no longer listed are removed from
+// the offset file. If the consumer is stopped while reading an object, reading
+// continues after the last message enqueued on restart unless the object has
+// been changed in the meantime. The offset file is written at most every 10
+// seconds, after each listing and when the consumer stops.
+//
+// Metadata
+//
+// - bucket: The bucket the message was read from (set)
+//
+// - key: The key of the object the message was read from (set)
+//
+// Parameters
+//
+// - Bucket: This value defines the bucket to read from.
+// By default this parameter is set to "".
+//
+// - Prefix: This value defines the key prefix of the objects to read.
+// By default this parameter is set to "".
+//
+// - PathStyle: When set to true, the bucket name is sent as part of the path
+// instead of the host name. This is required by most S3 compatible services
+// like MinIO.
+// By default this parameter is set to false.
+//
+// - SqsQueue: This value defines the URL of an SQS queue receiving S3 event
+// notifications, optionally wrapped in SNS notifications. If set, the bucket
+// is not listed and only objects from notifications matching Prefix are
+// read. Notifications are deleted from the queue after all objects have been
+// read. Only the object currently being read is stored in the offset file.
+// By default this parameter is set to "".
+//
+// - ListIntervalSec: This value defines the number of seconds to wait between
+// two listings of the bucket prefix.
+// By default this parameter is set to "60".
+//
+// - Compression: This value defines how objects are decompressed. Set to
+// "auto" to decompress gzip data if detected, "gzip" to always decompress or
+// "none" to never decompress objects.
+// By default this parameter is set to "auto".
+//
+// - OffsetFile: This value defines the path to a file storing the progress of
+// this consumer. You can set this parameter to "" for disabling. In this case
+// all objects found by the first listing are read.
+// By default this parameter is set to "".
+//
+// - Partitioner: This value defines the algorithm used to split objects into
+// messages. See consumer.Socket for a list of options.
+// By default this is set to "delimiter".
+//
+// - Delimiter: This value defines the delimiter used by the text and delimiter
+// partitioner. The last message of an object does not require a delimiter.
+// By default this parameter is set to "\n".
+//
+// - Offset: This value defines the offset used by the binary and text
+// partitioner. This setting is ignored by the fixed partitioner.
+// By default this parameter is set to "0".
+//
+// - Size: This value defines the size in bytes used by the binary or fixed
+// partitioner. For binary this can be set to 1,2,4 or 8. For fixed this
+// defines the size of a message.
+// By default this parameter is set to "4" for binary and "1" for fixed.
+//
+// Examples
+//
+// This example replays all log files written by a producer.AwsS3 to the
+// bucket "gollum-archive" below the prefix "logs/":
+//
+//  S3In:
+//    Type: consumer.AwsS3
+//    Streams: replay
+//    Credential:
+//      Type: shared
+//      File: /Users/<USERNAME>/.aws/credentials
+//      Profile: default
+//    Region: eu-west-1
+//    Bucket: gollum-archive
+//    Prefix: logs/
+//    OffsetFile: /var/lib/gollum/s3-replay.offset
+//
+// This example reads new objects announced by S3 event notifications:
+//
+//  S3Events:
+//    Type: consumer.AwsS3
+//    Streams: events
+//    Region: eu-west-1
+//    Bucket: gollum-archive
+//    SqsQueue: https://sqs.eu-west-1.amazonaws.com/123456789012/gollum-archive-events
+//
+type AwsS3 struct {
+	core.SimpleConsumer `gollumdoc:"embed_type"`
+
+	// AwsMultiClient is public to make AwsMultiClient.Configure() callable
+	AwsMultiClient components.AwsMultiClient `gollumdoc:"embed_type"`
+
+	bucket       string        `config:"Bucket"`
+	prefix       string        `config:"Prefix"`
+	pathStyle    bool          `config:"PathStyle" default:"false"`
+	queueURL     string        `config:"SqsQueue"`
+	listInterval time.Duration `config:"ListIntervalSec" default:"60" metric:"sec"`
+	compression  string        `config:"Compression" default:"auto"`
+	offsetFile   string        `config:"OffsetFile"`
+	delimiter    string        `config:"Delimiter" default:"\n"`
+	offset       int           `config:"Offset" default:"0"`
+
+	flags        tio.BufferedReaderFlags
+	s3Client     *s3.S3
+	sqsClient    *sqs.SQS
+	offsets      awsS3Offsets
+	offsetsGuard *sync.Mutex
+	lastStore    time.Time
+}
+
+// awsS3Offsets is stored in the offset file
+type awsS3Offsets struct {
+	Processed map[string]string `json:"processed"`
+	Current   string            `json:"current,omitempty"`
+	ETag      string            `json:"eTag,omitempty"`
+	Messages  int64             `json:"messages,omitempty"`
+}
+
+// awsS3Notification is the relevant part of an S3 event notification
+type awsS3Notification struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key  string `json:"key"`
+				ETag string `json:"eTag"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+
+	// Set if the notification is wrapped by SNS
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+}
+
+func init() {
+	core.TypeRegistry.Register(AwsS3{})
+}
+
+// Configure initializes this consumer with values from a plugin config.
+func (cons *AwsS3) Configure(conf core.PluginConfigReader) {
+	cons.SetStopCallback(cons.close)
+	cons.offsetsGuard = new(sync.Mutex)
+	cons.offsets = awsS3Offsets{
+		Processed: make(map[string]string),
+	}
+
+	switch cons.compression {
+	case s3CompressionAuto, s3CompressionGzip, s3CompressionNone:
+	default:
+		conf.Errors.Pushf("Unknown compression '%s'", cons.compression)
+	}
+
+	partitioner := strings.ToLower(conf.GetString("Partitioner", "delimiter"))
+	switch partitioner {
+	case "binary_be":
+		cons.flags |= tio.BufferedReaderFlagBigEndian
+		fallthrough
+
+	case "binary", "binary_le":
+		cons.flags |= tio.BufferedReaderFlagEverything
+		switch conf.GetInt("Size", 4) {
+		case 1:
+			cons.flags |= tio.BufferedReaderFlagMLE8
+		case 2:
+			cons.flags |= tio.BufferedReaderFlagMLE16
+		case 4:
+			cons.flags |= tio.BufferedReaderFlagMLE32
+		case 8:
+			cons.flags |= tio.BufferedReaderFlagMLE64
+		default:
+			conf.Errors.Pushf("Size only supports the value 1,2,4 and 8")
+		}
+
+	case "fixed":
+		cons.flags |= tio.BufferedReaderFlagMLEFixed
+		cons.offset = int(conf.GetInt("Size", 1))
+
+	case "ascii":
+		cons.flags |= tio.BufferedReaderFlagMLE
+
+	case "delimiter":
+		// Nothing to add
+
+	default:
+		conf.Errors.Pushf("Unknown partitioner: %s", partitioner)
+	}
+
+	if cons.offsetFile != "" {
+		fileContents, err := ioutil.ReadFile(cons.offsetFile)
+		switch {
+		case err != nil:
+			cons.Logger.Warningf("Failed to open s3 offset file: %s", err.Error())
+		case len(fileContents) > 0:
+			conf.Errors.Push(json.Unmarshal(fileContents, &cons.offsets))
+			if cons.offsets.Processed == nil {
+				cons.offsets.Processed = make(map[string]string)
+			}
+		}
+	}
+}
+
+func (cons *AwsS3) initClients() {
+	sess, err := cons.AwsMultiClient.NewSessionWithOptions()
+	if err != nil {
+		cons.Logger.WithError(err).Error("Can't get proper aws config")
+	}
+
+	awsConfig := cons.AwsMultiClient.GetConfig()
+	s3Config := awsConfig.Copy()
+
+	// set auto endpoint to s3 if setting is empty
+	if s3Config.Endpoint == nil || *s3Config.Endpoint == "" {
+		if *s3Config.Region != components.DefaultAwsRegion {
+			s3Config.WithEndpoint(fmt.Sprintf("s3-%s.amazonaws.com", *s3Config.Region))
+		} else {
+			s3Config.WithEndpoint("s3.amazonaws.com")
+		}
+	}
+
+	if cons.pathStyle {
+		s3Config.WithS3ForcePathStyle(true)
+	}
+	cons.s3Client = s3.New(sess, s3Config)
+
+	if cons.queueURL != "" {
+		// Endpoint is used for S3 only
+		cons.sqsClient = sqs.New(sess, awsConfig.Copy().WithEndpoint(""))
+	}
+}
+
+func (cons *AwsS3) storeOffsets() {
+	if cons.offsetFile == "" {
+		return
+	}
+
+	cons.offsetsGuard.Lock()
+	fileContents, err := json.Marshal(cons.offsets)
+	cons.lastStore = time.Now()
+	cons.offsetsGuard.Unlock()
+
+	if err != nil {
+		cons.Logger.Errorf("Failed to marshal s3 offsets: %s", err.Error())
+		return
+	}
+
+	if err := ioutil.WriteFile(cons.offsetFile, fileContents, 0644); err != nil {
+		cons.Logger.Errorf("Failed to write s3 offsets: %s", err.Error())
+	}
+}
+
+// storeOffsetsIfDue writes the offset file if it has not been written for
+// s3OffsetStoreInterval.
+func (cons *AwsS3) storeOffsetsIfDue() {
+	cons.offsetsGuard.Lock()
+	isDue := time.Since(cons.lastStore) >= s3OffsetStoreInterval
+	cons.offsetsGuard.Unlock()
+
+	if isDue {
+		cons.storeOffsets()
+	}
+}
+
+// startObject returns false if the given object has already been processed.
+// Otherwise the object is marked as current object and the number of messages
+// already read from it is returned.
+func (cons *AwsS3) startObject(path, etag string) (bool, int64) {
+	cons.offsetsGuard.Lock()
+	defer cons.offsetsGuard.Unlock()
+
+	if processedTag, processed := cons.offsets.Processed[path]; processed && processedTag == etag {
+		return false, 0 // ### return, nothing to do ###
+	}
+
+	if cons.offsets.Current != path || cons.offsets.ETag != etag {
+		cons.offsets.Current = path
+		cons.offsets.ETag = etag
+		cons.offsets.Messages = 0
+	}
+	return true, cons.offsets.Messages
+}
+
+func (cons *AwsS3) setProgress(messages int64) {
+	cons.offsetsGuard.Lock()
+	cons.offsets.Messages = messages
+	cons.offsetsGuard.Unlock()
+}
+
+func (cons *AwsS3) finishObject(path, etag string) {
+	cons.offsetsGuard.Lock()
+	if cons.sqsClient == nil {
+		cons.offsets.Processed[path] = etag
+	}
+	cons.offsets.Current = ""
+	cons.offsets.ETag = ""
+	cons.offsets.Messages = 0
+	cons.offsetsGuard.Unlock()
+}
+
+// pruneOffsets removes all processed keys that have not been listed
+func (cons *AwsS3) pruneOffsets(listed map[string]bool) {
+	cons.offsetsGuard.Lock()
+	defer cons.offsetsGuard.Unlock()
+
+	for path := range cons.offsets.Processed {
+		if !listed[path] {
+			delete(cons.offsets.Processed, path)
+		}
+	}
+}
+
+// readObject reads an object and enqueues its messages
+func (cons *AwsS3) readObject(bucket, key, etag string) error {
+	path := bucket + "/" + key
+	doRead, skip := cons.startObject(path, etag)
+	if !doRead {
+		return nil // ### return, already processed ###
+	}
+
+	object, err := cons.s3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer object.Body.Close()
+
+	reader, err := cons.newObjectReader(object.Body)
+	if err != nil {
+		return err
+	}
+
+	cons.Logger.Debugf("Reading s3://%s (%d messages already read)", path, skip)
+	buffer := tio.NewBufferedReader(s3BufferGrowSize, cons.flags, cons.offset, cons.delimiter)
+	count := int64(0)
+
+	err = buffer.ReadAll(reader, func(data []byte) {
+		if count++; count <= skip {
+			return // ### return, already read ###
+		}
+
+		metaData := core.Metadata{}
+		metaData.SetValue("bucket", []byte(bucket))
+		metaData.SetValue("key", []byte(key))
+		cons.EnqueueWithMetadata(data, metaData)
+		cons.setProgress(count)
+	})
+
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	cons.finishObject(path, etag)
+	return nil
+}
+
+// newObjectReader wraps the object body to decompress data, stop reading
+// when the consumer is stopped and terminate the last message.
+func (cons *AwsS3) newObjectReader(body io.Reader) (io.Reader, error) {
+	reader := bufio.NewReader(body)
+	useGzip := cons.compression == s3CompressionGzip
+
+	if cons.compression == s3CompressionAuto {
+		magic, _ := reader.Peek(2)
+		useGzip = len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b
+	}
+
+	var content io.Reader = reader
+	if useGzip {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		content = gzipReader
+	}
+
+	objectReader := &s3ObjectReader{
+		reader:   content,
+		isActive: cons.IsActive,
+	}
+
+	if cons.flags&tio.BufferedReaderFlagMaskMLE == 0 {
+		objectReader.delimiter = []byte(cons.delimiter)
+	}
+	return objectReader, nil
+}
+
+func (cons *AwsS3) listObjects() {
+	cons.AddWorker()
+	defer cons.WorkerDone()
+	defer cons.storeOffsets()
+
+	listed := make(map[string]bool)
+	input := &s3.ListObjectsInput{
+		Bucket: aws.String(cons.bucket),
+		Prefix: aws.String(cons.prefix),
+	}
+
+	completed := false
+	err := cons.s3Client.ListObjectsPages(input, func(page *s3.ListObjectsOutput, lastPage bool) bool {
+		for _, object := range page.Contents {
+			if !cons.IsActive() {
+				return false // ### return, stopped ###
+			}
+
+			key := aws.StringValue(object.Key)
+			listed[cons.bucket+"/"+key] = true
+			if strings.HasSuffix(key, "/") {
+				continue // ### continue, folder ###
+			}
+
+			if err := cons.readObject(cons.bucket, key, aws.StringValue(object.ETag)); err != nil && cons.IsActive() {
+				cons.Logger.WithError(err).Errorf("Failed to read s3://%s/%s", cons.bucket, key)
+			}
+			cons.storeOffsetsIfDue()
+		}
+
+		completed = lastPage
+		return true
+	})
+
+	if err != nil {
+		cons.Logger.WithError(err).Errorf("Failed to list s3://%s/%s", cons.bucket, cons.prefix)
+		return // ### return, incomplete list ###
+	}
+
+	if completed {
+		cons.pruneOffsets(listed)
+	}
+}
+
+func (cons *AwsS3) receiveNotifications() {
+	cons.AddWorker()
+	defer cons.WorkerDone()
+
+	result, err := cons.sqsClient.ReceiveMessage(&sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(cons.queueURL),
+		MaxNumberOfMessages: aws.Int64(10),
+		WaitTimeSeconds:     aws.Int64(s3NotificationWaitSec),
+	})
+	if err != nil {
+		cons.Logger.WithError(err).Error("Failed to receive s3 notifications")
+		time.Sleep(time.Second)
+		return // ### return, retry ###
+	}
+
+	for _, message := range result.Messages {
+		if !cons.IsActive() {
+			return // ### return, stopped ###
+		}
+
+		if cons.processNotification([]byte(aws.StringValue(message.Body))) {
+			_, err := cons.sqsClient.DeleteMessage(&sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(cons.queueURL),
+				ReceiptHandle: message.ReceiptHandle,
+			})
+			if err != nil {
+				cons.Logger.WithError(err).Error("Failed to delete s3 notification")
+			}
+		}
+		cons.storeOffsetsIfDue()
+	}
+}
+
+// processNotification reads all objects referenced by an S3 event
+// notification. Returns true if the notification can be deleted.
+func (cons *AwsS3) processNotification(body []byte) bool {
+	notification := awsS3Notification{}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		cons.Logger.WithError(err).Warning("Discarding invalid s3 notification")
+		return true // ### return, cannot be processed ###
+	}
+
+	if notification.Type == "Notification" && notification.Message != "" {
+		return cons.processNotification([]byte(notification.Message))
+	}
+
+	for _, record := range notification.Records {
+		if !strings.HasPrefix(record.EventName, "ObjectCreated:") {
+			continue // ### continue, not relevant ###
+		}
+
+		bucket := record.S3.Bucket.Name
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			key = record.S3.Object.Key
+		}
+
+		if (cons.bucket != "" && bucket != cons.bucket) || !strings.HasPrefix(key, cons.prefix) {
+			continue // ### continue, filtered ###
+		}
+
+		if err := cons.readObject(bucket, key, record.S3.Object.ETag); err != nil {
+			if cons.IsActive() {
+				cons.Logger.WithError(err).Errorf("Failed to read s3://%s/%s", bucket, key)
+			}
+			return false // ### return, retry ###
+		}
+	}
+
+	return true
+}
+
+func (cons *AwsS3) close() {
+	cons.storeOffsets()
+	cons.WorkerDone()
+}
+
+// Consume reads objects from S3 until the consumer is stopped.
+func (cons *AwsS3) Consume(workers *sync.WaitGroup) {
+	cons.AddMainWorker(workers)
+	cons.initClients()
+
+	if cons.sqsClient != nil {
+		cons.TickerControlLoop(time.Second, cons.receiveNotifications)
+	} else {
+		cons.TickerControlLoop(cons.listInterval, cons.listObjects)
+	}
+}
+
+// s3ObjectReader stops reading when the consumer is no longer active and
+// appends a delimiter to the last message if required.
+type s3ObjectReader struct {
+	reader    io.Reader
+	isActive  func() bool
+	delimiter []byte
+	tail      []byte
+	pending   []byte
+	eof       bool
+}
+
+func (r *s3ObjectReader) Read(p []byte) (int, error) {
+	if len(r.pending) > 0 {
+		n := copy(p, r.pending)
+		r.pending = r.pending[n:]
+		return n, nil
+	}
+
+	if r.eof {
+		return 0, io.EOF
+	}
+
+	if !r.isActive() {
+		return 0, fmt.Errorf("Consumer stopped")
+	}
+
+	n, err := r.reader.Read(p)
+	if n > 0 && len(r.delimiter) > 0 {
+		r.tail = append(r.tail, p[:n]...)
+		if len(r.tail) > len(r.delimiter) {
+			r.tail = r.tail[len(r.tail)-len(r.delimiter):]
+		}
+	}
+
+	if err == io.EOF {
+		r.eof = true
+		if len(r.tail) > 0 && !bytes.Equal(r.tail, r.delimiter) {
+			r.pending = r.delimiter
+			return n, nil // ### return, delimiter is sent with the next call ###
+		}
+	}
+	return n, err
+}
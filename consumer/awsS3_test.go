@@ -0,0 +1,102 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tio"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func readS3TestObject(cons *AwsS3, content []byte) ([]string, error) {
+	reader, err := cons.newObjectReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	// Replace the active check as the consumer is not running
+	reader.(*s3ObjectReader).isActive = func() bool { return true }
+
+	messages := []string{}
+	buffer := tio.NewBufferedReader(s3BufferGrowSize, cons.flags, cons.offset, cons.delimiter)
+	err = buffer.ReadAll(reader, func(data []byte) {
+		messages = append(messages, string(data))
+	})
+	return messages, err
+}
+
+func TestAwsS3ObjectReader(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "consumer.AwsS3")
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	cons := plugin.(*AwsS3)
+
+	messages, _ := readS3TestObject(cons, []byte("first\nsecond\nlast"))
+	expect.Equal([]string{"first", "second", "last"}, messages)
+
+	messages, _ = readS3TestObject(cons, []byte("first\nsecond\n"))
+	expect.Equal([]string{"first", "second"}, messages)
+
+	compressed := bytes.NewBuffer(nil)
+	writer := gzip.NewWriter(compressed)
+	writer.Write([]byte(strings.Repeat("x", 2000) + "\nend"))
+	writer.Close()
+
+	messages, _ = readS3TestObject(cons, compressed.Bytes())
+	expect.Equal(2, len(messages))
+	expect.Equal("end", messages[1])
+}
+
+func TestAwsS3Offsets(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "consumer.AwsS3")
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	cons := plugin.(*AwsS3)
+
+	doRead, skip := cons.startObject("bucket/a", "1")
+	expect.True(doRead)
+	expect.Equal(int64(0), skip)
+
+	cons.setProgress(10)
+	doRead, skip = cons.startObject("bucket/a", "1")
+	expect.True(doRead)
+	expect.Equal(int64(10), skip)
+
+	// progress of objects changed while being read is discarded
+	doRead, skip = cons.startObject("bucket/a", "2")
+	expect.True(doRead)
+	expect.Equal(int64(0), skip)
+
+	cons.setProgress(5)
+	cons.finishObject("bucket/a", "2")
+	doRead, _ = cons.startObject("bucket/a", "2")
+	expect.False(doRead)
+
+	// changed objects are read again
+	doRead, skip = cons.startObject("bucket/a", "3")
+	expect.True(doRead)
+	expect.Equal(int64(0), skip)
+
+	cons.pruneOffsets(map[string]bool{})
+	expect.Equal(0, len(cons.offsets.Processed))
+}
@@ -0,0 +1,205 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed cron expression. Each field is stored as a bitset
+// of the values matching that field.
+type cronSchedule struct {
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+	anyDay     bool
+}
+
+// cronField describes the valid values of a cron expression field
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronFields = [...]cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}},
+	{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}},
+}
+
+// cronSearchLimit is the maximum time searched for the next matching time
+const cronSearchLimit = 5
+
+// parseCronSchedule parses a cron expression with the five fields minute,
+// hour, day of month, month and day of week. Fields may contain lists, ranges,
+// steps and names of months and weekdays. The macros @yearly, @annually,
+// @monthly, @weekly, @daily, @midnight and @hourly are supported, too.
+func parseCronSchedule(expression string) (*cronSchedule, error) {
+	if macro, isMacro := cronMacros[strings.ToLower(strings.TrimSpace(expression))]; isMacro {
+		expression = macro
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression '%s' must have %d fields", expression, len(cronFields))
+	}
+
+	var bits [len(cronFields)]uint64
+	for i, field := range fields {
+		fieldBits, err := cronFields[i].parse(field)
+		if err != nil {
+			return nil, err
+		}
+		bits[i] = fieldBits
+	}
+
+	// Sunday may be given as 0 or 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	schedule := &cronSchedule{
+		minute:     bits[0],
+		hour:       bits[1],
+		dayOfMonth: bits[2],
+		month:      bits[3],
+		dayOfWeek:  bits[4],
+		anyDay:     strings.HasPrefix(fields[2], "*") || strings.HasPrefix(fields[4], "*"),
+	}
+
+	if schedule.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron expression '%s' never matches", expression)
+	}
+	return schedule, nil
+}
+
+// parse converts a single field of a cron expression into a bitset
+func (field cronField) parse(expression string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(expression, ",") {
+		valueRange, step := part, 1
+		if slashIdx := strings.Index(part, "/"); slashIdx >= 0 {
+			valueRange = part[:slashIdx]
+			parsedStep, err := strconv.Atoi(part[slashIdx+1:])
+			if err != nil || parsedStep <= 0 {
+				return 0, fmt.Errorf("invalid step in %s field '%s'", field.name, expression)
+			}
+			step = parsedStep
+		}
+
+		first, last := field.min, field.max
+		switch {
+		case valueRange == "*":
+		case strings.Contains(valueRange, "-"):
+			bounds := strings.SplitN(valueRange, "-", 2)
+			var err error
+			if first, err = field.parseValue(bounds[0]); err != nil {
+				return 0, err
+			}
+			if last, err = field.parseValue(bounds[1]); err != nil {
+				return 0, err
+			}
+			if first > last {
+				return 0, fmt.Errorf("invalid range in %s field '%s'", field.name, expression)
+			}
+		default:
+			value, err := field.parseValue(valueRange)
+			if err != nil {
+				return 0, err
+			}
+			first = value
+			if step == 1 {
+				last = value
+			}
+		}
+
+		for value := first; value <= last; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+// parseValue converts a number or name into a value of this field
+func (field cronField) parseValue(value string) (int, error) {
+	if named, isName := field.names[strings.ToLower(value)]; isName {
+		return named, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < field.min || number > field.max {
+		return 0, fmt.Errorf("invalid %s '%s'", field.name, value)
+	}
+	return number, nil
+}
+
+// matchesDay returns true if the day of the given time matches the day of
+// month and day of week fields. If both fields are restricted, matching one
+// of them is sufficient.
+func (schedule *cronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonth := schedule.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := schedule.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if schedule.anyDay {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// next returns the first time matching the schedule after the given time.
+// If no time matches within the next years, a zero time is returned.
+func (schedule *cronSchedule) next(after time.Time) time.Time {
+	loc := after.Location()
+	t := time.Date(after.Year(), after.Month(), after.Day(), after.Hour(), after.Minute()+1, 0, 0, loc)
+	limit := t.AddDate(cronSearchLimit, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case schedule.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !schedule.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case schedule.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case schedule.minute&(1<<uint(t.Minute())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -143,8 +143,10 @@ func (cons *File) Configure(conf core.PluginConfigReader) {
 	}
 }
 
-// Enqueue creates a new message
+// Enqueue creates a new message. Reading stops while the streams written to
+// are saturated.
 func (cons *File) Enqueue(data []byte) {
+	cons.WaitWhileSaturated()
 	metaData := core.Metadata{}
 
 	dir, file := filepath.Split(cons.source.realFileName)
@@ -0,0 +1,243 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo"
+)
+
+// Generator consumer plugin
+//
+// The "Generator" consumer plugin creates messages at a fixed interval or on a
+// cron schedule. It can be used to send heartbeats, canary events verifying a
+// pipeline end to end or periodic messages triggering actions downstream.
+//
+// Payload and metadata values are Go text/template templates. The following
+// values can be used inside templates:
+//
+// - .Time: The time the message has been generated as time.Time.
+//
+// - .Hostname: The hostname of the machine running gollum.
+//
+// - .Seq: The number of messages generated by this consumer, starting at 1.
+//
+// - .Env: A map of all environment variables, e.g. {{.Env.HOME}}. Variables
+// not set are replaced by an empty string.
+//
+// Parameters
+//
+// - Message: Defines the template used to generate the message payload.
+// By default this parameter is set to "{{.Time.Format \"2006-01-02T15:04:05Z07:00\"}} {{.Hostname}} {{.Seq}}".
+//
+// - Metadata: Defines a map of metadata keys to templates used to generate
+// the metadata values of each message.
+// By default this parameter is set to an empty map.
+//
+// - IntervalMs: Defines the number of milliseconds between two messages.
+// This parameter is ignored if Schedule is set.
+// By default this parameter is set to 1000.
+//
+// - Schedule: Defines a cron expression with the five fields minute, hour,
+// day of month, month and day of week, e.g. "*/5 * * * *". The macros
+// @yearly, @monthly, @weekly, @daily and @hourly are supported, too. Times
+// are evaluated in the local timezone. If set, IntervalMs is ignored.
+// By default this parameter is set to "".
+//
+// - JitterMs: Defines the maximum number of milliseconds a message is delayed
+// by a random amount. This spreads messages of multiple hosts using the same
+// schedule. Jitter should be smaller than the time between two messages.
+// By default this parameter is set to 0.
+//
+// Examples
+//
+// This example sends a JSON heartbeat every 5 minutes, spread over 30
+// seconds across all hosts.
+//
+//  Heartbeat:
+//    Type: consumer.Generator
+//    Streams: heartbeat
+//    Schedule: "*/5 * * * *"
+//    JitterMs: 30000
+//    Message: '{"host":"{{.Hostname}}","seq":{{.Seq}},"time":{{.Time.Unix}}}'
+//    Metadata:
+//      env: "{{.Env.STAGE}}"
+//
+type Generator struct {
+	core.SimpleConsumer `gollumdoc:"embed_type"`
+	interval            time.Duration `config:"IntervalMs" default:"1000" metric:"ms"`
+	jitter              time.Duration `config:"JitterMs" default:"0" metric:"ms"`
+	schedule            *cronSchedule
+	message             *template.Template
+	metadata            map[string]*template.Template
+	hostname            string
+	env                 map[string]string
+	seq                 uint64
+	random              *rand.Rand
+	stop                chan struct{}
+}
+
+// generatorValues is passed to the payload and metadata templates
+type generatorValues struct {
+	Time     time.Time
+	Hostname string
+	Seq      uint64
+	Env      map[string]string
+}
+
+const generatorDefaultMessage = "{{.Time.Format \"2006-01-02T15:04:05Z07:00\"}} {{.Hostname}} {{.Seq}}"
+
+func init() {
+	core.TypeRegistry.Register(Generator{})
+}
+
+// Configure initializes this consumer with values from a plugin config.
+func (cons *Generator) Configure(conf core.PluginConfigReader) {
+	var err error
+	cons.SetStopCallback(cons.close)
+	cons.stop = make(chan struct{})
+	cons.random = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	if schedule := conf.GetString("Schedule", ""); schedule != "" {
+		cons.schedule, err = parseCronSchedule(schedule)
+		conf.Errors.Push(err)
+	} else if cons.interval <= 0 {
+		conf.Errors.Pushf("IntervalMs must be greater than 0")
+	}
+	if cons.jitter < 0 {
+		conf.Errors.Pushf("JitterMs must not be negative")
+	}
+
+	cons.message, err = template.New("Message").Option("missingkey=zero").Parse(conf.GetString("Message", generatorDefaultMessage))
+	conf.Errors.Push(err)
+
+	cons.metadata = make(map[string]*template.Template)
+	for key, value := range conf.GetStringMap("Metadata", map[string]string{}) {
+		cons.metadata[key], err = template.New(key).Option("missingkey=zero").Parse(value)
+		conf.Errors.Push(err)
+	}
+
+	cons.hostname, _ = os.Hostname()
+	cons.env = make(map[string]string)
+	for _, variable := range os.Environ() {
+		if split := strings.IndexByte(variable, '='); split > 0 {
+			cons.env[variable[:split]] = variable[split+1:]
+		}
+	}
+}
+
+func (cons *Generator) close() {
+	close(cons.stop)
+}
+
+// getNextTime returns the time the message following the message scheduled
+// for last should be generated at, without jitter. Messages missed because
+// the consumer was blocked are skipped.
+func (cons *Generator) getNextTime(last, now time.Time) time.Time {
+	if cons.schedule != nil {
+		if last.Before(now) {
+			last = now
+		}
+		return cons.schedule.next(last)
+	}
+
+	next := last.Add(cons.interval)
+	if next.Before(now) {
+		return now
+	}
+	return next
+}
+
+// getJitter returns a random delay between 0 and JitterMs
+func (cons *Generator) getJitter() time.Duration {
+	if cons.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(cons.random.Int63n(int64(cons.jitter)))
+}
+
+// render executes the payload and metadata templates for the given time
+func (cons *Generator) render(now time.Time) ([]byte, core.Metadata, error) {
+	cons.seq++
+	values := generatorValues{
+		Time:     now,
+		Hostname: cons.hostname,
+		Seq:      cons.seq,
+		Env:      cons.env,
+	}
+
+	payload := bytes.Buffer{}
+	if err := cons.message.Execute(&payload, values); err != nil {
+		return nil, nil, err
+	}
+
+	var metadata core.Metadata
+	if len(cons.metadata) > 0 {
+		metadata = make(core.Metadata)
+		for key, tpl := range cons.metadata {
+			value := bytes.Buffer{}
+			if err := tpl.Execute(&value, values); err != nil {
+				return nil, nil, err
+			}
+			metadata.SetValue(key, value.Bytes())
+		}
+	}
+	return payload.Bytes(), metadata, nil
+}
+
+func (cons *Generator) generate() {
+	defer cons.WorkerDone()
+
+	scheduled := time.Now()
+	if cons.schedule == nil {
+		// The first message of an interval is sent immediately
+		scheduled = scheduled.Add(-cons.interval)
+	}
+
+	for {
+		scheduled = cons.getNextTime(scheduled, time.Now())
+		timer := time.NewTimer(scheduled.Add(cons.getJitter()).Sub(time.Now()))
+
+		select {
+		case <-cons.stop:
+			timer.Stop()
+			return // ### return, stopped ###
+		case <-timer.C:
+		}
+
+		payload, metadata, err := cons.render(time.Now())
+		if err != nil {
+			cons.Logger.Error("Failed to generate message: ", err)
+			continue // ### continue, template error ###
+		}
+		cons.EnqueueWithMetadata(payload, metadata)
+	}
+}
+
+// Consume generates messages until the consumer is stopped
+func (cons *Generator) Consume(workers *sync.WaitGroup) {
+	cons.AddMainWorker(workers)
+
+	go tgo.WithRecoverShutdown(cons.generate)
+	cons.ControlLoop()
+}
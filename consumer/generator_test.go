@@ -0,0 +1,121 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	start := time.Date(2017, time.March, 15, 10, 7, 30, 0, time.UTC) // Wednesday
+
+	schedule, err := parseCronSchedule("*/15 * * * *")
+	expect.NoError(err)
+	expect.Equal(time.Date(2017, time.March, 15, 10, 15, 0, 0, time.UTC), schedule.next(start))
+
+	schedule, err = parseCronSchedule("30 9 * * mon-fri")
+	expect.NoError(err)
+	expect.Equal(time.Date(2017, time.March, 16, 9, 30, 0, 0, time.UTC), schedule.next(start))
+
+	schedule, err = parseCronSchedule("0 0 1,15 * 7")
+	expect.NoError(err)
+	expect.Equal(time.Date(2017, time.March, 19, 0, 0, 0, 0, time.UTC), schedule.next(start))
+
+	schedule, err = parseCronSchedule("@monthly")
+	expect.NoError(err)
+	expect.Equal(time.Date(2017, time.April, 1, 0, 0, 0, 0, time.UTC), schedule.next(start))
+
+	schedule, err = parseCronSchedule("0 12 29 feb *")
+	expect.NoError(err)
+	expect.Equal(time.Date(2020, time.February, 29, 12, 0, 0, 0, time.UTC), schedule.next(start))
+}
+
+func TestCronScheduleErrors(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	for _, expression := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "0 0 31 feb *", "@often"} {
+		_, err := parseCronSchedule(expression)
+		expect.NotNil(err)
+	}
+}
+
+func TestGeneratorRender(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	os.Setenv("GOLLUM_GENERATOR_TEST", "canary")
+
+	config := core.NewPluginConfig("", "consumer.Generator")
+	config.Override("Streams", "test")
+	config.Override("Message", "{{.Seq}} {{.Time.Unix}} {{.Env.GOLLUM_GENERATOR_TEST}}{{.Env.GOLLUM_NOT_SET}}")
+	config.Override("Metadata", map[string]string{"host": "{{.Hostname}}"})
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	cons, casted := plugin.(*Generator)
+	expect.True(casted)
+
+	hostname, _ := os.Hostname()
+	now := time.Unix(1500000000, 0)
+
+	payload, metadata, err := cons.render(now)
+	expect.NoError(err)
+	expect.Equal("1 1500000000 canary", string(payload))
+	expect.Equal(hostname, metadata.GetValueString("host"))
+
+	payload, _, err = cons.render(now)
+	expect.NoError(err)
+	expect.Equal("2 1500000000 canary", string(payload))
+}
+
+func TestGeneratorNextTime(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "consumer.Generator")
+	config.Override("Streams", "test")
+	config.Override("IntervalMs", 1000)
+	config.Override("JitterMs", 100)
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	cons := plugin.(*Generator)
+
+	last := time.Unix(1500000000, 0)
+	expect.Equal(last.Add(time.Second), cons.getNextTime(last, last.Add(500*time.Millisecond)))
+
+	// Missed messages are skipped
+	now := last.Add(10 * time.Second)
+	expect.Equal(now, cons.getNextTime(last, now))
+
+	for i := 0; i < 100; i++ {
+		jitter := int64(cons.getJitter())
+		expect.Geq(jitter, int64(0))
+		expect.Less(jitter, int64(100*time.Millisecond))
+	}
+
+	config.Override("Schedule", "0 * * * *")
+	plugin, err = core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	cons = plugin.(*Generator)
+	expect.Equal(time.Date(2017, time.July, 14, 3, 0, 0, 0, time.UTC), cons.getNextTime(time.Date(2017, time.July, 14, 2, 40, 0, 0, time.UTC), time.Time{}))
+
+	config.Override("Schedule", "0 0 31 feb *")
+	_, err = core.NewPluginWithConfig(config)
+	expect.NotNil(err)
+}
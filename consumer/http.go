@@ -23,6 +23,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -59,6 +60,12 @@ import (
 // - PrivateKey: Path to an X509 formatted private key file. Meaningful only in
 // conjunction with Certificate.
 //
+// - RetryAfterSec: Defines the value of the Retry-After header sent with
+// "503 Service Unavailable" responses. Requests are answered with 503 while a
+// producer reading from the consumer's streams reached its
+// Backpressure/HighWaterMark.
+// By default this parameter is set to 1.
+//
 // Examples
 //
 // This example listens on port 9090 and writes to the stream "http_in_00".
@@ -76,6 +83,7 @@ type HTTP struct {
 	withHeaders         bool          `config:"WithHeaders" default:"true"`
 	htpasswd            string        `config:"Htpasswd"`
 	basicRealm          string        `config:"BasicRealm"`
+	retryAfter          time.Duration `config:"RetryAfterSec" default:"1" metric:"sec"`
 	secrets             auth.SecretProvider
 	listen              *tnet.StopListener
 	certificate         *tls.Config
@@ -131,6 +139,12 @@ func (cons *HTTP) requestHandler(resp http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	if cons.IsSaturated() {
+		resp.Header().Set("Retry-After", strconv.Itoa(int(cons.retryAfter/time.Second)))
+		resp.WriteHeader(http.StatusServiceUnavailable)
+		return // ### return, producers are saturated ###
+	}
+
 	if cons.withHeaders {
 		// Read the whole package
 		requestBuffer := bytes.NewBuffer(nil)
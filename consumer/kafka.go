@@ -116,11 +116,12 @@ const (
 // - MessageBufferCount: Sets the internal channel size for the kafka client.
 // By default this parameter is set to 8192.
 //
-// - PresistTimoutMs: Defines the interval in milliseconds in which data is
+// - PersistTimeoutMs: Defines the interval in milliseconds in which data is
 // written to the OffsetFile. Short durations reduce the amount of duplicate
 // messages after a crash but increases I/O. When using GroupId this setting
 // controls the pause time after receiving errors.
-// By default this parameter is set to 5000.
+// By default this parameter is set to 5000. PresistTimoutMs is accepted as a
+// deprecated alias.
 //
 // - ElectRetries: Defines how many times to retry fetching the new master
 // partition during a leader election.
@@ -186,7 +187,7 @@ type Kafka struct {
 	topic               string        `config:"Topic" default:"default"`
 	group               string        `config:"GroupId"`
 	offsetFile          string        `config:"OffsetFile"`
-	persistTimeout      time.Duration `config:"PresistTimoutMs" default:"5000" metric:"ms"`
+	persistTimeout      time.Duration `config:"PersistTimeoutMs" default:"5000" metric:"ms"`
 	orderedRead         bool          `config:"Ordered"`
 	folderPermissions   os.FileMode   `config:"FolderPermissions" default:"0755"`
 	client              kafka.Client
@@ -212,6 +213,11 @@ func (cons *Kafka) Configure(conf core.PluginConfigReader) {
 	cons.config.ClientID = conf.GetString("ClientId", "gollum")
 	cons.config.ChannelBufferSize = int(conf.GetInt("MessageBufferCount", 8192))
 
+	if conf.HasValue("PresistTimoutMs") {
+		cons.Logger.Warning("PresistTimoutMs is deprecated, use PersistTimeoutMs")
+		cons.persistTimeout = time.Duration(conf.GetInt("PresistTimoutMs", 5000)) * time.Millisecond
+	}
+
 	switch ver := conf.GetString("Version", "0.8.2"); ver {
 	case "0.8.2.0":
 		cons.config.Version = kafka.V0_8_2_0
@@ -383,6 +389,8 @@ func (cons *Kafka) readFromGroup() {
 	spin := tsync.NewSpinner(tsync.SpinPriorityLow)
 
 	for !cons.groupClient.Closed() {
+		cons.WaitWhileSaturated()
+
 		select {
 		case event := <-consumer.Messages():
 			cons.enqueueEvent(event)
@@ -432,6 +440,7 @@ func (cons *Kafka) readFromPartition(partitionID int32) {
 	spin := tsync.NewSpinner(tsync.SpinPriorityLow)
 
 	for !cons.client.Closed() {
+		cons.WaitWhileSaturated()
 
 		select {
 		case event := <-partCons.Messages():
@@ -480,6 +489,8 @@ func (cons *Kafka) readPartitions(partitions []int32) {
 
 	spin := tsync.NewSpinner(tsync.SpinPriorityLow)
 	for !cons.client.Closed() {
+		cons.WaitWhileSaturated()
+
 		for idx, consumer := range consumers {
 			partition := partitions[idx]
 
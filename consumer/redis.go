@@ -0,0 +1,410 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo"
+	"github.com/trivago/tgo/tnet"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redisModeList       = "list"
+	redisModeSubscribe  = "subscribe"
+	redisModePSubscribe = "psubscribe"
+	redisModeStream     = "stream"
+)
+
+// Redis consumer plugin
+//
+// This consumer reads messages from a redis server. Messages can be popped
+// from lists, received from pub/sub channels or read from streams as part of
+// a consumer group.
+//
+// When reading from streams, entries are acknowledged after they have been
+// routed. Entries that were delivered to this consumer but not acknowledged,
+// e.g. because gollum was stopped, are read again on startup.
+//
+// Metadata
+//
+// - key: The list, channel or stream the message was read from (set)
+//
+// - pattern: The pattern that matched the channel in psubscribe mode (set)
+//
+// - id: The id of the stream entry (set)
+//
+// All stream entry fields except the payload field are added as metadata, too.
+//
+// Parameters
+//
+// - Address: This value defines the redis server to connect to. This can
+// either be an ip address and port like "localhost:6379" or a file like
+// "unix:///var/redis.socket".
+// By default this parameter is set to ":6379".
+//
+// - Password: This value defines the password used to authenticate.
+// By default this parameter is set to "".
+//
+// - Database: This value defines the redis database to connect to.
+// By default this parameter is set to "0".
+//
+// - Mode: This value defines how messages are read. Set to "list" to pop
+// entries from lists via BLPOP, "subscribe" or "psubscribe" to receive
+// messages from pub/sub channels or channel patterns, or "stream" to read
+// from streams via XREADGROUP.
+// By default this parameter is set to "list".
+//
+// - Keys: This value defines the lists, channels, channel patterns or streams
+// to read from.
+// By default this parameter is set to ["default"].
+//
+// - Group: This value defines the consumer group used in stream mode. The
+// group is created if it does not exist.
+// By default this parameter is set to "gollum".
+//
+// - Consumer: This value defines the name of this consumer within the group.
+// Each gollum instance reading from the same group requires a unique name.
+// By default this parameter is set to the hostname.
+//
+// - StartID: This value defines the id a newly created group starts reading
+// from. Set to "$" to read new entries only or "0" to read the whole stream.
+// By default this parameter is set to "$".
+//
+// - BatchSize: This value defines the maximum number of stream entries read
+// with one request.
+// By default this parameter is set to "100".
+//
+// - PayloadField: This value defines the stream entry field holding the
+// message payload.
+// By default this parameter is set to "payload".
+//
+// - BlockTimeoutMs: This value defines the maximum time in milliseconds to
+// wait for new data with one request. This also defines how long it takes for
+// the consumer to notice it has been stopped.
+// By default this parameter is set to "1000".
+//
+// Examples
+//
+// This example reads entries written by producer.Redis with Storage "stream":
+//
+//  RedisIn:
+//    Type: consumer.Redis
+//    Streams: events
+//    Address: "redis:6379"
+//    Mode: stream
+//    Keys: events
+//    Group: gollum
+//
+// This example receives all messages published to channels starting with
+// "logs.":
+//
+//  RedisLogs:
+//    Type: consumer.Redis
+//    Streams: logs
+//    Mode: psubscribe
+//    Keys: "logs.*"
+type Redis struct {
+	core.SimpleConsumer `gollumdoc:"embed_type"`
+	address             string
+	protocol            string
+	password            string        `config:"Password"`
+	database            int           `config:"Database" default:"0"`
+	mode                string        `config:"Mode" default:"list"`
+	keys                []string      `config:"Keys" default:"default"`
+	group               string        `config:"Group" default:"gollum"`
+	startID             string        `config:"StartID" default:"$"`
+	batchSize           int64         `config:"BatchSize" default:"100"`
+	payloadField        string        `config:"PayloadField" default:"payload"`
+	blockTimeout        time.Duration `config:"BlockTimeoutMs" default:"1000" metric:"ms"`
+	consumerName        string
+	client              *redis.Client
+}
+
+// redisStreamEntry is a single entry returned by XREADGROUP
+type redisStreamEntry struct {
+	stream string
+	id     string
+	fields map[string]string
+}
+
+func init() {
+	core.TypeRegistry.Register(Redis{})
+}
+
+// Configure initializes this consumer with values from a plugin config.
+func (cons *Redis) Configure(conf core.PluginConfigReader) {
+	cons.SetStopCallback(cons.close)
+	cons.protocol, cons.address = tnet.ParseAddress(conf.GetString("Address", ":6379"), "tcp")
+
+	hostname, _ := os.Hostname()
+	cons.consumerName = conf.GetString("Consumer", hostname)
+
+	cons.mode = strings.ToLower(cons.mode)
+	switch cons.mode {
+	case redisModeList, redisModeSubscribe, redisModePSubscribe, redisModeStream:
+	default:
+		conf.Errors.Pushf("Unknown mode '%s'", cons.mode)
+	}
+
+	if len(cons.keys) == 0 {
+		conf.Errors.Pushf("Keys must not be empty")
+	}
+	if cons.mode == redisModeStream && cons.consumerName == "" {
+		conf.Errors.Pushf("Consumer must be set in stream mode")
+	}
+	if cons.blockTimeout <= 0 {
+		cons.blockTimeout = time.Second
+	}
+}
+
+func (cons *Redis) readList() {
+	for cons.IsActive() {
+		result, err := cons.client.BLPop(cons.blockTimeout, cons.keys...).Result()
+		switch {
+		case err == redis.Nil:
+			continue // ### continue, timeout ###
+		case err != nil:
+			cons.Logger.Error("Redis: ", err)
+			time.Sleep(cons.blockTimeout)
+			continue // ### continue, retry ###
+		}
+
+		cons.EnqueueWithMetadata([]byte(result[1]), core.Metadata{
+			"key": []byte(result[0]),
+		})
+	}
+}
+
+func (cons *Redis) readChannels() {
+	var (
+		pubsub *redis.PubSub
+		err    error
+	)
+
+	if cons.mode == redisModePSubscribe {
+		pubsub, err = cons.client.PSubscribe(cons.keys...)
+	} else {
+		pubsub, err = cons.client.Subscribe(cons.keys...)
+	}
+	if err != nil {
+		cons.Logger.Error("Redis: failed to subscribe: ", err)
+		return // ### return, cannot subscribe ###
+	}
+	defer pubsub.Close()
+
+	for cons.IsActive() {
+		reply, err := pubsub.ReceiveTimeout(cons.blockTimeout)
+		if err != nil {
+			if netErr, isNetErr := err.(net.Error); !isNetErr || !netErr.Timeout() {
+				cons.Logger.Error("Redis: ", err)
+				time.Sleep(cons.blockTimeout)
+			}
+			continue // ### continue, timeout or error ###
+		}
+
+		if msg, isMessage := reply.(*redis.Message); isMessage {
+			metadata := core.Metadata{"key": []byte(msg.Channel)}
+			if msg.Pattern != "" {
+				metadata["pattern"] = []byte(msg.Pattern)
+			}
+			cons.EnqueueWithMetadata([]byte(msg.Payload), metadata)
+		}
+	}
+}
+
+// createGroups creates the consumer group for all streams if it does not
+// exist yet. Streams that do not exist are created, too.
+func (cons *Redis) createGroups() error {
+	for _, key := range cons.keys {
+		cmd := redis.NewStatusCmd("XGROUP", "CREATE", key, cons.group, cons.startID, "MKSTREAM")
+		cons.client.Process(cmd)
+		if err := cmd.Err(); err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return fmt.Errorf("failed to create group %s on %s: %s", cons.group, key, err)
+		}
+	}
+	return nil
+}
+
+// readGroup reads up to BatchSize entries from all streams. If pending is
+// true, entries delivered to this consumer but not yet acknowledged are read
+// instead of new entries.
+func (cons *Redis) readGroup(pending bool) ([]redisStreamEntry, error) {
+	args := []interface{}{"XREADGROUP", "GROUP", cons.group, cons.consumerName, "COUNT", cons.batchSize}
+	if !pending {
+		args = append(args, "BLOCK", int64(cons.blockTimeout/time.Millisecond))
+	}
+	args = append(args, "STREAMS")
+	for _, key := range cons.keys {
+		args = append(args, key)
+	}
+
+	id := ">"
+	if pending {
+		id = "0"
+	}
+	for range cons.keys {
+		args = append(args, id)
+	}
+
+	cmd := redis.NewCmd(args...)
+	cons.client.Process(cmd)
+
+	reply, err := cmd.Result()
+	switch {
+	case err == redis.Nil:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	return parseRedisStreamReply(reply)
+}
+
+func (cons *Redis) enqueueEntries(entries []redisStreamEntry) {
+	acks := make(map[string][]interface{})
+	for _, entry := range entries {
+		acks[entry.stream] = append(acks[entry.stream], entry.id)
+		if len(entry.fields) == 0 {
+			continue // ### continue, entry has been deleted ###
+		}
+
+		metadata := core.Metadata{
+			"key": []byte(entry.stream),
+			"id":  []byte(entry.id),
+		}
+		for field, value := range entry.fields {
+			if field != cons.payloadField {
+				metadata[field] = []byte(value)
+			}
+		}
+
+		cons.EnqueueWithMetadata([]byte(entry.fields[cons.payloadField]), metadata)
+	}
+
+	for stream, ids := range acks {
+		args := append([]interface{}{"XACK", stream, cons.group}, ids...)
+		cmd := redis.NewIntCmd(args...)
+		cons.client.Process(cmd)
+		if err := cmd.Err(); err != nil {
+			cons.Logger.Errorf("Redis: failed to acknowledge %d entries on %s: %s", len(ids), stream, err)
+		}
+	}
+}
+
+func (cons *Redis) readStreams() {
+	for cons.IsActive() {
+		if err := cons.createGroups(); err != nil {
+			cons.Logger.Error("Redis: ", err)
+			time.Sleep(cons.blockTimeout)
+			continue // ### continue, retry ###
+		}
+		break
+	}
+
+	pending := true
+	for cons.IsActive() {
+		entries, err := cons.readGroup(pending)
+		if err != nil {
+			cons.Logger.Error("Redis: ", err)
+			time.Sleep(cons.blockTimeout)
+			continue // ### continue, retry ###
+		}
+
+		if pending && len(entries) == 0 {
+			pending = false
+			continue // ### continue, all pending entries processed ###
+		}
+		cons.enqueueEntries(entries)
+	}
+}
+
+func (cons *Redis) read() {
+	cons.AddWorker()
+	defer cons.WorkerDone()
+	defer cons.client.Close()
+
+	switch cons.mode {
+	case redisModeList:
+		cons.readList()
+	case redisModeSubscribe, redisModePSubscribe:
+		cons.readChannels()
+	case redisModeStream:
+		cons.readStreams()
+	}
+}
+
+func (cons *Redis) close() {
+	cons.WorkerDone()
+}
+
+// Consume reads from redis until the consumer is stopped.
+func (cons *Redis) Consume(workers *sync.WaitGroup) {
+	cons.client = redis.NewClient(&redis.Options{
+		Addr:        cons.address,
+		Network:     cons.protocol,
+		Password:    cons.password,
+		DB:          cons.database,
+		ReadTimeout: cons.blockTimeout + 3*time.Second,
+	})
+
+	cons.AddMainWorker(workers)
+	go tgo.WithRecoverShutdown(cons.read)
+
+	cons.ControlLoop()
+}
+
+// parseRedisStreamReply converts an XREAD or XREADGROUP reply into a list of
+// entries. Entries without fields, i.e. entries deleted while pending, are
+// returned with an empty field map.
+func parseRedisStreamReply(reply interface{}) ([]redisStreamEntry, error) {
+	streams, isList := reply.([]interface{})
+	if !isList {
+		return nil, fmt.Errorf("unexpected stream reply %#v", reply)
+	}
+
+	entries := []redisStreamEntry{}
+	for _, streamReply := range streams {
+		stream, isList := streamReply.([]interface{})
+		if !isList || len(stream) != 2 {
+			return nil, fmt.Errorf("unexpected stream %#v", streamReply)
+		}
+		name, _ := stream[0].(string)
+		streamEntries, _ := stream[1].([]interface{})
+
+		for _, entryReply := range streamEntries {
+			entry, isList := entryReply.([]interface{})
+			if !isList || len(entry) != 2 {
+				return nil, fmt.Errorf("unexpected stream entry %#v", entryReply)
+			}
+			id, _ := entry[0].(string)
+			fieldList, _ := entry[1].([]interface{})
+
+			fields := make(map[string]string, len(fieldList)/2)
+			for i := 0; i+1 < len(fieldList); i += 2 {
+				field, _ := fieldList[i].(string)
+				value, _ := fieldList[i+1].(string)
+				fields[field] = value
+			}
+			entries = append(entries, redisStreamEntry{stream: name, id: id, fields: fields})
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"testing"
+
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestParseRedisStreamReply(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	reply := []interface{}{
+		[]interface{}{"events", []interface{}{
+			[]interface{}{"1-0", []interface{}{"payload", "a", "host", "web1"}},
+			[]interface{}{"2-0", nil},
+		}},
+		[]interface{}{"audit", []interface{}{
+			[]interface{}{"1-1", []interface{}{"payload", "b"}},
+		}},
+	}
+
+	entries, err := parseRedisStreamReply(reply)
+	expect.NoError(err)
+	expect.Equal([]redisStreamEntry{
+		{stream: "events", id: "1-0", fields: map[string]string{"payload": "a", "host": "web1"}},
+		{stream: "events", id: "2-0", fields: map[string]string{}},
+		{stream: "audit", id: "1-1", fields: map[string]string{"payload": "b"}},
+	}, entries)
+
+	_, err = parseRedisStreamReply("OK")
+	expect.NotNil(err)
+
+	_, err = parseRedisStreamReply([]interface{}{[]interface{}{"events"}})
+	expect.NotNil(err)
+}
@@ -38,6 +38,10 @@ const (
 //
 // The socket consumer reads messages directly as-is from a given socket.
 // Messages are separated from the stream by using a specific partitioner method.
+// While a producer reading from the consumer's streams reached its
+// Backpressure/HighWaterMark, no new connections are accepted and no data is
+// read. If Acknowledge is set, data is still read but answered with
+// "NOT <Acknowledge>" and dropped, so the writer can send it again later.
 //
 // Parameters
 //
@@ -85,11 +89,11 @@ const (
 // is tried to be reopened again.
 // By default this parameter is set to "2".
 //
-// - AckTimoutSec: This value defines the number of seconds waited for an acknowledge to succeed.
-// By default this parameter is set to "2".
+// - AckTimeoutSec: This value defines the number of seconds waited for an acknowledge to succeed.
+// By default this parameter is set to "2". AckTimoutSec is accepted as a deprecated alias.
 //
-// - ReadTimoutSec: This value defines the number of seconds that waited for data to be received.
-// By default this parameter is set to "5".
+// - ReadTimeoutSec: This value defines the number of seconds that waited for data to be received.
+// By default this parameter is set to "5". ReadTimoutSec is accepted as a deprecated alias.
 //
 // - RemoveOldSocket: This value toggles removing existing files with the same name as the
 // socket (unix://<path>) prior to connecting.
@@ -112,8 +116,8 @@ type Socket struct {
 	acknowledge   string        `config:"Acknowledge" default:""`
 	delimiter     string        `config:"Delimiter" default:"\n"`
 	reconnectTime time.Duration `config:"ReconnectAfterSec" default:"2" metric:"sec"`
-	ackTimeout    time.Duration `config:"AckTimoutSec" default:"2" metric:"sec"`
-	readTimeout   time.Duration `config:"ReadTimoutSec" default:"5" metric:"sec"`
+	ackTimeout    time.Duration `config:"AckTimeoutSec" default:"2" metric:"sec"`
+	readTimeout   time.Duration `config:"ReadTimeoutSec" default:"5" metric:"sec"`
 	fileFlags     os.FileMode   `config:"Permissions" default:"0770"`
 	clearSocket   bool          `config:"RemoveOldSocket" default:"true"`
 	offset        int           `config:"Offset" default:"0"`
@@ -140,6 +144,15 @@ func (cons *Socket) Configure(conf core.PluginConfigReader) {
 	cons.clientLock = new(sync.Mutex)
 	cons.protocol, cons.address = tnet.ParseAddress(conf.GetString("Address", ":5880"), "tcp")
 
+	if conf.HasValue("AckTimoutSec") {
+		cons.Logger.Warning("AckTimoutSec is deprecated, use AckTimeoutSec")
+		cons.ackTimeout = time.Duration(conf.GetInt("AckTimoutSec", 2)) * time.Second
+	}
+	if conf.HasValue("ReadTimoutSec") {
+		cons.Logger.Warning("ReadTimoutSec is deprecated, use ReadTimeoutSec")
+		cons.readTimeout = time.Duration(conf.GetInt("ReadTimoutSec", 5)) * time.Second
+	}
+
 	if cons.protocol != "unix" {
 		if cons.acknowledge != "" {
 			cons.protocol = "tcp"
@@ -200,6 +213,12 @@ func (cons *Socket) sendAck(conn net.Conn, success bool) error {
 	return nil
 }
 
+// reject drops data read while the consumer's streams are saturated. The
+// writer is informed by a negative acknowledge.
+func (cons *Socket) reject(data []byte) {
+	cons.Logger.Debugf("Rejected %d bytes, streams are saturated", len(data))
+}
+
 func (cons *Socket) processConnection(conn net.Conn) {
 	cons.AddWorker()
 	defer cons.WorkerDone()
@@ -208,10 +227,20 @@ func (cons *Socket) processConnection(conn net.Conn) {
 	buffer := tio.NewBufferedReader(socketBufferGrowSize, cons.flags, cons.offset, cons.delimiter)
 
 	for cons.IsActive() {
+		enqueue := cons.Enqueue
+		accepted := true
+
+		if cons.acknowledge == "" {
+			cons.WaitWhileSaturated()
+		} else if cons.IsSaturated() {
+			enqueue = cons.reject
+			accepted = false
+		}
+
 		conn.SetReadDeadline(time.Now().Add(cons.readTimeout))
-		err := buffer.ReadAll(conn, cons.Enqueue)
+		err := buffer.ReadAll(conn, enqueue)
 		if err == nil {
-			if err = cons.sendAck(conn, true); err == nil {
+			if err = cons.sendAck(conn, accepted); err == nil {
 				continue // ### continue, all is well ###
 			}
 		}
@@ -310,6 +339,9 @@ func (cons *Socket) tcpAccept() {
 			}
 		}
 
+		// New connections wait in the listen backlog while saturated
+		cons.WaitWhileSaturated()
+
 		//cons.Logger.Info("Listening to open: ", cons.address)
 		listener := cons.listen.(net.Listener)
 		if client, err := listener.Accept(); err != nil {
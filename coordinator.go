@@ -167,18 +167,28 @@ func (co *Coordinator) Run() {
 			return // ### return, exit requested ###
 
 		case signalRoll:
-			for _, consumer := range co.consumers {
-				consumer.Control() <- core.PluginControlRoll
-			}
-			for _, producer := range co.producers {
-				producer.Control() <- core.PluginControlRoll
-			}
+			co.rollPlugins()
 
 		default:
 		}
 	}
 }
 
+// rollPlugins sends a roll command to all consumers and producers.
+// Producers drained via the admin API have left their control loop and are
+// skipped, as sending to them would block.
+func (co *Coordinator) rollPlugins() {
+	for _, consumer := range co.consumers {
+		consumer.Control() <- core.PluginControlRoll
+	}
+	for _, producer := range co.producers {
+		if producer.GetState() >= core.PluginStatePrepareStop {
+			continue // ### continue, drained ###
+		}
+		producer.Control() <- core.PluginControlRoll
+	}
+}
+
 // Shutdown all consumers and producers in a clean way.
 // The internal log is flushed after the consumers have been shut down so that
 // consumer related messages are still in the tlog.
@@ -370,6 +380,9 @@ func (co *Coordinator) shutdownProducers(stateAtShutdown coordinatorState) {
 			if timeout > waitTimeout {
 				waitTimeout = timeout
 			}
+			if prod.GetState() >= core.PluginStatePrepareStop {
+				continue // ### continue, already drained ###
+			}
 			prod.Control() <- core.PluginControlStopProducer
 		}
 
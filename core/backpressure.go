@@ -0,0 +1,75 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// backpressurePollInterval is the time waited between checks for saturated
+// streams.
+const backpressurePollInterval = 10 * time.Millisecond
+
+// BackpressureSource is implemented by producers and routers that can tell
+// if they are able to take more messages. Consumers use this information to
+// slow down reading.
+type BackpressureSource interface {
+	// IsSaturated returns true if messages should not be sent to this plugin
+	// until it has worked off its queue.
+	IsSaturated() bool
+}
+
+// backpressureGauge decides if a queue is saturated. A queue becomes
+// saturated when reaching the high water mark and stays saturated until
+// it has been worked off to the low water mark.
+type backpressureGauge struct {
+	highWaterMark int `config:"Backpressure/HighWaterMark" default:"0"`
+	lowWaterMark  int `config:"Backpressure/LowWaterMark" default:"-1"`
+	saturated     *int32
+}
+
+// init validates the water marks against the capacity of the queue
+func (gauge *backpressureGauge) init(capacity int) {
+	gauge.saturated = new(int32)
+	if gauge.highWaterMark > capacity {
+		gauge.highWaterMark = capacity
+	}
+	if gauge.lowWaterMark < 0 || gauge.lowWaterMark >= gauge.highWaterMark {
+		gauge.lowWaterMark = gauge.highWaterMark / 2
+	}
+}
+
+// isSaturated returns true if the given number of queued messages reached
+// the high water mark or did not yet fall below the low water mark.
+func (gauge backpressureGauge) isSaturated(numQueued int) bool {
+	if gauge.highWaterMark <= 0 || gauge.saturated == nil {
+		return false // ### return, disabled ###
+	}
+
+	if atomic.LoadInt32(gauge.saturated) != 0 {
+		if numQueued > gauge.lowWaterMark {
+			return true // ### return, not yet drained ###
+		}
+		atomic.StoreInt32(gauge.saturated, 0)
+		return false
+	}
+
+	if numQueued < gauge.highWaterMark {
+		return false // ### return, below high water mark ###
+	}
+	atomic.StoreInt32(gauge.saturated, 1)
+	return true
+}
@@ -0,0 +1,83 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"github.com/trivago/tgo/ttesting"
+	"testing"
+	"time"
+)
+
+func TestBackpressureGauge(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	gauge := backpressureGauge{highWaterMark: 4, lowWaterMark: -1}
+	gauge.init(8)
+	expect.Equal(2, gauge.lowWaterMark)
+
+	expect.False(gauge.isSaturated(3))
+	expect.True(gauge.isSaturated(4))
+	expect.True(gauge.isSaturated(3))
+	expect.False(gauge.isSaturated(2))
+	expect.False(gauge.isSaturated(3))
+
+	gauge = backpressureGauge{highWaterMark: 16, lowWaterMark: 4}
+	gauge.init(8)
+	expect.Equal(8, gauge.highWaterMark)
+	expect.Equal(4, gauge.lowWaterMark)
+
+	gauge = backpressureGauge{}
+	gauge.init(8)
+	expect.False(gauge.isSaturated(8))
+}
+
+func TestBackpressurePropagation(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	mockP := getMockProducer()
+	mockP.backpressure = backpressureGauge{highWaterMark: 2, lowWaterMark: 0}
+	mockP.backpressure.init(mockP.messages.Cap())
+
+	mockR := getMockRouter()
+	mockR.AddProducer(&mockP)
+
+	mockC := getMockConsumer()
+	mockC.routers = []Router{&mockR}
+
+	expect.False(mockC.IsSaturated())
+
+	mockP.messages.Push(getMockMessage("a"), 0)
+	mockP.messages.Push(getMockMessage("b"), 0)
+	expect.True(mockP.IsSaturated())
+	expect.True(mockR.IsSaturated())
+	expect.True(mockC.IsSaturated())
+
+	done := make(chan bool)
+	go func() {
+		mockC.WaitWhileSaturated()
+		done <- true
+	}()
+
+	mockP.messages.Pop()
+	expect.True(mockC.IsSaturated())
+	mockP.messages.Pop()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("WaitWhileSaturated did not return after draining")
+	}
+	expect.False(mockC.IsSaturated())
+}
@@ -42,6 +42,16 @@ import (
 // - Batch/TimeoutSec: Defines the maximum time in seconds messages can stay in
 // the internal buffer before being flushed.
 // By default this parameter is set to 5.
+//
+// - Backpressure/HighWaterMark: Defines the number of messages in the active
+// batch at which consumers writing to this producer stop reading new data.
+// Values larger than Batch/MaxCount are set to Batch/MaxCount. Set to 0 to
+// disable backpressure.
+// By default this parameter is set to 0.
+//
+// - Backpressure/LowWaterMark: Defines the number of messages the active batch
+// has to shrink to before consumers continue reading.
+// By default this parameter is set to half of Backpressure/HighWaterMark.
 type BatchedProducer struct {
 	DirectProducer  `gollumdoc:"embed_type"`
 	Batch           MessageBatch
@@ -49,6 +59,7 @@ type BatchedProducer struct {
 	batchFlushCount int           `config:"Batch/FlushCount" default:"4096"`
 	batchTimeout    time.Duration `config:"Batch/TimeoutSec" default:"5" metric:"sec"`
 	onBatchFlush    func() AssemblyFunc
+	backpressure    backpressureGauge
 }
 
 // Configure initializes the standard producer config values.
@@ -57,6 +68,22 @@ func (prod *BatchedProducer) Configure(conf PluginConfigReader) {
 
 	prod.batchFlushCount = tmath.MinI(prod.batchFlushCount, prod.batchMaxCount)
 	prod.Batch = NewMessageBatch(prod.batchMaxCount)
+	prod.backpressure.init(prod.batchMaxCount)
+}
+
+// GetNumQueued returns the number of messages waiting in the active batch
+func (prod *BatchedProducer) GetNumQueued() int {
+	return tmath.MinI(prod.Batch.getActiveBufferCount(), prod.Batch.Len())
+}
+
+// IsSaturated returns true if the number of messages in the active batch
+// reached Backpressure/HighWaterMark and did not yet fall to
+// Backpressure/LowWaterMark.
+func (prod *BatchedProducer) IsSaturated() bool {
+	if prod.GetState() >= PluginStateStopping {
+		return false // ### return, messages go to the fallback ###
+	}
+	return prod.backpressure.isSaturated(prod.GetNumQueued())
 }
 
 // Enqueue will add the message to the internal channel so it can be processed
@@ -75,6 +102,12 @@ func (prod *BatchedProducer) Enqueue(msg *Message, timeout time.Duration) {
 		return
 	}
 
+	if trace := msg.trace; trace != nil {
+		start := time.Now()
+		prod.appendMessage(msg)
+		trace.enqueued(prod.id, start, MessageQueueOk)
+		return // ### return, traced ###
+	}
 	prod.appendMessage(msg)
 }
 
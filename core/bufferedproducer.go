@@ -33,11 +33,42 @@ import (
 // You can set this parameter to "0" for disabling the timeout.
 // By default this parameter is set to "0".
 //
+// - Backpressure/HighWaterMark: This value defines the number of queued
+// messages at which consumers writing to this producer stop reading new data.
+// Values larger than Channel are set to Channel.
+// You can set this parameter to "0" for disabling backpressure.
+// By default this parameter is set to "0".
+//
+// - Backpressure/LowWaterMark: This value defines the number of queued
+// messages the queue has to shrink to before consumers continue reading.
+// By default this parameter is set to half of Backpressure/HighWaterMark.
+//
+// - Ordering/Lanes: This value defines the number of go routines sending
+// messages in parallel. Messages sharing the same key are always sent by the
+// same go routine in the order they were received. This parameter is only
+// supported by producers documenting it, others send messages from a single
+// go routine. Set this parameter to "0" to disable ordering lanes.
+// By default this parameter is set to "0".
+//
+// - Ordering/QueueSize: This value defines the number of messages buffered
+// per lane.
+// By default this parameter is set to "1024".
+//
+// - Ordering/MetadataKey: This value defines the metadata key to read the
+// ordering key from. If set, this key takes precedence over Ordering/Field.
+// By default this parameter is set to "".
+//
+// - Ordering/Field: This value defines a JSON field in the payload to read the
+// ordering key from. Paths can be defined in a format accepted by
+// tgo.MarshalMap.Path. Messages without a key share one lane.
+// By default this parameter is set to "".
 //
 type BufferedProducer struct {
 	DirectProducer `gollumdoc:"embed_type"`
-	messages       MessageQueue
+	messages       *MessageQueue
 	channelTimeout time.Duration `config:"ChannelTimeoutMs" default:"0" metric:"ms"`
+	backpressure   backpressureGauge
+	ordering       orderingLanes
 }
 
 // Configure initializes the standard producer config values.
@@ -45,6 +76,8 @@ func (prod *BufferedProducer) Configure(conf PluginConfigReader) {
 	prod.onPrepareStop = prod.DefaultDrain
 	prod.onStop = prod.DefaultClose
 	prod.messages = NewMessageQueue(int(conf.GetInt("Channel", 8192)))
+	prod.backpressure.init(prod.messages.Cap())
+	prod.ordering.init(conf)
 }
 
 // GetQueueTimeout returns the duration this producer will block before a
@@ -54,6 +87,30 @@ func (prod *BufferedProducer) GetQueueTimeout() time.Duration {
 	return prod.channelTimeout
 }
 
+// GetNumQueued returns the number of messages waiting in the producer's queue
+func (prod *BufferedProducer) GetNumQueued() int {
+	if prod.ordering.isRunning() {
+		return prod.messages.GetNumQueued() + prod.ordering.getNumQueued()
+	}
+	return prod.messages.GetNumQueued()
+}
+
+// IsOrdered returns true if messages are sent by ordering lanes, i.e. if
+// OrderedMessageControlLoop is used and Ordering/Lanes is set. Producers
+// need to send messages synchronously in this case to preserve their order.
+func (prod *BufferedProducer) IsOrdered() bool {
+	return prod.ordering.isRunning()
+}
+
+// IsSaturated returns true if the number of queued messages reached
+// Backpressure/HighWaterMark and did not yet fall to Backpressure/LowWaterMark.
+func (prod *BufferedProducer) IsSaturated() bool {
+	if prod.GetState() >= PluginStateStopping {
+		return false // ### return, messages go to the fallback ###
+	}
+	return prod.backpressure.isSaturated(prod.messages.GetNumQueued())
+}
+
 // Enqueue will add the message to the internal channel so it can be processed
 // by the producer main loop. A timeout value != nil will overwrite the channel
 // timeout value for this call.
@@ -76,13 +133,23 @@ func (prod *BufferedProducer) Enqueue(msg *Message, timeout time.Duration) {
 		usedTimeout = timeout
 	}
 
-	switch prod.messages.Push(msg, usedTimeout) {
+	trace, start := msg.trace, time.Time{}
+	if trace != nil {
+		start = time.Now()
+	}
+
+	result := prod.messages.Push(msg, usedTimeout)
+	if trace != nil {
+		trace.enqueued(prod.id, start, result)
+	}
+
+	switch result {
 	case MessageQueueTimeout:
 		prod.TryFallback(msg)
 		prod.setState(PluginStateWaiting)
 
 	case MessageQueueDiscard:
-		CountMessageDiscarded()
+		DiscardMessage(msg)
 		prod.setState(PluginStateWaiting)
 
 	default:
@@ -124,6 +191,11 @@ func (prod *BufferedProducer) DefaultClose() {
 	if prod.onMessage != nil {
 		prod.CloseMessageChannel(prod.onMessage)
 	}
+	if prod.ordering.isRunning() {
+		if !tgo.ReturnAfter(prod.shutdownTimeout, prod.ordering.stop) {
+			prod.Logger.Errorf("%d messages left in ordering lanes after closing.", prod.ordering.getNumQueued())
+		}
+	}
 }
 
 // CloseMessageChannel first calls DrainMessageChannel with shutdown timeout,
@@ -160,6 +232,22 @@ func (prod *BufferedProducer) MessageControlLoop(onMessage func(*Message)) {
 	prod.messageLoop(onMessage)
 }
 
+// OrderedMessageControlLoop works like MessageControlLoop but calls onMessage
+// from multiple go routines if Ordering/Lanes is set. Messages sharing the
+// same ordering key are passed to onMessage in order by the same go routine.
+// Producers using this function have to support concurrent calls to
+// onMessage.
+func (prod *BufferedProducer) OrderedMessageControlLoop(onMessage func(*Message)) {
+	if !prod.ordering.isEnabled() {
+		prod.MessageControlLoop(onMessage)
+		return // ### return, no ordering lanes ###
+	}
+
+	prod.Logger.Debugf("Using %d ordering lanes", prod.ordering.numLanes)
+	prod.ordering.start(onMessage)
+	prod.MessageControlLoop(prod.ordering.enqueue)
+}
+
 // TickerMessageControlLoop is like MessageLoop but executes a given function at
 // every given interval tick, too. If the onTick function takes longer than
 // interval, the next tick will be delayed until onTick finishes.
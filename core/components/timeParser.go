@@ -0,0 +1,149 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package components
+
+import (
+	"fmt"
+	"github.com/trivago/gollum/core"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	timeFormatUnix   = "unix"
+	timeFormatUnixMs = "unixms"
+	timeFormatUnixUs = "unixus"
+	timeFormatUnixNs = "unixns"
+)
+
+var namedTimeFormats = map[string]string{
+	"ansic":       time.ANSIC,
+	"rfc822":      time.RFC822,
+	"rfc822z":     time.RFC822Z,
+	"rfc850":      time.RFC850,
+	"rfc1123":     time.RFC1123,
+	"rfc1123z":    time.RFC1123Z,
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+}
+
+// TimeParser component
+//
+// The TimeParser is a helper component to parse timestamps using a list of
+// formats. Formats are tried in order, the first one matching is used.
+// Besides layouts accepted by Go's time.Parse the following names are
+// supported: "unix", "unixms", "unixus" and "unixns" for epoch based
+// timestamps in seconds, milli-, micro- or nanoseconds as well as "ansic",
+// "rfc822", "rfc822z", "rfc850", "rfc1123", "rfc1123z", "rfc3339" and
+// "rfc3339nano".
+//
+// Parameters
+//
+// - TimeFormats: This value defines the list of formats to try when parsing
+// a timestamp.
+// By default this parameter is set to "rfc3339".
+//
+// - Timezone: This value defines the timezone used for formats that do not
+// contain timezone information. Epoch based timestamps are converted to this
+// timezone. Valid values are "UTC", "Local" or a name from
+// the IANA timezone database like "Europe/Berlin".
+// By default this parameter is set to "UTC".
+//
+type TimeParser struct {
+	Formats  []string `config:"TimeFormats" default:"rfc3339"`
+	Timezone string   `config:"Timezone" default:"UTC"`
+	location *time.Location
+}
+
+// Configure method for interface implementation
+func (parser *TimeParser) Configure(conf core.PluginConfigReader) {
+	var err error
+	parser.location, err = time.LoadLocation(parser.Timezone)
+	if err != nil {
+		conf.Errors.Push(err)
+		parser.location = time.UTC
+	}
+
+	for i, format := range parser.Formats {
+		if named, isNamed := namedTimeFormats[strings.ToLower(format)]; isNamed {
+			parser.Formats[i] = named
+		}
+	}
+}
+
+// Parse tries to parse the given value with all configured formats.
+func (parser *TimeParser) Parse(value string) (time.Time, error) {
+	location := parser.location
+	if location == nil {
+		location = time.UTC
+	}
+
+	value = strings.TrimSpace(value)
+	for _, format := range parser.Formats {
+		if timestamp, err := parseTime(value, format, location); err == nil {
+			return timestamp, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Timestamp '%s' does not match any format", value)
+}
+
+// FormatTime formats the given time using a layout accepted by Go's
+// time.Format or one of the names supported by TimeParser.
+func FormatTime(timestamp time.Time, format string) string {
+	switch strings.ToLower(format) {
+	case timeFormatUnix:
+		return strconv.FormatInt(timestamp.Unix(), 10)
+	case timeFormatUnixMs:
+		return strconv.FormatInt(timestamp.UnixNano()/int64(time.Millisecond), 10)
+	case timeFormatUnixUs:
+		return strconv.FormatInt(timestamp.UnixNano()/int64(time.Microsecond), 10)
+	case timeFormatUnixNs:
+		return strconv.FormatInt(timestamp.UnixNano(), 10)
+	}
+
+	if named, isNamed := namedTimeFormats[strings.ToLower(format)]; isNamed {
+		format = named
+	}
+	return timestamp.Format(format)
+}
+
+func parseTime(value string, format string, location *time.Location) (time.Time, error) {
+	var scale time.Duration
+	switch strings.ToLower(format) {
+	case timeFormatUnix:
+		scale = time.Second
+	case timeFormatUnixMs:
+		scale = time.Millisecond
+	case timeFormatUnixUs:
+		scale = time.Microsecond
+	case timeFormatUnixNs:
+		scale = time.Nanosecond
+	default:
+		return time.ParseInLocation(format, value, location)
+	}
+
+	if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(0, epoch*int64(scale)).In(location), nil
+	}
+
+	// Allow fractions, e.g. "1500000000.123"
+	epoch, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(epoch*float64(scale))).In(location), nil
+}
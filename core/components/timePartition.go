@@ -0,0 +1,192 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package components
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tcontainer"
+	"sync/atomic"
+	"time"
+)
+
+// TimePartitionConfig defines how time partitioned producers select a target
+// for a message.
+//
+// Messages are assigned to a partition based on the time of the event they
+// describe. This time is read from a JSON field or a metadata key and parsed
+// using the TimeParser settings "TimeFormats" and "Timezone". If neither is
+// configured or the value cannot be parsed, Message.GetCreationTime is used.
+//
+// Parameters
+//
+// - Partition/Enable: If this value is set to "true" messages are routed to
+// the partition matching their event time instead of the current time.
+// By default this parameter is set to "false".
+//
+// - Partition/Field: This value defines a JSON field in the payload to read
+// the event time from. Paths can be defined in a format accepted by
+// tgo.MarshalMap.Path.
+// By default this parameter is set to "".
+//
+// - Partition/MetadataKey: This value defines a metadata key to read the event
+// time from. If set, this key takes precedence over Partition/Field.
+// By default this parameter is set to "".
+//
+// - Partition/Timestamp: This value defines the granularity of a partition as
+// a format accepted by Go's time.Format. Producers that write files use the
+// formatted time as part of the file name.
+// By default this parameter is set to "2006-01-02_15".
+//
+// - Partition/MaxOpen: This value defines the maximum number of partitions
+// kept open at the same time. When a new partition needs to be opened, the
+// least recently used partition is closed.
+// By default this parameter is set to "8".
+//
+// - Partition/LatenessMin: This value defines the number of minutes an event
+// may lag behind the current time. Later events are sent to the fallback.
+// Set this value to "0" to accept events of any age.
+// By default this parameter is set to "0".
+//
+type TimePartitionConfig struct {
+	Parser      TimeParser    `gollumdoc:"embed_type"`
+	Enabled     bool          `config:"Partition/Enable" default:"false"`
+	Field       string        `config:"Partition/Field"`
+	MetadataKey string        `config:"Partition/MetadataKey"`
+	Timestamp   string        `config:"Partition/Timestamp" default:"2006-01-02_15"`
+	MaxOpen     int           `config:"Partition/MaxOpen" default:"8"`
+	Lateness    time.Duration `config:"Partition/LatenessMin" default:"0" metric:"min"`
+}
+
+// Configure method for interface implementation
+func (config *TimePartitionConfig) Configure(conf core.PluginConfigReader) {
+	if config.MaxOpen < 1 {
+		conf.Errors.Pushf("Partition/MaxOpen must be at least 1")
+	}
+}
+
+// GetEventTime returns the time of the event described by the given message.
+// If no event time can be found, the creation time of the message is used.
+func (config *TimePartitionConfig) GetEventTime(msg *core.Message) time.Time {
+	var value string
+	switch {
+	case config.MetadataKey != "":
+		value = msg.GetMetadata().GetValueString(config.MetadataKey)
+
+	case config.Field != "":
+		values := tcontainer.NewMarshalMap()
+		decoder := json.NewDecoder(bytes.NewReader(msg.GetPayload()))
+		decoder.UseNumber()
+		if err := decoder.Decode(&values); err != nil {
+			return msg.GetCreationTime()
+		}
+		fieldValue, exists := values.Value(config.Field)
+		if !exists {
+			return msg.GetCreationTime()
+		}
+		value = fmt.Sprintf("%v", fieldValue)
+
+	default:
+		return msg.GetCreationTime()
+	}
+
+	if eventTime, err := config.Parser.Parse(value); err == nil {
+		return eventTime
+	}
+	return msg.GetCreationTime()
+}
+
+// GetPartition returns the name of the partition the given message belongs
+// to. The second return value is true if the message is too late to be
+// accepted.
+func (config *TimePartitionConfig) GetPartition(msg *core.Message) (string, bool) {
+	eventTime := config.GetEventTime(msg)
+	return eventTime.Format(config.Timestamp), config.IsLate(eventTime)
+}
+
+// IsLate returns true if the given event time is older than the configured
+// lateness horizon.
+func (config *TimePartitionConfig) IsLate(eventTime time.Time) bool {
+	return config.Lateness > 0 && time.Since(eventTime) > config.Lateness
+}
+
+// TimePartitionSet keeps track of a bounded number of open partitions,
+// ordered by last use.
+type TimePartitionSet struct {
+	useSeq  uint64 // first field to assure 64-bit alignment for atomic access
+	maxOpen int
+	lastUse map[string]*uint64
+}
+
+// NewTimePartitionSet creates a new set holding at most maxOpen partitions.
+func NewTimePartitionSet(maxOpen int) TimePartitionSet {
+	return TimePartitionSet{
+		maxOpen: maxOpen,
+		lastUse: make(map[string]*uint64),
+	}
+}
+
+// Refresh marks an already open partition as used and returns true. If the
+// partition is not part of the set, false is returned and Touch has to be
+// called. Refresh may be called concurrently with other calls to Refresh but
+// not with Touch or Remove.
+func (set *TimePartitionSet) Refresh(partition string) bool {
+	lastUse, isOpen := set.lastUse[partition]
+	if isOpen {
+		atomic.StoreUint64(lastUse, atomic.AddUint64(&set.useSeq, 1))
+	}
+	return isOpen
+}
+
+// Touch marks the given partition as used. If this exceeds the maximum number
+// of open partitions, the name of the least recently used partition is
+// returned and removed from the set. Otherwise an empty string is returned.
+func (set *TimePartitionSet) Touch(partition string) (evicted string) {
+	useSeq := atomic.AddUint64(&set.useSeq, 1)
+	if lastUse, isOpen := set.lastUse[partition]; isOpen {
+		atomic.StoreUint64(lastUse, useSeq)
+		return ""
+	}
+
+	set.lastUse[partition] = &useSeq
+	if len(set.lastUse) <= set.maxOpen {
+		return ""
+	}
+
+	oldest := useSeq
+	for name, lastUse := range set.lastUse {
+		if seq := atomic.LoadUint64(lastUse); seq < oldest {
+			evicted, oldest = name, seq
+		}
+	}
+	delete(set.lastUse, evicted)
+	return evicted
+}
+
+// Remove removes a partition from the set.
+func (set *TimePartitionSet) Remove(partition string) {
+	delete(set.lastUse, partition)
+}
+
+// Partitions returns the names of all open partitions.
+func (set *TimePartitionSet) Partitions() []string {
+	partitions := make([]string, 0, len(set.lastUse))
+	for name := range set.lastUse {
+		partitions = append(partitions, name)
+	}
+	return partitions
+}
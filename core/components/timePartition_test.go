@@ -0,0 +1,82 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package components
+
+import (
+	"testing"
+	"time"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestTimePartitionEventTime(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "")
+	config.Override("Partition/Field", "event/time")
+	config.Override("TimeFormats", "unixms")
+	config.Override("Partition/LatenessMin", 60)
+
+	partition := TimePartitionConfig{}
+	reader := core.NewPluginConfigReader(&config)
+	expect.NoError(reader.Configure(&partition))
+
+	msg := core.NewMessage(nil, []byte(`{"event":{"time":1500000000123}}`), nil, core.InvalidStreamID)
+	name, isLate := partition.GetPartition(msg)
+	expect.Equal("2017-07-14_02", name)
+	expect.True(isLate)
+
+	msg = core.NewMessage(nil, []byte(`{"event":{"time":"invalid"}}`), nil, core.InvalidStreamID)
+	expect.Equal(msg.GetCreationTime(), partition.GetEventTime(msg))
+	_, isLate = partition.GetPartition(msg)
+	expect.False(isLate)
+}
+
+func TestTimePartitionMetadata(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "")
+	config.Override("Partition/MetadataKey", "time")
+	config.Override("Partition/Timestamp", "2006-01-02")
+
+	partition := TimePartitionConfig{}
+	reader := core.NewPluginConfigReader(&config)
+	expect.NoError(reader.Configure(&partition))
+
+	msg := core.NewMessage(nil, []byte("payload"), core.Metadata{"time": []byte("2017-07-14T23:59:00-02:00")}, core.InvalidStreamID)
+	expect.Equal(time.Date(2017, 7, 15, 1, 59, 0, 0, time.UTC).Unix(), partition.GetEventTime(msg).Unix())
+	name, _ := partition.GetPartition(msg)
+	expect.Equal("2017-07-14", name)
+}
+
+func TestTimePartitionSet(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	set := NewTimePartitionSet(2)
+
+	expect.Equal("", set.Touch("a"))
+	expect.Equal("", set.Touch("b"))
+	expect.Equal("", set.Touch("a"))
+	expect.Equal("b", set.Touch("c"))
+	expect.Equal("a", set.Touch("b"))
+	expect.Equal(2, len(set.Partitions()))
+
+	set.Remove("c")
+	expect.Equal("", set.Touch("d"))
+
+	expect.True(set.Refresh("b"))
+	expect.False(set.Refresh("c"))
+	expect.Equal("d", set.Touch("e"))
+}
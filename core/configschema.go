@@ -0,0 +1,289 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"github.com/trivago/tgo/tcontainer"
+	"github.com/trivago/tgo/treflect"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const configSchemaModulator = "modulator"
+
+var (
+	filterInterface    = reflect.TypeOf((*Filter)(nil)).Elem()
+	formatterInterface = reflect.TypeOf((*Formatter)(nil)).Elem()
+	modulatorInterface = reflect.TypeOf((*Modulator)(nil)).Elem()
+)
+
+// PluginOption describes a configuration option of a plugin type as declared
+// by a "config" struct tag.
+type PluginOption struct {
+	// Key is the configuration key as written in the struct tag. Nested keys
+	// are separated by "/".
+	Key string
+	// Schema contains the JSON Schema of the option's value
+	Schema tcontainer.MarshalMap
+}
+
+// GetPluginOptions returns all options of a registered type that are
+// declared by "config" struct tags, including the options of embedded and
+// nested structs. Options read manually during Configure are not listed.
+func GetPluginOptions(typename string) ([]PluginOption, error) {
+	pluginType := TypeRegistry.GetTypeOf(typename)
+	if pluginType == nil {
+		return nil, fmt.Errorf("Type '%s' not found", typename)
+	}
+
+	options := []PluginOption{}
+	getStructOptions(treflect.RemovePtrFromType(pluginType), &options, make(map[reflect.Type]bool))
+	return options, nil
+}
+
+// getStructOptions collects the options of a struct in the same order
+// PluginConfigReader.Configure reads them.
+func getStructOptions(structType reflect.Type, options *[]PluginOption, visited map[reflect.Type]bool) {
+	if structType.Kind() != reflect.Struct || visited[structType] {
+		return // ### return, no struct or recursion ###
+	}
+	visited[structType] = true
+	defer delete(visited, structType)
+
+	for fieldIdx := 0; fieldIdx < structType.NumField(); fieldIdx++ {
+		field := structType.Field(fieldIdx)
+
+		if key, hasFieldConfig := field.Tag.Lookup("config"); hasFieldConfig {
+			if schema := getOptionSchema(field.Type, PluginStructTag(field.Tag)); schema != nil {
+				*options = append(*options, PluginOption{Key: key, Schema: schema})
+			}
+			continue // ### continue, configured by tag ###
+		}
+
+		// Pointers to structs are nil when a plugin is created, so they are
+		// not traversed by PluginConfigReader either.
+		getStructOptions(field.Type, options, visited)
+	}
+}
+
+// getOptionSchema returns the JSON Schema for a field as handled by
+// PluginConfigReader.configureField. Unsupported types return nil.
+func getOptionSchema(fieldType reflect.Type, tags PluginStructTag) tcontainer.MarshalMap {
+	schema := tcontainer.NewMarshalMap()
+	_, hasDefault := reflect.StructTag(tags).Lookup(PluginStructTagDefault)
+
+	switch fieldType.Kind() {
+	case reflect.Bool:
+		schema["type"] = "boolean"
+		if hasDefault {
+			schema["default"] = tags.GetBool()
+		}
+
+	case reflect.String:
+		schema["type"] = "string"
+		if hasDefault {
+			schema["default"] = tags.GetString()
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		schema["type"] = "integer"
+		if hasDefault {
+			schema["default"] = tags.GetInt()
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if fieldType.Name() == "MessageStreamID" {
+			schema["type"] = "string"
+			if hasDefault {
+				schema["default"] = tags.GetString()
+			}
+		} else {
+			schema["type"] = "integer"
+			schema["minimum"] = 0
+			if hasDefault {
+				schema["default"] = tags.GetUint()
+			}
+		}
+
+	case reflect.Array, reflect.Slice:
+		switch elementType := fieldType.Elem(); {
+		case elementType.Kind() == reflect.Int8 || elementType.Kind() == reflect.Uint8:
+			schema["type"] = "string"
+			if hasDefault {
+				schema["default"] = tags.GetString()
+			}
+
+		case elementType.Kind() == reflect.String || elementType.Name() == "MessageStreamID" || elementType.Name() == "Router":
+			// A single string is accepted as an array with one element
+			schema["type"] = []string{"array", "string"}
+			schema["items"] = tcontainer.MarshalMap{"type": "string"}
+			if hasDefault {
+				schema["default"] = tags.GetStringArray()
+			}
+
+		case elementType.Name() == "Modulator" || elementType.Name() == "Filter" || elementType.Name() == "Formatter":
+			schema["type"] = "array"
+			schema["items"] = tcontainer.MarshalMap{"$ref": "#/definitions/" + configSchemaModulator}
+
+		default:
+			return nil
+		}
+
+	case reflect.Interface:
+		if treflect.RemovePtrFromType(fieldType).Name() != "Router" {
+			return nil
+		}
+		schema["type"] = "string"
+		if hasDefault {
+			schema["default"] = tags.GetString()
+		}
+
+	default:
+		return nil
+	}
+
+	if metric, hasMetric := reflect.StructTag(tags).Lookup(PluginStructTagMetric); hasMetric {
+		schema["description"] = fmt.Sprintf("Unit: %s", metric)
+	}
+	return schema
+}
+
+// GetConfigSchema returns a JSON Schema (draft-07) for gollum config files.
+// It contains a definition for every registered plugin type, generated from
+// the type's "config" struct tags. Plugins are validated by their "Type" key.
+// As options read manually during Configure are not part of the schema,
+// additional keys are allowed.
+func GetConfigSchema() tcontainer.MarshalMap {
+	definitions := tcontainer.NewMarshalMap()
+	pluginTypes := []string{}
+	modulatorTypes := []string{}
+
+	for _, typename := range getSchemaTypenames() {
+		pluginType := TypeRegistry.GetTypeOf(typename)
+		isPlugin := pluginType.Implements(consumerInterface) || pluginType.Implements(producerInterface) || pluginType.Implements(routerInterface)
+		isModulator := pluginType.Implements(filterInterface) || pluginType.Implements(formatterInterface) || pluginType.Implements(modulatorInterface)
+		if !isPlugin && !isModulator {
+			continue // ### continue, not configurable by a config file ###
+		}
+
+		options, _ := GetPluginOptions(typename)
+		properties := tcontainer.NewMarshalMap()
+		for _, option := range options {
+			setSchemaProperty(properties, strings.Split(option.Key, "/"), option.Schema)
+		}
+
+		if isPlugin {
+			pluginTypes = append(pluginTypes, typename)
+			properties["Type"] = tcontainer.MarshalMap{"const": typename}
+			properties["Enable"] = tcontainer.MarshalMap{"type": "boolean", "default": true}
+		} else {
+			modulatorTypes = append(modulatorTypes, typename)
+		}
+
+		definitions[typename] = tcontainer.MarshalMap{
+			"type":       "object",
+			"properties": properties,
+		}
+	}
+
+	// Modulators are either given by type name or as a map with a single
+	// entry of type name to config.
+	modulatorProperties := tcontainer.NewMarshalMap()
+	for _, typename := range modulatorTypes {
+		modulatorProperties[typename] = tcontainer.MarshalMap{"$ref": "#/definitions/" + typename}
+	}
+	definitions[configSchemaModulator] = tcontainer.MarshalMap{
+		"anyOf": []interface{}{
+			tcontainer.MarshalMap{"type": "string", "enum": modulatorTypes},
+			tcontainer.MarshalMap{
+				"type":                 "object",
+				"properties":           modulatorProperties,
+				"additionalProperties": false,
+				"minProperties":        1,
+				"maxProperties":        1,
+			},
+		},
+	}
+
+	conditions := make([]interface{}, 0, len(pluginTypes))
+	for _, typename := range pluginTypes {
+		conditions = append(conditions, tcontainer.MarshalMap{
+			"if":   tcontainer.MarshalMap{"properties": tcontainer.MarshalMap{"Type": tcontainer.MarshalMap{"const": typename}}},
+			"then": tcontainer.MarshalMap{"$ref": "#/definitions/" + typename},
+		})
+	}
+
+	return tcontainer.MarshalMap{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"title":   "Gollum configuration",
+		"type":    "object",
+		"additionalProperties": tcontainer.MarshalMap{
+			"type":     "object",
+			"required": []string{"Type"},
+			"properties": tcontainer.MarshalMap{
+				"Type": tcontainer.MarshalMap{"type": "string", "enum": append(pluginTypes, pluginAggregate)},
+			},
+			"allOf": conditions,
+		},
+		"definitions": definitions,
+	}
+}
+
+// getSchemaTypenames returns the shortest name of every registered type.
+// Types are registered with multiple names, e.g. "producer.File" and
+// "gollum.producer.File".
+func getSchemaTypenames() []string {
+	names := make(map[reflect.Type]string)
+	for _, typename := range TypeRegistry.GetRegistered("") {
+		pluginType := TypeRegistry.GetTypeOf(typename)
+		if name, exists := names[pluginType]; !exists || len(typename) < len(name) || (len(typename) == len(name) && typename < name) {
+			names[pluginType] = typename
+		}
+	}
+
+	typenames := make([]string, 0, len(names))
+	for _, typename := range names {
+		typenames = append(typenames, typename)
+	}
+	sort.Strings(typenames)
+	return typenames
+}
+
+// setSchemaProperty stores a schema at a nested path of object properties
+func setSchemaProperty(properties tcontainer.MarshalMap, path []string, schema tcontainer.MarshalMap) {
+	if len(path) == 1 {
+		if _, exists := properties[path[0]]; !exists {
+			properties[path[0]] = schema
+		}
+		return // ### return, leaf reached ###
+	}
+
+	parent, exists := properties[path[0]].(tcontainer.MarshalMap)
+	if !exists {
+		parent = tcontainer.MarshalMap{
+			"type":       "object",
+			"properties": tcontainer.NewMarshalMap(),
+		}
+		properties[path[0]] = parent
+	}
+
+	nested, isObject := parent["properties"].(tcontainer.MarshalMap)
+	if !isObject {
+		return // ### return, option declared as non-object before ###
+	}
+	setSchemaProperty(nested, path[1:], schema)
+}
@@ -0,0 +1,103 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"github.com/trivago/tgo/tcontainer"
+	"github.com/trivago/tgo/ttesting"
+	"testing"
+	"time"
+)
+
+type mockSchemaBase struct {
+	Flag bool `config:"Flag" default:"true"`
+}
+
+type mockSchemaPlugin struct {
+	mockSchemaBase
+	count    int64             `config:"Batch/Count" default:"10"`
+	timeout  time.Duration     `config:"Batch/TimeoutMs" default:"5" metric:"ms"`
+	streams  []MessageStreamID `config:"Streams" default:"foo,bar"`
+	mods     ModulatorArray    `config:"Modulators"`
+	ignored  chan bool
+	internal *mockSchemaBase
+}
+
+func TestGetPluginOptions(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	TypeRegistry.Register(mockSchemaPlugin{})
+
+	options, err := GetPluginOptions("core.mockSchemaPlugin")
+	expect.NoError(err)
+	expect.Equal(5, len(options))
+
+	expect.Equal("Flag", options[0].Key)
+	expect.Equal("boolean", options[0].Schema["type"])
+	expect.Equal(true, options[0].Schema["default"])
+
+	expect.Equal("Batch/Count", options[1].Key)
+	expect.Equal("integer", options[1].Schema["type"])
+	expect.Equal(int64(10), options[1].Schema["default"])
+
+	expect.Equal("Batch/TimeoutMs", options[2].Key)
+	expect.Equal("Unit: ms", options[2].Schema["description"])
+
+	expect.Equal("Streams", options[3].Key)
+	expect.Equal([]string{"foo", "bar"}, options[3].Schema["default"])
+
+	expect.Equal("Modulators", options[4].Key)
+	expect.Equal("array", options[4].Schema["type"])
+
+	_, err = GetPluginOptions("core.unknownType")
+	expect.NotNil(err)
+}
+
+func TestGetConfigSchema(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	TypeRegistry.Register(mockProducer{})
+	TypeRegistry.Register(mockFormatter{})
+
+	schema := GetConfigSchema()
+	definitions, err := schema.MarshalMap("definitions")
+	expect.NoError(err)
+
+	producer, err := definitions.MarshalMap("core.mockProducer")
+	expect.NoError(err)
+	properties, err := producer.MarshalMap("properties")
+	expect.NoError(err)
+
+	// Options of the embedded SimpleProducer
+	expect.MapSet(properties, "Streams")
+	expect.MapSet(properties, "FallbackStream")
+	expect.MapSet(properties, "Type")
+
+	// Options of the embedded BufferedProducer
+	expect.MapEqual(properties, "ChannelTimeoutMs", tcontainer.MarshalMap{
+		"type":        "integer",
+		"default":     int64(0),
+		"description": "Unit: ms",
+	})
+
+	// Formatters are modulators, not plugins
+	formatter, err := definitions.MarshalMap("core.mockFormatter")
+	expect.NoError(err)
+	formatterProperties, err := formatter.MarshalMap("properties")
+	expect.NoError(err)
+	expect.MapNotSet(formatterProperties, "Type")
+
+	modulator, err := definitions.MarshalMap("modulator")
+	expect.NoError(err)
+	expect.MapSet(modulator, "anyOf")
+}
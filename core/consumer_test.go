@@ -39,9 +39,11 @@ func (mc *mockConsumer) Consume(wg *sync.WaitGroup) {
 func getMockConsumer() mockConsumer {
 	return mockConsumer{
 		SimpleConsumer: SimpleConsumer{
-			control:  make(chan PluginControl),
-			runState: NewPluginRunState(),
-			Logger:   logrus.WithField("Scope", "test"),
+			control:    make(chan PluginControl),
+			runState:   NewPluginRunState(),
+			paused:     new(int32),
+			pauseGuard: sync.NewCond(new(sync.Mutex)),
+			Logger:     logrus.WithField("Scope", "test"),
 		},
 	}
 }
@@ -137,3 +139,39 @@ func TestConsumerControlLoop(t *testing.T) {
 	time.Sleep(50 * time.Millisecond)
 	expect.Equal(atomic.LoadInt32(roll), int32(1))
 }
+
+func TestConsumerPause(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	mockC := getMockConsumer()
+
+	enqueued := new(int32)
+	mockC.enqueueMessage = func(msg *Message) {
+		atomic.AddInt32(enqueued, 1)
+	}
+
+	go mockC.ControlLoop()
+	mockC.Control() <- PluginControlPauseConsumer
+	mockC.Control() <- PluginControlRoll // wait for the pause to be processed
+
+	expect.True(mockC.IsPaused())
+	expect.True(mockC.IsBlocked())
+
+	done := make(chan struct{})
+	go func() {
+		mockC.Enqueue([]byte("test"))
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	expect.Equal(int32(0), atomic.LoadInt32(enqueued))
+
+	mockC.Control() <- PluginControlResumeConsumer
+	expect.NonBlocking(time.Second, func() { <-done })
+	expect.Equal(int32(1), atomic.LoadInt32(enqueued))
+	expect.False(mockC.IsPaused())
+
+	// A stop command releases a paused consumer
+	mockC.Control() <- PluginControlPauseConsumer
+	mockC.Control() <- PluginControlStopConsumer
+	expect.NonBlocking(time.Second, func() { mockC.Enqueue([]byte("test")) })
+}
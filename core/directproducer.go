@@ -73,6 +73,12 @@ func (prod *DirectProducer) Enqueue(msg *Message, timeout time.Duration) {
 		return
 	}
 
+	if trace := msg.trace; trace != nil {
+		start := time.Now()
+		prod.onMessage(msg)
+		trace.enqueued(prod.id, start, MessageQueueOk)
+		return // ### return, traced ###
+	}
 	prod.onMessage(msg)
 }
 
@@ -20,6 +20,14 @@ type Formatter interface {
 	ApplyFormatter(msg *Message) error
 }
 
+// HeaderFormatter is implemented by formatters that require a header to be
+// written at the beginning of every file or object created by a producer,
+// e.g. the column names of a CSV file.
+type HeaderFormatter interface {
+	// GetHeader returns the header to write or nil if no header is required.
+	GetHeader() []byte
+}
+
 // FormatterArray is a type wrapper to []Formatter to make array of formatter
 type FormatterArray []Formatter
 
@@ -34,7 +34,7 @@ type LogConsumer struct {
 	lastCountWarn  int64
 	lastCountError int64
 	stopped        bool
-	queue          MessageQueue
+	queue          *MessageQueue
 }
 
 // Configure initializes this consumer with values from a plugin config.
@@ -84,21 +84,25 @@ func (cons *LogConsumer) Control() chan<- PluginControl {
 
 // Consume starts listening for control statements
 func (cons *LogConsumer) Consume(threads *sync.WaitGroup) {
-	// Wait for control statements
-	for {
-		select {
-		case msg := <-cons.queue:
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			msg, more := cons.queue.Pop()
+			if !more {
+				return // ### return, closed and empty ###
+			}
 			cons.logRouter.Enqueue(msg)
+		}
+	}()
 
-		case command := <-cons.control:
-			if command == PluginControlStopConsumer {
-				cons.queue.Close()
-				for msg := range cons.queue {
-					cons.logRouter.Enqueue(msg)
-				}
-				cons.stopped = true
-				return // ### return ###
-			}
+	// Wait for control statements
+	for {
+		if command := <-cons.control; command == PluginControlStopConsumer {
+			cons.queue.Close()
+			<-done
+			cons.stopped = true
+			return // ### return ###
 		}
 	}
 }
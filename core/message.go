@@ -16,7 +16,7 @@ package core
 
 import (
 	"github.com/golang/protobuf/proto"
-	"github.com/trivago/tgo/tcontainer"
+	"sync/atomic"
 	"time"
 )
 
@@ -30,19 +30,26 @@ type MessageData struct {
 
 // Message is a container used for storing the internal state of messages.
 // This struct is passed between consumers and producers.
+// Messages created by NewMessage or one of the clone functions are reference
+// counted. When the last reference is released, the payload buffers are
+// returned to MessageDataPool.
 type Message struct {
 	data         MessageData
 	orig         MessageData
 	prevStreamID MessageStreamID
 	source       MessageSource
 	timestamp    time.Time
+	refCount     int32
+	origShared   bool
+	trace        *messageTrace
+	traceDone    bool
 }
 
 var (
 	// MessageDataPool is the pool used for message payloads.
 	// This pool should be used to allocate temporary buffers for e.g.
 	// formatters.
-	MessageDataPool = tcontainer.NewBytePoolWithSize(2)
+	MessageDataPool = NewPayloadPool(2)
 )
 
 // NewMessage creates a new message from a given data stream by copying data.
@@ -53,6 +60,7 @@ func NewMessage(source MessageSource, data []byte, metadata Metadata, streamID M
 		source:       source,
 		prevStreamID: streamID,
 		timestamp:    time.Now(),
+		refCount:     1,
 	}
 
 	message.data.payload = buffer
@@ -78,6 +86,13 @@ func (msg *Message) GetCreationTime() time.Time {
 	return msg.timestamp
 }
 
+// SetCreationTime overwrites the time this message is associated with, e.g.
+// to use the time of the original event instead of the time the message was
+// received.
+func (msg *Message) SetCreationTime(timestamp time.Time) {
+	msg.timestamp = timestamp
+}
+
 // GetStreamID returns the stream this message is currently routed to.
 func (msg *Message) GetStreamID() MessageStreamID {
 	return msg.data.streamID
@@ -110,6 +125,12 @@ func (msg *Message) SetStreamID(streamID MessageStreamID) {
 	msg.data.streamID = streamID
 }
 
+// SetPrevStreamID overwrites the stream stored as the last "hop" of this
+// message without changing the current stream.
+func (msg *Message) SetPrevStreamID(streamID MessageStreamID) {
+	msg.prevStreamID = streamID
+}
+
 // GetSource returns the message's source (can be nil).
 func (msg *Message) GetSource() MessageSource {
 	return msg.source
@@ -130,6 +151,33 @@ func (msg *Message) GetMetadata() Metadata {
 	return msg.data.Metadata
 }
 
+// Retain adds a reference to this message. Every call to Retain has to be
+// matched by a call to Release.
+func (msg *Message) Retain() {
+	atomic.AddInt32(&msg.refCount, 1)
+}
+
+// Release gives up a reference to this message. When the last reference has
+// been released the payload buffers are returned to MessageDataPool.
+// Producers should release messages after they have been delivered.
+// A message must not be accessed after it has been released.
+func (msg *Message) Release() {
+	if atomic.AddInt32(&msg.refCount, -1) != 0 {
+		return // ### return, still referenced or not reference counted ###
+	}
+
+	msg.TraceDelivered()
+
+	if !msg.origShared {
+		MessageDataPool.Put(msg.orig.payload)
+	}
+	MessageDataPool.Put(msg.data.payload)
+
+	msg.data.payload = nil
+	msg.orig.payload = nil
+	msg.origShared = false
+}
+
 // StorePayload copies data into the hold data buffer. If the buffer can hold
 // data it is resized, otherwise a new buffer will be allocated.
 func (msg *Message) StorePayload(data []byte) {
@@ -140,6 +188,9 @@ func (msg *Message) StorePayload(data []byte) {
 // not guaranteed to be preserved. If content needs to be preserved use Extend.
 func (msg *Message) ResizePayload(size int) []byte {
 	switch {
+	case msg.origShared:
+		msg.origShared = false
+		msg.data.payload = MessageDataPool.Get(size)
 	case size == len(msg.data.payload):
 	case size <= cap(msg.data.payload):
 		msg.data.payload = msg.data.payload[:size]
@@ -154,6 +205,10 @@ func (msg *Message) ResizePayload(size int) []byte {
 // be preserved. If content does not need to be preserved use Resize.
 func (msg *Message) ExtendPayload(size int) []byte {
 	switch {
+	case msg.origShared:
+		msg.origShared = false
+		msg.data.payload = MessageDataPool.Get(size)
+		copy(msg.data.payload, msg.orig.payload)
 	case size == len(msg.data.payload):
 	case size <= cap(msg.data.payload):
 		msg.data.payload = msg.data.payload[:size]
@@ -170,9 +225,16 @@ func (msg *Message) ExtendPayload(size int) []byte {
 // The created timestamp is copied, too.
 func (msg *Message) Clone() *Message {
 	clone := *msg
+	clone.refCount = 1
+	clone.traceDone = false
+	clone.data.payload = getPayloadCopy(msg.data.payload)
 
-	clone.data.payload = MessageDataPool.Get(len(msg.data.payload))
-	copy(clone.data.payload, msg.data.payload)
+	switch {
+	case msg.origShared:
+		clone.orig.payload = clone.data.payload
+	case msg.orig.payload != nil:
+		clone.orig.payload = getPayloadCopy(msg.orig.payload)
+	}
 
 	return &clone
 }
@@ -181,20 +243,31 @@ func (msg *Message) Clone() *Message {
 // The created timestamp is copied, too.
 func (msg *Message) CloneOriginal() *Message {
 	clone := *msg
-
-	clone.data.payload = MessageDataPool.Get(len(msg.orig.payload))
-	copy(clone.data.payload, msg.orig.payload)
+	clone.refCount = 1
+	clone.traceDone = false
+	clone.data.payload = getPayloadCopy(msg.orig.payload)
+	clone.orig.payload = clone.data.payload
+	clone.origShared = true
 
 	clone.SetStreamID(msg.orig.streamID)
 
 	return &clone
 }
 
-// FreezeOriginal set the original data and freeze the message
+// FreezeOriginal set the original data and freeze the message.
+// The original payload is not copied until the current payload is changed
+// by StorePayload, ResizePayload or ExtendPayload, i.e. messages that are not
+// modified do not pay for keeping the original.
 func (msg *Message) FreezeOriginal() {
-	msg.orig.payload = getPayloadCopy(msg.data.payload)
+	msg.orig.payload = msg.data.payload
 	msg.orig.streamID = msg.data.streamID
-	msg.orig.Metadata = msg.data.Metadata.Clone()
+	msg.origShared = true
+
+	if len(msg.data.Metadata) > 0 {
+		msg.orig.Metadata = msg.data.Metadata.Clone()
+	} else {
+		msg.orig.Metadata = nil
+	}
 }
 
 // Serialize generates a new payload containing all data that can be preserved
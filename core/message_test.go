@@ -91,6 +91,32 @@ func TestMessageOriginalDataIntegrity(t *testing.T) {
 	expect.Equal(MessageStreamID(1), msg.prevStreamID)
 }
 
+func TestMessageOriginalCopyOnWrite(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	msgString := "Test for copy on write"
+
+	msg := NewMessage(nil, []byte(msgString), nil, 1)
+	msg.FreezeOriginal()
+
+	expect.True(msg.origShared)
+	expect.True(&msg.data.payload[0] == &msg.orig.payload[0])
+
+	payload := msg.ExtendPayload(len(msgString) + 2)
+	copy(payload[len(msgString):], " !")
+
+	expect.False(msg.origShared)
+	expect.Equal(msgString+" !", msg.String())
+	expect.Equal(msgString, string(msg.orig.payload))
+
+	// A clone must not share the original payload with its source
+	msgClone := msg.Clone()
+	msg.Release()
+
+	expect.Nil(msg.orig.payload)
+	expect.Equal(msgString, string(msgClone.orig.payload))
+	expect.Equal(msgString+" !", msgClone.String())
+}
+
 func TestMessageClone(t *testing.T) {
 	expect := ttesting.NewExpect(t)
 	msgString := "Test for clone"
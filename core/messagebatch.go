@@ -17,7 +17,7 @@ package core
 import (
 	"github.com/trivago/tgo"
 	"github.com/trivago/tgo/tmath"
-	"github.com/trivago/tgo/tsync"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -28,12 +28,25 @@ import (
 // called, i.e. if a timeout or size threshold has been reached.
 type MessageBatch struct {
 	queue     [2]messageBuffer
-	flushing  *tsync.WaitGroup
+	state     *messageBatchState
 	lastFlush *int64
 	activeSet *uint32
 	closed    *int32
 }
 
+// messageBatchState holds the condition variables used to park goroutines
+// waiting for a running flush, for writers still appending to a buffer that
+// is about to be flushed or for a buffer swap.
+type messageBatchState struct {
+	guard          sync.Mutex
+	flushDone      sync.Cond
+	writersDone    sync.Cond
+	swapped        sync.Cond
+	flushRunning   bool
+	swapCount      uint32
+	waitForWriters int32
+}
+
 type messageBuffer struct {
 	messages  []*Message
 	doneCount *uint32
@@ -55,13 +68,99 @@ func NewMessageBatch(maxMessageCount int) MessageBatch {
 	now := time.Now().Unix()
 	return MessageBatch{
 		queue:     [2]messageBuffer{newMessageBuffer(maxMessageCount), newMessageBuffer(maxMessageCount)},
-		flushing:  new(tsync.WaitGroup),
+		state:     newMessageBatchState(),
 		lastFlush: &now,
 		activeSet: new(uint32),
 		closed:    new(int32),
 	}
 }
 
+func newMessageBatchState() *messageBatchState {
+	state := new(messageBatchState)
+	state.flushDone.L = &state.guard
+	state.writersDone.L = &state.guard
+	state.swapped.L = &state.guard
+	return state
+}
+
+// beginFlush blocks until no other flush is running and marks a flush as
+// running.
+func (state *messageBatchState) beginFlush() {
+	state.guard.Lock()
+	defer state.guard.Unlock()
+
+	for state.flushRunning {
+		state.flushDone.Wait()
+	}
+	state.flushRunning = true
+}
+
+// endFlush marks the running flush as done and wakes up waiting flushes.
+func (state *messageBatchState) endFlush() {
+	state.guard.Lock()
+	defer state.guard.Unlock()
+
+	state.flushRunning = false
+	state.flushDone.Broadcast()
+}
+
+// waitForFlush blocks until no flush is running or the timeout passed.
+// A timeout of 0 waits without a timeout.
+func (state *messageBatchState) waitForFlush(timeout time.Duration) bool {
+	state.guard.Lock()
+	defer state.guard.Unlock()
+
+	return waitUntil(&state.flushDone, timeout, func() bool { return !state.flushRunning })
+}
+
+// writerDone wakes up a flush waiting for writers if there is one
+func (state *messageBatchState) writerDone() {
+	if atomic.LoadInt32(&state.waitForWriters) != 0 {
+		state.guard.Lock()
+		defer state.guard.Unlock()
+		state.writersDone.Broadcast()
+	}
+}
+
+// waitForWritersOf blocks until the given number of writers finished writing
+// to a buffer.
+func (state *messageBatchState) waitForWritersOf(buffer *messageBuffer, writerCount uint32) {
+	atomic.StoreInt32(&state.waitForWriters, 1)
+	defer atomic.StoreInt32(&state.waitForWriters, 0)
+
+	state.guard.Lock()
+	defer state.guard.Unlock()
+
+	for writerCount != atomic.LoadUint32(buffer.doneCount) {
+		state.writersDone.Wait()
+	}
+}
+
+// getSwapCount returns the number of buffer swaps done so far
+func (state *messageBatchState) getSwapCount() uint32 {
+	return atomic.LoadUint32(&state.swapCount)
+}
+
+// notifySwap wakes up all writers waiting for a buffer swap. This is also
+// called when the batch is closed.
+func (state *messageBatchState) notifySwap() {
+	state.guard.Lock()
+	defer state.guard.Unlock()
+
+	atomic.AddUint32(&state.swapCount, 1)
+	state.swapped.Broadcast()
+}
+
+// waitForSwap blocks until the number of swaps differs from the given one
+func (state *messageBatchState) waitForSwap(swapCount uint32) {
+	state.guard.Lock()
+	defer state.guard.Unlock()
+
+	for swapCount == atomic.LoadUint32(&state.swapCount) {
+		state.swapped.Wait()
+	}
+}
+
 func newMessageBuffer(maxMessageCount int) messageBuffer {
 	return messageBuffer{
 		messages:  make([]*Message, maxMessageCount),
@@ -95,7 +194,10 @@ func (batch *MessageBatch) Append(msg *Message) bool {
 
 	// We mark the message as written even if the write fails so that flush
 	// does not block after a failed message.
-	defer func() { atomic.AddUint32(activeQueue.doneCount, 1) }()
+	defer func() {
+		atomic.AddUint32(activeQueue.doneCount, 1)
+		batch.state.writerDone()
+	}()
 
 	if ticketIdx >= uint32(len(activeQueue.messages)) {
 		return false // ### return, queue is full ###
@@ -106,14 +208,15 @@ func (batch *MessageBatch) Append(msg *Message) bool {
 }
 
 // AppendOrBlock works like Append but will block until Append returns true.
+// The call is parked until the next flush swaps the buffers.
 // If the batch was closed during this call, false is returned.
 func (batch *MessageBatch) AppendOrBlock(msg *Message) bool {
-	spin := tsync.NewSpinner(tsync.SpinPriorityMedium)
 	for !batch.IsClosed() {
+		swapCount := batch.state.getSwapCount()
 		if batch.Append(msg) {
 			return true // ### return, success ###
 		}
-		spin.Yield()
+		batch.state.waitForSwap(swapCount)
 	}
 
 	return false
@@ -142,6 +245,7 @@ func (batch *MessageBatch) Touch() {
 // Timeout is passed to WaitForFlush.
 func (batch *MessageBatch) Close(assemble AssemblyFunc, timeout time.Duration) {
 	atomic.StoreInt32(batch.closed, 1)
+	batch.state.notifySwap()
 	batch.Flush(assemble)
 	batch.WaitForFlush(timeout)
 }
@@ -169,24 +273,22 @@ func (batch *MessageBatch) Flush(assemble AssemblyFunc) {
 	}
 
 	// Only one flush at a time
-	batch.flushing.IncWhenDone()
+	batch.state.beginFlush()
 
 	// Switch the buffers so writers can go on writing
 	flushSet := atomic.SwapUint32(batch.activeSet, (atomic.LoadUint32(batch.activeSet)&messageBatchIndexMask)^messageBatchIndexMask)
+	batch.state.notifySwap()
 
 	flushIdx := flushSet >> messageBatchIndexShift
 	writerCount := flushSet & messageBatchCountMask
 	flushQueue := &batch.queue[flushIdx]
-	spin := tsync.NewSpinner(tsync.SpinPriorityHigh)
 
 	// Wait for remaining writers to finish
-	for writerCount != atomic.LoadUint32(flushQueue.doneCount) {
-		spin.Yield()
-	}
+	batch.state.waitForWritersOf(flushQueue, writerCount)
 
 	// Write data and reset buffer asynchronously
 	go tgo.WithRecoverShutdown(func() {
-		defer batch.flushing.Done()
+		defer batch.state.endFlush()
 
 		messageCount := tmath.MinI(int(writerCount), len(flushQueue.messages))
 		assemble(flushQueue.messages[:messageCount])
@@ -199,8 +301,8 @@ func (batch *MessageBatch) Flush(assemble AssemblyFunc) {
 // It also blocks any flush during the execution of callback.
 // Returns the error returned by callback
 func (batch *MessageBatch) AfterFlushDo(callback func() error) error {
-	batch.flushing.IncWhenDone()
-	defer batch.flushing.Done()
+	batch.state.beginFlush()
+	defer batch.state.endFlush()
 	return callback()
 }
 
@@ -208,7 +310,7 @@ func (batch *MessageBatch) AfterFlushDo(callback func() error) error {
 // Passing a timeout > 0 will unblock this function after the given duration at
 // the latest.
 func (batch *MessageBatch) WaitForFlush(timeout time.Duration) {
-	batch.flushing.WaitFor(timeout)
+	batch.state.waitForFlush(timeout)
 }
 
 // IsEmpty returns true if no data is stored in the front buffer, i.e. if no data
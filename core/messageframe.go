@@ -0,0 +1,202 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// MessageFrameHeaderSize is the number of bytes in front of each framed
+// record: a 4 byte big endian length followed by a 4 byte big endian CRC32C
+// (Castagnoli) checksum of the record.
+const MessageFrameHeaderSize = 8
+
+// MaxMessageFrameSize is the maximum size of a framed record. Larger length
+// prefixes are treated as corruption.
+const MaxMessageFrameSize = 1 << 26
+
+var (
+	// ErrMessageFrameTruncated is returned if a record is incomplete
+	ErrMessageFrameTruncated = errors.New("Message frame is truncated")
+	// ErrMessageFrameSize is returned if a record exceeds MaxMessageFrameSize
+	ErrMessageFrameSize = errors.New("Message frame exceeds maximum size")
+	// ErrMessageFrameChecksum is returned if a record's checksum does not match
+	ErrMessageFrameChecksum = errors.New("Message frame checksum mismatch")
+	// ErrMessageFrameEmpty is returned if a record has a length of 0
+	ErrMessageFrameEmpty = errors.New("Message frame is empty")
+)
+
+var messageFrameCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// EncodeMessageFrame returns the given record prefixed by its length and
+// checksum. The record is typically the result of Message.Serialize.
+// Empty records cannot be decoded as their frame cannot be distinguished from
+// zero-filled data, e.g. preallocated space left after a crash.
+func EncodeMessageFrame(record []byte) []byte {
+	frame := make([]byte, MessageFrameHeaderSize+len(record))
+	binary.BigEndian.PutUint32(frame[0:], uint32(len(record)))
+	binary.BigEndian.PutUint32(frame[4:], crc32.Checksum(record, messageFrameCRCTable))
+	copy(frame[MessageFrameHeaderSize:], record)
+	return frame
+}
+
+// DecodeMessageFrame parses the frame at the start of data. It returns the
+// record and the total number of bytes used by the frame. The record
+// references data, i.e. it is not copied.
+func DecodeMessageFrame(data []byte) ([]byte, int, error) {
+	if len(data) < MessageFrameHeaderSize {
+		return nil, 0, ErrMessageFrameTruncated
+	}
+
+	length := binary.BigEndian.Uint32(data[0:])
+	switch {
+	case length == 0:
+		return nil, 0, ErrMessageFrameEmpty
+	case length > MaxMessageFrameSize:
+		return nil, 0, ErrMessageFrameSize
+	}
+
+	frameSize := MessageFrameHeaderSize + int(length)
+	if len(data) < frameSize {
+		return nil, 0, ErrMessageFrameTruncated
+	}
+
+	record := data[MessageFrameHeaderSize:frameSize]
+	if crc32.Checksum(record, messageFrameCRCTable) != binary.BigEndian.Uint32(data[4:]) {
+		return nil, 0, ErrMessageFrameChecksum
+	}
+
+	return record, frameSize, nil
+}
+
+// MessageFrameReader reads framed records from a stream.
+// If Recover is set, corrupt or truncated records are skipped instead of
+// returning an error. Reading continues at the next position that contains a
+// valid record.
+type MessageFrameReader struct {
+	reader    io.Reader
+	buffer    []byte
+	start     int
+	end       int
+	eof       bool
+	resyncing bool
+	corrupt   int
+	skipped   int64
+	Recover   bool
+}
+
+// NewMessageFrameReader creates a new reader with the given initial buffer
+// size. The buffer grows if a record does not fit.
+func NewMessageFrameReader(reader io.Reader, bufferSize int, recoverCorrupt bool) *MessageFrameReader {
+	if bufferSize < MessageFrameHeaderSize {
+		bufferSize = MessageFrameHeaderSize
+	}
+	return &MessageFrameReader{
+		reader:  reader,
+		buffer:  make([]byte, bufferSize),
+		Recover: recoverCorrupt,
+	}
+}
+
+// Corrupt returns the number of corrupt sections skipped so far. A series of
+// consecutive damaged bytes is counted once.
+func (r *MessageFrameReader) Corrupt() int {
+	return r.corrupt
+}
+
+// Skipped returns the number of bytes skipped because of corruption
+func (r *MessageFrameReader) Skipped() int64 {
+	return r.skipped
+}
+
+// Next returns the next valid record or io.EOF if the stream is exhausted.
+// The returned slice is only valid until the next call to Next.
+func (r *MessageFrameReader) Next() ([]byte, error) {
+	for {
+		if err := r.fill(MessageFrameHeaderSize); err != nil {
+			return nil, err
+		}
+
+		available := r.end - r.start
+		if available == 0 {
+			return nil, io.EOF // ### return, done ###
+		}
+
+		record, frameSize, err := DecodeMessageFrame(r.buffer[r.start:r.end])
+		if err == ErrMessageFrameTruncated && !r.eof {
+			required := MessageFrameHeaderSize
+			if available >= MessageFrameHeaderSize {
+				required += int(binary.BigEndian.Uint32(r.buffer[r.start:]))
+			}
+			if err := r.fill(required); err != nil {
+				return nil, err
+			}
+			continue // ### continue, retry with more data ###
+		}
+
+		if err != nil {
+			if !r.Recover {
+				return nil, err // ### return, corrupt record ###
+			}
+			if !r.resyncing {
+				r.corrupt++
+				r.resyncing = true
+			}
+			r.start++
+			r.skipped++
+			continue // ### continue, search next valid record ###
+		}
+
+		r.resyncing = false
+		r.start += frameSize
+		return record, nil
+	}
+}
+
+// fill makes sure that at least size bytes are buffered or the end of the
+// stream has been reached.
+func (r *MessageFrameReader) fill(size int) error {
+	if r.end-r.start >= size || r.eof {
+		return nil
+	}
+
+	// Move data to the front and grow if necessary
+	if r.start > 0 {
+		copy(r.buffer, r.buffer[r.start:r.end])
+		r.end -= r.start
+		r.start = 0
+	}
+	if len(r.buffer) < size {
+		buffer := make([]byte, size)
+		copy(buffer, r.buffer[:r.end])
+		r.buffer = buffer
+	}
+
+	for r.end < size {
+		n, err := r.reader.Read(r.buffer[r.end:])
+		r.end += n
+		if err == io.EOF {
+			r.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestMessageFrameEncodeDecode(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	frame := EncodeMessageFrame([]byte("test"))
+	expect.Equal(MessageFrameHeaderSize+4, len(frame))
+
+	record, size, err := DecodeMessageFrame(frame)
+	expect.NoError(err)
+	expect.Equal("test", string(record))
+	expect.Equal(len(frame), size)
+
+	_, _, err = DecodeMessageFrame(frame[:len(frame)-1])
+	expect.Equal(ErrMessageFrameTruncated, err)
+
+	frame[MessageFrameHeaderSize] = 'b'
+	_, _, err = DecodeMessageFrame(frame)
+	expect.Equal(ErrMessageFrameChecksum, err)
+
+	frame[0] = 0xFF
+	_, _, err = DecodeMessageFrame(frame)
+	expect.Equal(ErrMessageFrameSize, err)
+
+	// CRC32C of an empty record is 0, i.e. zeros would decode as a valid frame
+	_, _, err = DecodeMessageFrame(make([]byte, MessageFrameHeaderSize))
+	expect.Equal(ErrMessageFrameEmpty, err)
+}
+
+func TestMessageFrameReader(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	data := bytes.NewBuffer(nil)
+	data.Write(EncodeMessageFrame([]byte("first")))
+	data.Write(EncodeMessageFrame(bytes.Repeat([]byte("x"), 100)))
+	data.Write(EncodeMessageFrame([]byte("third")))
+
+	// Small buffer forces the reader to grow
+	reader := NewMessageFrameReader(bytes.NewReader(data.Bytes()), 4, false)
+	records := []string{}
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		expect.NoError(err)
+		records = append(records, string(record))
+	}
+
+	expect.Equal(3, len(records))
+	expect.Equal("first", records[0])
+	expect.Equal(100, len(records[1]))
+	expect.Equal("third", records[2])
+	expect.Equal(0, reader.Corrupt())
+}
+
+func TestMessageFrameReaderRecover(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	data := bytes.NewBuffer(nil)
+	data.Write(EncodeMessageFrame([]byte("first")))
+	data.Write(EncodeMessageFrame([]byte("second")))
+	data.Write(EncodeMessageFrame([]byte("third")))
+	data.Write(EncodeMessageFrame([]byte("torn write"))[:10])
+
+	damaged := data.Bytes()
+	damaged[len(EncodeMessageFrame([]byte("first")))+MessageFrameHeaderSize] = 'S'
+
+	// Without recovery the first corrupt record stops reading
+	reader := NewMessageFrameReader(bytes.NewReader(damaged), 64, false)
+	record, err := reader.Next()
+	expect.NoError(err)
+	expect.Equal("first", string(record))
+	_, err = reader.Next()
+	expect.Equal(ErrMessageFrameChecksum, err)
+
+	// With recovery corrupt and incomplete records are skipped
+	reader = NewMessageFrameReader(bytes.NewReader(damaged), 64, true)
+	records := []string{}
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		expect.NoError(err)
+		records = append(records, string(record))
+	}
+
+	expect.Equal([]string{"first", "third"}, records)
+	expect.Equal(2, reader.Corrupt())
+	expect.Equal(int64(len(EncodeMessageFrame([]byte("second")))+10), reader.Skipped())
+}
+
+func TestMessageFrameReaderZeroFilled(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	data := bytes.NewBuffer(nil)
+	data.Write(EncodeMessageFrame([]byte("first")))
+	data.Write(make([]byte, 32))
+	data.Write(EncodeMessageFrame([]byte("second")))
+	data.Write(make([]byte, 64))
+
+	reader := NewMessageFrameReader(bytes.NewReader(data.Bytes()), 16, true)
+	records := []string{}
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		expect.NoError(err)
+		records = append(records, string(record))
+	}
+
+	// Each zero-filled section is counted once
+	expect.Equal([]string{"first", "second"}, records)
+	expect.Equal(2, reader.Corrupt())
+	expect.Equal(int64(32+64), reader.Skipped())
+}
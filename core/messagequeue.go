@@ -15,12 +15,28 @@
 package core
 
 import (
-	"github.com/trivago/tgo/tsync"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// MessageQueue is the type used for transferring messages between plugins
-type MessageQueue chan *Message
+// MessageQueue is the type used for transferring messages between plugins.
+// It is a fixed size ring buffer that can be used by multiple readers and
+// writers at the same time. Readers waiting for messages and writers waiting
+// for free space are parked until they can continue instead of polling the
+// queue.
+type MessageQueue struct {
+	guard          sync.Mutex
+	notEmpty       sync.Cond
+	notFull        sync.Cond
+	messages       []*Message
+	capacity       int
+	head           int
+	numQueued      int32
+	waitingReaders int
+	waitingWriters int
+	closed         bool
+}
 
 // MessageQueueResult is used as a return value for the Enqueue method
 type MessageQueueResult int
@@ -34,9 +50,35 @@ const (
 	MessageQueueDiscard = MessageQueueResult(iota)
 )
 
-// NewMessageQueue creates a new message buffer of the given capacity
-func NewMessageQueue(capacity int) MessageQueue {
-	return make(MessageQueue, capacity)
+// String returns a human readable name of the result
+func (result MessageQueueResult) String() string {
+	switch result {
+	case MessageQueueOk:
+		return "ok"
+	case MessageQueueTimeout:
+		return "timeout"
+	case MessageQueueDiscard:
+		return "discard"
+	default:
+		return "unknown"
+	}
+}
+
+// NewMessageQueue creates a new message buffer of the given capacity.
+// A queue with a capacity of 0 only accepts messages if a reader is waiting.
+func NewMessageQueue(capacity int) *MessageQueue {
+	bufferSize := capacity
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+
+	queue := &MessageQueue{
+		messages: make([]*Message, bufferSize),
+		capacity: capacity,
+	}
+	queue.notEmpty.L = &queue.guard
+	queue.notFull.L = &queue.guard
+	return queue
 }
 
 // Push adds a message to the MessageStream.
@@ -47,82 +89,192 @@ func NewMessageQueue(capacity int) MessageQueue {
 // consumer exists.
 // The source parameter is used when a message is sent to the fallback, i.e. it is passed
 // to the Drop function.
-func (channel MessageQueue) Push(msg *Message, timeout time.Duration) (state MessageQueueResult) {
-	defer func() {
-		// Treat closed channels like timeouts
-		if recover() != nil {
-			state = MessageQueueTimeout
-		}
-	}()
+func (queue *MessageQueue) Push(msg *Message, timeout time.Duration) MessageQueueResult {
+	queue.guard.Lock()
+	defer queue.guard.Unlock()
 
-	if timeout == 0 {
-		channel <- msg
-		return MessageQueueOk // ### return, done ###
+	// Treat closed queues like timeouts
+	if queue.closed {
+		return MessageQueueTimeout // ### return, closed ###
 	}
 
-	start := time.Time{}
-	spin := tsync.Spinner{}
-	for {
-		select {
-		case channel <- msg:
-			return MessageQueueOk // ### return, done ###
-
-		default:
-			switch {
-			// Start timeout based retries
-			case start.IsZero():
-				if timeout < 0 {
-					return MessageQueueDiscard // ### return, discard and ignore ###
-				}
-				start = time.Now()
-				spin = tsync.NewSpinner(tsync.SpinPriorityHigh)
-
-			// Discard message after timeout
-			case time.Since(start) > timeout:
-				return MessageQueueTimeout // ### return, fallback ###
-
-			// Yield and try again
-			default:
-				spin.Yield()
-			}
+	if !queue.canPush() {
+		if timeout < 0 {
+			return MessageQueueDiscard // ### return, discard and ignore ###
+		}
+
+		queue.waitingWriters++
+		waitUntil(&queue.notFull, timeout, queue.canPushOrClosed)
+		queue.waitingWriters--
+
+		if queue.closed || !queue.canPush() {
+			return MessageQueueTimeout // ### return, fallback ###
 		}
 	}
+
+	numQueued := int(atomic.LoadInt32(&queue.numQueued))
+	queue.messages[(queue.head+numQueued)%len(queue.messages)] = msg
+	atomic.AddInt32(&queue.numQueued, 1)
+
+	if queue.waitingReaders > 0 {
+		queue.notEmpty.Signal()
+	}
+	return MessageQueueOk
+}
+
+// canPush returns true if there is space for a message in the queue.
+// The queue's guard must be locked when calling this function.
+func (queue *MessageQueue) canPush() bool {
+	numQueued := int(atomic.LoadInt32(&queue.numQueued))
+	if queue.capacity == 0 {
+		return numQueued == 0 && queue.waitingReaders > 0
+	}
+	return numQueued < queue.capacity
+}
+
+// canPushOrClosed returns true if a waiting writer can continue.
+// The queue's guard must be locked when calling this function.
+func (queue *MessageQueue) canPushOrClosed() bool {
+	return queue.closed || queue.canPush()
+}
+
+// canPop returns true if a message can be read or if the queue has been
+// closed. The queue's guard must be locked when calling this function.
+func (queue *MessageQueue) canPop() bool {
+	return queue.closed || atomic.LoadInt32(&queue.numQueued) > 0
 }
 
 // IsEmpty returns true if no element is currently stored in the channel.
 // Please note that this information can be extremely volatile in multithreaded
 // environments.
-func (channel MessageQueue) IsEmpty() bool {
-	return len(channel) == 0
+func (queue *MessageQueue) IsEmpty() bool {
+	return queue.GetNumQueued() == 0
 }
 
 // GetNumQueued returns the number of queued messages.
 // Please note that this information can be extremely volatile in multithreaded
 // environments.
-func (channel MessageQueue) GetNumQueued() int {
-	return len(channel)
+func (queue *MessageQueue) GetNumQueued() int {
+	return int(atomic.LoadInt32(&queue.numQueued))
+}
+
+// Cap returns the number of messages the queue can store
+func (queue *MessageQueue) Cap() int {
+	return queue.capacity
 }
 
 // PopWithTimeout returns a message from the buffer with a runtime <= maxDuration.
 // If the channel is empty or the timout hit, the second return value is false.
-func (channel MessageQueue) PopWithTimeout(maxDuration time.Duration) (*Message, bool) {
-	timeout := time.NewTimer(maxDuration)
-	select {
-	case msg, more := <-channel:
-		timeout.Stop()
-		return msg, more
-	case <-timeout.C:
-		return nil, false
+func (queue *MessageQueue) PopWithTimeout(maxDuration time.Duration) (*Message, bool) {
+	if maxDuration <= 0 {
+		maxDuration = -1 // do not block
+	}
+
+	var msg [1]*Message
+	count, _ := queue.PopBatch(msg[:], maxDuration)
+	return msg[0], count > 0
+}
+
+// Pop returns a message from the buffer. This call blocks until a message is
+// available. If the queue has been closed and is empty, the second return
+// value is false.
+func (queue *MessageQueue) Pop() (*Message, bool) {
+	var msg [1]*Message
+	count, _ := queue.PopBatch(msg[:], 0)
+	return msg[0], count > 0
+}
+
+// PopBatch moves up to len(buffer) messages from the queue to the given
+// buffer and returns the number of messages moved. Reusing the buffer
+// allows producers to work off a queue without allocations.
+// The call blocks until at least one message is available or the timeout
+// passed. Passing a timeout of 0 will always block, passing a timeout of -1
+// will never block.
+// The second return value is false if the queue has been closed and is empty.
+func (queue *MessageQueue) PopBatch(buffer []*Message, timeout time.Duration) (int, bool) {
+	queue.guard.Lock()
+	defer queue.guard.Unlock()
+
+	if !queue.canPop() && timeout >= 0 {
+		queue.waitingReaders++
+		if queue.capacity == 0 && queue.waitingWriters > 0 {
+			queue.notFull.Signal()
+		}
+		waitUntil(&queue.notEmpty, timeout, queue.canPop)
+		queue.waitingReaders--
+	}
+
+	numQueued := int(atomic.LoadInt32(&queue.numQueued))
+	if numQueued == 0 {
+		return 0, !queue.closed // ### return, timeout or closed ###
+	}
+
+	count := len(buffer)
+	if count > numQueued {
+		count = numQueued
+	}
+
+	bufferSize := len(queue.messages)
+	for i := 0; i < count; i++ {
+		buffer[i] = queue.messages[queue.head]
+		queue.messages[queue.head] = nil
+		queue.head = (queue.head + 1) % bufferSize
+	}
+	atomic.AddInt32(&queue.numQueued, -int32(count))
+
+	switch {
+	case queue.waitingWriters == 0:
+	case count == 1:
+		queue.notFull.Signal()
+	default:
+		queue.notFull.Broadcast()
 	}
+	return count, true
+}
+
+// Close stops the buffer from being able to receive messages. Messages
+// still stored in the queue can be read until the queue is empty.
+func (queue *MessageQueue) Close() {
+	queue.guard.Lock()
+	defer queue.guard.Unlock()
+
+	queue.closed = true
+	queue.notEmpty.Broadcast()
+	queue.notFull.Broadcast()
 }
 
-// Pop returns a message from the buffer
-func (channel MessageQueue) Pop() (*Message, bool) {
-	msg, more := <-channel
-	return msg, more
+// IsClosed returns true if Close has been called.
+func (queue *MessageQueue) IsClosed() bool {
+	queue.guard.Lock()
+	defer queue.guard.Unlock()
+	return queue.closed
 }
 
-// Close stops the buffer from being able to receive messages
-func (channel MessageQueue) Close() {
-	close(channel)
+// waitUntil waits on the given condition until isDone returns true or the
+// given timeout passed. A timeout of 0 waits without a timeout. The lock of
+// the condition has to be held when calling this function. The result of
+// isDone is returned.
+func waitUntil(cond *sync.Cond, timeout time.Duration, isDone func() bool) bool {
+	if timeout <= 0 {
+		for !isDone() {
+			cond.Wait()
+		}
+		return true // ### return, done ###
+	}
+
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		cond.L.Lock()
+		defer cond.L.Unlock()
+		cond.Broadcast()
+	})
+	defer timer.Stop()
+
+	for !isDone() {
+		if !time.Now().Before(deadline) {
+			return false // ### return, timeout ###
+		}
+		cond.Wait()
+	}
+	return true
 }
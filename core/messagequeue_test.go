@@ -0,0 +1,258 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"github.com/trivago/tgo/tsync"
+	"github.com/trivago/tgo/ttesting"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMessageQueuePopBatch(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	queue := NewMessageQueue(4)
+	buffer := make([]*Message, 3)
+
+	count, more := queue.PopBatch(buffer, -1)
+	expect.Equal(0, count)
+	expect.True(more)
+
+	for _, data := range []string{"a", "b", "c", "d"} {
+		expect.Equal(MessageQueueOk, queue.Push(getMockMessage(data), -1))
+	}
+	expect.Equal(MessageQueueDiscard, queue.Push(getMockMessage("e"), -1))
+
+	count, more = queue.PopBatch(buffer, 0)
+	expect.Equal(3, count)
+	expect.True(more)
+	expect.Equal("a", buffer[0].String())
+	expect.Equal("c", buffer[2].String())
+
+	// Wrap around the end of the ring
+	expect.Equal(MessageQueueOk, queue.Push(getMockMessage("e"), -1))
+	count, _ = queue.PopBatch(buffer, 0)
+	expect.Equal(2, count)
+	expect.Equal("d", buffer[0].String())
+	expect.Equal("e", buffer[1].String())
+	expect.True(queue.IsEmpty())
+
+	count, more = queue.PopBatch(buffer, 10*time.Millisecond)
+	expect.Equal(0, count)
+	expect.True(more)
+}
+
+func TestMessageQueueClose(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	queue := NewMessageQueue(1)
+
+	expect.Equal(MessageQueueOk, queue.Push(getMockMessage("a"), 0))
+
+	// A blocked writer is released by Close
+	result := make(chan MessageQueueResult)
+	go func() {
+		result <- queue.Push(getMockMessage("b"), 0)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	queue.Close()
+
+	select {
+	case res := <-result:
+		expect.Equal(MessageQueueTimeout, res)
+	case <-time.After(time.Second):
+		t.Error("Push did not return after Close")
+	}
+
+	// Remaining messages can still be read
+	msg, more := queue.Pop()
+	expect.True(more)
+	expect.Equal("a", msg.String())
+
+	_, more = queue.Pop()
+	expect.False(more)
+	expect.Equal(MessageQueueTimeout, queue.Push(getMockMessage("c"), -1))
+}
+
+// spinMessageQueue is the spinning, channel based queue MessageQueue replaced.
+// It is kept as a baseline for the benchmarks below.
+type spinMessageQueue chan *Message
+
+func (channel spinMessageQueue) Push(msg *Message, timeout time.Duration) MessageQueueResult {
+	start := time.Time{}
+	spin := tsync.Spinner{}
+	for {
+		select {
+		case channel <- msg:
+			return MessageQueueOk
+
+		default:
+			switch {
+			case start.IsZero():
+				if timeout < 0 {
+					return MessageQueueDiscard
+				}
+				start = time.Now()
+				spin = tsync.NewSpinner(tsync.SpinPriorityHigh)
+
+			case time.Since(start) > timeout:
+				return MessageQueueTimeout
+
+			default:
+				spin.Yield()
+			}
+		}
+	}
+}
+
+func (channel spinMessageQueue) Pop() (*Message, bool) {
+	msg, more := <-channel
+	return msg, more
+}
+
+type benchmarkedQueue interface {
+	Push(msg *Message, timeout time.Duration) MessageQueueResult
+	Pop() (*Message, bool)
+}
+
+// benchmarkQueueThroughput sends b.N messages from multiple writers through
+// a queue that is read by a single reader.
+func benchmarkQueueThroughput(b *testing.B, queue benchmarkedQueue, numWriters int) {
+	msg := NewMessage(nil, []byte("benchmark"), nil, InvalidStreamID)
+	perWriter := b.N/numWriters + 1
+	writers := new(sync.WaitGroup)
+	writers.Add(numWriters)
+
+	b.ResetTimer()
+	startCPU := getCPUTime()
+
+	for w := 0; w < numWriters; w++ {
+		go func() {
+			defer writers.Done()
+			for i := 0; i < perWriter; i++ {
+				queue.Push(msg, time.Second)
+			}
+		}()
+	}
+	for i := 0; i < perWriter*numWriters; i++ {
+		queue.Pop()
+	}
+	writers.Wait()
+
+	b.StopTimer()
+	logCPUTime(b, startCPU, perWriter*numWriters)
+}
+
+// benchmarkQueueBlocked measures the costs of a writer waiting for a full
+// queue until its timeout.
+func benchmarkQueueBlocked(b *testing.B, queue benchmarkedQueue) {
+	msg := NewMessage(nil, []byte("benchmark"), nil, InvalidStreamID)
+	queue.Push(msg, -1)
+
+	b.ResetTimer()
+	startCPU := getCPUTime()
+
+	for i := 0; i < b.N; i++ {
+		queue.Push(msg, time.Millisecond)
+	}
+
+	b.StopTimer()
+	logCPUTime(b, startCPU, b.N)
+}
+
+func BenchmarkMessageQueueThroughput(b *testing.B) {
+	benchmarkQueueThroughput(b, NewMessageQueue(64), 4)
+}
+
+func BenchmarkSpinMessageQueueThroughput(b *testing.B) {
+	benchmarkQueueThroughput(b, make(spinMessageQueue, 64), 4)
+}
+
+func BenchmarkMessageQueueBlocked(b *testing.B) {
+	benchmarkQueueBlocked(b, NewMessageQueue(1))
+}
+
+func BenchmarkSpinMessageQueueBlocked(b *testing.B) {
+	benchmarkQueueBlocked(b, make(spinMessageQueue, 1))
+}
+
+func BenchmarkMessageQueuePopBatch(b *testing.B) {
+	queue := NewMessageQueue(64)
+	msg := NewMessage(nil, []byte("benchmark"), nil, InvalidStreamID)
+	numWriters := 4
+	perWriter := b.N/numWriters + 1
+	writers := new(sync.WaitGroup)
+	writers.Add(numWriters)
+
+	b.ResetTimer()
+	startCPU := getCPUTime()
+
+	for w := 0; w < numWriters; w++ {
+		go func() {
+			defer writers.Done()
+			for i := 0; i < perWriter; i++ {
+				queue.Push(msg, time.Second)
+			}
+		}()
+	}
+
+	buffer := make([]*Message, 32)
+	for received := 0; received < perWriter*numWriters; {
+		count, _ := queue.PopBatch(buffer, 0)
+		received += count
+	}
+	writers.Wait()
+
+	b.StopTimer()
+	logCPUTime(b, startCPU, perWriter*numWriters)
+}
+
+func BenchmarkMessageBatchAppendOrFlush(b *testing.B) {
+	batch := NewMessageBatch(64)
+	msg := NewMessage(nil, []byte("benchmark"), nil, InvalidStreamID)
+	numWriters := 4
+	perWriter := b.N/numWriters + 1
+	writers := new(sync.WaitGroup)
+	writers.Add(numWriters)
+
+	flush := func() { batch.Flush(func([]*Message) {}) }
+	canBlock := func() bool { return true }
+	fallback := func(*Message) {}
+
+	b.ResetTimer()
+	startCPU := getCPUTime()
+
+	for w := 0; w < numWriters; w++ {
+		go func() {
+			defer writers.Done()
+			for i := 0; i < perWriter; i++ {
+				batch.AppendOrFlush(msg, flush, canBlock, fallback)
+			}
+		}()
+	}
+	writers.Wait()
+	batch.Close(func([]*Message) {}, time.Second)
+
+	b.StopTimer()
+	logCPUTime(b, startCPU, perWriter*numWriters)
+}
+
+// logCPUTime logs the CPU time used per operation. As operations run in
+// parallel this can be larger than ns/op.
+func logCPUTime(b *testing.B, startCPU time.Duration, numOps int) {
+	if cpuTime := getCPUTime() - startCPU; cpuTime > 0 && numOps > 0 {
+		b.Logf("%d ops, %d cpu-ns/op", numOps, int64(cpuTime)/int64(numOps))
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/trivago/tgo"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Names of the spans recorded for traced messages
+const (
+	// TraceSpanConsume covers the time from creating a message in a consumer
+	// until it has been passed to all routers.
+	TraceSpanConsume = "consume"
+	// TraceSpanModulate is recorded for every modulator applied.
+	TraceSpanModulate = "modulate"
+	// TraceSpanRoute is recorded when a router decided what to do with a
+	// message.
+	TraceSpanRoute = "route"
+	// TraceSpanEnqueue is recorded when a producer accepted or rejected a
+	// message.
+	TraceSpanEnqueue = "enqueue"
+	// TraceSpanFallback is recorded when a message is sent to a fallback.
+	TraceSpanFallback = "fallback"
+	// TraceSpanDiscard is recorded when a message is discarded.
+	TraceSpanDiscard = "discard"
+	// TraceSpanDeliver is recorded when a producer delivered a message, i.e.
+	// called TraceDelivered or Release. It covers the time since the message
+	// has been created.
+	TraceSpanDeliver = "deliver"
+)
+
+const (
+	metricTraceSpans        = "Traces:Spans"
+	metricTraceSpansDropped = "Traces:SpansDropped"
+)
+
+// TraceSpan is a single step of a traced message. Spans are sent as JSON to
+// the _GOLLUM_TRACE_ stream.
+type TraceSpan struct {
+	TraceID    string            `json:"traceId"`
+	SpanID     string            `json:"spanId"`
+	ParentID   string            `json:"parentId,omitempty"`
+	Name       string            `json:"name"`
+	StartUs    int64             `json:"startUs"`
+	DurationUs int64             `json:"durationUs"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+// messageTrace is shared by a traced message and all of its clones
+type messageTrace struct {
+	traceID    string
+	rootSpanID string
+	start      time.Time
+}
+
+// traceSampler decides which messages created by a consumer are traced.
+type traceSampler struct {
+	sampleRate  int64  `config:"Trace/SampleRate" default:"0"`
+	metadataKey string `config:"Trace/MetadataKey"`
+	count       *int64
+}
+
+// spanQueueSize is the number of spans buffered before spans are dropped
+const spanQueueSize = 4096
+
+var (
+	spanQueue      = NewMessageQueue(spanQueueSize)
+	spanRouterOnce = new(sync.Once)
+)
+
+// newTraceID returns a random, hex encoded ID of the given number of bytes
+func newTraceID(numBytes int) string {
+	id := make([]byte, numBytes)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// newMessageTrace creates a new trace starting at the given time
+func newMessageTrace(start time.Time) *messageTrace {
+	return &messageTrace{
+		traceID:    newTraceID(16),
+		rootSpanID: newTraceID(8),
+		start:      start,
+	}
+}
+
+// init prepares the sampler after the config values have been read.
+func (sampler *traceSampler) init() {
+	sampler.count = new(int64)
+}
+
+// isEnabled returns true if messages may be traced.
+func (sampler traceSampler) isEnabled() bool {
+	return sampler.sampleRate > 0 || sampler.metadataKey != ""
+}
+
+// sample marks the given message as traced if the metadata flag is set or if
+// the message is hit by the sample rate.
+func (sampler traceSampler) sample(msg *Message) {
+	switch {
+	case sampler.metadataKey != "" && len(msg.GetMetadata().GetValue(sampler.metadataKey)) > 0:
+	case sampler.sampleRate > 0 && atomic.AddInt64(sampler.count, 1)%sampler.sampleRate == 0:
+	default:
+		return // ### return, not traced ###
+	}
+	msg.trace = newMessageTrace(msg.GetCreationTime())
+}
+
+// IsTraced returns true if spans are recorded for this message.
+func (msg *Message) IsTraced() bool {
+	return msg.trace != nil
+}
+
+// traceEnd records the last span of this message, i.e. the message has been
+// delivered, discarded or sent to a fallback. Later calls are ignored.
+func (msg *Message) traceEnd(name string, start time.Time, tags map[string]string) {
+	if msg.trace == nil || msg.traceDone {
+		return // ### return, not traced or already done ###
+	}
+	msg.traceDone = true
+	msg.trace.span(name, start, tags)
+}
+
+// TraceDelivered records that this message has been delivered. Producers
+// normally call Release after delivering a message, which records the span
+// on its own. Use this function if a message is still accessed after it has
+// been delivered. Later calls, including the one done by Release, are ignored.
+func (msg *Message) TraceDelivered() {
+	if msg.trace != nil {
+		msg.traceEnd(TraceSpanDeliver, msg.trace.start, map[string]string{
+			"stream": StreamRegistry.GetStreamName(msg.GetStreamID()),
+		})
+	}
+}
+
+// traceFallback records that this message has been sent to the given
+// fallback stream.
+func (msg *Message) traceFallback(streamName string) {
+	if msg.trace != nil {
+		msg.traceEnd(TraceSpanFallback, time.Now(), map[string]string{
+			"stream": streamName,
+		})
+	}
+}
+
+// span sends a span covering the time from start until now to the
+// _GOLLUM_TRACE_ stream. If the stream cannot keep up, the span is dropped.
+func (trace *messageTrace) span(name string, start time.Time, tags map[string]string) {
+	now := time.Now()
+	span := TraceSpan{
+		TraceID:    trace.traceID,
+		SpanID:     newTraceID(8),
+		ParentID:   trace.rootSpanID,
+		Name:       name,
+		StartUs:    start.UnixNano() / int64(time.Microsecond),
+		DurationUs: int64(now.Sub(start) / time.Microsecond),
+		Tags:       tags,
+	}
+	trace.emit(span)
+}
+
+// enqueued records that the producer with the given ID accepted or rejected
+// a message. Producers have to pass the trace as the message may already be
+// released when the enqueue call returns.
+func (trace *messageTrace) enqueued(producerID string, start time.Time, result MessageQueueResult) {
+	trace.span(TraceSpanEnqueue, start, map[string]string{
+		"producer": producerID,
+		"result":   result.String(),
+	})
+}
+
+// rootSpan sends the span all other spans of this trace refer to. It covers
+// the time from creating the message until now.
+func (trace *messageTrace) rootSpan(tags map[string]string) {
+	span := TraceSpan{
+		TraceID:    trace.traceID,
+		SpanID:     trace.rootSpanID,
+		Name:       TraceSpanConsume,
+		StartUs:    trace.start.UnixNano() / int64(time.Microsecond),
+		DurationUs: int64(time.Since(trace.start) / time.Microsecond),
+		Tags:       tags,
+	}
+	trace.emit(span)
+}
+
+// emit passes a span to the go routine routing spans to _GOLLUM_TRACE_.
+func (trace *messageTrace) emit(span TraceSpan) {
+	data, err := json.Marshal(span)
+	if err != nil {
+		return // ### return, cannot happen for valid UTF-8 tags ###
+	}
+
+	spanRouterOnce.Do(func() { go routeSpans() })
+	msg := NewMessage(nil, data, nil, TraceInternalStreamID)
+	if spanQueue.Push(msg, -1) != MessageQueueOk {
+		tgo.Metric.Inc(metricTraceSpansDropped)
+		return // ### return, queue full ###
+	}
+	tgo.Metric.Inc(metricTraceSpans)
+}
+
+// routeSpans routes the spans queued by emit. Spans are routed by a separate
+// go routine so that producers listening to _GOLLUM_TRACE_ cannot block the
+// plugins recording spans.
+func routeSpans() {
+	for {
+		msg, more := spanQueue.Pop()
+		if !more {
+			return // ### return, queue closed ###
+		}
+		if router := StreamRegistry.GetRouterOrFallback(TraceInternalStreamID); router != nil {
+			Route(msg, router)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"encoding/json"
+	"github.com/trivago/tgo/ttesting"
+	"testing"
+	"time"
+)
+
+func TestTraceSamplerSample(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	sampler := traceSampler{sampleRate: 3}
+	sampler.init()
+	expect.True(sampler.isEnabled())
+
+	numTraced := 0
+	for i := 0; i < 9; i++ {
+		msg := NewMessage(nil, []byte("sample"), nil, 1)
+		sampler.sample(msg)
+		if msg.IsTraced() {
+			numTraced++
+		}
+	}
+	expect.Equal(3, numTraced)
+
+	sampler = traceSampler{metadataKey: "trace"}
+	sampler.init()
+
+	msg := NewMessage(nil, []byte("flagged"), nil, 1)
+	msg.GetMetadata().SetValue("trace", []byte("1"))
+	sampler.sample(msg)
+	expect.True(msg.IsTraced())
+
+	msg = NewMessage(nil, []byte("not flagged"), nil, 1)
+	sampler.sample(msg)
+	expect.False(msg.IsTraced())
+
+	sampler = traceSampler{}
+	expect.False(sampler.isEnabled())
+}
+
+func TestMessageTraceSpans(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	traceRouter := getMockRouter()
+	traceRouter.streamID = TraceInternalStreamID
+	StreamRegistry.Register(&traceRouter, TraceInternalStreamID)
+
+	tap := TapStream(TraceInternalStreamID, 16)
+	defer tap.Close()
+
+	router := getMockRouter()
+	msg := NewMessage(nil, []byte("traced"), nil, router.GetStreamID())
+	msg.trace = newMessageTrace(msg.GetCreationTime())
+
+	expect.NoError(Route(msg, &router))
+	DiscardMessage(msg)
+	msg.trace.rootSpan(map[string]string{"consumer": "test"})
+
+	spans := []TraceSpan{}
+	timeout := time.After(time.Second)
+	for len(spans) < 3 {
+		select {
+		case spanMsg := <-tap.Messages():
+			span := TraceSpan{}
+			expect.NoError(json.Unmarshal(spanMsg.GetPayload(), &span))
+			spans = append(spans, span)
+		case <-timeout:
+			t.Fatalf("Received only %d of 3 spans", len(spans))
+		}
+	}
+
+	expect.Equal(TraceSpanRoute, spans[0].Name)
+	expect.Equal("continue", spans[0].Tags["result"])
+	expect.Equal(TraceSpanDiscard, spans[1].Name)
+	expect.Equal(TraceSpanConsume, spans[2].Name)
+	expect.Equal("", spans[2].ParentID)
+
+	for _, span := range spans {
+		expect.Equal(spans[2].TraceID, span.TraceID)
+	}
+	expect.Equal(spans[2].SpanID, spans[0].ParentID)
+	expect.Equal(spans[2].SpanID, spans[1].ParentID)
+}
+
+func TestMessageTraceDelivered(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	traceRouter := getMockRouter()
+	traceRouter.streamID = TraceInternalStreamID
+	StreamRegistry.Register(&traceRouter, TraceInternalStreamID)
+
+	tap := TapStream(TraceInternalStreamID, 16)
+	defer tap.Close()
+
+	msg := NewMessage(nil, []byte("traced"), nil, 1)
+	msg.trace = newMessageTrace(msg.GetCreationTime())
+
+	msg.TraceDelivered()
+	msg.TraceDelivered()
+	msg.Release()
+
+	select {
+	case spanMsg := <-tap.Messages():
+		span := TraceSpan{}
+		expect.NoError(json.Unmarshal(spanMsg.GetPayload(), &span))
+		expect.Equal(TraceSpanDeliver, span.Name)
+	case <-time.After(time.Second):
+		t.Fatal("No deliver span received")
+	}
+
+	select {
+	case <-tap.Messages():
+		t.Fatal("Deliver span recorded twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -89,6 +89,9 @@ func init() {
 	tgo.Metric.NewRate(metricMessagesRouted, MetricMessagesRoutedAvg, time.Second, 10, 3, true)
 	tgo.Metric.NewRate(metricMessagesEnqued, metricMessagesEnquedAvg, time.Second, 10, 3, true)
 	tgo.Metric.NewRate(metricMessagesDiscarded, metricMessagesDiscardedSec, time.Second, 10, 3, true)
+
+	tgo.Metric.New(metricTraceSpans)
+	tgo.Metric.New(metricTraceSpansDropped)
 }
 
 // CountMessageRouted increases the messages counter by 1
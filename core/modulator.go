@@ -16,6 +16,9 @@ package core
 
 import (
 	"github.com/sirupsen/logrus"
+	"reflect"
+	"strings"
+	"time"
 )
 
 // A Modulator defines a modification or analysis step inside the message
@@ -56,9 +59,28 @@ const (
 	ModulateResultDiscard = ModulateResult(iota)
 )
 
+// String returns the name of the result, i.e. "continue", "fallback" or
+// "discard".
+func (result ModulateResult) String() string {
+	switch result {
+	case ModulateResultContinue:
+		return "continue"
+	case ModulateResultFallback:
+		return "fallback"
+	case ModulateResultDiscard:
+		return "discard"
+	default:
+		return "unknown"
+	}
+}
+
 // Modulate calls Modulate on every Modulator in the array and react according
 // to the definition of each ModulateResult state.
 func (modulators ModulatorArray) Modulate(msg *Message) ModulateResult {
+	if msg.trace != nil {
+		return modulators.modulateTraced(msg) // ### return, traced ###
+	}
+
 	action := ModulateResultContinue
 	for _, modulator := range modulators {
 		switch modRes := modulator.Modulate(msg); modRes {
@@ -68,3 +90,52 @@ func (modulators ModulatorArray) Modulate(msg *Message) ModulateResult {
 	}
 	return action
 }
+
+// GetHeader returns the headers of all formatters implementing
+// HeaderFormatter in the order the formatters are applied. Only formatters
+// directly listed in this array are inspected. If no formatter requires a
+// header, nil is returned.
+func (modulators ModulatorArray) GetHeader() []byte {
+	var header []byte
+	for _, modulator := range modulators {
+		if wrapper, isFormatter := modulator.(*FormatterModulator); isFormatter {
+			if formatter, hasHeader := wrapper.Formatter.(HeaderFormatter); hasHeader {
+				header = append(header, formatter.GetHeader()...)
+			}
+		}
+	}
+	return header
+}
+
+// modulateTraced works like Modulate but records a span for every modulator
+// applied.
+func (modulators ModulatorArray) modulateTraced(msg *Message) ModulateResult {
+	trace := msg.trace
+	for _, modulator := range modulators {
+		start := time.Now()
+		modRes := modulator.Modulate(msg)
+		trace.span(TraceSpanModulate, start, map[string]string{
+			"modulator": getModulatorName(modulator),
+			"result":    modRes.String(),
+		})
+
+		switch modRes {
+		case ModulateResultDiscard, ModulateResultFallback:
+			return modRes // ### return, break modulator calls ###
+		}
+	}
+	return ModulateResultContinue
+}
+
+// getModulatorName returns the type name of the filter or formatter behind
+// a modulator, e.g. "format.Envelope".
+func getModulatorName(modulator Modulator) string {
+	var plugin interface{} = modulator
+	switch wrapper := modulator.(type) {
+	case *FilterModulator:
+		plugin = wrapper.Filter
+	case *FormatterModulator:
+		plugin = wrapper.Formatter
+	}
+	return strings.TrimPrefix(reflect.TypeOf(plugin).String(), "*")
+}
@@ -0,0 +1,144 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/trivago/tgo/tcontainer"
+	"sync"
+)
+
+// orderingLanes distributes messages to a fixed number of go routines, called
+// lanes, by a partition key. Messages sharing the same key always use the
+// same lane and are processed in the order they were enqueued. Messages with
+// different keys are processed in parallel.
+// The key is read from a metadata key or a JSON field of the payload.
+// Messages without a key share one lane.
+type orderingLanes struct {
+	numLanes    int    `config:"Ordering/Lanes" default:"0"`
+	queueSize   int    `config:"Ordering/QueueSize" default:"1024"`
+	metadataKey string `config:"Ordering/MetadataKey"`
+	field       string `config:"Ordering/Field"`
+	lanes       []*MessageQueue
+	workers     *sync.WaitGroup
+}
+
+// init validates the ordering settings read from the given config.
+func (ordering *orderingLanes) init(conf PluginConfigReader) {
+	if !ordering.isEnabled() {
+		return // ### return, disabled ###
+	}
+	if ordering.queueSize < 0 {
+		conf.Errors.Pushf("Ordering/QueueSize must not be negative")
+	}
+	if ordering.metadataKey == "" && ordering.field == "" {
+		conf.GetLogger().Warning("Ordering/Lanes is set without Ordering/MetadataKey or Ordering/Field. All messages will use the same lane.")
+	}
+}
+
+// isEnabled returns true if Ordering/Lanes has been set.
+func (ordering *orderingLanes) isEnabled() bool {
+	return ordering.numLanes > 0
+}
+
+// isRunning returns true if start has been called.
+func (ordering *orderingLanes) isRunning() bool {
+	return len(ordering.lanes) > 0
+}
+
+// start creates the lanes and starts one go routine per lane passing the
+// messages of that lane to process.
+func (ordering *orderingLanes) start(process func(*Message)) {
+	ordering.lanes = make([]*MessageQueue, ordering.numLanes)
+	ordering.workers = new(sync.WaitGroup)
+	ordering.workers.Add(ordering.numLanes)
+
+	for i := range ordering.lanes {
+		lane := NewMessageQueue(ordering.queueSize)
+		ordering.lanes[i] = lane
+
+		go func() {
+			defer ordering.workers.Done()
+			for {
+				msg, more := lane.Pop()
+				if !more {
+					return // ### return, lane closed ###
+				}
+				process(msg)
+			}
+		}()
+	}
+}
+
+// stop closes all lanes and waits until the remaining messages have been
+// processed.
+func (ordering *orderingLanes) stop() {
+	for _, lane := range ordering.lanes {
+		lane.Close()
+	}
+	ordering.workers.Wait()
+}
+
+// enqueue passes the message to the lane matching its key. This call blocks
+// if the lane is full.
+func (ordering *orderingLanes) enqueue(msg *Message) {
+	lane := ordering.lanes[ordering.getLaneIndex(ordering.getKey(msg))]
+	if lane.Push(msg, 0) != MessageQueueOk {
+		DiscardMessage(msg)
+	}
+}
+
+// getNumQueued returns the number of messages waiting in all lanes.
+func (ordering *orderingLanes) getNumQueued() int {
+	numQueued := 0
+	for _, lane := range ordering.lanes {
+		numQueued += lane.GetNumQueued()
+	}
+	return numQueued
+}
+
+// getKey returns the partition key of the given message. If no key could be
+// found, nil is returned.
+func (ordering *orderingLanes) getKey(msg *Message) []byte {
+	switch {
+	case ordering.metadataKey != "":
+		return msg.GetMetadata().GetValue(ordering.metadataKey)
+
+	case ordering.field != "":
+		values := tcontainer.NewMarshalMap()
+		decoder := json.NewDecoder(bytes.NewReader(msg.GetPayload()))
+		decoder.UseNumber()
+		if err := decoder.Decode(&values); err != nil {
+			return nil
+		}
+		if value, exists := values.Value(ordering.field); exists {
+			return []byte(fmt.Sprintf("%v", value))
+		}
+	}
+	return nil
+}
+
+// getLaneIndex returns the index of the lane used for the given key. Keys are
+// hashed using FNV-1a.
+func (ordering *orderingLanes) getLaneIndex(key []byte) int {
+	hash := uint32(2166136261)
+	for _, c := range key {
+		hash ^= uint32(c)
+		hash *= 16777619
+	}
+	return int(hash % uint32(len(ordering.lanes)))
+}
@@ -0,0 +1,89 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"github.com/trivago/tgo/ttesting"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestOrderingLanesGetKey(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	msg := NewMessage(nil, []byte(`{"user":{"id":42}}`), nil, 1)
+	msg.GetMetadata().SetValue("key", []byte("abc"))
+
+	ordering := orderingLanes{field: "user/id"}
+	expect.Equal("42", string(ordering.getKey(msg)))
+
+	ordering = orderingLanes{metadataKey: "key", field: "user/id"}
+	expect.Equal("abc", string(ordering.getKey(msg)))
+
+	ordering = orderingLanes{field: "user/name"}
+	expect.Nil(ordering.getKey(msg))
+
+	msg.StorePayload([]byte("not json"))
+	ordering = orderingLanes{field: "user/id"}
+	expect.Nil(ordering.getKey(msg))
+}
+
+func TestOrderingLanesPreserveOrder(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	const numKeys = 16
+	const numMessages = 2000
+
+	ordering := orderingLanes{numLanes: 4, queueSize: 8, metadataKey: "key"}
+
+	guard := new(sync.Mutex)
+	received := make(map[string][]int)
+	lanesUsed := make(map[int]bool)
+
+	ordering.start(func(msg *Message) {
+		key := msg.GetMetadata().GetValueString("key")
+		seq, _ := strconv.Atoi(msg.String())
+
+		guard.Lock()
+		defer guard.Unlock()
+		received[key] = append(received[key], seq)
+		lanesUsed[ordering.getLaneIndex([]byte(key))] = true
+	})
+
+	for i := 0; i < numMessages; i++ {
+		msg := NewMessage(nil, []byte(strconv.Itoa(i)), nil, 1)
+		msg.GetMetadata().SetValue("key", []byte(fmt.Sprintf("key%d", i%numKeys)))
+		ordering.enqueue(msg)
+	}
+	ordering.stop()
+
+	expect.Equal(numKeys, len(received))
+	expect.Greater(len(lanesUsed), 1)
+
+	total := 0
+	for key, sequence := range received {
+		total += len(sequence)
+		for i := 1; i < len(sequence); i++ {
+			if sequence[i] < sequence[i-1] {
+				t.Errorf("Message %d of %s overtook message %d", sequence[i], key, sequence[i-1])
+				break
+			}
+		}
+	}
+	expect.Equal(numMessages, total)
+	expect.Equal(0, ordering.getNumQueued())
+}
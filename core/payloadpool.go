@@ -0,0 +1,93 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"github.com/trivago/tgo/tcontainer"
+)
+
+// payloadSizeClasses lists the buffer sizes handed out by the slab allocator
+// of tcontainer.BytePool. Only buffers with exactly these capacities can be
+// returned to a PayloadPool.
+var payloadSizeClasses = [...]int{64, 512, 1024, 10 * 1024, 100 * 1024}
+
+// payloadFreeListSizes is the maximum number of returned buffers kept per
+// size class. Larger buffers are kept in smaller numbers to limit the memory
+// held by an idle pool.
+var payloadFreeListSizes = [len(payloadSizeClasses)]int{4096, 1024, 512, 64, 8}
+
+// PayloadPool hands out buffers for message payloads. Buffers returned by Put
+// are reused by later calls to Get. If no returned buffer is available, a new
+// buffer is taken from a slab allocator.
+type PayloadPool struct {
+	slabs     tcontainer.BytePool
+	freeLists [len(payloadSizeClasses)]chan []byte
+}
+
+// NewPayloadPool creates a new pool. The slab allocator used for new buffers
+// allocates slabCount slabs per size class at a time.
+func NewPayloadPool(slabCount int) *PayloadPool {
+	pool := &PayloadPool{
+		slabs: tcontainer.NewBytePoolWithSize(slabCount),
+	}
+	for i, size := range payloadFreeListSizes {
+		pool.freeLists[i] = make(chan []byte, size)
+	}
+	return pool
+}
+
+// getSizeClass returns the index of the smallest size class that can hold
+// size bytes or -1 if size exceeds all size classes.
+func getSizeClass(size int) int {
+	for i, classSize := range payloadSizeClasses {
+		if size <= classSize {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns a buffer of the given length. The content of the buffer is
+// undefined.
+func (pool *PayloadPool) Get(size int) []byte {
+	if size == 0 {
+		return []byte{} // ### return, nothing to allocate ###
+	}
+
+	if class := getSizeClass(size); class >= 0 {
+		select {
+		case buffer := <-pool.freeLists[class]:
+			return buffer[:size] // ### return, reused buffer ###
+		default:
+		}
+	}
+	return pool.slabs.Get(size)
+}
+
+// Put returns a buffer to the pool so that it can be reused by Get. The
+// buffer must not be referenced anymore after calling Put. Buffers not
+// created by Get or buffers exceeding the pool's capacity are left to the
+// garbage collector.
+func (pool *PayloadPool) Put(buffer []byte) {
+	class := getSizeClass(cap(buffer))
+	if class < 0 || cap(buffer) != payloadSizeClasses[class] {
+		return // ### return, not a pooled buffer ###
+	}
+
+	select {
+	case pool.freeLists[class] <- buffer:
+	default:
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"github.com/trivago/tgo/tcontainer"
+	"github.com/trivago/tgo/ttesting"
+	"testing"
+	"time"
+)
+
+func TestPayloadPoolReuse(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	pool := NewPayloadPool(1)
+
+	buffer := pool.Get(10)
+	expect.Equal(10, len(buffer))
+	expect.Equal(64, cap(buffer))
+
+	buffer[0] = 'x'
+	pool.Put(buffer)
+
+	reused := pool.Get(60)
+	expect.Equal(60, len(reused))
+	expect.Equal(byte('x'), reused[0])
+
+	// Buffers not matching a size class are not pooled
+	pool.Put(make([]byte, 100))
+	expect.Equal(512, cap(pool.Get(100)))
+	expect.Equal(0, len(pool.freeLists[1]))
+
+	expect.Equal(0, len(pool.Get(0)))
+	expect.Equal(200*1024, len(pool.Get(200*1024)))
+}
+
+func TestMessageReleaseReturnsPayload(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	numFree := len(MessageDataPool.freeLists[0])
+
+	msg := NewMessage(nil, []byte("release"), nil, 1)
+	msg.FreezeOriginal()
+	msg.StorePayload([]byte("modified"))
+
+	msg.Release()
+	expect.Equal(numFree+2, len(MessageDataPool.freeLists[0]))
+
+	// Releasing twice must not return the buffers twice
+	msg.Release()
+	expect.Equal(numFree+2, len(MessageDataPool.freeLists[0]))
+}
+
+// benchmarkSink keeps benchmarked messages from being allocated on the stack
+var benchmarkSink *Message
+
+var benchmarkPayload = []byte("{\"timestamp\":\"2017-01-01T00:00:00Z\",\"level\":\"info\",\"message\":\"benchmark message payload\"}")
+
+// newEagerMessage creates a message the way NewMessage and FreezeOriginal did
+// before messages were released to MessageDataPool, i.e. every message gets
+// new buffers for its payload and original payload. It is kept as a baseline
+// for the benchmarks below.
+func newEagerMessage(slabs *tcontainer.BytePool, data []byte, streamID MessageStreamID) *Message {
+	msg := &Message{
+		prevStreamID: streamID,
+		timestamp:    time.Now(),
+	}
+	msg.data.payload = slabs.Get(len(data))
+	copy(msg.data.payload, data)
+	msg.data.streamID = streamID
+	msg.data.Metadata = make(Metadata)
+
+	msg.orig.payload = slabs.Get(len(msg.data.payload))
+	copy(msg.orig.payload, msg.data.payload)
+	msg.orig.streamID = msg.data.streamID
+	msg.orig.Metadata = msg.data.Metadata.Clone()
+	return msg
+}
+
+func BenchmarkMessageLifecycle(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg := NewMessage(nil, benchmarkPayload, nil, 1)
+		msg.FreezeOriginal()
+		benchmarkSink = msg
+		msg.Release()
+	}
+}
+
+func BenchmarkEagerMessageLifecycle(b *testing.B) {
+	slabs := tcontainer.NewBytePoolWithSize(2)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchmarkSink = newEagerMessage(&slabs, benchmarkPayload, 1)
+	}
+}
+
+func BenchmarkMessageLifecycleModified(b *testing.B) {
+	modified := append(benchmarkPayload, benchmarkPayload...)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg := NewMessage(nil, benchmarkPayload, nil, 1)
+		msg.FreezeOriginal()
+		msg.StorePayload(modified)
+		benchmarkSink = msg
+		msg.Release()
+	}
+}
+
+func BenchmarkEagerMessageLifecycleModified(b *testing.B) {
+	slabs := tcontainer.NewBytePoolWithSize(2)
+	modified := append(benchmarkPayload, benchmarkPayload...)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg := newEagerMessage(&slabs, benchmarkPayload, 1)
+		msg.data.payload = slabs.Get(len(modified))
+		copy(msg.data.payload, modified)
+		benchmarkSink = msg
+	}
+}
+
+func BenchmarkMessageClone(b *testing.B) {
+	msg := NewMessage(nil, benchmarkPayload, nil, 1)
+	msg.FreezeOriginal()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchmarkSink = msg.Clone()
+		benchmarkSink.Release()
+	}
+}
+
+func BenchmarkEagerMessageClone(b *testing.B) {
+	slabs := tcontainer.NewBytePoolWithSize(2)
+	msg := newEagerMessage(&slabs, benchmarkPayload, 1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		clone := *msg
+		clone.data.payload = slabs.Get(len(msg.data.payload))
+		copy(clone.data.payload, msg.data.payload)
+		benchmarkSink = &clone
+	}
+}
@@ -38,6 +38,11 @@ const (
 	PluginControlStopConsumer = PluginControl(iota)
 	// PluginControlRoll notifies the consumer/producer about a reconnect or reopen request
 	PluginControlRoll = PluginControl(iota)
+	// PluginControlPauseConsumer will cause any consumer to stop passing on
+	// messages until PluginControlResumeConsumer is received.
+	PluginControlPauseConsumer = PluginControl(iota)
+	// PluginControlResumeConsumer will cause a paused consumer to continue.
+	PluginControlResumeConsumer = PluginControl(iota)
 )
 
 const (
@@ -105,6 +110,14 @@ type PluginWithState interface {
 	GetState() PluginState
 }
 
+// String returns a human readable description of a plugin state
+func (state PluginState) String() string {
+	if state < 0 || int(state) >= len(stateToDescription) {
+		return "Unknown"
+	}
+	return stateToDescription[state]
+}
+
 // NewPluginRunState creates a new plugin state helper
 func NewPluginRunState() *PluginRunState {
 	plugin := &PluginRunState{
@@ -18,6 +18,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/trivago/tgo"
 	"github.com/trivago/tgo/tcontainer"
+	"sort"
 	"strings"
 )
 
@@ -27,7 +28,7 @@ type PluginConfig struct {
 	Typename  string
 	Enable    bool
 	Settings  tcontainer.MarshalMap
-	validKeys map[string]bool
+	validKeys map[string]string
 }
 
 // NewPluginConfig creates a new plugin config with default values.
@@ -40,7 +41,7 @@ func NewPluginConfig(pluginID string, defaultTypename string) PluginConfig {
 		ID:        pluginID,
 		Typename:  defaultTypename,
 		Settings:  tcontainer.NewMarshalMap(),
-		validKeys: make(map[string]bool),
+		validKeys: make(map[string]string),
 	}
 }
 
@@ -54,7 +55,7 @@ func NewNestedPluginConfig(defaultTypename string, values tcontainer.MarshalMap)
 }
 
 // registerKey registers a key to the validKeys map as lowercase and returns
-// the lowercase key
+// the lowercase key. The original spelling is kept for error messages.
 func (conf *PluginConfig) registerKey(key string) string {
 	lowerCaseKey := strings.ToLower(key)
 	if _, exists := conf.validKeys[lowerCaseKey]; exists {
@@ -62,46 +63,139 @@ func (conf *PluginConfig) registerKey(key string) string {
 	}
 
 	// Remove array notation from path
-	path := lowerCaseKey
-	startIdx := strings.IndexRune(path, tcontainer.MarshalMapArrayBegin)
-	for startIdx > -1 {
-		if endIdx := strings.IndexRune(path[startIdx:], tcontainer.MarshalMapArrayEnd); endIdx > -1 {
-			path = path[0:startIdx] + path[startIdx+endIdx+1:]
-			startIdx = strings.IndexRune(path, tcontainer.MarshalMapArrayBegin)
-		} else {
-			startIdx = -1
-		}
-	}
-
+	path := removeArrayNotation(lowerCaseKey)
 	if _, exists := conf.validKeys[path]; exists {
 		return lowerCaseKey // ### return, already registered (without array notation) ###
 	}
+	name := removeArrayNotation(key)
+	if len(name) != len(path) {
+		name = path // lowercase changed the length, e.g. for some unicode runes
+	}
 
 	// Register all parts of the path
-	startIdx = strings.IndexRune(path, tcontainer.MarshalMapSeparator)
+	startIdx := strings.IndexRune(path, tcontainer.MarshalMapSeparator)
 	cutIdx := startIdx
 	for startIdx > -1 {
-		conf.validKeys[path[:cutIdx]] = true
+		if _, exists := conf.validKeys[path[:cutIdx]]; !exists {
+			conf.validKeys[path[:cutIdx]] = name[:cutIdx]
+		}
 		startIdx = strings.IndexRune(path[startIdx+1:], tcontainer.MarshalMapSeparator)
 		cutIdx += startIdx
 	}
 
-	conf.validKeys[path] = true
+	conf.validKeys[path] = name
 	return lowerCaseKey
 }
 
+// removeArrayNotation removes all array indices from a key path
+func removeArrayNotation(path string) string {
+	startIdx := strings.IndexRune(path, tcontainer.MarshalMapArrayBegin)
+	for startIdx > -1 {
+		if endIdx := strings.IndexRune(path[startIdx:], tcontainer.MarshalMapArrayEnd); endIdx > -1 {
+			path = path[0:startIdx] + path[startIdx+endIdx+1:]
+			startIdx = strings.IndexRune(path, tcontainer.MarshalMapArrayBegin)
+		} else {
+			startIdx = -1
+		}
+	}
+	return path
+}
+
 // Validate should be called after a configuration has been processed. It will
 // check the keys read from the config files against the keys requested up to
-// this point. Unknown keys will be returned as errors
+// this point. Unknown keys will be returned as errors. If a known key with a
+// similar name exists, it is suggested as a replacement.
+// Keys inside of nested maps are checked, too, if any of their keys have been
+// requested.
 func (conf PluginConfig) Validate() error {
 	errors := tgo.NewErrorStack()
 	errors.SetFormat(tgo.ErrorStackFormatCSV)
-	for key := range conf.Settings {
-		if _, exists := conf.validKeys[key]; !exists {
-			errors.Pushf("Unknown configuration key in %s: %s", conf.Typename, key)
+	conf.validateKeys("", conf.Settings, &errors)
+	return errors.OrNil()
+}
+
+func (conf PluginConfig) validateKeys(prefix string, values tcontainer.MarshalMap, errors *tgo.ErrorStack) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		path := prefix + key
+		if _, exists := conf.validKeys[path]; !exists {
+			if suggestion := conf.getSimilarKey(path); suggestion != "" {
+				errors.Pushf("Unknown configuration key in %s: %s (did you mean %s?)", conf.Typename, path, suggestion)
+			} else {
+				errors.Pushf("Unknown configuration key in %s: %s", conf.Typename, path)
+			}
+			continue // ### continue, unknown key ###
+		}
+
+		if nestedValues, isMap := values[key].(tcontainer.MarshalMap); isMap && conf.hasNestedKeys(path) {
+			conf.validateKeys(path+string(tcontainer.MarshalMapSeparator), nestedValues, errors)
 		}
 	}
-	return errors.OrNil()
+}
+
+// hasNestedKeys returns true if a key below the given path has been requested.
+// If not, the value at path is read as a whole, e.g. as a map of settings.
+func (conf PluginConfig) hasNestedKeys(path string) bool {
+	prefix := path + string(tcontainer.MarshalMapSeparator)
+	for key := range conf.validKeys {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// getSimilarKey returns the spelling of the requested key that is closest to
+// the given unknown key. If no key is similar enough, "" is returned.
+func (conf PluginConfig) getSimilarKey(path string) string {
+	maxDistance := len(path) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+
+	bestMatch := ""
+	bestDistance := maxDistance + 1
+	for key, name := range conf.validKeys {
+		distance := getEditDistance(path, key)
+		if distance < bestDistance || (distance == bestDistance && name < bestMatch) {
+			bestMatch = name
+			bestDistance = distance
+		}
+	}
+	return bestMatch
+}
+
+// getEditDistance returns the levenshtein distance between two strings
+func getEditDistance(a, b string) int {
+	lastRow := make([]int, len(b)+1)
+	row := make([]int, len(b)+1)
+	for j := range lastRow {
+		lastRow[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			row[j] = lastRow[j-1] + cost
+			if lastRow[j]+1 < row[j] {
+				row[j] = lastRow[j] + 1
+			}
+			if row[j-1]+1 < row[j] {
+				row[j] = row[j-1] + 1
+			}
+		}
+		lastRow, row = row, lastRow
+	}
+	return lastRow[len(b)]
 }
 
 // Read analyzes a given key/value map to extract the configuration values valid
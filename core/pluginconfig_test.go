@@ -17,6 +17,7 @@ package core
 import (
 	"github.com/trivago/tgo/tcontainer"
 	"github.com/trivago/tgo/ttesting"
+	"strings"
 	"testing"
 )
 
@@ -50,6 +51,32 @@ func TestPluginConfigValidate(t *testing.T) {
 	expect.NoError(err)
 }
 
+func TestPluginConfigValidateSuggestions(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	mockPluginCfg := NewPluginConfig("", "mockPlugin")
+	mockPluginCfgReader := NewPluginConfigReaderWithError(&mockPluginCfg)
+
+	values := tcontainer.NewMarshalMap()
+	values["PresistTimoutMs"] = 100
+	values["Stream"] = map[interface{}]interface{}{"MaxLn": 10}
+	values["Settings"] = map[interface{}]interface{}{"anything": "goes"}
+	values["Foo"] = "bar"
+	mockPluginCfg.Read(values)
+
+	mockPluginCfgReader.GetInt("PersistTimeoutMs", 0)
+	mockPluginCfgReader.GetInt("Stream/MaxLen", 0)
+	mockPluginCfgReader.GetMap("Settings", tcontainer.NewMarshalMap())
+
+	err := mockPluginCfg.Validate()
+	expect.NotNil(err)
+
+	errors := strings.Split(err.Error(), ", ")
+	expect.Equal(3, len(errors))
+	expect.Equal("Unknown configuration key in mockPlugin: foo", errors[0])
+	expect.Equal("Unknown configuration key in mockPlugin: presisttimoutms (did you mean PersistTimeoutMs?)", errors[1])
+	expect.Equal("Unknown configuration key in mockPlugin: stream/maxln (did you mean Stream/MaxLen?)", errors[2])
+}
+
 // Function reads initializes pluginConfig with predefined values and
 // non-predefined values in the Settings
 // Plan:
@@ -129,22 +129,24 @@ func TestProducerEnqueue(t *testing.T) {
 
 	mockP.fallbackStream = StreamRegistry.GetRouter(2)
 
-	msg := NewMessage(nil, []byte("ProdEnqueueTest"), nil, 1)
+	newMsg := func() *Message {
+		return NewMessage(nil, []byte("ProdEnqueueTest"), nil, 1)
+	}
 
 	enqTimeout := time.Second
 	mockP.setState(PluginStateStopping)
 	// cause panic and check if message is sent to the fallback
-	mockP.Enqueue(msg, enqTimeout)
+	mockP.Enqueue(newMsg(), enqTimeout)
 
 	mockP.setState(PluginStateActive)
-	mockP.Enqueue(msg, enqTimeout)
+	mockP.Enqueue(newMsg(), enqTimeout)
 
 	mockStream := getMockRouter()
 	mockDropStream.streamID = 1
 	StreamRegistry.Register(&mockStream, 1)
 
 	go func() {
-		mockP.Enqueue(msg, enqTimeout)
+		mockP.Enqueue(newMsg(), enqTimeout)
 	}()
 	//give time for message to enqueue in the channel
 	time.Sleep(200 * time.Millisecond)
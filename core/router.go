@@ -44,9 +44,20 @@ type Router interface {
 // Route tries to enqueue a message to the given stream. This function also
 // handles redirections enforced by formatters.
 func Route(msg *Message, router Router) error {
+	var start time.Time
+	if msg.trace != nil {
+		start = time.Now()
+	}
 
 	action := router.Modulate(msg)
 
+	if msg.trace != nil {
+		msg.trace.span(TraceSpanRoute, start, map[string]string{
+			"router": StreamRegistry.GetStreamName(router.GetStreamID()),
+			"result": action.String(),
+		})
+	}
+
 	streamName := StreamRegistry.GetStreamName(msg.GetStreamID())
 	streamMetric := GetStreamMetric(msg.GetStreamID())
 
@@ -59,6 +70,7 @@ func Route(msg *Message, router Router) error {
 	case ModulateResultContinue:
 		streamMetric.CountMessageRouted()
 		CountMessageRouted()
+		streamTaps.publish(msg)
 
 		return router.Enqueue(msg)
 
@@ -69,7 +81,10 @@ func Route(msg *Message, router Router) error {
 			return NewModulateResultError("Routing loop detected for router %s (from %s)", streamName, prevStreamName)
 		}
 
-		return RouteOriginal(msg, msg.GetRouter())
+		err := RouteOriginal(msg, msg.GetRouter())
+		msg.traceFallback(streamName)
+		msg.Release()
+		return err
 	}
 
 	return NewModulateResultError("Unknown ModulateResult action: %d", action)
@@ -82,7 +97,13 @@ func RouteOriginal(msg *Message, router Router) error {
 }
 
 // DiscardMessage increases the discard statistic and discards the given
-// message.
+// message. The message is released and must not be used afterwards.
 func DiscardMessage(msg *Message) {
 	CountMessageDiscarded()
+	if msg.trace != nil {
+		msg.traceEnd(TraceSpanDiscard, time.Now(), map[string]string{
+			"stream": StreamRegistry.GetStreamName(msg.GetStreamID()),
+		})
+	}
+	msg.Release()
 }
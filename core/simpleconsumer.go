@@ -19,6 +19,7 @@ import (
 	"github.com/trivago/tgo"
 	"github.com/trivago/tgo/thealthcheck"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -52,13 +53,46 @@ import (
 // - ModulatorRoutines: Defines the number of go routines reserved for
 // modulating messages. Setting this parameter to 0 will use as many go routines
 // as the specific consumer plugin is using for fetching data. Any other value
-// will force the given number fo go routines to be used.
+// will force the given number fo go routines to be used. Messages may overtake
+// each other when using more than one go routine. Use Ordering/Lanes if the
+// order of messages needs to be preserved.
 // By default this parameter is set to 0
 //
 // - ModulatorQueueSize: Defines the size of the channel used to buffer messages
 // before they are fetched by the next free modulator go routine. If the
 // ModulatorRoutines parameter is set to 0 this parameter is ignored.
 // By default this parameter is set to 1024.
+//
+// - Ordering/Lanes: Defines the number of go routines used for modulating and
+// routing messages while preserving the order of messages sharing the same
+// key. Messages with the same key always use the same go routine, messages
+// with different keys are processed in parallel. If set, ModulatorRoutines is
+// ignored. Set this parameter to 0 to disable ordering lanes.
+// By default this parameter is set to 0.
+//
+// - Ordering/QueueSize: Defines the number of messages buffered per lane.
+// By default this parameter is set to 1024.
+//
+// - Ordering/MetadataKey: Defines the metadata key to read the ordering key
+// from. If set, this key takes precedence over Ordering/Field.
+// By default this parameter is set to "".
+//
+// - Ordering/Field: Defines a JSON field in the payload to read the ordering
+// key from. Paths can be defined in a format accepted by
+// tgo.MarshalMap.Path. The key is read before modulators are applied.
+// Messages without a key share one lane.
+// By default this parameter is set to "".
+//
+// - Trace/SampleRate: Traces every Nth message created by this consumer.
+// Traced messages record a span with timings for every step they pass, i.e.
+// modulators, routers, producers, fallbacks and discards. Spans are sent as
+// JSON to the "_GOLLUM_TRACE_" stream. Set this parameter to 0 to disable
+// sampling.
+// By default this parameter is set to 0.
+//
+// - Trace/MetadataKey: Traces all messages having a non-empty value stored
+// for the given metadata key, independent of Trace/SampleRate.
+// By default this parameter is set to "".
 type SimpleConsumer struct {
 	id              string
 	control         chan PluginControl
@@ -70,7 +104,11 @@ type SimpleConsumer struct {
 	onPrepareStop   func()
 	onStop          func()
 	enqueueMessage  func(*Message)
-	modulatorQueue  MessageQueue
+	modulatorQueue  *MessageQueue
+	ordering        orderingLanes
+	tracing         traceSampler
+	paused          *int32
+	pauseGuard      *sync.Cond
 	Logger          logrus.FieldLogger
 }
 
@@ -80,18 +118,33 @@ func (cons *SimpleConsumer) Configure(conf PluginConfigReader) {
 	cons.Logger = conf.GetLogger()
 	cons.runState = NewPluginRunState()
 	cons.control = make(chan PluginControl, 1)
+	cons.paused = new(int32)
+	cons.pauseGuard = sync.NewCond(new(sync.Mutex))
 
 	numRoutines := conf.GetInt("ModulatorRoutines", 0)
 	queueSize := conf.GetInt("ModulatorQueueSize", 1024)
 
-	if numRoutines > 0 {
+	cons.ordering.init(conf)
+	cons.tracing.init()
+
+	switch {
+	case cons.ordering.isEnabled():
+		if numRoutines > 0 {
+			cons.Logger.Warning("ModulatorRoutines is ignored as Ordering/Lanes is set")
+		}
+		cons.Logger.Debugf("Using %d ordering lanes", cons.ordering.numLanes)
+		cons.ordering.start(cons.directEnqueue)
+		cons.enqueueMessage = cons.ordering.enqueue
+
+	case numRoutines > 0:
 		cons.Logger.Debugf("Using %d modulator routines", numRoutines)
 		cons.modulatorQueue = NewMessageQueue(int(queueSize))
 		for i := 0; i < int(numRoutines); i++ {
 			go cons.processQueue()
 		}
 		cons.enqueueMessage = cons.parallelEnqueue
-	} else {
+
+	default:
 		cons.enqueueMessage = cons.directEnqueue
 	}
 }
@@ -124,6 +177,48 @@ func (cons *SimpleConsumer) GetShutdownTimeout() time.Duration {
 	return cons.shutdownTimeout
 }
 
+// Streams returns the streams this consumer is writing to.
+func (cons *SimpleConsumer) Streams() []MessageStreamID {
+	streams := make([]MessageStreamID, 0, len(cons.routers))
+	for _, router := range cons.routers {
+		streams = append(streams, router.GetStreamID())
+	}
+	return streams
+}
+
+// IsPaused returns true if the consumer has been paused by
+// PluginControlPauseConsumer.
+func (cons *SimpleConsumer) IsPaused() bool {
+	return atomic.LoadInt32(cons.paused) != 0
+}
+
+// IsSaturated returns true if one of the streams this consumer is writing to
+// is saturated, i.e. a producer bound to it reached its high water mark.
+// Push based consumers should reject new data while this is true.
+func (cons *SimpleConsumer) IsSaturated() bool {
+	for _, router := range cons.routers {
+		if source, isSource := router.(BackpressureSource); isSource && source.IsSaturated() {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitWhileSaturated blocks as long as IsSaturated returns true or until the
+// consumer is stopped. Pull based consumers should call this function before
+// fetching new data.
+func (cons *SimpleConsumer) WaitWhileSaturated() {
+	if !cons.IsSaturated() {
+		return // ### return, not saturated ###
+	}
+
+	cons.Logger.Debug("Streams are saturated, waiting")
+	for cons.IsSaturated() && !cons.IsStopping() {
+		time.Sleep(backpressurePollInterval)
+	}
+	cons.Logger.Debug("Streams are drained, continuing")
+}
+
 // Control returns write access to this consumer's control channel.
 // See ConsumerControl* constants.
 func (cons *SimpleConsumer) Control() chan<- PluginControl {
@@ -200,12 +295,43 @@ func (cons *SimpleConsumer) Enqueue(data []byte) {
 	cons.EnqueueWithMetadata(data, nil)
 }
 
-// EnqueueWithMetadata works like EnqueueWithSequence and allows to set meta data directly
+// EnqueueWithMetadata works like EnqueueWithSequence and allows to set meta data directly.
+// This call blocks while the consumer is paused.
 func (cons *SimpleConsumer) EnqueueWithMetadata(data []byte, metaData Metadata) {
+	cons.waitWhilePaused()
 	msg := NewMessage(cons, data, metaData, InvalidStreamID)
+	if cons.tracing.isEnabled() {
+		cons.tracing.sample(msg)
+	}
 	cons.enqueueMessage(msg)
 }
 
+func (cons *SimpleConsumer) waitWhilePaused() {
+	if !cons.IsPaused() {
+		return // ### return, not paused ###
+	}
+
+	cons.pauseGuard.L.Lock()
+	defer cons.pauseGuard.L.Unlock()
+	for cons.IsPaused() {
+		cons.pauseGuard.Wait()
+	}
+}
+
+func (cons *SimpleConsumer) setPaused(paused bool) {
+	cons.pauseGuard.L.Lock()
+	defer cons.pauseGuard.L.Unlock()
+
+	if paused {
+		atomic.StoreInt32(cons.paused, 1)
+		cons.setState(PluginStateWaiting)
+	} else {
+		atomic.StoreInt32(cons.paused, 0)
+		cons.setState(PluginStateActive)
+		cons.pauseGuard.Broadcast()
+	}
+}
+
 func (cons *SimpleConsumer) parallelEnqueue(msg *Message) {
 	cons.modulatorQueue.Push(msg, 0)
 }
@@ -219,6 +345,10 @@ loop:
 }
 
 func (cons *SimpleConsumer) directEnqueue(msg *Message) {
+	if trace := msg.trace; trace != nil {
+		defer trace.rootSpan(map[string]string{"consumer": cons.id})
+	}
+
 	// Execute configured modulators
 	switch cons.modulators.Modulate(msg) {
 	case ModulateResultDiscard:
@@ -229,6 +359,8 @@ func (cons *SimpleConsumer) directEnqueue(msg *Message) {
 		if err := RouteOriginal(msg, msg.GetRouter()); err != nil {
 			cons.Logger.Error(err)
 		}
+		msg.traceFallback(StreamRegistry.GetStreamName(msg.GetStreamID()))
+		msg.Release()
 		return
 	}
 
@@ -275,6 +407,9 @@ func (cons *SimpleConsumer) ControlLoop() {
 
 		case PluginControlStopConsumer:
 			cons.Logger.Debug("Preparing for stop")
+			if cons.IsPaused() {
+				cons.setPaused(false)
+			}
 			cons.setState(PluginStatePrepareStop)
 
 			if cons.onPrepareStop != nil {
@@ -293,7 +428,12 @@ func (cons *SimpleConsumer) ControlLoop() {
 			}
 
 			if cons.modulatorQueue != nil {
-				close(cons.modulatorQueue)
+				cons.modulatorQueue.Close()
+			}
+			if cons.ordering.isRunning() {
+				if !tgo.ReturnAfter(cons.shutdownTimeout*5, cons.ordering.stop) {
+					cons.Logger.Error("Timeout while waiting for ordering lanes")
+				}
 			}
 			return // ### return ###
 
@@ -302,6 +442,14 @@ func (cons *SimpleConsumer) ControlLoop() {
 			if cons.onRoll != nil {
 				cons.onRoll()
 			}
+
+		case PluginControlPauseConsumer:
+			cons.Logger.Debug("Received pause command")
+			cons.setPaused(true)
+
+		case PluginControlResumeConsumer:
+			cons.Logger.Debug("Received resume command")
+			cons.setPaused(false)
 		}
 	}
 }
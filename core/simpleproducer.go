@@ -36,7 +36,7 @@ import (
 //
 // - Streams: Defines a list of streams a producer will receive from. This
 // parameter is mandatory. When using "*" the producer will receive messages
-// from all streams but the internal streams (e.g. _GOLLUM_).
+// from all streams but the internal streams (_GOLLUM_ and _GOLLUM_TRACE_).
 // By default this parameter is set to an empty list".
 //
 // - FallbackStream: Defines a stream to route messages to when delivery failed.
@@ -193,6 +193,11 @@ func (prod *SimpleProducer) GetShutdownTimeout() time.Duration {
 	return prod.shutdownTimeout
 }
 
+// GetModulators returns the modulators applied by this producer
+func (prod *SimpleProducer) GetModulators() ModulatorArray {
+	return prod.modulators
+}
+
 // Modulate applies all modulators from this producer to a given message.
 // This implementation handles routing and discarding of messages.
 func (prod *SimpleProducer) Modulate(msg *Message) ModulateResult {
@@ -210,6 +215,8 @@ func (prod *SimpleProducer) HasContinueAfterModulate(msg *Message) bool {
 
 	case ModulateResultFallback:
 		RouteOriginal(msg, msg.GetRouter())
+		msg.traceFallback(StreamRegistry.GetStreamName(msg.GetStreamID()))
+		msg.Release()
 		return false
 
 	case ModulateResultContinue:
@@ -223,8 +230,16 @@ func (prod *SimpleProducer) HasContinueAfterModulate(msg *Message) bool {
 }
 
 // TryFallback routes the message to the configured fallback stream.
+// If no fallback stream is configured the message is discarded.
+// The message is released and must not be used afterwards.
 func (prod *SimpleProducer) TryFallback(msg *Message) {
+	if prod.fallbackStream == nil {
+		DiscardMessage(msg)
+		return // ### return, no fallback ###
+	}
 	RouteOriginal(msg, prod.fallbackStream)
+	msg.traceFallback(StreamRegistry.GetStreamName(prod.fallbackStream.GetStreamID()))
+	msg.Release()
 }
 
 // ControlLoop listens to the control channel and triggers callbacks for these
@@ -104,6 +104,17 @@ func (router *SimpleRouter) GetProducers() []Producer {
 	return router.Producers
 }
 
+// IsSaturated returns true if one of the producers bound to this stream is
+// saturated. See BackpressureSource.
+func (router *SimpleRouter) IsSaturated() bool {
+	for _, prod := range router.Producers {
+		if source, isSource := prod.(BackpressureSource); isSource && source.IsSaturated() {
+			return true
+		}
+	}
+	return false
+}
+
 // Modulate calls all modulators in their order of definition
 func (router *SimpleRouter) Modulate(msg *Message) ModulateResult {
 	mod := NewFilterModulator(router.filters)
@@ -70,6 +70,9 @@ func (registry streamRegistry) GetStreamName(streamID MessageStreamID) string {
 	case LogInternalStreamID:
 		return LogInternalStream
 
+	case TraceInternalStreamID:
+		return TraceInternalStream
+
 	case WildcardStreamID:
 		return WildcardStream
 
@@ -150,7 +153,7 @@ nextProd:
 // phase.
 func (registry streamRegistry) AddWildcardProducersToRouter(router Router) {
 	streamID := router.GetStreamID()
-	if streamID != LogInternalStreamID {
+	if streamID != LogInternalStreamID && streamID != TraceInternalStreamID {
 		router.AddProducer(registry.wildcard...)
 	}
 }
@@ -22,6 +22,9 @@ const (
 	InvalidStream = ""
 	// LogInternalStream is the name of the internal message channel (logs)
 	LogInternalStream = "_GOLLUM_"
+	// TraceInternalStream is the name of the internal message channel for
+	// spans of traced messages
+	TraceInternalStream = "_GOLLUM_TRACE_"
 	// WildcardStream is the name of the "all routers" channel
 	WildcardStream = "*"
 )
@@ -31,6 +34,8 @@ var (
 	InvalidStreamID = GetStreamID(InvalidStream)
 	// LogInternalStreamID is the ID of the "_GOLLUM_" stream
 	LogInternalStreamID = GetStreamID(LogInternalStream)
+	// TraceInternalStreamID is the ID of the "_GOLLUM_TRACE_" stream
+	TraceInternalStreamID = GetStreamID(TraceInternalStream)
 	// WildcardStreamID is the ID of the "*" stream
 	WildcardStreamID = GetStreamID(WildcardStream)
 )
@@ -0,0 +1,98 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// StreamTap receives copies of the messages routed to a stream. Messages are
+// copied after the router's filters have been applied. If the tap's buffer
+// is full, messages are skipped, so a tap never slows down routing.
+type StreamTap struct {
+	streamID MessageStreamID
+	messages chan *Message
+}
+
+type streamTapRegistry struct {
+	taps  map[MessageStreamID][]*StreamTap
+	count *int32
+	guard *sync.RWMutex
+}
+
+var streamTaps = streamTapRegistry{
+	taps:  make(map[MessageStreamID][]*StreamTap),
+	count: new(int32),
+	guard: new(sync.RWMutex),
+}
+
+// TapStream attaches a new tap to the given stream. The tap buffers up to
+// capacity messages. Call Close to detach the tap.
+func TapStream(streamID MessageStreamID, capacity int) *StreamTap {
+	tap := &StreamTap{
+		streamID: streamID,
+		messages: make(chan *Message, capacity),
+	}
+
+	streamTaps.guard.Lock()
+	defer streamTaps.guard.Unlock()
+
+	streamTaps.taps[streamID] = append(streamTaps.taps[streamID], tap)
+	atomic.AddInt32(streamTaps.count, 1)
+	return tap
+}
+
+// Messages returns the channel the copied messages are written to
+func (tap *StreamTap) Messages() <-chan *Message {
+	return tap.messages
+}
+
+// Close detaches the tap from its stream
+func (tap *StreamTap) Close() {
+	streamTaps.guard.Lock()
+	defer streamTaps.guard.Unlock()
+
+	taps := streamTaps.taps[tap.streamID]
+	for idx, registered := range taps {
+		if registered == tap {
+			streamTaps.taps[tap.streamID] = append(taps[:idx:idx], taps[idx+1:]...)
+			atomic.AddInt32(streamTaps.count, -1)
+			return // ### return, removed ###
+		}
+	}
+}
+
+// publish sends a copy of the message to all taps of the message's stream.
+func (registry streamTapRegistry) publish(msg *Message) {
+	if atomic.LoadInt32(registry.count) == 0 {
+		return // ### return, no taps ###
+	}
+
+	registry.guard.RLock()
+	defer registry.guard.RUnlock()
+
+	for _, tap := range registry.taps[msg.GetStreamID()] {
+		// Metadata is copied, too, as it is modified by producers
+		clone := msg.Clone()
+		clone.data.Metadata = msg.data.Metadata.Clone()
+
+		select {
+		case tap.messages <- clone:
+		default:
+			// buffer is full, skip this message
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"github.com/trivago/tgo/ttesting"
+	"testing"
+)
+
+func TestStreamTap(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	streamID := GetStreamID("tapStream")
+	otherID := GetStreamID("otherTapStream")
+
+	tap := TapStream(streamID, 1)
+	msg := NewMessage(nil, []byte("first"), nil, streamID)
+	msg.GetMetadata().SetValue("key", []byte("value"))
+
+	streamTaps.publish(msg)
+	streamTaps.publish(NewMessage(nil, []byte("skipped"), nil, streamID))
+	streamTaps.publish(NewMessage(nil, []byte("other"), nil, otherID))
+
+	expect.Equal(1, len(tap.Messages()))
+	copied := <-tap.Messages()
+	expect.Equal("first", copied.String())
+
+	// Changes to the routed message must not affect the copy
+	msg.GetMetadata().SetValue("key", []byte("changed"))
+	expect.Equal("value", copied.GetMetadata().GetValueString("key"))
+
+	tap.Close()
+	streamTaps.publish(NewMessage(nil, []byte("closed"), nil, streamID))
+	expect.Equal(0, len(tap.Messages()))
+	expect.Equal(int32(0), *streamTaps.count)
+}
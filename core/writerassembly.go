@@ -77,6 +77,7 @@ func (asm *WriterAssembly) getWriter() io.Writer {
 // a MessageBatch to an io.Writer.
 // Messages are formatted using a given formatter. If the io.Writer fails to
 // write the assembled buffer all messages are passed to the FLush() method.
+// Messages that have been written are released.
 func (asm *WriterAssembly) Write(messages []*Message) {
 	writer := asm.getWriter()
 
@@ -112,6 +113,11 @@ func (asm *WriterAssembly) Write(messages []*Message) {
 	// Data sent, flush if validation is required and fails
 	if asm.validate != nil && !asm.validate() {
 		asm.Flush(messages)
+		return // ### return, validation failed ###
+	}
+
+	for _, msg := range messages {
+		msg.Release()
 	}
 }
 
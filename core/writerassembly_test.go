@@ -88,7 +88,9 @@ func TestWriterAssemblyWrite(t *testing.T) {
 	mockIo := mockIoWrite{expect}
 	wa := NewWriterAssembly(nil, mockIo.mockFlush, &mockFormatter{})
 
-	msg1 := NewMessage(nil, []byte("abcde"), nil, InvalidStreamID)
+	// Mock messages are not reference counted, so they can be written
+	// multiple times.
+	msg1 := getMockMessage("abcde")
 
 	// should give error msg and flush msg as there writer is not available yet
 	// test here is done in mockIo.mockFlush
@@ -26,6 +26,7 @@ var (
 	flagVersion        = tflag.Switch("v", "version", "Print version information and quit.")
 	flagExtVersion     = tflag.Switch("r", "runtime", "Print runtime information and quit.")
 	flagModules        = tflag.Switch("l", "list", "Print plugin information and quit.")
+	flagSchema         = tflag.Switch("js", "schema", "Print a JSON Schema of all plugin options for config files and quit.")
 	flagConfigFile     = tflag.String("c", "config", "", "Use a given configuration file.")
 	flagTestConfigFile = tflag.String("tc", "testconfig", "", "Test the given configuration file and exit.")
 	flagLoglevel       = tflag.Int("ll", "loglevel", 2, "Set the loglevel [0-3] as in {0=Error, 1=+Warning, 2=+Info, 3=+Debug}.")
@@ -34,10 +35,17 @@ var (
 	flagPidFile        = tflag.String("p", "pidfile", "", "Write the process id into a given file.")
 	flagMetricsAddress = tflag.String("m", "metrics", "", "Address to use for metric queries. Disabled by default.")
 	flagHealthCheck    = tflag.String("hc", "healthcheck", "", "Listening address ([IP]:PORT) to use for healthcheck HTTP endpoint. Disabled by default.")
+	flagAdminAddress   = tflag.String("a", "admin", "", "Listening address ([IP]:PORT) to use for the admin HTTP API. Disabled by default.")
+	flagAdminToken     = tflag.String("at", "admin-token", "", "Token required by the admin HTTP API. Defaults to the GOLLUM_ADMIN_TOKEN environment variable.")
 	flagCPUProfile     = tflag.String("pc", "profilecpu", "", "Write CPU profiler results to a given file.")
 	flagMemProfile     = tflag.String("pm", "profilemem", "", "Write heap profile results to a given file.")
 	flagProfile        = tflag.Switch("ps", "profilespeed", "Write msg/sec measurements to log.")
 	flagTrace          = tflag.String("tr", "trace", "", "Write trace results to a given file.")
+	flagReplay         = tflag.String("rp", "replay", "", "Replay sample input through the given configuration file without sending any data and exit.")
+	flagReplayInput    = tflag.String("i", "input", "", "Read sample input for -replay from a given file, one message per line. Defaults to stdin.")
+	flagReplayConsumer = tflag.String("rc", "consumer", "", "Use the consumer with the given ID as the source of -replay messages.")
+	flagGraph          = tflag.String("gr", "graph", "", "Print the message flow of the given configuration file, report problems found in it and exit.")
+	flagGraphFormat    = tflag.String("gf", "graph-format", "dot", "Output format of -graph. One of \"dot\" (default), \"mermaid\".")
 )
 
 func parseFlags() {
@@ -45,7 +53,7 @@ func parseFlags() {
 }
 
 func printFlags() {
-	helpMessageStr := fmt.Sprintf("Usage: gollum [OPTIONS]\n\nGollum - An n:m message multiplexer.\nVersion: %s\n\nOptions:", core.GetVersionString())
+	helpMessageStr := fmt.Sprintf("Usage: gollum [OPTIONS]\n       gollum -replay CONFIG [-input FILE] [-consumer ID]\n       gollum -graph CONFIG [-graph-format dot|mermaid]\n       gollum spool COMMAND [OPTIONS]\n\nGollum - An n:m message multiplexer.\nVersion: %s\n\nOptions:", core.GetVersionString())
 	tflag.PrintFlags(helpMessageStr)
 }
 
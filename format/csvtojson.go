@@ -0,0 +1,267 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/trivago/gollum/core"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// CSVToJSON formatter
+//
+// This formatter parses RFC4180 compliant CSV data and converts each record
+// into a JSON object. Quoted fields may contain delimiters, escaped quotes
+// ("") and newlines. If a message contains more than one record, one JSON
+// object per record is written, separated by newlines.
+//
+// Parameters
+//
+// - Delimiter: Defines the character separating two fields.
+// By default this parameter is set to ",".
+//
+// - Columns: Defines the names of the columns in order of appearance. Values
+// without a column name are stored as "field<index>" with index starting at 0.
+// By default this parameter is set to an empty list.
+//
+// - FirstLineHeader: When set to true, the first record of each input file
+// is used as the list of column names and overrides Columns. Input files are
+// told apart by HeaderSource. Records repeating the header of their file,
+// e.g. after the file has been rotated, are skipped. A message that contains
+// only the header record is discarded.
+// By default this parameter is set to false.
+//
+// - HeaderSource: Defines the metadata key identifying the input file of a
+// message when FirstLineHeader is set. Use "file" for consumer.File and "key"
+// for consumer.AwsS3. Messages without this key share the same header. The
+// headers of the last 1024 files are kept.
+// By default this parameter is set to "file".
+//
+// - Types: Defines a map of column name to type. Column names are matched
+// case insensitive. Valid types are "string", "int", "float", "bool" and
+// "json". Values that cannot be converted are
+// written as strings. Columns not listed are written as strings.
+// By default this parameter is set to an empty map.
+//
+// - TrimSpace: When set to true, leading whitespace is removed from fields.
+// By default this parameter is set to false.
+//
+// Examples
+//
+// This example reads CSV files that start with a header line, converting the
+// "status" and "bytes" columns to numbers:
+//
+//  exampleConsumer:
+//    Type: consumer.File
+//    Streams: "*"
+//    File: /var/log/export.csv
+//    Modulators:
+//      - format.CSVToJSON:
+//          FirstLineHeader: true
+//          Types:
+//            status: int
+//            bytes: int
+type CSVToJSON struct {
+	core.SimpleFormatter `gollumdoc:"embed_type"`
+	firstLineHeader      bool   `config:"FirstLineHeader" default:"false"`
+	headerSource         string `config:"HeaderSource" default:"file"`
+	trimSpace            bool   `config:"TrimSpace" default:"false"`
+	delimiter            rune
+	columns              []string
+	types                map[string]string
+	headers              map[string][]string
+	headerOrder          []string
+	headerGuard          *sync.RWMutex
+}
+
+// csvMaxHeaderSources is the number of input files headers are kept for
+const csvMaxHeaderSources = 1024
+
+func init() {
+	core.TypeRegistry.Register(CSVToJSON{})
+}
+
+// Configure initializes this formatter with values from a plugin config.
+func (format *CSVToJSON) Configure(conf core.PluginConfigReader) {
+	format.delimiter = getCSVDelimiter(conf)
+	format.columns = conf.GetStringArray("Columns", []string{})
+	format.types = make(map[string]string)
+	format.headers = make(map[string][]string)
+	format.headerGuard = new(sync.RWMutex)
+
+	// Map keys are lowercased when reading the config, so columns are matched
+	// case insensitive.
+	for column, typeName := range conf.GetStringMap("Types", map[string]string{}) {
+		switch strings.ToLower(typeName) {
+		case "string", "int", "float", "bool", "json":
+			format.types[strings.ToLower(column)] = strings.ToLower(typeName)
+		default:
+			conf.Errors.Pushf("Unknown type '%s' for column '%s'", typeName, column)
+		}
+	}
+}
+
+// getCSVDelimiter reads the "Delimiter" setting shared by the CSV formatters
+func getCSVDelimiter(conf core.PluginConfigReader) rune {
+	delimiter := conf.GetString("Delimiter", ",")
+	if utf8.RuneCountInString(delimiter) != 1 {
+		conf.Errors.Pushf("Delimiter must be a single character")
+		return ','
+	}
+	char, _ := utf8.DecodeRuneInString(delimiter)
+	return char
+}
+
+// ApplyFormatter update message payload
+func (format *CSVToJSON) ApplyFormatter(msg *core.Message) error {
+	reader := csv.NewReader(bytes.NewReader(format.GetAppliedContent(msg)))
+	reader.Comma = format.delimiter
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = format.trimSpace
+
+	source := ""
+	if format.headerSource != "" {
+		source = msg.GetMetadata().GetValueString(format.headerSource)
+	}
+
+	buffer := bytes.NewBuffer(make([]byte, 0, 256))
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			format.Logger.Warning("CSVToJSON failed to parse a message: ", err)
+			return err
+		}
+
+		columns, isHeader := format.getColumns(source, record)
+		if isHeader {
+			continue // ### continue, header record ###
+		}
+
+		if buffer.Len() > 0 {
+			buffer.WriteByte('\n')
+		}
+		format.writeRecord(buffer, record, columns)
+	}
+
+	if buffer.Len() == 0 {
+		return fmt.Errorf("CSVToJSON message contained no records")
+	}
+
+	format.SetAppliedContent(msg, buffer.Bytes())
+	return nil
+}
+
+// getColumns returns the column names used for a record of the given input
+// file. If FirstLineHeader is set, the first record of each file and records
+// repeating that header are reported as header.
+func (format *CSVToJSON) getColumns(source string, record []string) ([]string, bool) {
+	if !format.firstLineHeader {
+		return format.columns, false // ### return, no header expected ###
+	}
+
+	format.headerGuard.RLock()
+	header, known := format.headers[source]
+	format.headerGuard.RUnlock()
+	if known {
+		return header, isSameCSVRecord(header, record)
+	}
+
+	format.headerGuard.Lock()
+	defer format.headerGuard.Unlock()
+	if header, known := format.headers[source]; known {
+		return header, isSameCSVRecord(header, record) // ### return, header set by concurrent call ###
+	}
+
+	if len(format.headerOrder) >= csvMaxHeaderSources {
+		delete(format.headers, format.headerOrder[0])
+		format.headerOrder = format.headerOrder[1:]
+	}
+	format.headers[source] = record
+	format.headerOrder = append(format.headerOrder, source)
+	return record, true
+}
+
+// isSameCSVRecord returns true if both records contain the same fields
+func isSameCSVRecord(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeRecord writes a record as JSON object to the given buffer
+func (format *CSVToJSON) writeRecord(buffer *bytes.Buffer, record []string, columns []string) {
+	buffer.WriteByte('{')
+	for i, value := range record {
+		var column string
+		if i < len(columns) {
+			column = columns[i]
+		} else {
+			column = fmt.Sprintf("field%d", i)
+		}
+
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		key, _ := json.Marshal(column)
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(format.convertValue(column, value))
+	}
+	buffer.WriteByte('}')
+}
+
+// convertValue returns the JSON representation of a value based on the type
+// configured for the given column.
+func (format *CSVToJSON) convertValue(column, value string) []byte {
+	switch format.types[strings.ToLower(column)] {
+	case "int":
+		if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return strconv.AppendInt(nil, number, 10)
+		}
+	case "float":
+		if number, err := strconv.ParseFloat(value, 64); err == nil && !math.IsNaN(number) && !math.IsInf(number, 0) {
+			return strconv.AppendFloat(nil, number, 'f', -1, 64)
+		}
+	case "bool":
+		if flag, err := strconv.ParseBool(value); err == nil {
+			return strconv.AppendBool(nil, flag)
+		}
+	case "json":
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(value), &decoded); err == nil {
+			return []byte(value)
+		}
+	}
+
+	encoded, _ := json.Marshal(value)
+	return encoded
+}
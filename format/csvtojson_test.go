@@ -0,0 +1,99 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"testing"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestCSVToJSONColumns(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.CSVToJSON")
+	config.Override("Columns", []string{"name", "comment", "count"})
+	config.Override("Types", map[string]string{"count": "int"})
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter, casted := plugin.(*CSVToJSON)
+	expect.True(casted)
+
+	msg := core.NewMessage(nil, []byte("frodo,\"a, \"\"quoted\"\"\nvalue\",42,extra"),
+		nil, core.InvalidStreamID)
+
+	err = formatter.ApplyFormatter(msg)
+	expect.NoError(err)
+	expect.Equal(`{"name":"frodo","comment":"a, \"quoted\"\nvalue","count":42,"field3":"extra"}`,
+		string(msg.GetPayload()))
+}
+
+func TestCSVToJSONFirstLineHeader(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.CSVToJSON")
+	config.Override("FirstLineHeader", true)
+	config.Override("Delimiter", ";")
+	config.Override("Types", map[string]string{"ok": "bool", "ratio": "float"})
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter := plugin.(*CSVToJSON)
+
+	msg := core.NewMessage(nil, []byte("host;OK;ratio"), nil, core.InvalidStreamID)
+	expect.NotNil(formatter.ApplyFormatter(msg))
+
+	msg = core.NewMessage(nil, []byte("web01;true;0.5\nweb02;no;n/a"), nil, core.InvalidStreamID)
+	expect.NoError(formatter.ApplyFormatter(msg))
+	expect.Equal("{\"host\":\"web01\",\"OK\":true,\"ratio\":0.5}\n{\"host\":\"web02\",\"OK\":\"no\",\"ratio\":\"n/a\"}",
+		string(msg.GetPayload()))
+}
+
+func TestCSVToJSONHeaderPerFile(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.CSVToJSON")
+	config.Override("FirstLineHeader", true)
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter := plugin.(*CSVToJSON)
+
+	apply := func(file, data string) (string, error) {
+		msg := core.NewMessage(nil, []byte(data), core.Metadata{"file": []byte(file)}, core.InvalidStreamID)
+		err := formatter.ApplyFormatter(msg)
+		return msg.String(), err
+	}
+
+	_, err = apply("a.csv", "host,status")
+	expect.NotNil(err)
+	_, err = apply("b.csv", "status,host")
+	expect.NotNil(err)
+
+	result, err := apply("a.csv", "web01,200")
+	expect.NoError(err)
+	expect.Equal(`{"host":"web01","status":"200"}`, result)
+
+	result, err = apply("b.csv", "404,web02")
+	expect.NoError(err)
+	expect.Equal(`{"status":"404","host":"web02"}`, result)
+
+	// A rotated file repeats its header
+	result, err = apply("a.csv", "host,status\nweb03,500")
+	expect.NoError(err)
+	expect.Equal(`{"host":"web03","status":"500"}`, result)
+}
@@ -0,0 +1,239 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/gollum/core/components"
+	"github.com/trivago/tgo/tcontainer"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// EventTime formatter
+//
+// This formatter parses the time an event happened and uses it as the
+// message's timestamp (see Message.GetCreationTime) instead of the time the
+// message was received. Producers using date based targets, like
+// producer.ElasticSearch or producer.AwsS3, can use this time to select the
+// correct target. The timestamp is read from the content selected by ApplyTo,
+// optionally narrowed down by a JSON field or regular expression.
+//
+// Parameters
+//
+// - Field: Defines a JSON field to read the timestamp from. Paths can be
+// defined in a format accepted by tgo.MarshalMap.Path. If this parameter is
+// empty, the content is not parsed as JSON.
+// By default this parameter is set to "".
+//
+// - Regexp: Defines a regular expression to extract the timestamp. The first
+// capturing group is used as timestamp. If no group is defined the whole
+// match is used. If Field is set, the regular expression is applied to the
+// field's value.
+// By default this parameter is set to "".
+//
+// - WriteFormat: If set, the parsed timestamp is written back in this format,
+// replacing the original value. Supports the same names as TimeFormats.
+// By default this parameter is set to "", i.e. the content is not modified.
+//
+// - DiscardOnError: When set to true, messages without a parsable timestamp
+// are discarded. Otherwise these messages keep their original timestamp. In
+// this case a warning containing the number of affected messages is logged at
+// most every 10 seconds, each message is logged at debug level.
+// By default this parameter is set to false.
+//
+// Examples
+//
+// This example reads an epoch timestamp in milliseconds or an RFC3339 date
+// from the field "time" and rewrites it as RFC3339 in UTC.
+//
+//  exampleConsumer:
+//    Type: consumer.Console
+//    Streams: "*"
+//    Modulators:
+//      - format.EventTime:
+//          Field: time
+//          TimeFormats:
+//            - unixms
+//            - rfc3339
+//          WriteFormat: rfc3339
+//
+// This example reads the timestamp of an nginx access log line.
+//
+//  exampleConsumer:
+//    Type: consumer.Console
+//    Streams: "*"
+//    Modulators:
+//      - format.EventTime:
+//          Regexp: '\[([^\]]+)\]'
+//          TimeFormats: "02/Jan/2006:15:04:05 -0700"
+type EventTime struct {
+	core.SimpleFormatter `gollumdoc:"embed_type"`
+	Parser               components.TimeParser `gollumdoc:"embed_type"`
+	field                string                `config:"Field"`
+	writeFormat          string                `config:"WriteFormat"`
+	discardOnError       bool                  `config:"DiscardOnError" default:"false"`
+	expression           *regexp.Regexp
+	numErrors            *int64
+	lastWarning          *int64
+}
+
+// eventTimeWarnInterval is the minimum time between two warnings about
+// messages keeping their original timestamp
+const eventTimeWarnInterval = 10 * time.Second
+
+func init() {
+	core.TypeRegistry.Register(EventTime{})
+}
+
+// Configure initializes this formatter with values from a plugin config.
+func (format *EventTime) Configure(conf core.PluginConfigReader) {
+	format.numErrors = new(int64)
+	format.lastWarning = new(int64)
+
+	if expression := conf.GetString("Regexp", ""); expression != "" {
+		var err error
+		format.expression, err = regexp.Compile(expression)
+		conf.Errors.Push(err)
+	}
+}
+
+// ApplyFormatter update message payload
+func (format *EventTime) ApplyFormatter(msg *core.Message) error {
+	err := format.applyEventTime(msg)
+	switch {
+	case err == nil:
+		return nil
+	case format.discardOnError:
+		return err
+	default:
+		format.logParseError(err)
+		return nil
+	}
+}
+
+// logParseError reports a message keeping its original timestamp. To avoid
+// flooding the log, the number of these messages is logged as warning at most
+// every eventTimeWarnInterval.
+func (format *EventTime) logParseError(err error) {
+	atomic.AddInt64(format.numErrors, 1)
+	format.Logger.Debug("EventTime kept the original timestamp: ", err)
+
+	now := time.Now().UnixNano()
+	lastWarning := atomic.LoadInt64(format.lastWarning)
+	if now-lastWarning < int64(eventTimeWarnInterval) || !atomic.CompareAndSwapInt64(format.lastWarning, lastWarning, now) {
+		return // ### return, warned recently ###
+	}
+
+	numErrors := atomic.SwapInt64(format.numErrors, 0)
+	format.Logger.Warningf("EventTime kept the original timestamp of %d message(s), last error: %s", numErrors, err)
+}
+
+func (format *EventTime) applyEventTime(msg *core.Message) error {
+	content := format.GetAppliedContent(msg)
+	if format.field == "" {
+		value, err := format.parseAndRewrite(msg, string(content))
+		if err == nil && format.writeFormat != "" {
+			format.SetAppliedContent(msg, []byte(value))
+		}
+		return err
+	}
+
+	values := tcontainer.NewMarshalMap()
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return fmt.Errorf("EventTime failed to unmarshal a message: %s", err)
+	}
+
+	fieldValue, exists := values.Value(format.field)
+	if !exists {
+		return fmt.Errorf("EventTime field '%s' not found", format.field)
+	}
+
+	value, err := format.parseAndRewrite(msg, fmt.Sprintf("%v", fieldValue))
+	if err != nil || format.writeFormat == "" {
+		return err
+	}
+
+	var newValue interface{} = value
+	if format.expression == nil && strings.HasPrefix(strings.ToLower(format.writeFormat), "unix") {
+		newValue = json.Number(value)
+	}
+
+	if !setJSONPath(values, format.field, newValue) {
+		return fmt.Errorf("EventTime cannot write field '%s'", format.field)
+	}
+
+	newContent, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	format.SetAppliedContent(msg, newContent)
+	return nil
+}
+
+// parseAndRewrite parses the timestamp from value, sets it as message time
+// and returns value with the timestamp formatted using WriteFormat.
+func (format *EventTime) parseAndRewrite(msg *core.Message, value string) (string, error) {
+	start, end := 0, len(value)
+	if format.expression != nil {
+		match := format.expression.FindStringSubmatchIndex(value)
+		switch {
+		case match == nil:
+			return value, fmt.Errorf("EventTime regexp did not match")
+		case len(match) >= 4 && match[2] >= 0:
+			start, end = match[2], match[3]
+		default:
+			start, end = match[0], match[1]
+		}
+	}
+
+	timestamp, err := format.Parser.Parse(value[start:end])
+	if err != nil {
+		return value, err
+	}
+
+	msg.SetCreationTime(timestamp)
+	if format.writeFormat == "" {
+		return value, nil
+	}
+
+	return value[:start] + components.FormatTime(timestamp, format.writeFormat) + value[end:], nil
+}
+
+// setJSONPath sets the value of a "/" separated path inside nested objects.
+// Returns false if the path does not exist.
+func setJSONPath(values map[string]interface{}, path string, value interface{}) bool {
+	keys := strings.Split(path, "/")
+	for _, key := range keys[:len(keys)-1] {
+		switch nested := values[key].(type) {
+		case map[string]interface{}:
+			values = nested
+		case tcontainer.MarshalMap:
+			values = nested
+		default:
+			return false
+		}
+	}
+
+	values[keys[len(keys)-1]] = value
+	return true
+}
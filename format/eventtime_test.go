@@ -0,0 +1,147 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestEventTimeJSONField(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.EventTime")
+	config.Override("Field", "event/time")
+	config.Override("TimeFormats", []string{"rfc3339", "unixms"})
+	config.Override("WriteFormat", "rfc3339")
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter, casted := plugin.(*EventTime)
+	expect.True(casted)
+
+	msg := core.NewMessage(nil, []byte(`{"event":{"time":1500000000123},"foo":"bar"}`), nil, core.InvalidStreamID)
+
+	err = formatter.ApplyFormatter(msg)
+	expect.NoError(err)
+	expect.Equal(int64(1500000000123), msg.GetCreationTime().UnixNano()/int64(time.Millisecond))
+	expect.Equal(`{"event":{"time":"2017-07-14T02:40:00Z"},"foo":"bar"}`, string(msg.GetPayload()))
+}
+
+func TestEventTimeRegexpTimezone(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.EventTime")
+	config.Override("Regexp", `\[([^\]]+)\]`)
+	config.Override("TimeFormats", "2006-01-02 15:04:05")
+	config.Override("Timezone", "Europe/Berlin")
+	config.Override("WriteFormat", "unix")
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter := plugin.(*EventTime)
+
+	msg := core.NewMessage(nil, []byte(`GET / [2017-07-14 04:40:00] 200`), nil, core.InvalidStreamID)
+
+	err = formatter.ApplyFormatter(msg)
+	expect.NoError(err)
+	expect.Equal(int64(1500000000), msg.GetCreationTime().Unix())
+	expect.Equal(`GET / [1500000000] 200`, string(msg.GetPayload()))
+}
+
+func TestEventTimeMetadata(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.EventTime")
+	config.Override("ApplyTo", "ts")
+	config.Override("TimeFormats", "unixns")
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter := plugin.(*EventTime)
+
+	metadata := core.Metadata{"ts": []byte("1500000000000000001")}
+	msg := core.NewMessage(nil, []byte("payload"), metadata, core.InvalidStreamID)
+
+	err = formatter.ApplyFormatter(msg)
+	expect.NoError(err)
+	expect.Equal(int64(1500000000000000001), msg.GetCreationTime().UnixNano())
+	expect.Equal("1500000000000000001", msg.GetMetadata().GetValueString("ts"))
+}
+
+func TestEventTimeInvalid(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.EventTime")
+	config.Override("Field", "time")
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter := plugin.(*EventTime)
+
+	msg := core.NewMessage(nil, []byte(`{"time":"yesterday"}`), nil, core.InvalidStreamID)
+	arrival := msg.GetCreationTime()
+
+	expect.NoError(formatter.ApplyFormatter(msg))
+	expect.Equal(arrival, msg.GetCreationTime())
+
+	config.Override("DiscardOnError", true)
+	plugin, err = core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter = plugin.(*EventTime)
+
+	expect.NotNil(formatter.ApplyFormatter(msg))
+}
+
+func TestEventTimeInvalidWarning(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.EventTime")
+	config.Override("Field", "time")
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter := plugin.(*EventTime)
+
+	logger, hook := test.NewNullLogger()
+	logger.Level = logrus.DebugLevel
+	formatter.SetLogger(logger)
+
+	for i := 0; i < 3; i++ {
+		msg := core.NewMessage(nil, []byte(`{"time":"yesterday"}`), nil, core.InvalidStreamID)
+		expect.NoError(formatter.ApplyFormatter(msg))
+	}
+
+	warnings := 0
+	debugs := 0
+	for _, entry := range hook.AllEntries() {
+		switch entry.Level {
+		case logrus.WarnLevel:
+			warnings++
+		case logrus.DebugLevel:
+			debugs++
+		}
+	}
+
+	// Only the first error is reported as warning within the warn interval
+	expect.Equal(1, warnings)
+	expect.Equal(3, debugs)
+	expect.Equal(int64(2), *formatter.numErrors)
+}
@@ -0,0 +1,155 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jmespath/go-jmespath"
+	"github.com/trivago/gollum/core"
+	"strings"
+)
+
+// JMESPath formatter
+//
+// This formatter evaluates a JMESPath expression (see http://jmespath.org)
+// against a JSON encoded payload or metadata field. The result of the
+// expression replaces the target content. This allows selecting array
+// elements by predicate, projecting lists and building completely new
+// documents from an existing one.
+//
+// Parameters
+//
+// - Expression: Defines the JMESPath expression to evaluate.
+// By default this parameter is set to "@", i.e. the document is passed as-is.
+//
+// - Target: Defines where the result is written to. Use "" to write to the
+// payload, other values define the name of a metadata field. By default
+// the result is written to the location given by ApplyTo.
+//
+// - RawStrings: When set to true, results that evaluate to a single string
+// are written without quotes. Other results are always written as JSON.
+// By default this parameter is set to false.
+//
+// - OnNull: Defines what happens if the expression evaluates to null.
+// Set to "keep" to leave the target untouched, "discard" to drop the message
+// or "fallback" to write the value given by FallbackValue.
+// By default this parameter is set to "keep".
+//
+// - FallbackValue: Defines the content written to the target if OnNull is
+// set to "fallback". The value is written as-is.
+// By default this parameter is set to "null".
+//
+// Examples
+//
+// This example picks the names of all failed checks from a health report and
+// stores them as a JSON array in the metadata field "failed".
+//
+//  exampleConsumer:
+//    Type: consumer.Console
+//    Streams: "*"
+//    Modulators:
+//      - format.JMESPath:
+//          Expression: "checks[?status != 'ok'].name"
+//          Target: failed
+//
+// This example restructures a document, keeping only selected fields.
+//
+//  exampleConsumer:
+//    Type: consumer.Console
+//    Streams: "*"
+//    Modulators:
+//      - format.JMESPath:
+//          Expression: "{host: meta.host, level: log.level, msg: log.message}"
+//          OnNull: discard
+type JMESPath struct {
+	core.SimpleFormatter `gollumdoc:"embed_type"`
+	expression           *jmespath.JMESPath
+	setTargetContent     core.SetAppliedContent
+	rawStrings           bool   `config:"RawStrings" default:"false"`
+	fallbackValue        []byte `config:"FallbackValue" default:"null"`
+	onNull               jmesPathNullAction
+}
+
+type jmesPathNullAction int
+
+const (
+	jmesPathNullKeep = jmesPathNullAction(iota)
+	jmesPathNullDiscard
+	jmesPathNullFallback
+)
+
+func init() {
+	core.TypeRegistry.Register(JMESPath{})
+}
+
+// Configure initializes this formatter with values from a plugin config.
+func (format *JMESPath) Configure(conf core.PluginConfigReader) {
+	var err error
+	format.expression, err = jmespath.Compile(conf.GetString("Expression", "@"))
+	conf.Errors.Push(err)
+
+	target := conf.GetString("Target", conf.GetString("ApplyTo", ""))
+	format.setTargetContent = core.GetAppliedContentSetFunction(target)
+
+	switch onNull := strings.ToLower(conf.GetString("OnNull", "keep")); onNull {
+	case "keep":
+		format.onNull = jmesPathNullKeep
+	case "discard":
+		format.onNull = jmesPathNullDiscard
+	case "fallback":
+		format.onNull = jmesPathNullFallback
+	default:
+		conf.Errors.Pushf("Unknown OnNull action '%s'", onNull)
+	}
+}
+
+// ApplyFormatter update message payload
+func (format *JMESPath) ApplyFormatter(msg *core.Message) error {
+	var document interface{}
+	if err := json.Unmarshal(format.GetAppliedContent(msg), &document); err != nil {
+		format.Logger.Warning("JMESPath failed to unmarshal a message: ", err)
+		return err
+	}
+
+	result, err := format.expression.Search(document)
+	if err != nil {
+		format.Logger.Warning("JMESPath failed to evaluate expression: ", err)
+		return err
+	}
+
+	if result == nil {
+		switch format.onNull {
+		case jmesPathNullDiscard:
+			return fmt.Errorf("JMESPath expression evaluated to null")
+		case jmesPathNullFallback:
+			format.setTargetContent(msg, format.fallbackValue)
+		}
+		return nil
+	}
+
+	if str, isString := result.(string); isString && format.rawStrings {
+		format.setTargetContent(msg, []byte(str))
+		return nil
+	}
+
+	content, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	format.setTargetContent(msg, content)
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"testing"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestJMESPathProjection(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.JMESPath")
+	config.Override("Expression", "checks[?status != 'ok'].name")
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter, casted := plugin.(*JMESPath)
+	expect.True(casted)
+
+	msg := core.NewMessage(nil, []byte(`{"checks":[{"name":"disk","status":"ok"},{"name":"cpu","status":"high"},{"name":"net","status":"down"}]}`),
+		nil, core.InvalidStreamID)
+
+	err = formatter.ApplyFormatter(msg)
+	expect.NoError(err)
+	expect.Equal(`["cpu","net"]`, string(msg.GetPayload()))
+}
+
+func TestJMESPathReshape(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.JMESPath")
+	config.Override("Expression", "{host: meta.host, count: length(items)}")
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter, casted := plugin.(*JMESPath)
+	expect.True(casted)
+
+	msg := core.NewMessage(nil, []byte(`{"meta":{"host":"web01"},"items":[1,2,3]}`),
+		nil, core.InvalidStreamID)
+
+	err = formatter.ApplyFormatter(msg)
+	expect.NoError(err)
+	expect.Equal(`{"count":3,"host":"web01"}`, string(msg.GetPayload()))
+}
+
+func TestJMESPathMetadata(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.JMESPath")
+	config.Override("Expression", "user.name")
+	config.Override("ApplyTo", "doc")
+	config.Override("Target", "user")
+	config.Override("RawStrings", true)
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter, casted := plugin.(*JMESPath)
+	expect.True(casted)
+
+	metadata := core.Metadata{"doc": []byte(`{"user":{"name":"bilbo"}}`)}
+	msg := core.NewMessage(nil, []byte("payload"), metadata, core.InvalidStreamID)
+
+	err = formatter.ApplyFormatter(msg)
+	expect.NoError(err)
+	expect.Equal("payload", string(msg.GetPayload()))
+	expect.Equal("bilbo", msg.GetMetadata().GetValueString("user"))
+	expect.Equal(`{"user":{"name":"bilbo"}}`, msg.GetMetadata().GetValueString("doc"))
+}
+
+func TestJMESPathOnNull(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	input := []byte(`{"foo":"bar"}`)
+
+	config := core.NewPluginConfig("", "format.JMESPath")
+	config.Override("Expression", "missing")
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter := plugin.(*JMESPath)
+
+	msg := core.NewMessage(nil, input, nil, core.InvalidStreamID)
+	expect.NoError(formatter.ApplyFormatter(msg))
+	expect.Equal(string(input), string(msg.GetPayload()))
+
+	config.Override("OnNull", "discard")
+	plugin, err = core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter = plugin.(*JMESPath)
+
+	msg = core.NewMessage(nil, input, nil, core.InvalidStreamID)
+	expect.NotNil(formatter.ApplyFormatter(msg))
+
+	config.Override("OnNull", "fallback")
+	config.Override("FallbackValue", "{}")
+	plugin, err = core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter = plugin.(*JMESPath)
+
+	msg = core.NewMessage(nil, input, nil, core.InvalidStreamID)
+	expect.NoError(formatter.ApplyFormatter(msg))
+	expect.Equal("{}", string(msg.GetPayload()))
+}
+
+func TestJMESPathInvalidExpression(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.JMESPath")
+	config.Override("Expression", "foo[?")
+
+	_, err := core.NewPluginWithConfig(config)
+	expect.NotNil(err)
+}
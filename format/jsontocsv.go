@@ -0,0 +1,198 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tcontainer"
+	"sort"
+	"sync/atomic"
+)
+
+// JSONToCSV formatter
+//
+// This formatter converts a JSON object into a single RFC4180 compliant CSV
+// record. Values containing delimiters, quotes or newlines are quoted. Nested
+// objects and arrays are written as JSON, null values as empty fields.
+// The resulting record does not end with a newline, use format.Envelope to
+// add one if required.
+//
+// Parameters
+//
+// - Delimiter: Defines the character separating two fields.
+// By default this parameter is set to ",".
+//
+// - Columns: Defines the JSON keys to write and their order. Missing keys are
+// written as empty fields. If this list is empty, all keys of each object are
+// written in alphabetical order, so records of objects with different keys do
+// not share the same columns. Keys can be given as paths like "user/name"
+// (see tcontainer.MarshalMap.Path).
+// By default this parameter is set to an empty list.
+//
+// - Header: When set to true, producers writing files or objects, i.e.
+// producer.File and producer.AwsS3, write a header record containing the
+// column names at the beginning of every new file or object. The formatter
+// has to be a direct modulator of such a producer. If it is used by a
+// consumer or router or nested in another modulator, no header is written and
+// a warning is logged for the first message. This parameter requires Columns
+// to be set.
+// By default this parameter is set to false.
+//
+// - UseCRLF: When set to true, "\r\n" is used as line ending of the header
+// and of records containing newlines. Otherwise "\n" is used.
+// By default this parameter is set to false.
+//
+// Examples
+//
+// This example writes CSV files with a fixed column order and a header line:
+//
+//  exampleProducer:
+//    Type: producer.File
+//    Streams: "*"
+//    File: /tmp/export.csv
+//    Modulators:
+//      - format.JSONToCSV:
+//          Header: true
+//          Columns:
+//            - time
+//            - host
+//            - status
+//      - format.Envelope
+type JSONToCSV struct {
+	core.SimpleFormatter `gollumdoc:"embed_type"`
+	columns              []string `config:"Columns"`
+	header               bool     `config:"Header" default:"false"`
+	useCRLF              bool     `config:"UseCRLF" default:"false"`
+	delimiter            rune
+	headerRequested      *int32
+	headerChecked        *int32
+}
+
+func init() {
+	core.TypeRegistry.Register(JSONToCSV{})
+}
+
+// Configure initializes this formatter with values from a plugin config.
+func (format *JSONToCSV) Configure(conf core.PluginConfigReader) {
+	format.delimiter = getCSVDelimiter(conf)
+	format.headerRequested = new(int32)
+	format.headerChecked = new(int32)
+	if format.header && len(format.columns) == 0 {
+		conf.Errors.Pushf("Header requires Columns to be set")
+	}
+}
+
+// ApplyFormatter update message payload
+func (format *JSONToCSV) ApplyFormatter(msg *core.Message) error {
+	values, err := format.decode(format.GetAppliedContent(msg))
+	if err != nil {
+		format.Logger.Warning("JSONToCSV failed to unmarshal a message: ", err)
+		return err
+	}
+	format.checkHeaderRequested()
+
+	columns := format.columns
+	if len(columns) == 0 {
+		columns = make([]string, 0, len(values))
+		for key := range values {
+			columns = append(columns, key)
+		}
+		sort.Strings(columns)
+	}
+
+	record := make([]string, len(columns))
+	for i, column := range columns {
+		if value, exists := values.Value(column); exists {
+			record[i] = format.toString(value)
+		}
+	}
+
+	content, err := format.encode(record)
+	if err != nil {
+		return err
+	}
+
+	content = bytes.TrimSuffix(content, []byte("\n"))
+	content = bytes.TrimSuffix(content, []byte("\r"))
+
+	format.SetAppliedContent(msg, content)
+	return nil
+}
+
+// GetHeader returns the header record including the line ending if Header is
+// set. This function implements core.HeaderFormatter.
+func (format *JSONToCSV) GetHeader() []byte {
+	if !format.header {
+		return nil
+	}
+	atomic.StoreInt32(format.headerRequested, 1)
+	header, _ := format.encode(format.columns)
+	return header
+}
+
+// checkHeaderRequested warns once if Header is set but no producer asked for
+// the header during configuration.
+func (format *JSONToCSV) checkHeaderRequested() {
+	if !format.header || !atomic.CompareAndSwapInt32(format.headerChecked, 0, 1) {
+		return // ### return, no header or already checked ###
+	}
+	if atomic.LoadInt32(format.headerRequested) == 0 {
+		format.Logger.Warning("JSONToCSV has Header set but no producer writes it. Use it as a direct modulator of producer.File or producer.AwsS3.")
+	}
+}
+
+// encode writes a single CSV record including the line ending
+func (format *JSONToCSV) encode(record []string) ([]byte, error) {
+	buffer := bytes.NewBuffer(make([]byte, 0, 256))
+	writer := csv.NewWriter(buffer)
+	writer.Comma = format.delimiter
+	writer.UseCRLF = format.useCRLF
+
+	writer.Write(record)
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
+
+// decode parses a JSON object while keeping numbers as written
+func (format *JSONToCSV) decode(content []byte) (tcontainer.MarshalMap, error) {
+	values := tcontainer.NewMarshalMap()
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	err := decoder.Decode(&values)
+	return values, err
+}
+
+// toString converts a JSON value into its CSV representation
+func (format *JSONToCSV) toString(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		if value {
+			return "true"
+		}
+		return "false"
+	default:
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
+	}
+}
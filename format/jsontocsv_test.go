@@ -0,0 +1,115 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestJSONToCSV(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.JSONToCSV")
+	config.Override("Columns", []string{"name", "comment", "user/id", "missing", "tags"})
+	config.Override("Header", true)
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter, casted := plugin.(*JSONToCSV)
+	expect.True(casted)
+
+	msg := core.NewMessage(nil, []byte(`{"name":"frodo","comment":"a, \"b\"\nc","user":{"id":12345678901234567},"tags":["x"]}`),
+		nil, core.InvalidStreamID)
+
+	err = formatter.ApplyFormatter(msg)
+	expect.NoError(err)
+	expect.Equal("frodo,\"a, \"\"b\"\"\nc\",12345678901234567,,\"[\"\"x\"\"]\"",
+		string(msg.GetPayload()))
+
+	msg = core.NewMessage(nil, []byte(`{"name":"sam","comment":null}`), nil, core.InvalidStreamID)
+	err = formatter.ApplyFormatter(msg)
+	expect.NoError(err)
+	expect.Equal("sam,,,,", string(msg.GetPayload()))
+
+	// The header is written by producers at the start of each file
+	expect.Equal("name,comment,user/id,missing,tags\n", string(formatter.GetHeader()))
+	expect.Equal("name,comment,user/id,missing,tags\n", string(core.ModulatorArray{core.NewFormatterModulator(formatter)}.GetHeader()))
+}
+
+func TestJSONToCSVHeaderRequiresColumns(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.JSONToCSV")
+	config.Override("Header", true)
+
+	_, err := core.NewPluginWithConfig(config)
+	expect.NotNil(err)
+}
+
+func TestJSONToCSVSortedKeys(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.JSONToCSV")
+	config.Override("Delimiter", "\t")
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter := plugin.(*JSONToCSV)
+
+	msg := core.NewMessage(nil, []byte(`{"c":true,"a":1.50,"b":"x"}`), nil, core.InvalidStreamID)
+	err = formatter.ApplyFormatter(msg)
+	expect.NoError(err)
+	expect.Equal("1.50\tx\ttrue", string(msg.GetPayload()))
+	expect.Nil(formatter.GetHeader())
+}
+
+func TestJSONToCSVHeaderNotRequested(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	newFormatter := func() (*JSONToCSV, *test.Hook) {
+		config := core.NewPluginConfig("", "format.JSONToCSV")
+		config.Override("Columns", []string{"name"})
+		config.Override("Header", true)
+
+		plugin, err := core.NewPluginWithConfig(config)
+		expect.NoError(err)
+		formatter := plugin.(*JSONToCSV)
+
+		logger, hook := test.NewNullLogger()
+		formatter.SetLogger(logger)
+		return formatter, hook
+	}
+
+	// Not used by a producer writing the header
+	formatter, hook := newFormatter()
+	for i := 0; i < 2; i++ {
+		msg := core.NewMessage(nil, []byte(`{"name":"frodo"}`), nil, core.InvalidStreamID)
+		expect.NoError(formatter.ApplyFormatter(msg))
+	}
+	expect.Equal(1, len(hook.AllEntries()))
+	expect.Equal(logrus.WarnLevel, hook.LastEntry().Level)
+
+	// Header requested by a producer
+	formatter, hook = newFormatter()
+	core.ModulatorArray{core.NewFormatterModulator(formatter)}.GetHeader()
+	msg := core.NewMessage(nil, []byte(`{"name":"frodo"}`), nil, core.InvalidStreamID)
+	expect.NoError(formatter.ApplyFormatter(msg))
+	expect.Equal(0, len(hook.AllEntries()))
+}
@@ -0,0 +1,288 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/trivago/gollum/core"
+	"regexp"
+)
+
+// keyValueNumber matches all values that can be written as JSON numbers
+var keyValueNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// KeyValueToJSON formatter
+//
+// This formatter parses "key=value" style data, e.g. logfmt as used by Heroku
+// or custom nginx log formats, and converts it into a JSON object. Pairs can
+// alternatively be written to the message's metadata.
+//
+// Parameters
+//
+// - Delimiter: Defines the string separating two key/value pairs.
+// By default this parameter is set to " ".
+//
+// - KeyValueSeparator: Defines the string separating a key from its value.
+// By default this parameter is set to "=".
+//
+// - QuoteChars: Defines the characters that may be used to quote values.
+// Quoted values may contain delimiters and separators. Quotes are removed
+// from the resulting value. Set to "" to disable quoting.
+// By default this parameter is set to "\"".
+//
+// - EscapeChar: Defines the character used to escape quote characters inside
+// quoted values. The sequences \n, \r and \t are translated, all other
+// escaped characters are taken literally. Set to "" to disable escaping.
+// By default this parameter is set to "\\".
+//
+// - IncludeKeys: Defines a list of keys to keep. If this list is empty, all
+// keys are kept.
+// By default this parameter is set to an empty list.
+//
+// - ExcludeKeys: Defines a list of keys to drop. Excludes are evaluated after
+// includes.
+// By default this parameter is set to an empty list.
+//
+// - InferTypes: When set to true, values that look like integers, floats or
+// booleans are written as JSON numbers or booleans instead of strings. Keys
+// without a value are written as true.
+// By default this parameter is set to false.
+//
+// - ToMetadata: When set to true, each pair is written to the metadata field
+// named MetadataPrefix + key and the payload is left untouched.
+// By default this parameter is set to false.
+//
+// - MetadataPrefix: Defines a prefix prepended to metadata keys when
+// ToMetadata is enabled.
+// By default this parameter is set to "".
+//
+// Examples
+//
+// This example converts logfmt lines like
+// `at=info method=GET path="/a b" status=200` into
+// `{"at":"info","method":"GET","path":"/a b","status":200}`:
+//
+//  exampleConsumer:
+//    Type: consumer.Console
+//    Streams: "*"
+//    Modulators:
+//      - format.KeyValueToJSON:
+//          InferTypes: true
+//
+// This example stores the values of "user" and "action" from comma separated
+// pairs in the metadata fields "kv_user" and "kv_action":
+//
+//  exampleConsumer:
+//    Type: consumer.Console
+//    Streams: "*"
+//    Modulators:
+//      - format.KeyValueToJSON:
+//          Delimiter: ","
+//          KeyValueSeparator: ":"
+//          IncludeKeys: [user, action]
+//          ToMetadata: true
+//          MetadataPrefix: "kv_"
+type KeyValueToJSON struct {
+	core.SimpleFormatter `gollumdoc:"embed_type"`
+	delimiter            []byte `config:"Delimiter" default:" "`
+	separator            []byte `config:"KeyValueSeparator" default:"="`
+	quoteChars           []byte `config:"QuoteChars" default:"\""`
+	escapeChars          []byte `config:"EscapeChar" default:"\\"`
+	inferTypes           bool   `config:"InferTypes" default:"false"`
+	toMetadata           bool   `config:"ToMetadata" default:"false"`
+	metadataPrefix       string `config:"MetadataPrefix"`
+	includeKeys          map[string]bool
+	excludeKeys          map[string]bool
+}
+
+type keyValuePair struct {
+	key   string
+	value []byte
+	bare  bool
+}
+
+func init() {
+	core.TypeRegistry.Register(KeyValueToJSON{})
+}
+
+// Configure initializes this formatter with values from a plugin config.
+func (format *KeyValueToJSON) Configure(conf core.PluginConfigReader) {
+	if len(format.delimiter) == 0 {
+		conf.Errors.Pushf("Delimiter must not be empty")
+	}
+	if len(format.separator) == 0 {
+		conf.Errors.Pushf("KeyValueSeparator must not be empty")
+	}
+	if len(format.escapeChars) > 1 {
+		conf.Errors.Pushf("EscapeChar must be a single character")
+	}
+
+	format.includeKeys = make(map[string]bool)
+	for _, key := range conf.GetStringArray("IncludeKeys", []string{}) {
+		format.includeKeys[key] = true
+	}
+
+	format.excludeKeys = make(map[string]bool)
+	for _, key := range conf.GetStringArray("ExcludeKeys", []string{}) {
+		format.excludeKeys[key] = true
+	}
+}
+
+// ApplyFormatter update message payload
+func (format *KeyValueToJSON) ApplyFormatter(msg *core.Message) error {
+	pairs := format.parse(format.GetAppliedContent(msg))
+
+	if format.toMetadata {
+		metadata := msg.GetMetadata()
+		for _, pair := range pairs {
+			value := make([]byte, len(pair.value))
+			copy(value, pair.value)
+			metadata.SetValue(format.metadataPrefix+pair.key, value)
+		}
+		return nil
+	}
+
+	buffer := bytes.NewBuffer(make([]byte, 0, 256))
+	buffer.WriteByte('{')
+	for i, pair := range pairs {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		key, _ := json.Marshal(pair.key)
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(format.encodeValue(pair))
+	}
+	buffer.WriteByte('}')
+
+	format.SetAppliedContent(msg, buffer.Bytes())
+	return nil
+}
+
+// encodeValue returns the JSON representation of a parsed value
+func (format *KeyValueToJSON) encodeValue(pair keyValuePair) []byte {
+	if format.inferTypes {
+		switch {
+		case pair.bare:
+			return []byte("true")
+		case keyValueNumber.Match(pair.value):
+			return pair.value
+		case string(pair.value) == "true", string(pair.value) == "false":
+			return pair.value
+		}
+	}
+
+	value, _ := json.Marshal(string(pair.value))
+	return value
+}
+
+// parse splits the given data into key/value pairs. If a key appears more
+// than once, the last value wins but the position of the first occurrence is
+// kept.
+func (format *KeyValueToJSON) parse(data []byte) []keyValuePair {
+	pairs := []keyValuePair{}
+	index := make(map[string]int)
+
+	for len(data) > 0 {
+		// Skip leading delimiters
+		if bytes.HasPrefix(data, format.delimiter) {
+			data = data[len(format.delimiter):]
+			continue
+		}
+
+		var pair keyValuePair
+		keyEnd := bytes.Index(data, format.separator)
+		delimEnd := bytes.Index(data, format.delimiter)
+
+		if keyEnd == -1 || (delimEnd != -1 && delimEnd < keyEnd) {
+			// Key without value
+			if delimEnd == -1 {
+				delimEnd = len(data)
+			}
+			pair = keyValuePair{key: string(data[:delimEnd]), value: []byte{}, bare: true}
+			data = data[delimEnd:]
+		} else {
+			pair.key = string(data[:keyEnd])
+			data = data[keyEnd+len(format.separator):]
+			pair.value, data = format.parseValue(data)
+		}
+
+		if !format.isKeyAccepted(pair.key) {
+			continue
+		}
+
+		if idx, exists := index[pair.key]; exists {
+			pairs[idx] = pair
+		} else {
+			index[pair.key] = len(pairs)
+			pairs = append(pairs, pair)
+		}
+	}
+
+	return pairs
+}
+
+// parseValue reads a (possibly quoted) value from the start of data and
+// returns the unquoted value plus the remaining data.
+func (format *KeyValueToJSON) parseValue(data []byte) ([]byte, []byte) {
+	if len(data) == 0 || bytes.IndexByte(format.quoteChars, data[0]) == -1 {
+		end := bytes.Index(data, format.delimiter)
+		if end == -1 {
+			return data, nil
+		}
+		return data[:end], data[end:]
+	}
+
+	quote := data[0]
+	value := make([]byte, 0, len(data))
+
+	for i := 1; i < len(data); i++ {
+		switch {
+		case data[i] == quote:
+			return value, data[i+1:]
+
+		case len(format.escapeChars) > 0 && data[i] == format.escapeChars[0] && i+1 < len(data):
+			i++
+			switch data[i] {
+			case 'n':
+				value = append(value, '\n')
+			case 'r':
+				value = append(value, '\r')
+			case 't':
+				value = append(value, '\t')
+			default:
+				value = append(value, data[i])
+			}
+
+		default:
+			value = append(value, data[i])
+		}
+	}
+
+	// Unterminated quote, treat the rest as value
+	return value, nil
+}
+
+// isKeyAccepted checks a key against the include and exclude lists
+func (format *KeyValueToJSON) isKeyAccepted(key string) bool {
+	if key == "" {
+		return false
+	}
+	if len(format.includeKeys) > 0 && !format.includeKeys[key] {
+		return false
+	}
+	return !format.excludeKeys[key]
+}
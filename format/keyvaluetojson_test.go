@@ -0,0 +1,103 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"testing"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestKeyValueToJSONLogfmt(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.KeyValueToJSON")
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter, casted := plugin.(*KeyValueToJSON)
+	expect.True(casted)
+
+	msg := core.NewMessage(nil, []byte(`at=info method=GET path="/a b=c" msg="say \"hi\"" status=200 debug`),
+		nil, core.InvalidStreamID)
+
+	err = formatter.ApplyFormatter(msg)
+	expect.NoError(err)
+	expect.Equal(`{"at":"info","method":"GET","path":"/a b=c","msg":"say \"hi\"","status":"200","debug":""}`,
+		string(msg.GetPayload()))
+}
+
+func TestKeyValueToJSONInferTypes(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.KeyValueToJSON")
+	config.Override("InferTypes", true)
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter := plugin.(*KeyValueToJSON)
+
+	msg := core.NewMessage(nil, []byte(`status=200 time=0.25 ok=true zip=01234 neg=-3 flag`),
+		nil, core.InvalidStreamID)
+
+	err = formatter.ApplyFormatter(msg)
+	expect.NoError(err)
+	expect.Equal(`{"status":200,"time":0.25,"ok":true,"zip":"01234","neg":-3,"flag":true}`,
+		string(msg.GetPayload()))
+}
+
+func TestKeyValueToJSONSeparators(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.KeyValueToJSON")
+	config.Override("Delimiter", ", ")
+	config.Override("KeyValueSeparator", ":")
+	config.Override("QuoteChars", "'")
+	config.Override("IncludeKeys", []string{"user", "action", "secret"})
+	config.Override("ExcludeKeys", []string{"secret"})
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter := plugin.(*KeyValueToJSON)
+
+	msg := core.NewMessage(nil, []byte(`user:frodo, action:'put on, ring', secret:precious, ignored:1, user:baggins`),
+		nil, core.InvalidStreamID)
+
+	err = formatter.ApplyFormatter(msg)
+	expect.NoError(err)
+	expect.Equal(`{"user":"baggins","action":"put on, ring"}`, string(msg.GetPayload()))
+}
+
+func TestKeyValueToJSONMetadata(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.KeyValueToJSON")
+	config.Override("ToMetadata", true)
+	config.Override("MetadataPrefix", "kv_")
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter := plugin.(*KeyValueToJSON)
+
+	payload := `host=web01 level=warn`
+	msg := core.NewMessage(nil, []byte(payload), nil, core.InvalidStreamID)
+
+	err = formatter.ApplyFormatter(msg)
+	expect.NoError(err)
+	expect.Equal(payload, string(msg.GetPayload()))
+	expect.Equal("web01", msg.GetMetadata().GetValueString("kv_host"))
+	expect.Equal("warn", msg.GetMetadata().GetValueString("kv_level"))
+}
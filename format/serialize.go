@@ -23,6 +23,13 @@ import (
 // Serialize is a formatter that serializes a message for later retrieval.
 // The formatter use the internal protobuf based function from msg.Serialize().
 //
+// Parameters
+//
+// - Framed: Prefix the serialized message with its length and a CRC32C
+// checksum as done by core.EncodeMessageFrame. This allows readers to detect
+// incomplete or corrupt records, e.g. by using core.MessageFrameReader.
+// By default this parameter is set to false.
+//
 // Examples
 //
 // This example serialize all consumed messages:
@@ -35,6 +42,7 @@ import (
 //
 type Serialize struct {
 	core.SimpleFormatter `gollumdoc:"embed_type"`
+	framed               bool `config:"Framed" default:"false"`
 }
 
 func init() {
@@ -53,6 +61,10 @@ func (format *Serialize) ApplyFormatter(msg *core.Message) error {
 		return err
 	}
 
+	if format.framed {
+		data = core.EncodeMessageFrame(data)
+	}
+
 	format.SetAppliedContent(msg, data)
 	return nil
 }
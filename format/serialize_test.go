@@ -52,3 +52,30 @@ func TestFormatterSerializeApplyTo(t *testing.T) {
 	expect.Equal("foo bar", controlMsg.String())
 	expect.Equal("foo bar", msg.String())
 }
+
+func TestFormatterSerializeFramed(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.Serialize")
+	config.Override("Framed", true)
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+
+	formatter, casted := plugin.(*Serialize)
+	expect.True(casted)
+
+	msg := core.NewMessage(nil, []byte("foo bar"), nil, core.InvalidStreamID)
+
+	err = formatter.ApplyFormatter(msg)
+	expect.NoError(err)
+
+	record, size, err := core.DecodeMessageFrame(msg.GetPayload())
+	expect.NoError(err)
+	expect.Equal(len(msg.GetPayload()), size)
+
+	controlMsg, err := core.DeserializeMessage(record)
+	expect.NoError(err)
+
+	expect.Equal("foo bar", string(controlMsg.GetPayload()))
+}
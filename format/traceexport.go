@@ -0,0 +1,207 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"encoding/json"
+	"github.com/trivago/gollum/core"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// TraceExport formatter
+//
+// This formatter converts the JSON spans sent to the "_GOLLUM_TRACE_" stream
+// by traced messages into a format accepted by tracing collectors. Each
+// message is converted into a request body containing a single span.
+//
+// Parameters
+//
+// - Format: Defines the output format. Set to "zipkin" to create Zipkin v2
+// JSON or to "otlp" to create OpenTelemetry OTLP/HTTP JSON.
+// By default this parameter is set to "zipkin".
+//
+// - ServiceName: Defines the service name reported to the collector.
+// By default this parameter is set to "gollum".
+//
+// Examples
+//
+// This example sends all spans to a Zipkin collector running on localhost.
+//
+//  ZipkinExport:
+//    Type: producer.HTTPRequest
+//    Streams: _GOLLUM_TRACE_
+//    Address: "http://localhost:9411/api/v2/spans"
+//    Encoding: "application/json"
+//    RawData: false
+//    Modulators:
+//      - format.TraceExport:
+//        Format: zipkin
+type TraceExport struct {
+	core.SimpleFormatter `gollumdoc:"embed_type"`
+	format               string `config:"Format" default:"zipkin"`
+	serviceName          string `config:"ServiceName" default:"gollum"`
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+}
+
+type zipkinSpan struct {
+	TraceID       string            `json:"traceId"`
+	ID            string            `json:"id"`
+	ParentID      string            `json:"parentId,omitempty"`
+	Name          string            `json:"name"`
+	Timestamp     int64             `json:"timestamp"`
+	Duration      int64             `json:"duration,omitempty"`
+	LocalEndpoint zipkinEndpoint    `json:"localEndpoint"`
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// otlpSpanKindInternal is the OpenTelemetry span kind used for all spans
+const otlpSpanKindInternal = 1
+
+func init() {
+	core.TypeRegistry.Register(TraceExport{})
+}
+
+// Configure initializes this formatter with values from a plugin config.
+func (format *TraceExport) Configure(conf core.PluginConfigReader) {
+	switch format.format {
+	case "zipkin", "otlp":
+	default:
+		conf.Errors.Pushf("Format must be \"zipkin\" or \"otlp\"")
+	}
+}
+
+// ApplyFormatter update message payload
+func (format *TraceExport) ApplyFormatter(msg *core.Message) error {
+	span := core.TraceSpan{}
+	if err := json.Unmarshal(format.GetAppliedContent(msg), &span); err != nil {
+		return err
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	switch format.format {
+	case "otlp":
+		data, err = json.Marshal(format.toOTLP(span))
+	default:
+		data, err = json.Marshal(format.toZipkin(span))
+	}
+	if err != nil {
+		return err
+	}
+
+	format.SetAppliedContent(msg, data)
+	return nil
+}
+
+// toZipkin converts a span into a Zipkin v2 span list
+func (format *TraceExport) toZipkin(span core.TraceSpan) []zipkinSpan {
+	return []zipkinSpan{{
+		TraceID:       span.TraceID,
+		ID:            span.SpanID,
+		ParentID:      span.ParentID,
+		Name:          span.Name,
+		Timestamp:     span.StartUs,
+		Duration:      span.DurationUs,
+		LocalEndpoint: zipkinEndpoint{ServiceName: format.serviceName},
+		Tags:          span.Tags,
+	}}
+}
+
+// toOTLP converts a span into an OTLP/HTTP trace export request
+func (format *TraceExport) toOTLP(span core.TraceSpan) otlpTraces {
+	start := span.StartUs * int64(time.Microsecond)
+	end := start + span.DurationUs*int64(time.Microsecond)
+
+	keys := make([]string, 0, len(span.Tags))
+	for key := range span.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attributes := make([]otlpAttribute, 0, len(keys))
+	for _, key := range keys {
+		attributes = append(attributes, otlpAttribute{key, otlpValue{span.Tags[key]}})
+	}
+
+	return otlpTraces{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpAttribute{{"service.name", otlpValue{format.serviceName}}},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "gollum"},
+				Spans: []otlpSpan{{
+					TraceID:           span.TraceID,
+					SpanID:            span.SpanID,
+					ParentSpanID:      span.ParentID,
+					Name:              span.Name,
+					Kind:              otlpSpanKindInternal,
+					StartTimeUnixNano: strconv.FormatInt(start, 10),
+					EndTimeUnixNano:   strconv.FormatInt(end, 10),
+					Attributes:        attributes,
+				}},
+			}},
+		}},
+	}
+}
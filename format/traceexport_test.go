@@ -0,0 +1,69 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"testing"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+const testTraceSpan = `{"traceId":"0af7651916cd43dd8448eb211c80319c","spanId":"b7ad6b7169203331","parentId":"00f067aa0ba902b7","name":"route","startUs":1500000000000000,"durationUs":42,"tags":{"router":"foo","result":"continue"}}`
+
+func TestTraceExportZipkin(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.TraceExport")
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+
+	formatter, casted := plugin.(*TraceExport)
+	expect.True(casted)
+
+	msg := core.NewMessage(nil, []byte(testTraceSpan), nil, core.TraceInternalStreamID)
+	err = formatter.ApplyFormatter(msg)
+	expect.NoError(err)
+
+	expect.Equal(`[{"traceId":"0af7651916cd43dd8448eb211c80319c","id":"b7ad6b7169203331","parentId":"00f067aa0ba902b7","name":"route","timestamp":1500000000000000,"duration":42,"localEndpoint":{"serviceName":"gollum"},"tags":{"result":"continue","router":"foo"}}]`, msg.String())
+}
+
+func TestTraceExportOTLP(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.TraceExport")
+	config.Override("Format", "otlp")
+	config.Override("ServiceName", "test")
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+
+	formatter, casted := plugin.(*TraceExport)
+	expect.True(casted)
+
+	msg := core.NewMessage(nil, []byte(testTraceSpan), nil, core.TraceInternalStreamID)
+	err = formatter.ApplyFormatter(msg)
+	expect.NoError(err)
+
+	expect.Equal(`{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"test"}}]},"scopeSpans":[{"scope":{"name":"gollum"},"spans":[{"traceId":"0af7651916cd43dd8448eb211c80319c","spanId":"b7ad6b7169203331","parentSpanId":"00f067aa0ba902b7","name":"route","kind":1,"startTimeUnixNano":"1500000000000000000","endTimeUnixNano":"1500000000000042000","attributes":[{"key":"result","value":{"stringValue":"continue"}},{"key":"router","value":{"stringValue":"foo"}}]}]}]}]}`, msg.String())
+}
+
+func TestTraceExportInvalidFormat(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.TraceExport")
+	config.Override("Format", "jaeger")
+	_, err := core.NewPluginWithConfig(config)
+	expect.NotNil(err)
+}
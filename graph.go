@@ -0,0 +1,538 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tcontainer"
+	"github.com/trivago/tgo/tos"
+)
+
+const (
+	graphNodeConsumer = "consumer"
+	graphNodeStream   = "stream"
+	graphNodeProducer = "producer"
+	graphNodeDynamic  = "dynamic"
+
+	graphEdgeRoute      = "route"
+	graphEdgeDistribute = "distribute"
+	graphEdgeFiltered   = "filtered"
+	graphEdgeFallback   = "fallback"
+	graphEdgeDynamic    = "dynamic"
+
+	graphDynamicID = "dynamic:"
+)
+
+// configGraph is the static message flow of a config. Nodes are consumers,
+// streams and producers, edges are the paths a message can take between them.
+type configGraph struct {
+	nodes    []*graphNode
+	nodeByID map[string]*graphNode
+	edges    []graphEdge
+	warnings []string
+}
+
+type graphNode struct {
+	id       string
+	name     string
+	kind     string
+	typename string
+	index    int
+	// distributeOnly is set for streams bound to a router.Distribute that
+	// does not list the stream in its own TargetStreams. Producers of these
+	// streams never receive messages.
+	distributeOnly bool
+}
+
+type graphEdge struct {
+	from *graphNode
+	to   *graphNode
+	kind string
+}
+
+// graphCommand implements "gollum -graph". The graph is written to out,
+// problems found in the graph are written to warnOut.
+func graphCommand(configFile string, format string, out io.Writer, warnOut io.Writer) int {
+	config, err := core.ReadConfigFromFile(configFile)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to read config")
+		return tos.ExitError // ### return, invalid config ###
+	}
+	if err := config.Validate(); err != nil {
+		logrus.WithError(err).Error("Config validation failed")
+		return tos.ExitError // ### return, invalid config ###
+	}
+
+	graph := newConfigGraph(config)
+
+	switch strings.ToLower(format) {
+	case "dot":
+		graph.writeDOT(out)
+	case "mermaid":
+		graph.writeMermaid(out)
+	default:
+		logrus.Errorf("Unknown graph format '%s'. Use either dot or mermaid", format)
+		return tos.ExitError // ### return, invalid format ###
+	}
+
+	for _, warning := range graph.warnings {
+		fmt.Fprintln(warnOut, "Warning:", warning)
+	}
+	return tos.ExitSuccess
+}
+
+// newConfigGraph builds the message flow graph for a given config and
+// collects warnings about problems found in that graph.
+func newConfigGraph(config *core.Config) *configGraph {
+	graph := &configGraph{
+		nodeByID: make(map[string]*graphNode),
+	}
+
+	for _, consumerConfig := range sortPluginConfigs(config.GetConsumers()) {
+		reader := core.NewPluginConfigReader(&consumerConfig)
+		consumer := graph.addNode(graphNodeConsumer, consumerConfig.ID, consumerConfig.Typename)
+
+		for _, stream := range reader.GetStringArray("Streams", []string{}) {
+			graph.addEdge(consumer, graph.getStream(stream), graphEdgeRoute)
+		}
+		graph.addModulatorEdges(consumer, reader, "Modulators")
+	}
+
+	for _, routerConfig := range sortPluginConfigs(config.GetRouters()) {
+		reader := core.NewPluginConfigReader(&routerConfig)
+		streamName := reader.GetString("Stream", "")
+		if streamName == "" {
+			graph.warnf("Router '%s' is not bound to a stream", routerConfig.ID)
+			continue
+		}
+
+		stream := graph.getStream(streamName)
+		stream.typename = routerConfig.Typename
+		stream.distributeOnly = routerConfig.Typename == "router.Distribute"
+
+		for _, target := range reader.GetStringArray("TargetStreams", []string{}) {
+			if target == streamName {
+				stream.distributeOnly = false
+				continue // ### continue, sent to the stream's producers ###
+			}
+			graph.addEdge(stream, graph.getStream(target), graphEdgeDistribute)
+		}
+		graph.addModulatorEdges(stream, reader, "Filters")
+	}
+
+	wildcardProducers := []*graphNode{}
+	for _, producerConfig := range sortPluginConfigs(config.GetProducers()) {
+		reader := core.NewPluginConfigReader(&producerConfig)
+		producer := graph.addNode(graphNodeProducer, producerConfig.ID, producerConfig.Typename)
+
+		for _, stream := range reader.GetStringArray("Streams", []string{}) {
+			if stream == core.WildcardStream {
+				wildcardProducers = append(wildcardProducers, producer)
+				continue
+			}
+			graph.addRouteEdge(graph.getStream(stream), producer)
+		}
+		if fallback := reader.GetString("FallbackStream", ""); fallback != "" {
+			graph.addEdge(producer, graph.getStream(fallback), graphEdgeFallback)
+		}
+		graph.addModulatorEdges(producer, reader, "Modulators")
+	}
+
+	// Wildcard producers are attached to all streams, except for the internal
+	// log and trace streams. This mirrors core.StreamRegistry.
+	for _, producer := range wildcardProducers {
+		for _, node := range graph.getNodes(graphNodeStream) {
+			if !isInternalStream(node.name) {
+				graph.addRouteEdge(node, producer)
+			}
+		}
+	}
+
+	graph.checkReachability()
+	graph.checkConsumedStreams()
+	graph.checkFallbackCycles()
+	graph.checkMissingFallbacks()
+	return graph
+}
+
+// sortPluginConfigs orders plugin configs by ID so that the output is stable
+func sortPluginConfigs(configs []core.PluginConfig) []core.PluginConfig {
+	sort.Slice(configs, func(i, j int) bool {
+		return configs[i].ID < configs[j].ID
+	})
+	return configs
+}
+
+// addModulatorEdges adds edges for all streams a list of modulators can send
+// messages to. This covers the FilteredStream of filters and the dynamic
+// targets of format.StreamRoute.
+func (graph *configGraph) addModulatorEdges(from *graphNode, reader core.PluginConfigReader, key string) {
+	modulators, err := reader.WithError.GetArray(key, []interface{}{})
+	if err != nil {
+		return // ### return, reported by config validation ###
+	}
+	graph.addNestedModulatorEdges(from, modulators)
+}
+
+func (graph *configGraph) addNestedModulatorEdges(from *graphNode, modulators []interface{}) {
+	for _, modulator := range modulators {
+		// Entries are either a type name or a map of type name to config
+		typedConfig, isMap := modulator.(map[interface{}]interface{})
+		if !isMap {
+			if typename, isString := modulator.(string); isString {
+				graph.addModulatorEdge(from, typename, tcontainer.NewMarshalMap())
+			}
+			continue
+		}
+
+		for typename, config := range typedConfig {
+			typenameStr, isString := typename.(string)
+			if !isString {
+				continue
+			}
+			configMap, err := tcontainer.ConvertToMarshalMap(config, strings.ToLower)
+			if err != nil {
+				configMap = tcontainer.NewMarshalMap()
+			}
+			graph.addModulatorEdge(from, typenameStr, configMap)
+		}
+	}
+}
+
+func (graph *configGraph) addModulatorEdge(from *graphNode, typename string, config tcontainer.MarshalMap) {
+	if typename == "format.StreamRoute" {
+		graph.addEdge(from, graph.getDynamic(), graphEdgeDynamic)
+	}
+	if stream, err := config.String("filteredstream"); err == nil && stream != "" {
+		graph.addEdge(from, graph.getStream(stream), graphEdgeFiltered)
+	}
+	if nested, err := config.Array("modulators"); err == nil {
+		graph.addNestedModulatorEdges(from, nested)
+	}
+}
+
+func (graph *configGraph) addNode(kind string, name string, typename string) *graphNode {
+	node := &graphNode{
+		id:       kind + ":" + name,
+		name:     name,
+		kind:     kind,
+		typename: typename,
+		index:    len(graph.nodes),
+	}
+	graph.nodes = append(graph.nodes, node)
+	graph.nodeByID[node.id] = node
+	return node
+}
+
+// getStream returns the node for a given stream and creates it if necessary.
+// Streams without a router use router.Broadcast, as in core.StreamRegistry.
+func (graph *configGraph) getStream(name string) *graphNode {
+	if node, exists := graph.nodeByID[graphNodeStream+":"+name]; exists {
+		return node
+	}
+	return graph.addNode(graphNodeStream, name, "router.Broadcast")
+}
+
+// getDynamic returns the node representing streams chosen at runtime
+func (graph *configGraph) getDynamic() *graphNode {
+	if node, exists := graph.nodeByID[graphDynamicID]; exists {
+		return node
+	}
+	return graph.addNode(graphNodeDynamic, "", "format.StreamRoute")
+}
+
+func (graph *configGraph) getNodes(kind string) []*graphNode {
+	nodes := []*graphNode{}
+	for _, node := range graph.nodes {
+		if node.kind == kind {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+func (graph *configGraph) addEdge(from *graphNode, to *graphNode, kind string) {
+	for _, edge := range graph.edges {
+		if edge.from == from && edge.to == to && edge.kind == kind {
+			return // ### return, duplicate ###
+		}
+	}
+	graph.edges = append(graph.edges, graphEdge{from: from, to: to, kind: kind})
+}
+
+// addRouteEdge adds an edge from a stream to one of its producers unless
+// the stream's router only sends messages to other streams.
+func (graph *configGraph) addRouteEdge(stream *graphNode, producer *graphNode) {
+	if !stream.distributeOnly {
+		graph.addEdge(stream, producer, graphEdgeRoute)
+	}
+}
+
+func (graph *configGraph) getEdgesFrom(node *graphNode) []graphEdge {
+	edges := []graphEdge{}
+	for _, edge := range graph.edges {
+		if edge.from == node {
+			edges = append(edges, edge)
+		}
+	}
+	return edges
+}
+
+func (graph *configGraph) warnf(format string, args ...interface{}) {
+	graph.warnings = append(graph.warnings, fmt.Sprintf(format, args...))
+}
+
+// isInternalStream returns true for the streams gollum writes its own logs
+// and trace spans to.
+func isInternalStream(name string) bool {
+	return name == core.LogInternalStream || name == core.TraceInternalStream
+}
+
+// checkReachability warns about producers that no message from a consumer or
+// from gollum's internal log and trace streams can reach. If a dynamic route
+// is reachable, all streams are treated as reachable.
+func (graph *configGraph) checkReachability() {
+	sources := graph.getNodes(graphNodeConsumer)
+	for _, name := range []string{core.LogInternalStream, core.TraceInternalStream} {
+		if node, exists := graph.nodeByID[graphNodeStream+":"+name]; exists {
+			sources = append(sources, node)
+		}
+	}
+
+	reached := graph.walk(sources)
+	if reached[graphDynamicID] {
+		for id := range graph.walk(graph.getNodes(graphNodeStream)) {
+			reached[id] = true
+		}
+	}
+
+	for _, producer := range graph.getNodes(graphNodeProducer) {
+		if !reached[producer.id] {
+			graph.warnf("Producer '%s' is not reachable from any consumer", producer.name)
+		}
+	}
+}
+
+// checkConsumedStreams warns about streams that receive messages but are not
+// read by any producer or router target.
+func (graph *configGraph) checkConsumedStreams() {
+	written := make(map[*graphNode]bool)
+	for _, edge := range graph.edges {
+		written[edge.to] = true
+	}
+
+	for _, stream := range graph.getNodes(graphNodeStream) {
+		if !written[stream] || isInternalStream(stream.name) || stream.name == core.WildcardStream {
+			continue
+		}
+		consumed := false
+		for _, edge := range graph.getEdgesFrom(stream) {
+			if edge.kind == graphEdgeRoute || edge.kind == graphEdgeDistribute {
+				consumed = true
+				break
+			}
+		}
+		if !consumed {
+			graph.warnf("Stream '%s' receives messages but is not read by any producer", stream.name)
+		}
+	}
+}
+
+// checkFallbackCycles warns about producers whose fallback stream leads back
+// to the producer itself. Messages in such a cycle are only stopped by the
+// routing loop detection.
+func (graph *configGraph) checkFallbackCycles() {
+	reported := make(map[string]bool)
+	for _, edge := range graph.edges {
+		if edge.kind != graphEdgeFallback {
+			continue
+		}
+		path := graph.findPath(edge.to, edge.from)
+		if path == nil {
+			continue
+		}
+
+		// A cycle with multiple fallback edges is found once per edge
+		names := []string{edge.from.name}
+		ids := []string{}
+		for _, node := range path {
+			names = append(names, node.name)
+			ids = append(ids, node.id)
+		}
+		sort.Strings(ids)
+		if cycleKey := strings.Join(ids, ","); !reported[cycleKey] {
+			reported[cycleKey] = true
+			graph.warnf("Fallback cycle: %s", strings.Join(names, " -> "))
+		}
+	}
+}
+
+// checkMissingFallbacks warns about streams that have producers without a
+// fallback stream. Messages failing in these producers are lost.
+func (graph *configGraph) checkMissingFallbacks() {
+	for _, stream := range graph.getNodes(graphNodeStream) {
+		if isInternalStream(stream.name) || stream.name == core.WildcardStream {
+			continue
+		}
+
+		producers := []string{}
+		for _, edge := range graph.getEdgesFrom(stream) {
+			if edge.kind != graphEdgeRoute || edge.to.kind != graphNodeProducer {
+				continue
+			}
+			hasFallback := false
+			for _, producerEdge := range graph.getEdgesFrom(edge.to) {
+				if producerEdge.kind == graphEdgeFallback {
+					hasFallback = true
+					break
+				}
+			}
+			if !hasFallback {
+				producers = append(producers, edge.to.name)
+			}
+		}
+
+		if len(producers) > 0 {
+			graph.warnf("Stream '%s' has no fallback: messages failing in producer(s) %s are dropped", stream.name, strings.Join(producers, ", "))
+		}
+	}
+}
+
+// walk returns the IDs of all nodes reachable from the given nodes
+func (graph *configGraph) walk(start []*graphNode) map[string]bool {
+	reached := make(map[string]bool)
+	queue := append([]*graphNode{}, start...)
+	for _, node := range start {
+		reached[node.id] = true
+	}
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, edge := range graph.getEdgesFrom(node) {
+			if !reached[edge.to.id] {
+				reached[edge.to.id] = true
+				queue = append(queue, edge.to)
+			}
+		}
+	}
+	return reached
+}
+
+// findPath returns the shortest path from one node to another, including
+// both nodes. Dynamic routes are not followed. If there is no path, nil is
+// returned.
+func (graph *configGraph) findPath(from *graphNode, to *graphNode) []*graphNode {
+	parents := map[*graphNode]*graphNode{from: nil}
+	queue := []*graphNode{from}
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if node == to {
+			path := []*graphNode{}
+			for ; node != nil; node = parents[node] {
+				path = append([]*graphNode{node}, path...)
+			}
+			return path
+		}
+		for _, edge := range graph.getEdgesFrom(node) {
+			if _, visited := parents[edge.to]; !visited && edge.kind != graphEdgeDynamic {
+				parents[edge.to] = node
+				queue = append(queue, edge.to)
+			}
+		}
+	}
+	return nil
+}
+
+func (node *graphNode) label() string {
+	if node.kind == graphNodeDynamic {
+		return "dynamic streams\n" + node.typename
+	}
+	return node.name + "\n" + node.typename
+}
+
+// writeDOT writes the graph in Graphviz DOT format
+func (graph *configGraph) writeDOT(out io.Writer) {
+	shapes := map[string]string{
+		graphNodeConsumer: "shape=box",
+		graphNodeStream:   "shape=ellipse",
+		graphNodeProducer: "shape=box, style=rounded",
+		graphNodeDynamic:  "shape=diamond",
+	}
+	styles := map[string]string{
+		graphEdgeRoute:      "",
+		graphEdgeDistribute: " [label=\"distribute\"]",
+		graphEdgeFiltered:   " [label=\"filtered\", style=dashed]",
+		graphEdgeFallback:   " [label=\"fallback\", style=dashed, color=red]",
+		graphEdgeDynamic:    " [label=\"dynamic\", style=dotted]",
+	}
+
+	fmt.Fprintln(out, "digraph gollum {")
+	fmt.Fprintln(out, "  rankdir=LR;")
+	for _, node := range graph.nodes {
+		fmt.Fprintf(out, "  %s [label=%s, %s];\n", quoteDOT(node.id), quoteDOT(node.label()), shapes[node.kind])
+	}
+	for _, edge := range graph.edges {
+		fmt.Fprintf(out, "  %s -> %s%s;\n", quoteDOT(edge.from.id), quoteDOT(edge.to.id), styles[edge.kind])
+	}
+	fmt.Fprintln(out, "}")
+}
+
+// writeMermaid writes the graph as a Mermaid flowchart
+func (graph *configGraph) writeMermaid(out io.Writer) {
+	shapes := map[string][2]string{
+		graphNodeConsumer: {"[", "]"},
+		graphNodeStream:   {"([", "])"},
+		graphNodeProducer: {"[[", "]]"},
+		graphNodeDynamic:  {"{", "}"},
+	}
+	arrows := map[string]string{
+		graphEdgeRoute:      "-->",
+		graphEdgeDistribute: "-->|distribute|",
+		graphEdgeFiltered:   "-.->|filtered|",
+		graphEdgeFallback:   "-.->|fallback|",
+		graphEdgeDynamic:    "-.->|dynamic|",
+	}
+
+	fmt.Fprintln(out, "flowchart LR")
+	for _, node := range graph.nodes {
+		shape := shapes[node.kind]
+		fmt.Fprintf(out, "  n%d%s%s%s\n", node.index, shape[0], quoteMermaid(node.label()), shape[1])
+	}
+	for _, edge := range graph.edges {
+		fmt.Fprintf(out, "  n%d %s n%d\n", edge.from.index, arrows[edge.kind], edge.to.index)
+	}
+}
+
+func quoteDOT(value string) string {
+	value = strings.Replace(value, "\\", "\\\\", -1)
+	value = strings.Replace(value, "\"", "\\\"", -1)
+	value = strings.Replace(value, "\n", "\\n", -1)
+	return "\"" + value + "\""
+}
+
+func quoteMermaid(value string) string {
+	value = strings.Replace(value, "\"", "#quot;", -1)
+	value = strings.Replace(value, "\n", "<br/>", -1)
+	return "\"" + value + "\""
+}
@@ -0,0 +1,189 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func newTestConfigGraph(t *testing.T, config string) *configGraph {
+	expect := ttesting.NewExpect(t)
+	conf, err := core.ReadConfig([]byte(config))
+	expect.NoError(err)
+	expect.NoError(conf.Validate())
+	return newConfigGraph(conf)
+}
+
+func (graph *configGraph) hasEdge(from string, to string, kind string) bool {
+	for _, edge := range graph.edges {
+		if edge.from.id == from && edge.to.id == to && edge.kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (graph *configGraph) hasWarning(prefix string) bool {
+	for _, warning := range graph.warnings {
+		if strings.HasPrefix(warning, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestConfigGraphEdges(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	graph := newTestConfigGraph(t, `
+In:
+  Type: consumer.Console
+  Streams: input
+  Modulators:
+    - filter.RegExp:
+        Expression: "^ok"
+        FilteredStream: rejected
+Out:
+  Type: producer.Console
+  Streams: input
+  FallbackStream: failed
+Failed:
+  Type: producer.Console
+  Streams: failed
+  FallbackStream: rejected
+Unused:
+  Type: producer.Console
+  Streams: unused
+`)
+
+	expect.True(graph.hasEdge("consumer:In", "stream:input", graphEdgeRoute))
+	expect.True(graph.hasEdge("consumer:In", "stream:rejected", graphEdgeFiltered))
+	expect.True(graph.hasEdge("stream:input", "producer:Out", graphEdgeRoute))
+	expect.True(graph.hasEdge("producer:Out", "stream:failed", graphEdgeFallback))
+	expect.True(graph.hasEdge("stream:failed", "producer:Failed", graphEdgeRoute))
+
+	expect.True(graph.hasWarning("Producer 'Unused' is not reachable"))
+	expect.False(graph.hasWarning("Producer 'Out'"))
+	expect.True(graph.hasWarning("Stream 'rejected' receives messages but is not read"))
+	expect.True(graph.hasWarning("Stream 'unused' has no fallback"))
+	expect.False(graph.hasWarning("Stream 'input' has no fallback"))
+}
+
+func TestConfigGraphDistribute(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	graph := newTestConfigGraph(t, `
+In:
+  Type: consumer.Console
+  Streams: input
+Split:
+  Type: router.Distribute
+  Stream: input
+  TargetStreams: [left, right]
+Direct:
+  Type: producer.Console
+  Streams: input
+All:
+  Type: producer.Console
+  Streams: "*"
+`)
+
+	expect.True(graph.hasEdge("stream:input", "stream:left", graphEdgeDistribute))
+	expect.True(graph.hasEdge("stream:input", "stream:right", graphEdgeDistribute))
+	expect.True(graph.hasEdge("stream:left", "producer:All", graphEdgeRoute))
+
+	// Distribute only routes to its target streams
+	expect.False(graph.hasEdge("stream:input", "producer:Direct", graphEdgeRoute))
+	expect.False(graph.hasEdge("stream:input", "producer:All", graphEdgeRoute))
+	expect.True(graph.hasWarning("Producer 'Direct' is not reachable"))
+	expect.False(graph.hasWarning("Producer 'All'"))
+	expect.False(graph.hasWarning("Stream 'input' has no fallback"))
+
+	// Listing the router's own stream keeps its producers
+	graph = newTestConfigGraph(t, `
+In:
+  Type: consumer.Console
+  Streams: input
+Split:
+  Type: router.Distribute
+  Stream: input
+  TargetStreams: [input, left]
+Direct:
+  Type: producer.Console
+  Streams: input
+`)
+
+	expect.True(graph.hasEdge("stream:input", "producer:Direct", graphEdgeRoute))
+	expect.False(graph.hasEdge("stream:input", "stream:input", graphEdgeDistribute))
+	expect.False(graph.hasWarning("Producer 'Direct' is not reachable"))
+}
+
+func TestConfigGraphFallbackCycle(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	graph := newTestConfigGraph(t, `
+In:
+  Type: consumer.Console
+  Streams: a
+A:
+  Type: producer.Console
+  Streams: a
+  FallbackStream: b
+B:
+  Type: producer.Console
+  Streams: b
+  FallbackStream: a
+`)
+
+	expect.True(graph.hasWarning("Fallback cycle: "))
+	cycles := 0
+	for _, warning := range graph.warnings {
+		if strings.HasPrefix(warning, "Fallback cycle: ") {
+			cycles++
+		}
+	}
+	expect.Equal(1, cycles)
+}
+
+func TestConfigGraphInternalStreams(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	graph := newTestConfigGraph(t, `
+In:
+  Type: consumer.Console
+  Streams: input
+All:
+  Type: producer.Console
+  Streams: "*"
+Logs:
+  Type: producer.Console
+  Streams: _GOLLUM_
+Spans:
+  Type: producer.Console
+  Streams: _GOLLUM_TRACE_
+`)
+
+	// Wildcard producers do not read the internal streams
+	expect.True(graph.hasEdge("stream:input", "producer:All", graphEdgeRoute))
+	expect.False(graph.hasEdge("stream:_GOLLUM_", "producer:All", graphEdgeRoute))
+	expect.False(graph.hasEdge("stream:_GOLLUM_TRACE_", "producer:All", graphEdgeRoute))
+	expect.True(graph.hasEdge("stream:_GOLLUM_TRACE_", "producer:Spans", graphEdgeRoute))
+
+	// Internal streams are written by gollum itself
+	expect.False(graph.hasWarning("Producer 'Logs'"))
+	expect.False(graph.hasWarning("Producer 'Spans'"))
+	expect.False(graph.hasWarning("Stream '_GOLLUM_' has no fallback"))
+	expect.False(graph.hasWarning("Stream '_GOLLUM_TRACE_' has no fallback"))
+}
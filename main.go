@@ -15,6 +15,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	_ "github.com/trivago/gollum/consumer"
@@ -48,6 +49,10 @@ func main() {
 }
 
 func mainWithExitCode() int {
+	if len(os.Args) > 1 && os.Args[1] == "spool" {
+		return spoolCommand(os.Args[2:], os.Stdout) // ### return, spool subcommand ###
+	}
+
 	parseFlags()
 
 	if *flagHelp || len(os.Args) == 1 {
@@ -70,10 +75,23 @@ func mainWithExitCode() int {
 		return tos.ExitSuccess // ### return, modules only ###
 	}
 
+	if *flagSchema {
+		printSchema()
+		return tos.ExitSuccess // ### return, schema only ###
+	}
+
 	if stop := initLogrus(); stop != nil {
 		defer stop()
 	}
 
+	if *flagReplay != "" {
+		return replayCommand(*flagReplay, *flagReplayInput, *flagReplayConsumer, os.Stdout) // ### return, replay only ###
+	}
+
+	if *flagGraph != "" {
+		return graphCommand(*flagGraph, *flagGraphFormat, os.Stdout, os.Stderr) // ### return, graph only ###
+	}
+
 	logrus.Debug("GOLLUM STARTING")
 	defer logrus.Debug("GOLLUM STOPPED")
 
@@ -113,6 +131,11 @@ func mainWithExitCode() int {
 	}
 
 	coordinator.StartPlugins()
+
+	if stop := startAdminService(&coordinator); stop != nil {
+		defer stop()
+	}
+
 	coordinator.Run()
 	return tos.ExitSuccess
 }
@@ -376,6 +399,15 @@ func printModules() {
 	}
 }
 
+func printSchema() {
+	schema, err := json.MarshalIndent(core.GetConfigSchema(), "", "  ")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(string(schema))
+}
+
 func printProfile() {
 	msgSec, err := tgo.Metric.Get(core.MetricMessagesRoutedAvg)
 	if err == nil {
@@ -17,6 +17,7 @@ package producer
 import (
 	"github.com/trivago/gollum/core"
 	"io"
+	"strings"
 	"sync"
 )
 
@@ -39,13 +40,18 @@ import (
 //    UseVersion08: false
 //    Version: 100
 //    RetentionPolicy: ""
+//    Organization: ""
+//    Bucket: ""
+//    Token: ""
+//    Precision: "ms"
+//    PacketSize: 512
 //    Batch
 //      - MaxCount: 8192
 //      - FlushCount: 4096
 //      - TimeoutSec: 5
 //
 // Host defines the host (and port) of the InfluxDB server.
-// Defaults to "localhost:8086".
+// Defaults to "localhost:8086" or "localhost:8089" when using UDP.
 //
 // User defines the InfluxDB username to use to login. If this name is
 // left empty credentials are assumed to be disabled. Defaults to empty.
@@ -67,7 +73,25 @@ import (
 //
 // Version defines the InfluxDB version to use as in Mmp (Major, minor, patch).
 // For version 0.8.x use 80, for version 0.9.0 use 90, for version 1.0.0 use
-// use 100 and so on. Defaults to 100.
+// use 100 and so on. Versions 200 and above use the InfluxDB 2.x write API.
+// Set to "udp" to send line protocol data to the InfluxDB UDP service.
+// Defaults to 100.
+//
+// Organization sets the InfluxDB 2.x organization to write to. Must be set
+// when using Version 200 or above. Defaults to empty.
+//
+// Bucket sets the InfluxDB 2.x bucket to write to. Like Database this value
+// is time.Format based if TimeBasedName is enabled. Defaults to Database.
+//
+// Token defines the InfluxDB 2.x API token used for authentication.
+// Defaults to empty.
+//
+// Precision defines the InfluxDB 2.x timestamp precision of the written
+// points. Valid values are "ns", "us", "ms" and "s". Defaults to "ms".
+//
+// PacketSize defines the maximum number of bytes sent in one UDP packet.
+// Points are never split, i.e. larger points are sent as a packet of their
+// own. Defaults to 512.
 //
 // BatchMaxCount defines the maximum number of messages that can be buffered
 // before a flush is mandatory. If the buffer is full and a flush is still
@@ -99,21 +123,34 @@ func init() {
 
 // Configure initializes this producer with values from a plugin config.
 func (prod *InfluxDB) Configure(conf core.PluginConfigReader) {
-	version := conf.GetInt("Version", 100)
+	// Version is either a number or "udp"
+	versionName, err := conf.WithError.GetString("Version", "")
+	useUDP := err == nil && strings.EqualFold(versionName, "udp")
+
+	version := int64(100)
+	if !useUDP {
+		version = conf.GetInt("Version", 100)
+	}
 	if conf.GetBool("UseVersion08", false) {
 		version = 80
 	}
 
 	switch {
+	case useUDP:
+		prod.Logger.Debug("Using InfluxDB UDP line protocol")
+		prod.writer = new(influxDBWriterUDP)
 	case version < 90:
 		prod.Logger.Debug("Using InfluxDB 0.8.x format")
 		prod.writer = new(influxDBWriter08)
 	case version == 90:
 		prod.Logger.Debug("Using InfluxDB 0.9.0 format")
 		prod.writer = new(influxDBWriter09)
-	default:
+	case version < 200:
 		prod.Logger.Debug("Using InfluxDB 0.9.1+ format")
 		prod.writer = new(influxDBWriter10)
+	default:
+		prod.Logger.Debug("Using InfluxDB 2.x format")
+		prod.writer = new(influxDBWriter20)
 	}
 
 	if err := prod.writer.configure(conf, prod); conf.Errors.Push(err) {
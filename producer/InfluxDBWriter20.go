@@ -0,0 +1,137 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package producer
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tio"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// influxDBWriter20 implements the io.Writer interface for InfluxDB 2.x
+// connections using the /api/v2/write endpoint
+type influxDBWriter20 struct {
+	client         http.Client
+	writeURL       string
+	pingURL        string
+	bucketTemplate string
+	host           string
+	token          string
+	connectionUp   bool
+	timeBasedName  bool
+	buffer         tio.ByteStream
+	logger         logrus.FieldLogger
+}
+
+// Configure sets the database connection values
+func (writer *influxDBWriter20) configure(conf core.PluginConfigReader, prod *InfluxDB) error {
+	writer.host = conf.GetString("Host", "localhost:8086")
+	writer.token = conf.GetString("Token", "")
+	writer.bucketTemplate = conf.GetString("Bucket", conf.GetString("Database", "default"))
+	writer.buffer = tio.NewByteStream(4096)
+	writer.connectionUp = false
+	writer.timeBasedName = conf.GetBool("TimeBasedName", true)
+	writer.logger = prod.Logger
+
+	organization := conf.GetString("Organization", "")
+	if organization == "" {
+		conf.Errors.Pushf("Organization must be set for InfluxDB 2.x")
+	}
+
+	precision := conf.GetString("Precision", "ms")
+	switch precision {
+	case "ns", "us", "ms", "s":
+	default:
+		conf.Errors.Pushf("Precision must be one of ns, us, ms or s")
+	}
+
+	baseURL := writer.host
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "http://" + baseURL
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	writer.pingURL = baseURL + "/ping"
+	writer.writeURL = fmt.Sprintf("%s/api/v2/write?org=%s&precision=%s",
+		baseURL, url.QueryEscape(organization), precision)
+
+	return conf.Errors.OrNil()
+}
+
+func (writer *influxDBWriter20) isConnectionUp() bool {
+	if writer.connectionUp {
+		return true // ### return, connection not reported to be down ###
+	}
+
+	if response, err := writer.client.Get(writer.pingURL); err == nil && response != nil {
+		defer response.Body.Close()
+		switch response.Status[:3] {
+		case "200", "204":
+			writer.connectionUp = true
+			writer.logger.Debug("Connected to " + writer.host)
+		}
+	}
+
+	return writer.connectionUp
+}
+
+func (writer *influxDBWriter20) post() (int, error) {
+	bucketName := writer.bucketTemplate
+	if writer.timeBasedName {
+		bucketName = time.Now().Format(bucketName)
+	}
+	writeURL := fmt.Sprintf("%s&bucket=%s", writer.writeURL, url.QueryEscape(bucketName))
+
+	request, err := http.NewRequest("POST", writeURL, &writer.buffer)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if writer.token != "" {
+		request.Header.Set("Authorization", "Token "+writer.token)
+	}
+
+	response, err := writer.client.Do(request)
+	if err != nil {
+		writer.connectionUp = false
+		return 0, err // ### return, failed to connect ###
+	}
+
+	defer response.Body.Close()
+
+	// Check status codes
+	switch response.Status[:3] {
+	case "200", "204":
+		return writer.buffer.Len(), nil // ### return, OK ###
+
+	default:
+		// 400 invalid line protocol, 401 invalid token, 404 unknown bucket
+		body, _ := ioutil.ReadAll(response.Body)
+		writer.connectionUp = false
+		return 0, fmt.Errorf("%s returned %s: %s", writeURL, response.Status, string(body))
+	}
+}
+
+func (writer *influxDBWriter20) Write(data []byte) (int, error) {
+	writer.buffer.Reset()
+	writer.buffer.Write(data)
+	return writer.post()
+}
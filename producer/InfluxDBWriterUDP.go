@@ -0,0 +1,95 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package producer
+
+import (
+	"bytes"
+	"github.com/sirupsen/logrus"
+	"github.com/trivago/gollum/core"
+	"net"
+)
+
+// influxDBWriterUDP implements the io.Writer interface for the InfluxDB UDP
+// service. Data is expected to be in line protocol format, i.e. one point per
+// line. Lines are grouped into packets of at most packetSize bytes.
+type influxDBWriterUDP struct {
+	connection net.Conn
+	host       string
+	packetSize int
+	logger     logrus.FieldLogger
+}
+
+// Configure sets the database connection values
+func (writer *influxDBWriterUDP) configure(conf core.PluginConfigReader, prod *InfluxDB) error {
+	writer.host = conf.GetString("Host", "localhost:8089")
+	writer.packetSize = int(conf.GetInt("PacketSize", 512))
+	writer.logger = prod.Logger
+
+	if writer.packetSize <= 0 {
+		conf.Errors.Pushf("PacketSize must be greater than 0")
+	}
+	return conf.Errors.OrNil()
+}
+
+func (writer *influxDBWriterUDP) isConnectionUp() bool {
+	if writer.connection != nil {
+		return true // ### return, already "connected" ###
+	}
+
+	connection, err := net.Dial("udp", writer.host)
+	if err != nil {
+		writer.logger.Error("Failed to resolve ", writer.host, ": ", err)
+		return false
+	}
+
+	writer.connection = connection
+	writer.logger.Debug("Sending to " + writer.host)
+	return true
+}
+
+// Write sends the given lines as one or more packets. Lines larger than the
+// packet size are sent as a packet of their own.
+func (writer *influxDBWriterUDP) Write(data []byte) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		packet := writer.nextPacket(data)
+		if _, err := writer.connection.Write(packet); err != nil {
+			writer.connection.Close()
+			writer.connection = nil
+			return written, err
+		}
+		written += len(packet)
+		data = data[len(packet):]
+	}
+	return written, nil
+}
+
+// nextPacket returns the largest prefix of data ending at a line break that
+// fits into a packet. If the first line does not fit, it is returned as is.
+func (writer *influxDBWriterUDP) nextPacket(data []byte) []byte {
+	if len(data) <= writer.packetSize {
+		return data
+	}
+
+	if end := bytes.LastIndexByte(data[:writer.packetSize], '\n'); end >= 0 {
+		return data[:end+1]
+	}
+
+	writer.logger.Warningf("Line exceeds packet size of %d bytes", writer.packetSize)
+	if end := bytes.IndexByte(data, '\n'); end >= 0 {
+		return data[:end+1]
+	}
+	return data
+}
@@ -0,0 +1,104 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package producer
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestInfluxDBWriter20(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	var body, query, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v2/write" {
+			data, _ := ioutil.ReadAll(r.Body)
+			body, query, auth = string(data), r.URL.RawQuery, r.Header.Get("Authorization")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	config := core.NewPluginConfig("influxV2", "producer.InfluxDB")
+	config.Override("Version", 200)
+	config.Override("Host", server.URL)
+	config.Override("Organization", "gollum")
+	config.Override("Bucket", "metrics")
+	config.Override("Token", "secret")
+	config.Override("Precision", "s")
+	config.Override("TimeBasedName", false)
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	prod := plugin.(*InfluxDB)
+
+	writer, isV2 := prod.writer.(*influxDBWriter20)
+	expect.True(isV2)
+	expect.True(writer.isConnectionUp())
+
+	_, err = writer.Write([]byte("cpu value=1 1\n"))
+	expect.NoError(err)
+	expect.Equal("cpu value=1 1\n", body)
+	expect.Equal("org=gollum&precision=s&bucket=metrics", query)
+	expect.Equal("Token secret", auth)
+
+	config = core.NewPluginConfig("influxV2NoOrg", "producer.InfluxDB")
+	config.Override("Version", 200)
+	_, err = core.NewPluginWithConfig(config)
+	expect.NotNil(err)
+}
+
+func TestInfluxDBWriterUDP(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	expect.NoError(err)
+	defer listener.Close()
+
+	config := core.NewPluginConfig("influxUDP", "producer.InfluxDB")
+	config.Override("Version", "udp")
+	config.Override("Host", listener.LocalAddr().String())
+	config.Override("PacketSize", 20)
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	prod := plugin.(*InfluxDB)
+
+	writer, isUDP := prod.writer.(*influxDBWriterUDP)
+	expect.True(isUDP)
+	expect.True(writer.isConnectionUp())
+
+	lines := []string{"a value=1\n", "b value=2\n", "long_measurement value=3\n", "c value=4\n"}
+	_, err = writer.Write([]byte(strings.Join(lines, "")))
+	expect.NoError(err)
+
+	packets := []string{}
+	buffer := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		n, _, err := listener.ReadFrom(buffer)
+		expect.NoError(err)
+		packets = append(packets, string(buffer[:n]))
+	}
+
+	expect.Equal([]string{lines[0] + lines[1], lines[2], lines[3]}, packets)
+}
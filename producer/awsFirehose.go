@@ -233,6 +233,10 @@ func (prod *AwsFirehose) transformMessages(messages []*core.Message) {
 					for _, msg := range records.original[msgIdx] {
 						prod.TryFallback(msg)
 					}
+				} else {
+					for _, msg := range records.original[msgIdx] {
+						msg.Release()
+					}
 				}
 			}
 		}
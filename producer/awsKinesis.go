@@ -194,9 +194,9 @@ func (prod *AwsKinesis) transformMessages(messages []*core.Message) {
 		var record *kinesis.PutRecordsRequestEntry
 		recordExists := len(records.content.Records) > 0
 		if !recordExists || records.lastRecordMessages+1 > prod.recordMaxMessages {
-			// Append record to stream
-			record := &kinesis.PutRecordsRequestEntry{
-				Data:         msg.GetPayload(),
+			// Append record to stream. Data is copied below so that records
+			// do not share buffers with released messages.
+			record = &kinesis.PutRecordsRequestEntry{
 				PartitionKey: aws.String(messageHash),
 			}
 			records.content.Records = append(records.content.Records, record)
@@ -239,6 +239,10 @@ func (prod *AwsKinesis) transformMessages(messages []*core.Message) {
 					for _, msg := range records.original[msgIdx] {
 						prod.TryFallback(msg)
 					}
+				} else {
+					for _, msg := range records.original[msgIdx] {
+						msg.Release()
+					}
 				}
 			}
 		}
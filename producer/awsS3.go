@@ -45,6 +45,56 @@ const defaultAwsEndpoint = "s3.amazonaws.com"
 // The " * " will parsed to the active stream name.
 // By default this parameter is set to "gollum_*.log"
 //
+// When partitioning is enabled (see Partition/Enable) the formatted event time
+// of a message is added to the object name, e.g.
+// "gollum_2017-07-14_02_<rotation timestamp>.log".
+//
+// - ObjectKey: This value defines a text/template used to generate the object
+// name per message and replaces File if set. The rotation timestamp is added
+// before the file extension. The template can use {{.Stream}} for the stream
+// name, {{.Time "layout"}} for the event time (see Partition/Field and
+// Partition/MetadataKey), {{.Meta "key"}} for metadata values and
+// {{.Field "path"}} for JSON fields of the payload. Empty or missing values
+// are written as "__HIVE_DEFAULT_PARTITION__", slashes in values are replaced
+// by underscores. The number of objects open at the same time is limited by
+// Partition/MaxOpen, which defaults to 8. Each open object keeps a batch and
+// an upload buffer in memory, so raising this limit increases memory usage.
+// If the template yields more distinct keys at the same time than objects
+// may be open, e.g. because of a metadata value with many distinct values,
+// objects are closed and uploaded early. This results in many small objects,
+// so Partition/MaxOpen should be set to at least the number of distinct keys
+// expected per rotation interval.
+// By default this parameter is set to "".
+//
+// - PathStyle: When set to true, the bucket name is sent as part of the path
+// instead of the host name. This is required by most S3 compatible services
+// like MinIO.
+// By default this parameter is set to false.
+//
+// - Format: This value defines the object format. Set to "lines" to write the
+// message payloads as they are or "parquet" to write each message as a row of
+// a parquet file. Payloads are expected to be JSON objects in this case.
+// By default this parameter is set to "lines".
+//
+// - Parquet/Schema: This value defines the list of columns for the parquet
+// format in the form "name:type". Valid types are "string", "int", "int32",
+// "float", "bool", "timestamp" and "json". Columns are read from JSON fields
+// of the same name, paths like "user/name" are written as column "user_name".
+// Timestamps are parsed using TimeFormats and Timezone. Values that cannot be
+// converted, e.g. numbers out of the range of an "int32" column, are written
+// as null.
+// By default this parameter is set to an empty list.
+//
+// - Parquet/Compression: This value defines the compression used for parquet
+// pages. Valid values are "none", "snappy" and "gzip".
+// By default this parameter is set to "snappy".
+//
+// - Parquet/RowGroupSizeMB: This value defines the amount of JSON data to
+// buffer before a parquet row group is written. The value is limited to
+// Rotation/SizeMB so that every object contains at most one row group per
+// rotation cycle if set higher.
+// By default this parameter is set to "128".
+//
 // Examples
 //
 // This example will send all received message from all stream to S3
@@ -73,6 +123,33 @@ const defaultAwsEndpoint = "s3.amazonaws.com"
 //      - format.Envelope:
 //        Postfix: "\n"
 //
+// This example writes hive style partitioned parquet files to a local MinIO
+// server:
+//
+//  S3Parquet:
+//    Type: producer.AwsS3
+//    Streams: access
+//    Endpoint: http://localhost:9000
+//    PathStyle: true
+//    Credential:
+//      Type: static
+//      Id: minio
+//      Secret: minio123
+//    Bucket: datalake/access
+//    ObjectKey: 'dt={{.Time "2006-01-02"}}/hour={{.Time "15"}}/service={{.Meta "service"}}/access.parquet'
+//    Partition/Field: time
+//    TimeFormats: unixms
+//    Format: parquet
+//    Parquet:
+//      Schema:
+//        - time:timestamp
+//        - status:int
+//        - path:string
+//        - request/bytes:int
+//    Rotation:
+//      TimeoutMin: 10
+//      SizeMB: 64
+//
 type AwsS3 struct {
 	core.DirectProducer `gollumdoc:"embed_type"`
 
@@ -82,10 +159,12 @@ type AwsS3 struct {
 	Rotate         components.RotateConfig        `gollumdoc:"embed_type"`
 	AwsMultiClient components.AwsMultiClient      `gollumdoc:"embed_type"`
 	BatchConfig    components.BatchedWriterConfig `gollumdoc:"embed_type"`
+	Partition      components.TimePartitionConfig `gollumdoc:"embed_type"`
 
 	// configurations
 	bucket          string `config:"Bucket" default:""`
 	fileNamePattern string `config:"File" default:"gollum_*.log"`
+	pathStyle       bool   `config:"PathStyle" default:"false"`
 
 	// properties
 	filesByStream    map[core.MessageStreamID]*components.BatchedWriterAssembly
@@ -93,6 +172,10 @@ type AwsS3 struct {
 	hasWildcard      bool
 	batchedFileGuard *sync.RWMutex
 	s3Client         *s3.S3
+	partitions       components.TimePartitionSet
+	objectKey        *awsS3.ObjectKeyTemplate
+	parquet          *awsS3.ParquetFormat
+	header           []byte
 }
 
 func init() {
@@ -111,6 +194,33 @@ func (prod *AwsS3) Configure(conf core.PluginConfigReader) {
 	prod.Rotate.Enabled = true // force rotation
 
 	prod.batchedFileGuard = new(sync.RWMutex)
+	prod.partitions = components.NewTimePartitionSet(prod.Partition.MaxOpen)
+	prod.header = prod.GetModulators().GetHeader()
+
+	if objectKey := conf.GetString("ObjectKey", ""); objectKey != "" {
+		var err error
+		prod.objectKey, err = awsS3.NewObjectKeyTemplate(objectKey)
+		conf.Errors.Push(err)
+	}
+
+	switch strings.ToLower(conf.GetString("Format", "lines")) {
+	case "lines":
+	case "parquet":
+		rowGroupSize := int64(conf.GetInt("Parquet/RowGroupSizeMB", 128)) << 20
+		if rowGroupSize > prod.Rotate.SizeByte {
+			rowGroupSize = prod.Rotate.SizeByte
+		}
+
+		var err error
+		prod.parquet, err = awsS3.NewParquetFormat(
+			conf.GetStringArray("Parquet/Schema", []string{}),
+			conf.GetString("Parquet/Compression", "snappy"),
+			rowGroupSize,
+			&prod.Partition.Parser)
+		conf.Errors.Push(err)
+	default:
+		conf.Errors.Pushf("Unknown Format '%s'", conf.GetString("Format", "lines"))
+	}
 }
 
 // Produce writes to a buffer that is send to S3 as a multipart upload.
@@ -138,9 +248,31 @@ func (prod *AwsS3) initS3Client() {
 		}
 	}
 
+	if prod.pathStyle {
+		awsConfig.WithS3ForcePathStyle(true)
+	}
+
 	prod.s3Client = s3.New(sess, awsConfig)
 }
 
+// newWriter creates a writer for a new object based on the given file name
+func (prod *AwsS3) newWriter(baseFileName string) components.BatchedWriter {
+	writer := awsS3.NewBatchedFileWriter(prod.s3Client, prod.bucket, prod.getFinalFileName(baseFileName), prod.Logger)
+	if prod.parquet != nil {
+		return prod.parquet.NewWriter(&writer, prod.Logger)
+	}
+	// Objects are started with the header of formatters like format.JSONToCSV
+	if len(prod.header) > 0 {
+		writer.Write(prod.header)
+	}
+	return &writer
+}
+
+// isPartitioned returns true if objects are selected per message
+func (prod *AwsS3) isPartitioned() bool {
+	return prod.Partition.Enabled || prod.objectKey != nil
+}
+
 func (prod *AwsS3) getBatchedFile(streamID core.MessageStreamID, forceRotate bool) (*components.BatchedWriterAssembly, error) {
 	// get batchedFile from filesByStream[streamID] map
 	prod.batchedFileGuard.RLock()
@@ -187,12 +319,81 @@ func (prod *AwsS3) getBatchedFile(streamID core.MessageStreamID, forceRotate boo
 	}
 
 	// Update BatchedWriterAssembly writer
-	writer := awsS3.NewBatchedFileWriter(prod.s3Client, prod.bucket, prod.getFinalFileName(baseFileName), prod.Logger)
-	batchedFile.SetWriter(&writer)
+	batchedFile.SetWriter(prod.newWriter(baseFileName))
+
+	return batchedFile, nil
+}
+
+func (prod *AwsS3) getPartitionFileName(streamID core.MessageStreamID, partition string) string {
+	baseFileName := prod.getBaseFileName(streamID)
+	fileExt := filepath.Ext(baseFileName)
+	return fmt.Sprintf("%s_%s%s", baseFileName[:len(baseFileName)-len(fileExt)], partition, fileExt)
+}
+
+func (prod *AwsS3) getPartitionedFile(partitionFileName string) (*components.BatchedWriterAssembly, error) {
+	// fast path for open partitions that don't need to be rotated
+	prod.batchedFileGuard.RLock()
+	batchedFile, fileExists := prod.files[partitionFileName]
+	if fileExists && prod.partitions.Refresh(partitionFileName) {
+		if rotate, err := prod.needsRotate(batchedFile, false); !rotate {
+			prod.batchedFileGuard.RUnlock()
+			return batchedFile, err // ### return, already open or error ###
+		}
+	}
+	prod.batchedFileGuard.RUnlock()
+
+	prod.batchedFileGuard.Lock()
+	defer prod.batchedFileGuard.Unlock()
+
+	if evicted := prod.partitions.Touch(partitionFileName); evicted != "" {
+		prod.closePartition(evicted)
+	}
+
+	// check again to avoid race conditions
+	batchedFile, fileExists = prod.files[partitionFileName]
+	if fileExists {
+		if rotate, err := prod.needsRotate(batchedFile, false); !rotate {
+			return batchedFile, err // ### return, already open or error ###
+		}
+	} else {
+		batchedFile = components.NewBatchedWriterAssembly(
+			prod.BatchConfig,
+			prod,
+			prod.TryFallback,
+			prod.Logger,
+		)
+		prod.files[partitionFileName] = batchedFile
+	}
+
+	if batchedFile.HasWriter() {
+		oldAwsWriter := batchedFile.GetWriterAndUnset()
+
+		prod.Logger.Info("Rotated ", oldAwsWriter.Name(), " -> ", partitionFileName)
+		go oldAwsWriter.Close()
+	}
+
+	batchedFile.SetWriter(prod.newWriter(partitionFileName))
 
 	return batchedFile, nil
 }
 
+// closePartition uploads and closes the object of a partition.
+// batchedFileGuard must be locked when calling this function.
+func (prod *AwsS3) closePartition(partitionFileName string) {
+	batchedFile, fileExists := prod.files[partitionFileName]
+	if !fileExists {
+		return // ### return, already closed ###
+	}
+
+	delete(prod.files, partitionFileName)
+	prod.partitions.Remove(partitionFileName)
+
+	if batchedFile.HasWriter() {
+		prod.Logger.Debug("Closing partition ", batchedFile.GetWriter().Name())
+		batchedFile.Close()
+	}
+}
+
 func (prod *AwsS3) needsRotate(batchedFile *components.BatchedWriterAssembly, forceRotate bool) (bool, error) {
 	// run default rotation checks
 	if needUpload, err := batchedFile.NeedsRotate(prod.Rotate, forceRotate); needUpload {
@@ -233,7 +434,30 @@ func (prod *AwsS3) getFinalFileName(baseFileName string) string {
 }
 
 func (prod *AwsS3) writeMessage(msg *core.Message) {
-	batchedFile, err := prod.getBatchedFile(msg.GetStreamID(), false)
+	var (
+		batchedFile *components.BatchedWriterAssembly
+		err         error
+	)
+
+	if !prod.isPartitioned() {
+		batchedFile, err = prod.getBatchedFile(msg.GetStreamID(), false)
+	} else {
+		eventTime := prod.Partition.GetEventTime(msg)
+		if prod.Partition.Enabled && prod.Partition.IsLate(eventTime) {
+			prod.Logger.Debug("Message for ", eventTime, " is too late")
+			prod.TryFallback(msg)
+			return // ### return, fallback ###
+		}
+
+		partitionFileName := prod.getPartitionFileName(msg.GetStreamID(), eventTime.Format(prod.Partition.Timestamp))
+		if prod.objectKey != nil {
+			partitionFileName, err = prod.objectKey.Execute(msg, eventTime)
+		}
+		if err == nil {
+			batchedFile, err = prod.getPartitionedFile(partitionFileName)
+		}
+	}
+
 	if err != nil {
 		prod.Logger.Error("Write error: ", err)
 		prod.TryFallback(msg)
@@ -244,12 +468,25 @@ func (prod *AwsS3) writeMessage(msg *core.Message) {
 }
 
 func (prod *AwsS3) writeBatchOnTimeOut() {
+	prod.batchedFileGuard.RLock()
+	defer prod.batchedFileGuard.RUnlock()
+
 	for _, batchedFile := range prod.files {
 		batchedFile.FlushOnTimeOut()
 	}
 }
 
 func (prod *AwsS3) rotateTargetFiles() {
+	if prod.isPartitioned() {
+		// Partitions are reopened with the next message
+		prod.batchedFileGuard.Lock()
+		for _, partitionFileName := range prod.partitions.Partitions() {
+			prod.closePartition(partitionFileName)
+		}
+		prod.batchedFileGuard.Unlock()
+		return
+	}
+
 	for streamID := range prod.filesByStream {
 		if _, err := prod.getBatchedFile(streamID, true); err != nil {
 			prod.Logger.Error("Rotate error: ", err)
@@ -0,0 +1,121 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awsS3
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/gollum/core/components"
+	"github.com/trivago/tgo/tcontainer"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// HiveDefaultPartition is used for empty or missing key values. Hive based
+// query engines treat this value as null.
+const HiveDefaultPartition = "__HIVE_DEFAULT_PARTITION__"
+
+// ObjectKeyTemplate generates object keys from messages using text/template.
+// The following functions are available inside the template:
+//  {{.Stream}}       the name of the message's stream
+//  {{.Time "15"}}    the event time, formatted by the given layout
+//  {{.Meta "key"}}   the value of a metadata key
+//  {{.Field "path"}} the value of a JSON field in the payload
+type ObjectKeyTemplate struct {
+	template *template.Template
+}
+
+// objectKeyData is passed to the template when generating a key
+type objectKeyData struct {
+	msg       *core.Message
+	eventTime time.Time
+	values    tcontainer.MarshalMap
+}
+
+// NewObjectKeyTemplate parses the given template
+func NewObjectKeyTemplate(pattern string) (*ObjectKeyTemplate, error) {
+	tpl, err := template.New("ObjectKey").Parse(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectKeyTemplate{template: tpl}, nil
+}
+
+// Execute returns the object key for the given message
+func (key *ObjectKeyTemplate) Execute(msg *core.Message, eventTime time.Time) (string, error) {
+	data := &objectKeyData{
+		msg:       msg,
+		eventTime: eventTime,
+	}
+
+	var objectKey bytes.Buffer
+	if err := key.template.Execute(&objectKey, data); err != nil {
+		return "", err
+	}
+
+	return strings.TrimPrefix(objectKey.String(), "/"), nil
+}
+
+// Stream returns the name of the message's stream
+func (data *objectKeyData) Stream() string {
+	return core.StreamRegistry.GetStreamName(data.msg.GetStreamID())
+}
+
+// Time returns the event time formatted by the given layout. Supports the
+// same names as components.FormatTime.
+func (data *objectKeyData) Time(layout string) string {
+	return components.FormatTime(data.eventTime, layout)
+}
+
+// Meta returns the value of the given metadata key
+func (data *objectKeyData) Meta(key string) string {
+	return sanitizeKeyValue(data.msg.GetMetadata().GetValueString(key))
+}
+
+// Field returns the value of the given JSON field. Paths can be defined in a
+// format accepted by tgo.MarshalMap.Path.
+func (data *objectKeyData) Field(path string) string {
+	if data.values == nil {
+		data.values = tcontainer.NewMarshalMap()
+		decoder := json.NewDecoder(bytes.NewReader(data.msg.GetPayload()))
+		decoder.UseNumber()
+		decoder.Decode(&data.values)
+	}
+
+	value, exists := data.values.Value(path)
+	if !exists || value == nil {
+		return HiveDefaultPartition
+	}
+
+	switch value := value.(type) {
+	case string:
+		return sanitizeKeyValue(value)
+	case json.Number, bool:
+		return fmt.Sprintf("%v", value)
+	default:
+		return HiveDefaultPartition
+	}
+}
+
+// sanitizeKeyValue makes sure values do not create additional path elements
+func sanitizeKeyValue(value string) string {
+	if value == "" {
+		return HiveDefaultPartition
+	}
+	return strings.Replace(value, "/", "_", -1)
+}
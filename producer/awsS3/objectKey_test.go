@@ -0,0 +1,46 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awsS3
+
+import (
+	"testing"
+	"time"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestObjectKeyTemplate(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	key, err := NewObjectKeyTemplate(`dt={{.Time "2006-01-02"}}/hour={{.Time "15"}}/service={{.Meta "service"}}/{{.Field "user/id"}}/{{.Field "missing"}}/{{.Stream}}.log`)
+	expect.NoError(err)
+
+	streamID := core.StreamRegistry.GetStreamID("objectKeyTest")
+	msg := core.NewMessage(nil, []byte(`{"user":{"id":42}}`), core.Metadata{"service": []byte("api/v1")}, streamID)
+	eventTime := time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC)
+
+	objectKey, err := key.Execute(msg, eventTime)
+	expect.NoError(err)
+	expect.Equal("dt=2017-07-14/hour=02/service=api_v1/42/__HIVE_DEFAULT_PARTITION__/objectKeyTest.log", objectKey)
+
+	_, err = NewObjectKeyTemplate(`{{.Time`)
+	expect.NotNil(err)
+
+	key, err = NewObjectKeyTemplate(`{{.Unknown}}`)
+	expect.NoError(err)
+	_, err = key.Execute(msg, eventTime)
+	expect.NotNil(err)
+}
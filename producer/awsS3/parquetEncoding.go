@@ -0,0 +1,264 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awsS3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"github.com/artyom/thrift"
+	"github.com/golang/snappy"
+)
+
+// Constants as defined by parquet.thrift
+// @see https://github.com/apache/parquet-format/blob/master/src/main/thrift/parquet.thrift
+const (
+	parquetTypeBoolean   = int32(0)
+	parquetTypeInt32     = int32(1)
+	parquetTypeInt64     = int32(2)
+	parquetTypeDouble    = int32(5)
+	parquetTypeByteArray = int32(6)
+
+	parquetConvertedNone      = int32(-1)
+	parquetConvertedUTF8      = int32(0)
+	parquetConvertedTimestamp = int32(9) // TIMESTAMP_MILLIS
+
+	parquetRepetitionOptional = int32(1)
+
+	parquetEncodingPlain = int32(0)
+	parquetEncodingRLE   = int32(3)
+
+	parquetPageTypeData = int32(0)
+
+	parquetCodecNone   = int32(0)
+	parquetCodecSnappy = int32(1)
+	parquetCodecGzip   = int32(2)
+)
+
+const parquetMagic = "PAR1"
+
+// parquetChunkInfo holds the metadata of a written column chunk
+type parquetChunkInfo struct {
+	column           *parquetColumnSchema
+	offset           int64
+	numValues        int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+// parquetRowGroupInfo holds the metadata of a written row group
+type parquetRowGroupInfo struct {
+	numRows int64
+	chunks  []parquetChunkInfo
+}
+
+// parquetEncoder wraps the thrift compact protocol used for parquet metadata
+type parquetEncoder struct {
+	*thrift.TCompactProtocol
+	buffer *thrift.TMemoryBuffer
+}
+
+func newParquetEncoder() parquetEncoder {
+	buffer := thrift.NewTMemoryBuffer()
+	return parquetEncoder{
+		TCompactProtocol: thrift.NewTCompactProtocol(buffer),
+		buffer:           buffer,
+	}
+}
+
+// Writes to a memory buffer cannot fail so errors are ignored by the
+// following helper functions.
+
+func (enc parquetEncoder) i32(id int16, value int32) {
+	enc.WriteFieldBegin("", thrift.I32, id)
+	enc.WriteI32(value)
+}
+
+func (enc parquetEncoder) i64(id int16, value int64) {
+	enc.WriteFieldBegin("", thrift.I64, id)
+	enc.WriteI64(value)
+}
+
+func (enc parquetEncoder) str(id int16, value string) {
+	enc.WriteFieldBegin("", thrift.STRING, id)
+	enc.WriteString(value)
+}
+
+func (enc parquetEncoder) list(id int16, elemType thrift.TType, size int) {
+	enc.WriteFieldBegin("", thrift.LIST, id)
+	enc.WriteListBegin(elemType, size)
+}
+
+func (enc parquetEncoder) structBegin(id int16) {
+	if id > 0 {
+		enc.WriteFieldBegin("", thrift.STRUCT, id)
+	}
+	enc.WriteStructBegin("")
+}
+
+func (enc parquetEncoder) structEnd() {
+	enc.WriteFieldStop()
+	enc.WriteStructEnd()
+}
+
+func (enc parquetEncoder) Bytes() []byte {
+	return enc.buffer.Bytes()
+}
+
+// encodePageHeader returns a PageHeader struct for a data page
+func encodePageHeader(uncompressedSize, compressedSize, numValues int) []byte {
+	enc := newParquetEncoder()
+	enc.structBegin(0)
+	enc.i32(1, parquetPageTypeData)
+	enc.i32(2, int32(uncompressedSize))
+	enc.i32(3, int32(compressedSize))
+
+	enc.structBegin(5) // DataPageHeader
+	enc.i32(1, int32(numValues))
+	enc.i32(2, parquetEncodingPlain)
+	enc.i32(3, parquetEncodingRLE)
+	enc.i32(4, parquetEncodingRLE)
+	enc.structEnd()
+
+	enc.structEnd()
+	return enc.Bytes()
+}
+
+// encodeFileMetaData returns a FileMetaData struct describing the given
+// columns and row groups.
+func encodeFileMetaData(columns []parquetColumnSchema, rowGroups []parquetRowGroupInfo, codec int32) []byte {
+	numRows := int64(0)
+	for _, rowGroup := range rowGroups {
+		numRows += rowGroup.numRows
+	}
+
+	enc := newParquetEncoder()
+	enc.structBegin(0)
+	enc.i32(1, 1) // version
+
+	enc.list(2, thrift.STRUCT, len(columns)+1)
+	enc.structBegin(0) // root element
+	enc.str(4, "schema")
+	enc.i32(5, int32(len(columns)))
+	enc.structEnd()
+
+	for _, column := range columns {
+		enc.structBegin(0)
+		enc.i32(1, column.physicalType)
+		enc.i32(3, parquetRepetitionOptional)
+		enc.str(4, column.name)
+		if column.convertedType != parquetConvertedNone {
+			enc.i32(6, column.convertedType)
+		}
+		enc.structEnd()
+	}
+
+	enc.i64(3, numRows)
+
+	enc.list(4, thrift.STRUCT, len(rowGroups))
+	for _, rowGroup := range rowGroups {
+		totalSize := int64(0)
+		for _, chunk := range rowGroup.chunks {
+			totalSize += chunk.uncompressedSize
+		}
+
+		enc.structBegin(0)
+		enc.list(1, thrift.STRUCT, len(rowGroup.chunks))
+		for _, chunk := range rowGroup.chunks {
+			enc.structBegin(0) // ColumnChunk
+			enc.i64(2, chunk.offset)
+
+			enc.structBegin(3) // ColumnMetaData
+			enc.i32(1, chunk.column.physicalType)
+			enc.list(2, thrift.I32, 2)
+			enc.WriteI32(parquetEncodingPlain)
+			enc.WriteI32(parquetEncodingRLE)
+			enc.list(3, thrift.STRING, 1)
+			enc.WriteString(chunk.column.name)
+			enc.i32(4, codec)
+			enc.i64(5, chunk.numValues)
+			enc.i64(6, chunk.uncompressedSize)
+			enc.i64(7, chunk.compressedSize)
+			enc.i64(9, chunk.offset)
+			enc.structEnd()
+
+			enc.structEnd()
+		}
+		enc.i64(2, totalSize)
+		enc.i64(3, rowGroup.numRows)
+		enc.structEnd()
+	}
+
+	enc.str(6, "gollum")
+	enc.structEnd()
+	return enc.Bytes()
+}
+
+// encodeDefinitionLevels writes the definition levels of an optional column
+// using the RLE/bit-packing hybrid encoding, prefixed by its length.
+func encodeDefinitionLevels(buffer *bytes.Buffer, present []bool) {
+	levels := bytes.NewBuffer(make([]byte, 0, 16))
+	varint := make([]byte, binary.MaxVarintLen64)
+
+	for start := 0; start < len(present); {
+		end := start + 1
+		for end < len(present) && present[end] == present[start] {
+			end++
+		}
+
+		// RLE run: header is the run length shifted by one, followed by the
+		// value padded to a full byte (bit width 1).
+		n := binary.PutUvarint(varint, uint64(end-start)<<1)
+		levels.Write(varint[:n])
+		if present[start] {
+			levels.WriteByte(1)
+		} else {
+			levels.WriteByte(0)
+		}
+		start = end
+	}
+
+	binary.Write(buffer, binary.LittleEndian, uint32(levels.Len()))
+	buffer.Write(levels.Bytes())
+}
+
+// encodeBooleans writes booleans using the plain (bit-packed) encoding
+func encodeBooleans(buffer *bytes.Buffer, values []bool) {
+	packed := make([]byte, (len(values)+7)/8)
+	for i, value := range values {
+		if value {
+			packed[i/8] |= 1 << uint(i%8)
+		}
+	}
+	buffer.Write(packed)
+}
+
+// compressPage compresses page data with the given codec
+func compressPage(data []byte, codec int32) []byte {
+	switch codec {
+	case parquetCodecSnappy:
+		return snappy.Encode(nil, data)
+
+	case parquetCodecGzip:
+		compressed := bytes.NewBuffer(make([]byte, 0, len(data)/2))
+		writer := gzip.NewWriter(compressed)
+		writer.Write(data)
+		writer.Close()
+		return compressed.Bytes()
+
+	default:
+		return data
+	}
+}
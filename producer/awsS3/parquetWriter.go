@@ -0,0 +1,407 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awsS3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/trivago/gollum/core/components"
+	"github.com/trivago/tgo/tcontainer"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// parquetColumnSchema describes a single parquet column
+type parquetColumnSchema struct {
+	name          string
+	path          string
+	typeName      string
+	physicalType  int32
+	convertedType int32
+}
+
+// ParquetFormat holds the schema and settings shared by all ParquetWriter
+// instances of a producer.
+type ParquetFormat struct {
+	columns      []parquetColumnSchema
+	codec        int32
+	rowGroupSize int64
+	timeParser   *components.TimeParser
+}
+
+// ParquetWriter is a BatchedFileWriterInterface implementation that converts
+// JSON documents into parquet rows. Rows are buffered in memory until the
+// configured row group size is reached. The file footer is written on Close.
+type ParquetWriter struct {
+	target       BatchedFileWriterInterface
+	format       *ParquetFormat
+	logger       logrus.FieldLogger
+	columns      []parquetColumnBuffer
+	rowGroups    []parquetRowGroupInfo
+	numRows      int64
+	bufferedSize int64
+	offset       int64
+	guard        *sync.Mutex
+}
+
+// parquetColumnBuffer holds the values of a column of the active row group
+type parquetColumnBuffer struct {
+	schema  *parquetColumnSchema
+	present []bool
+	bools   []bool
+	values  *bytes.Buffer
+}
+
+// NewParquetFormat creates a parquet format from a list of columns in the form
+// "name:type". Valid types are "string", "int", "int32", "float", "bool",
+// "timestamp" and "json". Column names may be paths like "user/name", in which
+// case the column is named "user_name". Compression can be "none", "snappy"
+// or "gzip".
+func NewParquetFormat(schema []string, compression string, rowGroupSize int64, timeParser *components.TimeParser) (*ParquetFormat, error) {
+	format := &ParquetFormat{
+		columns:      make([]parquetColumnSchema, 0, len(schema)),
+		rowGroupSize: rowGroupSize,
+		timeParser:   timeParser,
+	}
+
+	switch strings.ToLower(compression) {
+	case "none", "":
+		format.codec = parquetCodecNone
+	case "snappy":
+		format.codec = parquetCodecSnappy
+	case "gzip":
+		format.codec = parquetCodecGzip
+	default:
+		return nil, fmt.Errorf("Unknown parquet compression '%s'", compression)
+	}
+
+	if len(schema) == 0 {
+		return nil, fmt.Errorf("Parquet schema must define at least one column")
+	}
+
+	for _, definition := range schema {
+		separator := strings.LastIndexByte(definition, ':')
+		if separator < 1 {
+			return nil, fmt.Errorf("Parquet column '%s' must be defined as name:type", definition)
+		}
+
+		path, typeName := definition[:separator], definition[separator+1:]
+		column := parquetColumnSchema{
+			name:          strings.Replace(path, "/", "_", -1),
+			path:          path,
+			typeName:      strings.ToLower(typeName),
+			convertedType: parquetConvertedNone,
+		}
+
+		switch column.typeName {
+		case "string", "json":
+			column.physicalType = parquetTypeByteArray
+			column.convertedType = parquetConvertedUTF8
+		case "int":
+			column.physicalType = parquetTypeInt64
+		case "int32":
+			column.physicalType = parquetTypeInt32
+		case "float":
+			column.physicalType = parquetTypeDouble
+		case "bool":
+			column.physicalType = parquetTypeBoolean
+		case "timestamp":
+			column.physicalType = parquetTypeInt64
+			column.convertedType = parquetConvertedTimestamp
+		default:
+			return nil, fmt.Errorf("Unknown parquet type '%s' for column '%s'", typeName, path)
+		}
+		format.columns = append(format.columns, column)
+	}
+
+	return format, nil
+}
+
+// NewWriter returns a ParquetWriter writing to the given target
+func (format *ParquetFormat) NewWriter(target BatchedFileWriterInterface, logger logrus.FieldLogger) *ParquetWriter {
+	writer := &ParquetWriter{
+		target:  target,
+		format:  format,
+		logger:  logger,
+		columns: make([]parquetColumnBuffer, len(format.columns)),
+		guard:   new(sync.Mutex),
+	}
+
+	for i := range format.columns {
+		writer.columns[i] = parquetColumnBuffer{
+			schema: &format.columns[i],
+			values: bytes.NewBuffer(make([]byte, 0, 1024)),
+		}
+	}
+
+	writer.write([]byte(parquetMagic))
+	return writer
+}
+
+// Write is part of the BatchedWriter interface. The given data is expected to
+// contain one or more JSON objects, each of which is converted into a row.
+func (w *ParquetWriter) Write(p []byte) (int, error) {
+	w.guard.Lock()
+	defer w.guard.Unlock()
+
+	w.bufferedSize += int64(len(p))
+	defer func() {
+		if w.bufferedSize >= w.format.rowGroupSize {
+			w.flushRowGroup()
+		}
+	}()
+
+	decoder := json.NewDecoder(bytes.NewReader(p))
+	decoder.UseNumber()
+
+	for {
+		row := tcontainer.NewMarshalMap()
+		err := decoder.Decode(&row)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// The decoder cannot continue after a syntax error, so all
+			// remaining documents of this write are skipped.
+			return len(p), fmt.Errorf("Parquet row %d could not be parsed: %s", w.numRows, err.Error())
+		}
+
+		for i := range w.columns {
+			value, _ := row.Value(w.columns[i].schema.path)
+			w.columns[i].append(value, w.format.timeParser)
+		}
+		w.numRows++
+	}
+
+	return len(p), nil
+}
+
+// Name is part of the BatchedWriter interface
+func (w *ParquetWriter) Name() string {
+	return w.target.Name()
+}
+
+// Size is part of the BatchedWriter interface and returns the number of bytes
+// written plus the size of the rows not yet written.
+func (w *ParquetWriter) Size() int64 {
+	w.guard.Lock()
+	defer w.guard.Unlock()
+	return w.offset + w.bufferedSize
+}
+
+// IsAccessible is part of the BatchedWriter interface
+func (w *ParquetWriter) IsAccessible() bool {
+	return w.target.IsAccessible()
+}
+
+// GetUploadCount is part of the BatchedFileWriterInterface interface
+func (w *ParquetWriter) GetUploadCount() int {
+	return w.target.GetUploadCount()
+}
+
+// Close writes all buffered rows and the file footer before closing the
+// target.
+func (w *ParquetWriter) Close() error {
+	w.guard.Lock()
+	defer w.guard.Unlock()
+
+	w.flushRowGroup()
+
+	footer := encodeFileMetaData(w.format.columns, w.rowGroups, w.format.codec)
+	w.write(footer)
+
+	footerLen := make([]byte, 4)
+	binary.LittleEndian.PutUint32(footerLen, uint32(len(footer)))
+	w.write(footerLen)
+	w.write([]byte(parquetMagic))
+
+	return w.target.Close()
+}
+
+func (w *ParquetWriter) write(p []byte) {
+	n, _ := w.target.Write(p)
+	w.offset += int64(n)
+}
+
+// flushRowGroup writes all buffered rows as a row group with one data page
+// per column.
+func (w *ParquetWriter) flushRowGroup() {
+	w.bufferedSize = 0
+	if w.numRows == 0 {
+		return // ### return, nothing to write ###
+	}
+
+	rowGroup := parquetRowGroupInfo{
+		numRows: w.numRows,
+		chunks:  make([]parquetChunkInfo, 0, len(w.columns)),
+	}
+
+	for i := range w.columns {
+		column := &w.columns[i]
+		page := column.encodePage()
+		compressed := compressPage(page, w.format.codec)
+		header := encodePageHeader(len(page), len(compressed), len(column.present))
+
+		chunk := parquetChunkInfo{
+			column:           column.schema,
+			offset:           w.offset,
+			numValues:        int64(len(column.present)),
+			uncompressedSize: int64(len(header) + len(page)),
+			compressedSize:   int64(len(header) + len(compressed)),
+		}
+
+		w.write(header)
+		w.write(compressed)
+		rowGroup.chunks = append(rowGroup.chunks, chunk)
+		column.reset()
+	}
+
+	w.logger.
+		WithField("rows", w.numRows).
+		WithField("file", w.Name()).
+		Debug("Parquet row group written")
+
+	w.rowGroups = append(w.rowGroups, rowGroup)
+	w.numRows = 0
+}
+
+// append converts a JSON value to the type of this column. Values that cannot
+// be converted, including numbers out of the range of int32 columns, are
+// stored as null.
+func (column *parquetColumnBuffer) append(value interface{}, timeParser *components.TimeParser) {
+	if value == nil {
+		column.present = append(column.present, false)
+		return // ### return, null ###
+	}
+
+	converted := true
+	switch column.schema.typeName {
+	case "string":
+		if str, isString := value.(string); isString {
+			column.appendBytes([]byte(str))
+		} else {
+			column.appendJSON(value)
+		}
+
+	case "json":
+		column.appendJSON(value)
+
+	case "int", "int32":
+		var number int64
+		if number, converted = parquetToInt64(value); converted {
+			if column.schema.physicalType == parquetTypeInt32 {
+				if converted = number >= math.MinInt32 && number <= math.MaxInt32; converted {
+					binary.Write(column.values, binary.LittleEndian, int32(number))
+				}
+			} else {
+				binary.Write(column.values, binary.LittleEndian, number)
+			}
+		}
+
+	case "float":
+		var number float64
+		if number, converted = parquetToFloat64(value); converted {
+			binary.Write(column.values, binary.LittleEndian, math.Float64bits(number))
+		}
+
+	case "bool":
+		var flag bool
+		if flag, converted = parquetToBool(value); converted {
+			column.bools = append(column.bools, flag)
+		}
+
+	case "timestamp":
+		timestamp, err := timeParser.Parse(fmt.Sprintf("%v", value))
+		if converted = err == nil; converted {
+			binary.Write(column.values, binary.LittleEndian, timestamp.UnixNano()/1000000)
+		}
+	}
+
+	column.present = append(column.present, converted)
+}
+
+func (column *parquetColumnBuffer) appendBytes(data []byte) {
+	binary.Write(column.values, binary.LittleEndian, uint32(len(data)))
+	column.values.Write(data)
+}
+
+func (column *parquetColumnBuffer) appendJSON(value interface{}) {
+	encoded, _ := json.Marshal(value)
+	column.appendBytes(encoded)
+}
+
+// encodePage returns the uncompressed content of a data page
+func (column *parquetColumnBuffer) encodePage() []byte {
+	page := bytes.NewBuffer(make([]byte, 0, column.values.Len()+len(column.present)/4+16))
+	encodeDefinitionLevels(page, column.present)
+	if column.schema.physicalType == parquetTypeBoolean {
+		encodeBooleans(page, column.bools)
+	} else {
+		page.Write(column.values.Bytes())
+	}
+	return page.Bytes()
+}
+
+func (column *parquetColumnBuffer) reset() {
+	column.present = column.present[:0]
+	column.bools = column.bools[:0]
+	column.values.Reset()
+}
+
+func parquetToInt64(value interface{}) (int64, bool) {
+	switch value := value.(type) {
+	case json.Number:
+		if number, err := value.Int64(); err == nil {
+			return number, true
+		}
+		if number, err := value.Float64(); err == nil && number == math.Trunc(number) {
+			return int64(number), true
+		}
+	case string:
+		if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return number, true
+		}
+	}
+	return 0, false
+}
+
+func parquetToFloat64(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case json.Number:
+		number, err := value.Float64()
+		return number, err == nil
+	case string:
+		number, err := strconv.ParseFloat(value, 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+func parquetToBool(value interface{}) (bool, bool) {
+	switch value := value.(type) {
+	case bool:
+		return value, true
+	case string:
+		flag, err := strconv.ParseBool(value)
+		return flag, err == nil
+	}
+	return false, false
+}
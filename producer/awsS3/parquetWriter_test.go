@@ -0,0 +1,522 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awsS3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/sirupsen/logrus"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/gollum/core/components"
+	"github.com/trivago/tgo/ttesting"
+)
+
+type mockTargetWriter struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (w *mockTargetWriter) Name() string        { return "mock" }
+func (w *mockTargetWriter) Size() int64         { return int64(w.Len()) }
+func (w *mockTargetWriter) IsAccessible() bool  { return true }
+func (w *mockTargetWriter) GetUploadCount() int { return 0 }
+func (w *mockTargetWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func newTestTimeParser() *components.TimeParser {
+	parser := &components.TimeParser{Formats: []string{"unixms"}, Timezone: "UTC"}
+	config := core.NewPluginConfig("", "")
+	parser.Configure(core.NewPluginConfigReader(&config))
+	return parser
+}
+
+func TestParquetWriter(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	format, err := NewParquetFormat([]string{
+		"name:string",
+		"count:int",
+		"ratio:float",
+		"ok:bool",
+		"time:timestamp",
+		"request/size:int32",
+	}, "none", 1<<20, newTestTimeParser())
+	expect.NoError(err)
+
+	target := &mockTargetWriter{}
+	writer := format.NewWriter(target, logrus.StandardLogger())
+
+	_, err = writer.Write([]byte(`{"name":"a","count":1,"ratio":0.5,"ok":true,"time":1500000000123,"request":{"size":10}}
+{"name":"b","count":"x","ok":false}`))
+	expect.NoError(err)
+	expect.Equal(int64(2), writer.numRows)
+	expect.Greater(writer.Size(), int64(len(parquetMagic)))
+
+	expect.NoError(writer.Close())
+	expect.True(target.closed)
+
+	data := target.Bytes()
+	expect.Equal(parquetMagic, string(data[:4]))
+	expect.Equal(parquetMagic, string(data[len(data)-4:]))
+
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	expect.Less(footerLen, len(data)-12)
+	expect.Equal(1, len(writer.rowGroups))
+
+	// columns keep their order, paths are flattened
+	names := []string{}
+	for _, chunk := range writer.rowGroups[0].chunks {
+		names = append(names, chunk.column.name)
+		expect.Equal(int64(2), chunk.numValues)
+	}
+	expect.Equal([]string{"name", "count", "ratio", "ok", "time", "request_size"}, names)
+}
+
+func TestParquetWriterRowGroups(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	format, err := NewParquetFormat([]string{"name:string"}, "snappy", 16, newTestTimeParser())
+	expect.NoError(err)
+
+	target := &mockTargetWriter{}
+	writer := format.NewWriter(target, logrus.StandardLogger())
+
+	writer.Write([]byte(`{"name":"first message"}`))
+	writer.Write([]byte(`{"name":"second message"}`))
+	_, err = writer.Write([]byte(`{"name":`))
+	expect.NotNil(err)
+
+	expect.NoError(writer.Close())
+	expect.Equal(2, len(writer.rowGroups))
+}
+
+func TestParquetFormatErrors(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	_, err := NewParquetFormat([]string{"name:varchar"}, "none", 1, nil)
+	expect.NotNil(err)
+
+	_, err = NewParquetFormat([]string{"name"}, "none", 1, nil)
+	expect.NotNil(err)
+
+	_, err = NewParquetFormat([]string{"name:string"}, "lz4", 1, nil)
+	expect.NotNil(err)
+
+	_, err = NewParquetFormat([]string{}, "none", 1, nil)
+	expect.NotNil(err)
+}
+
+func TestParquetInt32Range(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	format, err := NewParquetFormat([]string{"size:int32"}, "none", 1<<20, nil)
+	expect.NoError(err)
+
+	column := parquetColumnBuffer{
+		schema: &format.columns[0],
+		values: bytes.NewBuffer(nil),
+	}
+	for _, value := range []interface{}{
+		json.Number("2147483647"),
+		json.Number("2147483648"),
+		json.Number("-2147483649"),
+		"-2147483648",
+	} {
+		column.append(value, nil)
+	}
+
+	expect.Equal([]bool{true, false, false, true}, column.present)
+	expect.Equal(8, column.values.Len())
+
+	values := []int32{}
+	for column.values.Len() > 0 {
+		var value int32
+		expect.NoError(binary.Read(column.values, binary.LittleEndian, &value))
+		values = append(values, value)
+	}
+	expect.Equal([]int32{math.MaxInt32, math.MinInt32}, values)
+}
+
+func TestParquetWriterReadBack(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	rows := []string{
+		`{"name":"a","count":1,"small":10,"ratio":0.5,"ok":true,"time":1500000000123}`,
+		`{"name":"b","count":"x","small":2147483648,"ok":false}`,
+		`{"count":-5,"small":-2147483648,"ratio":"1.5","ok":"true","time":"bad"}`,
+	}
+
+	expected := []map[string]interface{}{
+		{"name": "a", "count": int64(1), "small": int32(10), "ratio": 0.5, "ok": true, "time": int64(1500000000123)},
+		{"name": "b", "count": nil, "small": nil, "ratio": nil, "ok": false, "time": nil},
+		{"name": nil, "count": int64(-5), "small": int32(math.MinInt32), "ratio": 1.5, "ok": true, "time": nil},
+	}
+
+	for _, compression := range []string{"none", "snappy", "gzip"} {
+		// A row group size of 1 byte writes one row group per Write call
+		format, err := NewParquetFormat([]string{
+			"name:string", "count:int", "small:int32", "ratio:float", "ok:bool", "time:timestamp",
+		}, compression, 1, newTestTimeParser())
+		expect.NoError(err)
+
+		target := &mockTargetWriter{}
+		writer := format.NewWriter(target, logrus.StandardLogger())
+		for _, row := range rows {
+			_, err = writer.Write([]byte(row))
+			expect.NoError(err)
+		}
+		expect.NoError(writer.Close())
+
+		file, err := readTestParquetFile(target.Bytes())
+		expect.NoError(err)
+
+		expect.Equal(int64(3), file.numRows)
+		expect.Equal([]int64{1, 1, 1}, file.rowGroups)
+		expect.Equal([]string{"name", "count", "small", "ratio", "ok", "time"}, file.columns)
+		expect.Equal([]int32{parquetTypeByteArray, parquetTypeInt64, parquetTypeInt32, parquetTypeDouble, parquetTypeBoolean, parquetTypeInt64}, file.types)
+		expect.Equal(len(expected), len(file.rows))
+
+		for i, row := range file.rows {
+			for name, value := range expected[i] {
+				expect.Equal(value, row[name])
+			}
+		}
+	}
+}
+
+// parquetTestFile holds the content of a parquet file decoded by
+// readTestParquetFile.
+type parquetTestFile struct {
+	numRows   int64
+	rowGroups []int64
+	columns   []string
+	types     []int32
+	rows      []map[string]interface{}
+}
+
+// readTestParquetFile decodes a flat parquet file with optional columns and
+// plain encoded data pages. Only the parquet specification is used, so
+// this function does not depend on the writer's data structures.
+func readTestParquetFile(data []byte) (*parquetTestFile, error) {
+	if len(data) < 12 || string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		return nil, fmt.Errorf("magic bytes missing")
+	}
+
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footerLen > len(data)-12 {
+		return nil, fmt.Errorf("invalid footer length %d", footerLen)
+	}
+	metaData, _, err := readTestThriftStruct(data[len(data)-8-footerLen : len(data)-8])
+	if err != nil {
+		return nil, err
+	}
+
+	file := &parquetTestFile{numRows: metaData[3].(int64)}
+
+	schema := metaData[2].([]interface{})
+	root := schema[0].(map[int16]interface{})
+	if int(root[5].(int32)) != len(schema)-1 {
+		return nil, fmt.Errorf("root has %d children, %d columns found", root[5], len(schema)-1)
+	}
+	for _, element := range schema[1:] {
+		column := element.(map[int16]interface{})
+		if column[3].(int32) != 1 {
+			return nil, fmt.Errorf("column %s is not optional", column[4])
+		}
+		file.columns = append(file.columns, string(column[4].([]byte)))
+		file.types = append(file.types, column[1].(int32))
+	}
+
+	for _, element := range metaData[4].([]interface{}) {
+		rowGroup := element.(map[int16]interface{})
+		numRows := rowGroup[3].(int64)
+		file.rowGroups = append(file.rowGroups, numRows)
+
+		firstRow := len(file.rows)
+		for i := int64(0); i < numRows; i++ {
+			file.rows = append(file.rows, make(map[string]interface{}))
+		}
+
+		chunks := rowGroup[1].([]interface{})
+		if len(chunks) != len(file.columns) {
+			return nil, fmt.Errorf("row group has %d chunks for %d columns", len(chunks), len(file.columns))
+		}
+
+		for columnIdx, chunkElement := range chunks {
+			chunk := chunkElement.(map[int16]interface{})[3].(map[int16]interface{})
+			values, err := readTestParquetChunk(data, chunk, file.types[columnIdx])
+			if err != nil {
+				return nil, err
+			}
+			if int64(len(values)) != numRows {
+				return nil, fmt.Errorf("chunk has %d values for %d rows", len(values), numRows)
+			}
+			for i, value := range values {
+				file.rows[firstRow+i][file.columns[columnIdx]] = value
+			}
+		}
+	}
+
+	if int64(len(file.rows)) != file.numRows {
+		return nil, fmt.Errorf("file has %d rows, %d found in row groups", file.numRows, len(file.rows))
+	}
+	return file, nil
+}
+
+// readTestParquetChunk decodes a column chunk consisting of a single data
+// page. Null values are returned as nil.
+func readTestParquetChunk(data []byte, chunk map[int16]interface{}, physicalType int32) ([]interface{}, error) {
+	offset := chunk[9].(int64)
+	header, headerLen, err := readTestThriftStruct(data[offset:])
+	if err != nil {
+		return nil, err
+	}
+	if header[1].(int32) != 0 {
+		return nil, fmt.Errorf("page is not a data page")
+	}
+
+	compressedSize := int64(header[3].(int32))
+	if int64(headerLen)+compressedSize != chunk[7].(int64) {
+		return nil, fmt.Errorf("chunk size does not match page size")
+	}
+
+	page := data[offset+int64(headerLen) : offset+int64(headerLen)+compressedSize]
+	switch chunk[4].(int32) {
+	case 1:
+		if page, err = snappy.Decode(nil, page); err != nil {
+			return nil, err
+		}
+	case 2:
+		reader, err := gzip.NewReader(bytes.NewReader(page))
+		if err != nil {
+			return nil, err
+		}
+		if page, err = ioutil.ReadAll(reader); err != nil {
+			return nil, err
+		}
+	}
+	if len(page) != int(header[2].(int32)) {
+		return nil, fmt.Errorf("uncompressed page has %d bytes, expected %d", len(page), header[2])
+	}
+
+	dataPage := header[5].(map[int16]interface{})
+	numValues := int(dataPage[1].(int32))
+	if numValues != int(chunk[5].(int64)) {
+		return nil, fmt.Errorf("page has %d values, chunk %d", numValues, chunk[5])
+	}
+
+	levelsLen := int(binary.LittleEndian.Uint32(page))
+	levels := readTestDefinitionLevels(page[4:4+levelsLen], numValues)
+	values := bytes.NewReader(page[4+levelsLen:])
+
+	result := make([]interface{}, numValues)
+	boolIdx := 0
+	for i, defined := range levels {
+		if !defined {
+			continue // ### continue, null ###
+		}
+
+		switch physicalType {
+		case parquetTypeBoolean:
+			packed := page[4+levelsLen+boolIdx/8]
+			result[i] = packed&(1<<uint(boolIdx%8)) != 0
+			boolIdx++
+		case parquetTypeInt32:
+			var value int32
+			err = binary.Read(values, binary.LittleEndian, &value)
+			result[i] = value
+		case parquetTypeInt64:
+			var value int64
+			err = binary.Read(values, binary.LittleEndian, &value)
+			result[i] = value
+		case parquetTypeDouble:
+			var value float64
+			err = binary.Read(values, binary.LittleEndian, &value)
+			result[i] = value
+		case parquetTypeByteArray:
+			var length uint32
+			if err = binary.Read(values, binary.LittleEndian, &length); err == nil {
+				value := make([]byte, length)
+				_, err = values.Read(value)
+				result[i] = string(value)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if physicalType != parquetTypeBoolean && values.Len() > 0 {
+		return nil, fmt.Errorf("%d bytes left after reading values", values.Len())
+	}
+	return result, nil
+}
+
+// readTestDefinitionLevels decodes definition levels of bit width 1 stored
+// with the RLE/bit-packing hybrid encoding.
+func readTestDefinitionLevels(data []byte, numValues int) []bool {
+	levels := make([]bool, 0, numValues)
+	for len(levels) < numValues && len(data) > 0 {
+		header, n := binary.Uvarint(data)
+		data = data[n:]
+
+		if header&1 == 0 {
+			// RLE run
+			for i := uint64(0); i < header>>1; i++ {
+				levels = append(levels, data[0] != 0)
+			}
+			data = data[1:]
+		} else {
+			// Bit-packed groups of 8 values, one byte per group
+			for _, packed := range data[:header>>1] {
+				for bit := uint(0); bit < 8; bit++ {
+					levels = append(levels, packed&(1<<bit) != 0)
+				}
+			}
+			data = data[header>>1:]
+		}
+	}
+	return levels[:numValues]
+}
+
+// readTestThriftStruct decodes a thrift compact protocol struct into a map of
+// field ids to values. The number of bytes read is returned, too. Structs are
+// decoded without the thrift library used by the writer.
+func readTestThriftStruct(data []byte) (map[int16]interface{}, int, error) {
+	reader := bytes.NewReader(data)
+	value, err := readTestThriftValue(reader, parquetTestThriftStruct)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value.(map[int16]interface{}), len(data) - reader.Len(), nil
+}
+
+// Compact protocol type ids
+const (
+	parquetTestThriftTrue   = 1
+	parquetTestThriftFalse  = 2
+	parquetTestThriftByte   = 3
+	parquetTestThriftI16    = 4
+	parquetTestThriftI32    = 5
+	parquetTestThriftI64    = 6
+	parquetTestThriftDouble = 7
+	parquetTestThriftBinary = 8
+	parquetTestThriftList   = 9
+	parquetTestThriftSet    = 10
+	parquetTestThriftStruct = 12
+)
+
+func readTestThriftValue(reader *bytes.Reader, valueType byte) (interface{}, error) {
+	switch valueType {
+	case parquetTestThriftTrue, parquetTestThriftFalse:
+		// Bools are encoded in the field header, list elements use one byte
+		return valueType == parquetTestThriftTrue, nil
+
+	case parquetTestThriftByte:
+		return reader.ReadByte()
+
+	case parquetTestThriftI16, parquetTestThriftI32, parquetTestThriftI64:
+		value, err := binary.ReadVarint(reader) // zigzag encoded
+		switch valueType {
+		case parquetTestThriftI16:
+			return int16(value), err
+		case parquetTestThriftI32:
+			return int32(value), err
+		}
+		return value, err
+
+	case parquetTestThriftDouble:
+		var value float64
+		err := binary.Read(reader, binary.LittleEndian, &value)
+		return value, err
+
+	case parquetTestThriftBinary:
+		length, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, length)
+		_, err = reader.Read(value)
+		return value, err
+
+	case parquetTestThriftList, parquetTestThriftSet:
+		header, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		size := int(header >> 4)
+		if size == 15 {
+			longSize, err := binary.ReadUvarint(reader)
+			if err != nil {
+				return nil, err
+			}
+			size = int(longSize)
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			elemType := header & 0x0f
+			if elemType == parquetTestThriftTrue {
+				flag, err := reader.ReadByte()
+				list[i] = flag == parquetTestThriftTrue
+				if err != nil {
+					return nil, err
+				}
+				continue // ### continue, bool element ###
+			}
+			if list[i], err = readTestThriftValue(reader, elemType); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+
+	case parquetTestThriftStruct:
+		fields := make(map[int16]interface{})
+		lastID := int16(0)
+		for {
+			header, err := reader.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if header == 0 {
+				return fields, nil // ### return, end of struct ###
+			}
+
+			id := lastID + int16(header>>4)
+			if header>>4 == 0 {
+				longID, err := binary.ReadVarint(reader)
+				if err != nil {
+					return nil, err
+				}
+				id = int16(longID)
+			}
+			lastID = id
+
+			if fields[id], err = readTestThriftValue(reader, header&0x0f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, fmt.Errorf("unsupported thrift type %d", valueType)
+}
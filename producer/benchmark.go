@@ -38,6 +38,7 @@ func init() {
 }
 
 func (prod *Benchmark) null(msg *core.Message) {
+	msg.Release()
 }
 
 // Produce writes to stdout or stderr.
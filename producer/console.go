@@ -57,6 +57,7 @@ func (prod *Console) Configure(conf core.PluginConfigReader) {
 
 func (prod *Console) printMessage(msg *core.Message) {
 	fmt.Fprint(prod.console, msg.String())
+	msg.Release()
 }
 
 // Produce writes to stdout or stderr.
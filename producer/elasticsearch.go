@@ -19,10 +19,13 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/trivago/gollum/core"
+	"github.com/trivago/gollum/core/components"
+	"github.com/trivago/gollum/producer/elasticsearch"
 	"github.com/trivago/tgo"
 	"github.com/trivago/tgo/tcontainer"
 	"gopkg.in/olivere/elastic.v5"
 	"net/http"
+	"net/url"
 	"sync"
 	"syscall"
 	"time"
@@ -32,16 +35,28 @@ import (
 //
 // The ElasticSearch producer sends messages to elastic search using the bulk
 // http API. The producer expects a json payload.
+// The result of each document in a bulk request is checked separately.
+// Documents rejected because of load (status 429 or 503) are sent again,
+// all other rejected documents are sent to the fallback stream with the
+// error reason stored in the metadata field set by ErrorMetadataKey.
+// The server version is detected when connecting. Elasticsearch 5 to 8 and
+// OpenSearch 1 and 2 are supported. Document types are only used with
+// Elasticsearch versions before 7, newer servers receive typeless requests.
 //
 // Parameters
 //
 // - Retry/Count: Set the amount of retries before a Elasticsearch request fail finally.
+// This is also the number of times documents rejected because of load are sent again.
 // By default this parameter is set to "3".
 //
 // - Retry/TimeToWaitSec: This value denotes the time in seconds after which a failed dataset will be
 // transmitted again.
 // By default this parameter is set to "3".
 //
+// - ErrorMetadataKey: This value defines the metadata key used to store the
+// error reason of documents sent to the fallback stream.
+// By default this parameter is set to "error".
+//
 // - SetGzip: This value enables or disables gzip compression for Elasticsearch
 // requests (disabled by default). This option is used one to one for the library package.
 // See: http://godoc.org/gopkg.in/olivere/elastic.v5#SetGzip
@@ -61,12 +76,65 @@ import (
 // - StreamProperties/<streamName>/Index: The value defines the Elasticsearch index which used for the stream.
 //
 // - StreamProperties/<streamName>/Type: This value defines the document type which used for the stream.
+// The type is ignored by Elasticsearch 7 or later and by OpenSearch.
 //
 // - StreamProperties/<streamName>/DayBasedIndex: This value can be set to "true" to append the date of the message to the
-// index as in "<index>_YYYY-MM-DD".
-// NOTE: This setting need more performance because it is necessary to check if an index exist for each message!
+// index as in "<index>_YYYY-MM-DD". This is equivalent to setting IndexPattern to "daily".
 // By default this parameter is set to "false".
 //
+// - StreamProperties/<streamName>/IndexPattern: This value defines the date appended to the index name.
+// Valid values are "hourly" ("_YYYY-MM-DD-HH"), "daily" ("_YYYY-MM-DD"), "weekly" ("_YYYY-wWW", ISO week)
+// and "monthly" ("_YYYY-MM"). Any other value is used as a Go time layout, e.g. ".2006.01.02".
+// If Partition/Enable is set, the date is taken from the message's event time (see Partition/Field and
+// Partition/MetadataKey), falling back to the time the message was created. Otherwise the creation time is used. Indexes are created on first use, known indexes are cached.
+// By default this parameter is set to "".
+//
+// - StreamProperties/<streamName>/Template: This value defines the name of an index template pushed at startup.
+// The template applies Settings and Mapping to all indexes starting with the index name, so that indexes created
+// by elasticsearch, e.g. by rollover, use the same settings.
+// By default this parameter is set to "".
+//
+// - StreamProperties/<streamName>/Rollover/MaxSizeMB: If set, Index is used as a write alias and the index
+// behind it is replaced by a new index once it exceeds the given size. The first index is created as
+// "<index>-000001". This setting requires elasticsearch 6.1 or later and cannot be combined with IndexPattern.
+// By default this parameter is set to "0".
+//
+// - StreamProperties/<streamName>/Rollover/MaxAgeMin: If set, Index is used as a write alias and the index
+// behind it is replaced by a new index once it is older than the given number of minutes.
+// By default this parameter is set to "0".
+//
+// - StreamProperties/<streamName>/Rollover/MaxDocs: If set, Index is used as a write alias and the index
+// behind it is replaced by a new index once it holds the given number of documents.
+// By default this parameter is set to "0".
+//
+// - StreamProperties/<streamName>/Rollover/CheckIntervalSec: This value defines how often the producer asks
+// elasticsearch to check the rollover conditions. A roll (e.g. SIGHUP) rolls over all write aliases regardless
+// of their conditions.
+// By default this parameter is set to "60".
+//
+// - StreamProperties/<streamName>/Action: This value defines the bulk action used
+// for the stream's documents. Valid values are "index", "create", "update" and "delete".
+// "update" creates the document if it does not exist (upsert). "update" and "delete"
+// require a document id, messages without an id are sent to the fallback stream.
+// By default this parameter is set to "index".
+//
+// - StreamProperties/<streamName>/IDMetadataKey: This value defines the metadata key the
+// document id is read from. If not set or empty, IDField is used.
+// By default this parameter is set to "".
+//
+// - StreamProperties/<streamName>/IDField: This value defines the path of the JSON field
+// the document id is read from, e.g. "user/id". If no id is found, elasticsearch
+// generates one.
+// By default this parameter is set to "".
+//
+// - StreamProperties/<streamName>/RoutingMetadataKey: This value defines the metadata key
+// the routing key is read from. If not set or empty, RoutingField is used.
+// By default this parameter is set to "".
+//
+// - StreamProperties/<streamName>/RoutingField: This value defines the path of the JSON field
+// the routing key is read from.
+// By default this parameter is set to "".
+//
 // - StreamProperties/<streamName>/Mapping: This value is a map which used for the document field mapping.
 // As document type the already definded type is reused for the field mapping
 // See https://www.elastic.co/guide/en/elasticsearch/reference/5.4/indices-create-index.html#mappings
@@ -74,6 +142,11 @@ import (
 // - StreamProperties/<streamName>/Settings: This value is a map which is used for the index settings.
 // See https://www.elastic.co/guide/en/elasticsearch/reference/5.4/indices-create-index.html#mappings
 //
+// - Partition/Enable: If set to "true", event times are read from Partition/Field or Partition/MetadataKey and
+// messages older than Partition/LatenessMin are sent to the fallback stream. Partition/Timestamp and
+// Partition/MaxOpen are not used by this producer.
+// By default this parameter is set to "false".
+//
 // Examples
 //
 // This example starts a simple twitter example producer for local running ElasticSearch:
@@ -87,8 +160,10 @@ import (
 //    StreamProperties:
 //		tweets_stream:
 //			Index: twitter
-// 			DayBasedIndex: true
+// 			IndexPattern: weekly
 //			Type: tweet
+//			IDField: id_str
+//			Template: twitter
 //			Mapping:
 //				# index mapping for payload
 // 				user: keyword
@@ -99,23 +174,31 @@ import (
 //
 type ElasticSearch struct {
 	core.BatchedProducer `gollumdoc:"embed_type"`
+	Partition            components.TimePartitionConfig `gollumdoc:"embed_type"`
 	connection           elasticConnection
 	indexMap             map[core.MessageStreamID]*indexMapItem
+	errorKey             string `config:"ErrorMetadataKey" default:"error"`
+	retryCount           int
+	retryWait            time.Duration
+	indexCache           *elasticsearch.IndexCache
+	rolloverGuard        *sync.Mutex
 }
 
 type indexMapItem struct {
-	name        string
-	typeName    string
-	settings    *elasticIndex
-	useDayIndex bool
+	name         string
+	typeName     string
+	settings     *elasticIndex
+	pattern      elasticsearch.IndexPattern
+	templateName string
+	rollover     elasticsearch.Rollover
+	action       elasticsearch.Action
+	id           elasticsearch.DocumentKey
+	routing      elasticsearch.DocumentKey
 }
 
 func newIndexMapItem() *indexMapItem {
 	return &indexMapItem{
-		"",
-		"",
-		nil,
-		false,
+		action: elasticsearch.ActionIndex,
 	}
 }
 
@@ -177,6 +260,9 @@ func (prod *ElasticSearch) Configure(conf core.PluginConfigReader) {
 	prod.connection.password = conf.GetString("Password", "")
 	prod.connection.setGzip = conf.GetBool("SetGzip", false)
 	prod.connection.isConnectedStatus = false
+	prod.indexCache = elasticsearch.NewIndexCache(1024)
+	prod.rolloverGuard = new(sync.Mutex)
+	prod.SetRollCallback(prod.onRoll)
 
 	prod.configureIndexSettings(conf.GetMap("StreamProperties", tcontainer.NewMarshalMap()), conf.Errors)
 	prod.configureRetrySettings(conf.GetInt("Retry/Count", 3), conf.GetInt("Retry/TimeToWaitSec", 3))
@@ -192,6 +278,8 @@ func (prod *ElasticSearch) Produce(workers *sync.WaitGroup) {
 }
 
 func (prod *ElasticSearch) configureRetrySettings(retry, timeToWaitSec int64) {
+	prod.retryCount = int(retry)
+	prod.retryWait = time.Duration(timeToWaitSec) * time.Second
 	prod.connection.retrier.retry = int(retry)
 	prod.connection.retrier.backoff = elastic.NewConstantBackoff(time.Duration(timeToWaitSec) * time.Second)
 	prod.connection.retrier.logger = prod.Logger.WithField("Scope", "connection.retrier")
@@ -225,11 +313,29 @@ func (prod *ElasticSearch) configureIndexSettings(properties tcontainer.MarshalM
 			continue
 		}
 
-		dayBasedIndex, _ := property.Bool("daybasedindex")
-		if dayBasedIndex {
-			currentTime := time.Now()
-			indexName += currentTime.Format("_2006-01-02")
-			indexMapItem.useDayIndex = true
+		patternName, _ := property.String("indexpattern")
+		if dayBasedIndex, _ := property.Bool("daybasedindex"); dayBasedIndex && patternName == "" {
+			patternName = string(elasticsearch.IndexPatternDaily)
+		}
+		pattern, err := elasticsearch.ParseIndexPattern(patternName)
+		if err != nil {
+			errors.Pushf("Stream '%s': %s", streamName, err.Error())
+			continue
+		}
+		indexMapItem.pattern = pattern
+
+		indexMapItem.templateName, _ = property.String("template")
+		indexMapItem.rollover.MaxSizeMB, _ = property.Int("rollover/maxsizemb")
+		indexMapItem.rollover.MaxAgeMin, _ = property.Int("rollover/maxagemin")
+		indexMapItem.rollover.MaxDocs, _ = property.Int("rollover/maxdocs")
+		indexMapItem.rollover.CheckInterval = 60 * time.Second
+		if intervalSec, err := property.Int("rollover/checkintervalsec"); err == nil {
+			indexMapItem.rollover.CheckInterval = time.Duration(intervalSec) * time.Second
+		}
+
+		if indexMapItem.rollover.IsEnabled() && pattern.IsSet() {
+			errors.Pushf("Stream '%s': Rollover cannot be combined with IndexPattern or DayBasedIndex", streamName)
+			continue
 		}
 
 		typeName, err := property.String("type")
@@ -237,6 +343,24 @@ func (prod *ElasticSearch) configureIndexSettings(properties tcontainer.MarshalM
 			prod.Logger.Errorf("no data type configured for stream '%s'. Please check your config.", streamName)
 		}
 
+		actionName, _ := property.String("action")
+		action, err := elasticsearch.ParseAction(actionName)
+		if err != nil {
+			errors.Pushf("Stream '%s': %s", streamName, err.Error())
+			continue
+		}
+		indexMapItem.action = action
+
+		indexMapItem.id.MetadataKey, _ = property.String("idmetadatakey")
+		indexMapItem.id.Field, _ = property.String("idfield")
+		indexMapItem.routing.MetadataKey, _ = property.String("routingmetadatakey")
+		indexMapItem.routing.Field, _ = property.String("routingfield")
+
+		if action.RequiresID() && !indexMapItem.id.IsSet() {
+			errors.Pushf("Stream '%s': action '%s' requires IDField or IDMetadataKey to be set", streamName, action)
+			continue
+		}
+
 		indexMapItem.settings = newElasticIndex(property)
 		indexMapItem.typeName = typeName
 		indexMapItem.name = indexName
@@ -245,6 +369,12 @@ func (prod *ElasticSearch) configureIndexSettings(properties tcontainer.MarshalM
 	}
 }
 
+// getIndexName returns the name of the index (or write alias) a message
+// with the given event time is written to.
+func (item *indexMapItem) getIndexName(eventTime time.Time) string {
+	return item.pattern.Format(item.name, eventTime)
+}
+
 func (prod *ElasticSearch) getClient() (*elastic.Client, error) {
 	if !prod.connection.isConnected() {
 		err := prod.connection.connect()
@@ -259,11 +389,188 @@ func (prod *ElasticSearch) getClient() (*elastic.Client, error) {
 
 func (prod *ElasticSearch) initIndex() {
 	for _, indexMapItem := range prod.indexMap {
-		prod.createIndex(indexMapItem.name, indexMapItem.settings, indexExistsAuto)
+		if indexMapItem.templateName != "" {
+			prod.putTemplate(indexMapItem)
+		}
+
+		if indexMapItem.rollover.IsEnabled() {
+			prod.createWriteAlias(indexMapItem)
+			continue
+		}
+
+		indexName := indexMapItem.getIndexName(time.Now())
+		if err := prod.createIndex(indexName, indexMapItem, indexExistsAuto); err == nil {
+			prod.indexCache.Add(indexName)
+		}
+	}
+}
+
+// getTypelessMapping merges the properties of all configured types into
+// a single mapping as expected by servers without document types.
+func (item *indexMapItem) getTypelessMapping() elasticMapping {
+	merged := elasticMapping{
+		Properties: make(map[string]elasticType),
+	}
+	for _, mapping := range item.settings.Mappings {
+		for field, fieldType := range mapping.Properties {
+			merged.Properties[field] = fieldType
+		}
+	}
+	return merged
+}
+
+// getIndexBody returns the configured settings and mappings as used when
+// creating an index. Empty sections are left out.
+func (item *indexMapItem) getIndexBody(server elasticsearch.Server) map[string]interface{} {
+	body := make(map[string]interface{})
+	if len(item.settings.Settings) > 0 {
+		body["settings"] = item.settings.Settings
+	}
+
+	if !server.SupportsTypes() {
+		if mapping := item.getTypelessMapping(); len(mapping.Properties) > 0 {
+			body["mappings"] = mapping
+		}
+		return body
+	}
+
+	mappings := make(map[string]interface{})
+	for typeName, mapping := range item.settings.Mappings {
+		if len(mapping.Properties) > 0 {
+			mappings[typeName] = mapping
+		}
+	}
+	if len(mappings) > 0 {
+		body["mappings"] = mappings
+	}
+	return body
+}
+
+// getTemplate returns the settings and mappings of the given stream as
+// index template.
+func (item *indexMapItem) getTemplate(server elasticsearch.Server) map[string]interface{} {
+	template := item.getIndexBody(server)
+	template[server.TemplatePatternKey()] = elasticsearch.GetTemplatePattern(item.name, item.rollover.IsEnabled())
+	return template
+}
+
+func (prod *ElasticSearch) putTemplate(item *indexMapItem) error {
+	client, err := prod.getClient()
+	if err != nil {
+		return err
+	}
+
+	template := item.getTemplate(prod.connection.server)
+	_, err = client.IndexPutTemplate(item.templateName).BodyJson(template).Do(context.Background())
+	if err != nil {
+		prod.Logger.Errorf("Issue during creating index template '%s': %s", item.templateName, err)
+		return err
+	}
+
+	prod.Logger.Debugf("Created index template '%s'", item.templateName)
+	return nil
+}
+
+// createWriteAlias creates the first index of a rollover series unless the
+// write alias already exists.
+func (prod *ElasticSearch) createWriteAlias(item *indexMapItem) error {
+	client, err := prod.getClient()
+	if err != nil {
+		return err
 	}
+
+	if item.rollover.MaxSizeMB > 0 && !prod.connection.server.SupportsMaxSizeRollover() {
+		prod.Logger.Warningf("Rollover/MaxSizeMB of '%s' is not supported by %s and will be ignored", item.name, prod.connection.server)
+	}
+
+	ctx := context.Background()
+	exists, err := prod.isIndexExists(ctx, item.name, client)
+	if err != nil || exists {
+		return err
+	}
+
+	body := make(map[string]interface{})
+	if item.templateName == "" {
+		body = item.getIndexBody(prod.connection.server)
+	}
+	body["aliases"] = map[string]interface{}{item.name: map[string]interface{}{}}
+
+	indexName := elasticsearch.GetFirstIndexName(item.name)
+	if _, err := client.CreateIndex(indexName).BodyJson(body).Do(ctx); err != nil {
+		prod.Logger.Errorf("Issue during creating index '%s' for alias '%s': %s", indexName, item.name, err)
+		return err
+	}
+
+	prod.Logger.Debugf("Created index '%s' for write alias '%s'", indexName, item.name)
+	return nil
+}
+
+// rollover asks elasticsearch to replace the indexes behind all write
+// aliases with a new index. If force is false, only aliases with a due
+// check are rolled over and only if their conditions are met.
+func (prod *ElasticSearch) rollover(ctx context.Context, client *elastic.Client, force bool) {
+	prod.rolloverGuard.Lock()
+	defer prod.rolloverGuard.Unlock()
+
+	now := time.Now()
+	server := prod.connection.server
+	for _, item := range prod.indexMap {
+		if !item.rollover.IsEnabled() || (!item.rollover.IsDue(now) && !force) {
+			continue // ### continue, nothing to check ###
+		}
+
+		body := make(map[string]interface{})
+		if item.templateName == "" {
+			body = item.getIndexBody(server)
+		}
+		if !force {
+			conditions := item.rollover.Conditions()
+			if !server.SupportsMaxSizeRollover() {
+				delete(conditions, "max_size")
+			}
+			body["conditions"] = conditions
+		}
+
+		result, err := client.RolloverIndex(item.name).BodyJson(body).Do(ctx)
+		switch {
+		case err != nil:
+			prod.Logger.Errorf("Issue during rollover of '%s': %s", item.name, err)
+		case result.RolledOver:
+			prod.Logger.Infof("Rolled over '%s' from '%s' to '%s'", item.name, result.OldIndex, result.NewIndex)
+		}
+	}
+}
+
+// onRoll forces a rollover of all write aliases
+func (prod *ElasticSearch) onRoll() {
+	client, err := prod.getClient()
+	if err != nil {
+		return
+	}
+	prod.rollover(context.Background(), client, true)
+}
+
+// ensureIndex creates date based indexes on first use. Indexes known to
+// exist are cached, so elasticsearch is only asked once per index.
+func (prod *ElasticSearch) ensureIndex(ctx context.Context, client *elastic.Client, item *indexMapItem, indexName string) {
+	if prod.indexCache.Contains(indexName) {
+		return
+	}
+
+	exists, err := prod.isIndexExists(ctx, indexName, client)
+	if err != nil {
+		return // ### return, try again with the next message ###
+	}
+
+	if !exists {
+		if err := prod.createIndex(indexName, item, indexExistsFalse); err != nil {
+			return // ### return, try again with the next message ###
+		}
+	}
+	prod.indexCache.Add(indexName)
 }
 
-func (prod *ElasticSearch) createIndex(indexName string, elIndex *elasticIndex, indexExistsCheck indexExists) error {
+func (prod *ElasticSearch) createIndex(indexName string, item *indexMapItem, indexExistsCheck indexExists) error {
 	client, err := prod.getClient()
 	if err != nil {
 		return err
@@ -297,7 +604,24 @@ func (prod *ElasticSearch) createIndex(indexName string, elIndex *elasticIndex,
 		prod.Logger.Debugf("Created index '%s'\n", indexName)
 	}
 
-	for typeName, properties := range elIndex.Mappings {
+	if item.templateName != "" {
+		return nil // ### return, mappings are set by the template ###
+	}
+
+	if !prod.connection.server.SupportsTypes() {
+		mapping := item.getTypelessMapping()
+		if len(mapping.Properties) == 0 {
+			return nil // ### return, nothing to map ###
+		}
+		// The vendored client only supports typed mappings
+		_, err = client.PerformRequest(ctx, "PUT", "/"+url.PathEscape(indexName)+"/_mapping", nil, mapping)
+		if err != nil {
+			prod.Logger.Errorln("Issue during creating index mapping: ", err)
+		}
+		return err
+	}
+
+	for typeName, properties := range item.settings.Mappings {
 		mapping := client.PutMapping()
 		mapping.Index(indexName)
 		mapping.Type(typeName)
@@ -325,61 +649,143 @@ func (prod *ElasticSearch) isIndexExists(ctx context.Context, indexName string,
 	return exists, nil
 }
 
+// bulkItem links a message to the request sent for it
+type bulkItem struct {
+	msg     *core.Message
+	action  elasticsearch.Action
+	request *elasticsearch.BulkRequest
+	err     string
+}
+
+// newDocument returns the bulk document for the given message
+func (prod *ElasticSearch) newDocument(msg *core.Message, indexMapItem *indexMapItem, indexName string) elasticsearch.Document {
+	var payload tcontainer.MarshalMap // parsed on demand and shared by all keys
+	return elasticsearch.Document{
+		Action:  indexMapItem.action,
+		Index:   indexName,
+		Type:    indexMapItem.typeName,
+		ID:      indexMapItem.id.Get(msg, &payload),
+		Routing: indexMapItem.routing.Get(msg, &payload),
+	}
+}
+
+// fallbackWithError stores the reason of a failed document in the message
+// metadata and sends the message to the fallback stream.
+func (prod *ElasticSearch) fallbackWithError(msg *core.Message, reason string) {
+	msg.GetMetadata().SetValue(prod.errorKey, []byte(reason))
+	prod.TryFallback(msg)
+}
+
 func (prod *ElasticSearch) submitMessages() core.AssemblyFunc {
 	return func(messages []*core.Message) {
 		client, err := prod.getClient()
 		if err != nil {
 			prod.Logger.Error("Sending messages failed: ", err)
+			for _, msg := range messages {
+				prod.fallbackWithError(msg, err.Error())
+			}
 			return
 		}
 
-		bulkRequest := client.Bulk()
 		ctx := context.Background()
+		pending := make([]bulkItem, 0, len(messages))
 
 		for _, msg := range messages {
 			indexMapItem := prod.indexMap[msg.GetStreamID()]
-
-			if indexMapItem.useDayIndex {
-				// create daily index ...
-				indexExists, _ := prod.isIndexExists(ctx, indexMapItem.name, client)
-				if !indexExists {
-					prod.createIndex(indexMapItem.name, indexMapItem.settings, indexExistsFalse)
+			eventTime := msg.GetCreationTime()
+
+			if prod.Partition.Enabled {
+				eventTime = prod.Partition.GetEventTime(msg)
+				if prod.Partition.IsLate(eventTime) {
+					prod.Logger.Debug("Message for ", eventTime, " is too late")
+					prod.TryFallback(msg)
+					continue // ### continue, fallback ###
 				}
 			}
 
-			bulkIndexRequest := elastic.NewBulkIndexRequest()
-			bulkIndexRequest.Index(indexMapItem.name).
-				Type(indexMapItem.typeName).
-				Doc(msg.String())
+			indexName := indexMapItem.getIndexName(eventTime)
+			if indexMapItem.pattern.IsSet() {
+				prod.ensureIndex(ctx, client, indexMapItem, indexName)
+			}
 
-			bulkRequest.Add(bulkIndexRequest)
+			doc := prod.newDocument(msg, indexMapItem, indexName)
+			request, err := elasticsearch.NewBulkRequest(prod.connection.server, doc, msg.GetPayload())
+			if err != nil {
+				prod.Logger.Warning(err)
+				prod.fallbackWithError(msg, err.Error())
+				continue // ### continue, fallback ###
+			}
+
+			pending = append(pending, bulkItem{msg: msg, action: doc.Action, request: request})
 		}
 
-		// NumberOfActions contains the number of requests in a bulk
-		prod.Logger.Debugf("bulkRequest.NumberOfActions: %d", bulkRequest.NumberOfActions())
+		for retry := 0; len(pending) > 0; retry++ {
+			if retry > 0 {
+				prod.Logger.Debugf("Retrying %d documents rejected because of load", len(pending))
+				time.Sleep(prod.retryWait)
+			}
 
-		// Do sends the bulk requests to Elasticsearch
-		bulkResponse, err := bulkRequest.Do(ctx)
-		if err != nil {
-			prod.Logger.Error(err)
+			pending = prod.sendBulk(ctx, client, pending)
+			if retry >= prod.retryCount {
+				break // ### break, retries exceeded ###
+			}
 		}
 
-		// Bulk request actions get cleared
-		numberOfActionsAfter := bulkRequest.NumberOfActions()
-		if numberOfActionsAfter != 0 {
-			prod.Logger.Errorf("Could not send '%d' messages to Elasticsearch", numberOfActionsAfter)
+		if len(pending) > 0 {
+			prod.Logger.Errorf("Could not send '%d' messages to Elasticsearch", len(pending))
+			for _, item := range pending {
+				prod.fallbackWithError(item.msg, item.err)
+			}
 		}
 
-		if bulkResponse != nil {
-			// Indexed returns information abount indexed documents
-			indexed := bulkResponse.Indexed()
-			prod.Logger.Debugf("%d messages indexed successfully in Elasticsearch", len(indexed))
+		prod.rollover(ctx, client, false)
+	}
+}
+
+// sendBulk sends the given items as one bulk request. Rejected items are
+// sent to the fallback stream. Items that may succeed when being sent again
+// are returned.
+func (prod *ElasticSearch) sendBulk(ctx context.Context, client *elastic.Client, items []bulkItem) []bulkItem {
+	bulkRequest := client.Bulk()
+	for _, item := range items {
+		bulkRequest.Add(item.request)
+	}
+
+	// NumberOfActions contains the number of requests in a bulk
+	prod.Logger.Debugf("bulkRequest.NumberOfActions: %d", bulkRequest.NumberOfActions())
 
-			// Created returns information about created documents
-			created := bulkResponse.Created()
-			prod.Logger.Debugf("%d messages created successfully in Elasticsearch", len(created))
+	// Do sends the bulk requests to Elasticsearch
+	bulkResponse, err := bulkRequest.Do(ctx)
+	if err != nil {
+		prod.Logger.Error(err)
+		for _, item := range items {
+			prod.fallbackWithError(item.msg, err.Error())
 		}
+		return nil
 	}
+
+	retry := items[:0]
+	succeeded := 0
+	for idx, item := range items {
+		responseItem := elasticsearch.GetResponseItem(bulkResponse, idx)
+		switch elasticsearch.GetItemResult(item.action, responseItem) {
+		case elasticsearch.ItemSuccess:
+			item.msg.Release()
+			succeeded++
+
+		case elasticsearch.ItemRetry:
+			item.err = elasticsearch.GetItemError(responseItem)
+			retry = append(retry, item)
+
+		default:
+			reason := elasticsearch.GetItemError(responseItem)
+			prod.Logger.Warningf("Document rejected by Elasticsearch: %s", reason)
+			prod.fallbackWithError(item.msg, reason)
+		}
+	}
+
+	prod.Logger.Debugf("%d messages sent successfully to Elasticsearch", succeeded)
+	return retry
 }
 
 // -- elasticConnection --
@@ -390,6 +796,7 @@ type elasticConnection struct {
 	password          string
 	setGzip           bool
 	client            *elastic.Client
+	server            elasticsearch.Server
 	isConnectedStatus bool
 	retrier           retrier
 }
@@ -413,7 +820,14 @@ func (conn *elasticConnection) connect() error {
 		return err
 	}
 
+	server, err := elasticsearch.DetectServer(context.Background(), client)
+	if err != nil {
+		return err
+	}
+	conn.retrier.logger.Infof("Connected to %s", server)
+
 	conn.client = client
+	conn.server = server
 	conn.isConnectedStatus = true
 
 	return nil
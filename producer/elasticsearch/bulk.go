@@ -0,0 +1,233 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tcontainer"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// Action defines the bulk operation used for a document
+type Action string
+
+const (
+	// ActionIndex creates or replaces a document
+	ActionIndex = Action("index")
+	// ActionCreate creates a document and fails if it already exists
+	ActionCreate = Action("create")
+	// ActionUpdate updates a document or creates it if it does not exist
+	ActionUpdate = Action("update")
+	// ActionDelete removes a document
+	ActionDelete = Action("delete")
+)
+
+// ParseAction converts an action name into an Action. An empty name is
+// treated as ActionIndex.
+func ParseAction(name string) (Action, error) {
+	switch action := Action(strings.ToLower(name)); action {
+	case "":
+		return ActionIndex, nil
+	case ActionIndex, ActionCreate, ActionUpdate, ActionDelete:
+		return action, nil
+	default:
+		return ActionIndex, fmt.Errorf("Unknown bulk action '%s'", name)
+	}
+}
+
+// RequiresID returns true if the action cannot be executed without a
+// document id.
+func (action Action) RequiresID() bool {
+	return action == ActionUpdate || action == ActionDelete
+}
+
+// DocumentKey reads a value like a document id or a routing key from a
+// message. Metadata is checked before the JSON payload.
+type DocumentKey struct {
+	MetadataKey string
+	Field       string
+}
+
+// IsSet returns true if a source for the key has been configured
+func (key DocumentKey) IsSet() bool {
+	return key.MetadataKey != "" || key.Field != ""
+}
+
+// Get returns the key for the given message. The decoded payload is cached
+// in doc so that multiple keys can be read with a single parse.
+func (key DocumentKey) Get(msg *core.Message, doc *tcontainer.MarshalMap) string {
+	if key.MetadataKey != "" {
+		if value := msg.GetMetadata().GetValueString(key.MetadataKey); value != "" {
+			return value
+		}
+	}
+
+	if key.Field == "" {
+		return ""
+	}
+
+	if *doc == nil {
+		*doc = tcontainer.NewMarshalMap()
+		decoder := json.NewDecoder(bytes.NewReader(msg.GetPayload()))
+		decoder.UseNumber()
+		decoder.Decode(doc)
+	}
+
+	value, exists := doc.Value(key.Field)
+	if !exists || value == nil {
+		return ""
+	}
+
+	switch value := value.(type) {
+	case string:
+		return value
+	case json.Number, bool:
+		return fmt.Sprintf("%v", value)
+	default:
+		return ""
+	}
+}
+
+// Document holds all information required to send a message as part of a
+// bulk request.
+type Document struct {
+	Action  Action
+	Index   string
+	Type    string
+	ID      string
+	Routing string
+}
+
+// BulkRequest is an elastic.BulkableRequest for a single document
+type BulkRequest struct {
+	action Action
+	meta   map[string]string
+	body   string
+}
+
+// NewBulkRequest creates the bulk request for the given document and
+// payload. The document type is only sent if supported by the server. An
+// error is returned if the action requires an id but none is set.
+func NewBulkRequest(server Server, doc Document, payload []byte) (*BulkRequest, error) {
+	if doc.Action.RequiresID() && doc.ID == "" {
+		return nil, fmt.Errorf("Action '%s' requires a document id", doc.Action)
+	}
+
+	req := &BulkRequest{
+		action: doc.Action,
+		meta:   map[string]string{"_index": doc.Index},
+	}
+	if doc.Type != "" && server.SupportsTypes() {
+		req.meta["_type"] = doc.Type
+	}
+	if doc.ID != "" {
+		req.meta["_id"] = doc.ID
+	}
+	if doc.Routing != "" {
+		req.meta[server.RoutingParameter()] = doc.Routing
+	}
+
+	switch doc.Action {
+	case ActionUpdate:
+		req.body = `{"doc":` + string(payload) + `,"doc_as_upsert":true}`
+	case ActionDelete:
+		// no body
+	default:
+		req.body = string(payload)
+	}
+	return req, nil
+}
+
+// Source returns the action and metadata line followed by the optional body
+// line. This function is part of the elastic.BulkableRequest interface.
+func (req *BulkRequest) Source() ([]string, error) {
+	action, err := json.Marshal(map[Action]map[string]string{req.action: req.meta})
+	if err != nil {
+		return nil, err
+	}
+	if req.action == ActionDelete {
+		return []string{string(action)}, nil
+	}
+	return []string{string(action), req.body}, nil
+}
+
+// String returns the request as sent to the bulk API. This function is part
+// of the elastic.BulkableRequest interface.
+func (req *BulkRequest) String() string {
+	lines, err := req.Source()
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ItemResult classifies the result of a single bulk item
+type ItemResult int
+
+const (
+	// ItemSuccess is returned for successfully executed items
+	ItemSuccess = ItemResult(iota)
+	// ItemRetry is returned for items rejected because of load, i.e. items
+	// that may succeed when sent again
+	ItemRetry = ItemResult(iota)
+	// ItemRejected is returned for items that will not succeed when sent again
+	ItemRejected = ItemResult(iota)
+)
+
+// GetItemResult classifies a bulk response item. Deleting a document that
+// does not exist is treated as success.
+func GetItemResult(action Action, item *elastic.BulkResponseItem) ItemResult {
+	switch {
+	case item == nil:
+		return ItemRetry
+	case item.Status >= 200 && item.Status < 300:
+		return ItemSuccess
+	case action == ActionDelete && item.Status == http.StatusNotFound:
+		return ItemSuccess
+	case item.Status == http.StatusTooManyRequests || item.Status == http.StatusServiceUnavailable:
+		return ItemRetry
+	default:
+		return ItemRejected
+	}
+}
+
+// GetItemError returns a human readable error for a bulk response item
+func GetItemError(item *elastic.BulkResponseItem) string {
+	if item == nil {
+		return "no response"
+	}
+	if item.Error == nil {
+		return fmt.Sprintf("%d %s", item.Status, http.StatusText(item.Status))
+	}
+	return fmt.Sprintf("%d %s: %s", item.Status, item.Error.Type, item.Error.Reason)
+}
+
+// GetResponseItem returns the response item for the request at the given
+// position or nil if the response does not contain it.
+func GetResponseItem(response *elastic.BulkResponse, idx int) *elastic.BulkResponseItem {
+	if response == nil || idx >= len(response.Items) {
+		return nil
+	}
+	for _, item := range response.Items[idx] {
+		return item // ### return, each entry holds exactly one action ###
+	}
+	return nil
+}
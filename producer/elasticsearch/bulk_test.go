@@ -0,0 +1,124 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+	"testing"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tcontainer"
+	"github.com/trivago/tgo/ttesting"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+func TestParseAction(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	action, err := ParseAction("")
+	expect.NoError(err)
+	expect.Equal(ActionIndex, action)
+
+	action, err = ParseAction("Update")
+	expect.NoError(err)
+	expect.Equal(ActionUpdate, action)
+	expect.True(action.RequiresID())
+
+	_, err = ParseAction("upsert")
+	expect.NotNil(err)
+}
+
+func TestDocumentKey(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	metadata := core.Metadata{"id": []byte("meta")}
+	msg := core.NewMessage(nil, []byte(`{"user":{"id":42,"name":"test"}}`), metadata, core.InvalidStreamID)
+
+	var doc tcontainer.MarshalMap
+	key := DocumentKey{MetadataKey: "id", Field: "user/id"}
+	expect.Equal("meta", key.Get(msg, &doc))
+	expect.Nil(doc)
+
+	msg.GetMetadata().Delete("id")
+	expect.Equal("42", key.Get(msg, &doc))
+	expect.NotNil(doc)
+
+	key = DocumentKey{Field: "user/name"}
+	expect.Equal("test", key.Get(msg, &doc))
+
+	key = DocumentKey{Field: "user"}
+	expect.Equal("", key.Get(msg, &doc))
+}
+
+func TestNewBulkRequest(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	payload := []byte(`{"a":1}`)
+
+	req, err := NewBulkRequest(Server{}, Document{Action: ActionIndex, Index: "test"}, payload)
+	expect.NoError(err)
+	expect.Equal("{\"index\":{\"_index\":\"test\"}}\n{\"a\":1}", req.String())
+
+	req, err = NewBulkRequest(Server{}, Document{Action: ActionCreate, Index: "test", Type: "log", ID: "1", Routing: "r"}, payload)
+	expect.NoError(err)
+	expect.Equal("{\"create\":{\"_id\":\"1\",\"_index\":\"test\",\"_routing\":\"r\",\"_type\":\"log\"}}\n{\"a\":1}", req.String())
+
+	server := Server{Distribution: DistributionElasticsearch, Version: "7.17.0", Major: 7, Minor: 17}
+	req, err = NewBulkRequest(server, Document{Action: ActionCreate, Index: "test", Type: "log", ID: "1", Routing: "r"}, payload)
+	expect.NoError(err)
+	expect.Equal("{\"create\":{\"_id\":\"1\",\"_index\":\"test\",\"routing\":\"r\"}}\n{\"a\":1}", req.String())
+
+	req, err = NewBulkRequest(Server{}, Document{Action: ActionUpdate, Index: "test", ID: "1"}, payload)
+	expect.NoError(err)
+	expect.Equal("{\"update\":{\"_id\":\"1\",\"_index\":\"test\"}}\n{\"doc\":{\"a\":1},\"doc_as_upsert\":true}", req.String())
+
+	req, err = NewBulkRequest(Server{}, Document{Action: ActionDelete, Index: "test", ID: "1"}, payload)
+	expect.NoError(err)
+	lines, err := req.Source()
+	expect.NoError(err)
+	expect.Equal([]string{"{\"delete\":{\"_id\":\"1\",\"_index\":\"test\"}}"}, lines)
+
+	_, err = NewBulkRequest(Server{}, Document{Action: ActionDelete, Index: "test"}, payload)
+	expect.NotNil(err)
+}
+
+func TestGetItemResult(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	expect.Equal(ItemSuccess, GetItemResult(ActionCreate, &elastic.BulkResponseItem{Status: 201}))
+	expect.Equal(ItemSuccess, GetItemResult(ActionDelete, &elastic.BulkResponseItem{Status: 404}))
+	expect.Equal(ItemRejected, GetItemResult(ActionUpdate, &elastic.BulkResponseItem{Status: 404}))
+	expect.Equal(ItemRejected, GetItemResult(ActionIndex, &elastic.BulkResponseItem{Status: 400}))
+	expect.Equal(ItemRetry, GetItemResult(ActionIndex, &elastic.BulkResponseItem{Status: 429}))
+	expect.Equal(ItemRetry, GetItemResult(ActionIndex, &elastic.BulkResponseItem{Status: 503}))
+	expect.Equal(ItemRetry, GetItemResult(ActionIndex, nil))
+}
+
+func TestGetResponseItem(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	response := &elastic.BulkResponse{
+		Items: []map[string]*elastic.BulkResponseItem{
+			{"index": {Status: 201}},
+			{"index": {
+				Status: 400,
+				Error:  &elastic.ErrorDetails{Type: "mapper_parsing_exception", Reason: "failed to parse"},
+			}},
+		},
+	}
+
+	expect.Equal(201, GetResponseItem(response, 0).Status)
+	expect.Equal("400 mapper_parsing_exception: failed to parse", GetItemError(GetResponseItem(response, 1)))
+	expect.Nil(GetResponseItem(response, 2))
+	expect.Equal("no response", GetItemError(nil))
+}
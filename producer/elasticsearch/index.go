@@ -0,0 +1,181 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IndexPattern defines how the date of a message is appended to an index
+// name. An empty pattern leaves the index name unchanged.
+type IndexPattern string
+
+const (
+	// IndexPatternNone does not append a date
+	IndexPatternNone = IndexPattern("")
+	// IndexPatternHourly appends "_YYYY-MM-DD-HH"
+	IndexPatternHourly = IndexPattern("hourly")
+	// IndexPatternDaily appends "_YYYY-MM-DD"
+	IndexPatternDaily = IndexPattern("daily")
+	// IndexPatternWeekly appends the ISO week as in "_YYYY-wWW"
+	IndexPatternWeekly = IndexPattern("weekly")
+	// IndexPatternMonthly appends "_YYYY-MM"
+	IndexPatternMonthly = IndexPattern("monthly")
+)
+
+var indexPatternLayouts = map[IndexPattern]string{
+	IndexPatternHourly:  "_2006-01-02-15",
+	IndexPatternDaily:   "_2006-01-02",
+	IndexPatternMonthly: "_2006-01",
+}
+
+// ParseIndexPattern converts a pattern name into an IndexPattern. Besides
+// the named patterns any Go time layout containing a date or time reference
+// is accepted, e.g. ".2006.01.02".
+func ParseIndexPattern(name string) (IndexPattern, error) {
+	pattern := IndexPattern(strings.ToLower(name))
+	switch pattern {
+	case IndexPatternNone, IndexPatternHourly, IndexPatternDaily, IndexPatternWeekly, IndexPatternMonthly:
+		return pattern, nil
+	}
+
+	// A layout without references to date or time formats to itself
+	sample := time.Date(2001, 3, 4, 5, 6, 7, 0, time.UTC)
+	if sample.Format(name) == name {
+		return IndexPatternNone, fmt.Errorf("Unknown index pattern '%s'", name)
+	}
+	return IndexPattern(name), nil
+}
+
+// IsSet returns true if the pattern appends a date to the index name
+func (pattern IndexPattern) IsSet() bool {
+	return pattern != IndexPatternNone
+}
+
+// Format returns the index name for the given time
+func (pattern IndexPattern) Format(indexName string, eventTime time.Time) string {
+	switch pattern {
+	case IndexPatternNone:
+		return indexName
+	case IndexPatternWeekly:
+		year, week := eventTime.ISOWeek()
+		return fmt.Sprintf("%s_%04d-w%02d", indexName, year, week)
+	}
+
+	if layout, isNamed := indexPatternLayouts[pattern]; isNamed {
+		return indexName + eventTime.Format(layout)
+	}
+	return indexName + eventTime.Format(string(pattern))
+}
+
+// IndexCache remembers indexes known to exist so that date based index names
+// do not need to be checked for each message. The cache is cleared once it
+// holds more than the configured number of indexes.
+type IndexCache struct {
+	indexes map[string]struct{}
+	maxSize int
+	guard   sync.Mutex
+}
+
+// NewIndexCache creates a cache holding at most maxSize index names
+func NewIndexCache(maxSize int) *IndexCache {
+	return &IndexCache{
+		indexes: make(map[string]struct{}),
+		maxSize: maxSize,
+	}
+}
+
+// Contains returns true if the given index has been added before
+func (cache *IndexCache) Contains(indexName string) bool {
+	cache.guard.Lock()
+	defer cache.guard.Unlock()
+	_, exists := cache.indexes[indexName]
+	return exists
+}
+
+// Add marks the given index as existing
+func (cache *IndexCache) Add(indexName string) {
+	cache.guard.Lock()
+	defer cache.guard.Unlock()
+	if len(cache.indexes) >= cache.maxSize {
+		cache.indexes = make(map[string]struct{})
+	}
+	cache.indexes[indexName] = struct{}{}
+}
+
+// Remove forgets the given index, e.g. after it has been deleted
+func (cache *IndexCache) Remove(indexName string) {
+	cache.guard.Lock()
+	defer cache.guard.Unlock()
+	delete(cache.indexes, indexName)
+}
+
+// Rollover defines when the index behind a write alias is replaced by a new
+// index.
+type Rollover struct {
+	MaxSizeMB     int64
+	MaxAgeMin     int64
+	MaxDocs       int64
+	CheckInterval time.Duration
+	lastCheck     time.Time
+}
+
+// IsEnabled returns true if at least one rollover condition is set
+func (rollover *Rollover) IsEnabled() bool {
+	return rollover.MaxSizeMB > 0 || rollover.MaxAgeMin > 0 || rollover.MaxDocs > 0
+}
+
+// Conditions returns the conditions passed to the rollover API
+func (rollover *Rollover) Conditions() map[string]interface{} {
+	conditions := make(map[string]interface{})
+	if rollover.MaxSizeMB > 0 {
+		conditions["max_size"] = fmt.Sprintf("%dmb", rollover.MaxSizeMB)
+	}
+	if rollover.MaxAgeMin > 0 {
+		conditions["max_age"] = fmt.Sprintf("%dm", rollover.MaxAgeMin)
+	}
+	if rollover.MaxDocs > 0 {
+		conditions["max_docs"] = rollover.MaxDocs
+	}
+	return conditions
+}
+
+// IsDue returns true if the conditions should be checked again. The check
+// time is updated if true is returned.
+func (rollover *Rollover) IsDue(now time.Time) bool {
+	if now.Sub(rollover.lastCheck) < rollover.CheckInterval {
+		return false
+	}
+	rollover.lastCheck = now
+	return true
+}
+
+// GetFirstIndexName returns the name of the first index behind a write
+// alias. The numeric suffix is incremented by elasticsearch on rollover.
+func GetFirstIndexName(alias string) string {
+	return alias + "-000001"
+}
+
+// GetTemplatePattern returns the index pattern an index template is applied
+// to. Rollover indexes are matched by their numeric suffix.
+func GetTemplatePattern(indexName string, rollover bool) string {
+	if rollover {
+		return indexName + "-*"
+	}
+	return indexName + "*"
+}
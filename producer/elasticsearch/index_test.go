@@ -0,0 +1,91 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestIndexPattern(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	eventTime := time.Date(2021, 1, 3, 7, 30, 0, 0, time.UTC)
+
+	patterns := map[string]string{
+		"":            "logs",
+		"Hourly":      "logs_2021-01-03-07",
+		"daily":       "logs_2021-01-03",
+		"weekly":      "logs_2020-w53",
+		"monthly":     "logs_2021-01",
+		".2006.01.02": "logs.2021.01.03",
+	}
+
+	for name, expected := range patterns {
+		pattern, err := ParseIndexPattern(name)
+		expect.NoError(err)
+		expect.Equal(expected, pattern.Format("logs", eventTime))
+	}
+
+	_, err := ParseIndexPattern("yearly")
+	expect.NotNil(err)
+}
+
+func TestIndexCache(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	cache := NewIndexCache(2)
+
+	expect.False(cache.Contains("a"))
+	cache.Add("a")
+	cache.Add("b")
+	expect.True(cache.Contains("a"))
+	expect.True(cache.Contains("b"))
+
+	cache.Remove("b")
+	expect.False(cache.Contains("b"))
+
+	cache.Add("b")
+	cache.Add("c") // exceeds the size, clears the cache
+	expect.False(cache.Contains("a"))
+	expect.True(cache.Contains("c"))
+}
+
+func TestRollover(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	rollover := Rollover{CheckInterval: time.Minute}
+	expect.False(rollover.IsEnabled())
+	expect.Equal(0, len(rollover.Conditions()))
+
+	rollover.MaxSizeMB = 512
+	rollover.MaxAgeMin = 60
+	rollover.MaxDocs = 1000
+	expect.True(rollover.IsEnabled())
+
+	conditions := rollover.Conditions()
+	expect.Equal("512mb", conditions["max_size"])
+	expect.Equal("60m", conditions["max_age"])
+	expect.Equal(int64(1000), conditions["max_docs"])
+
+	now := time.Now()
+	expect.True(rollover.IsDue(now))
+	expect.False(rollover.IsDue(now.Add(time.Second)))
+	expect.True(rollover.IsDue(now.Add(time.Minute)))
+
+	expect.Equal("logs-000001", GetFirstIndexName("logs"))
+	expect.Equal("logs-*", GetTemplatePattern("logs", true))
+	expect.Equal("logs*", GetTemplatePattern("logs", false))
+}
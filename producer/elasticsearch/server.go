@@ -0,0 +1,138 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/olivere/elastic.v5"
+)
+
+const (
+	// DistributionElasticsearch is used for servers not reporting a distribution
+	DistributionElasticsearch = "elasticsearch"
+	// DistributionOpenSearch is reported by OpenSearch servers
+	DistributionOpenSearch = "opensearch"
+)
+
+// Server describes the distribution and version of a cluster. The zero value
+// describes an Elasticsearch 5 server, i.e. the API version used before
+// version detection was added.
+type Server struct {
+	Distribution string
+	Version      string
+	Major        int
+	Minor        int
+}
+
+// serverInfo is the part of the root endpoint's response we are interested in
+type serverInfo struct {
+	Version struct {
+		Number       string `json:"number"`
+		Distribution string `json:"distribution"`
+	} `json:"version"`
+}
+
+// ParseServer reads the server description from the response of the root
+// endpoint ("GET /").
+func ParseServer(response []byte) (Server, error) {
+	info := serverInfo{}
+	if err := json.Unmarshal(response, &info); err != nil {
+		return Server{}, err
+	}
+
+	server := Server{
+		Distribution: strings.ToLower(info.Version.Distribution),
+		Version:      info.Version.Number,
+	}
+	if server.Distribution == "" {
+		server.Distribution = DistributionElasticsearch
+	}
+
+	parts := strings.SplitN(server.Version, ".", 3)
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return Server{}, fmt.Errorf("Invalid server version '%s'", server.Version)
+	}
+	server.Major = major
+	if len(parts) > 1 {
+		server.Minor, _ = strconv.Atoi(parts[1])
+	}
+
+	return server, nil
+}
+
+// DetectServer asks the cluster for its distribution and version
+func DetectServer(ctx context.Context, client *elastic.Client) (Server, error) {
+	response, err := client.PerformRequest(ctx, "GET", "/", nil, nil)
+	if err != nil {
+		return Server{}, err
+	}
+	return ParseServer(response.Body)
+}
+
+// String returns distribution and version, e.g. "opensearch 2.11.0"
+func (server Server) String() string {
+	if server.Version == "" {
+		return "unknown"
+	}
+	return server.Distribution + " " + server.Version
+}
+
+// IsOpenSearch returns true for OpenSearch servers
+func (server Server) IsOpenSearch() bool {
+	return server.Distribution == DistributionOpenSearch
+}
+
+// isBefore returns true for Elasticsearch versions before major.minor
+func (server Server) isBefore(major, minor int) bool {
+	if server.IsOpenSearch() {
+		return false // OpenSearch forked from Elasticsearch 7.10
+	}
+	return server.Major < major || (server.Major == major && server.Minor < minor)
+}
+
+// SupportsTypes returns true if documents and mappings are expected to have a
+// type. Types have been removed with Elasticsearch 7.
+func (server Server) SupportsTypes() bool {
+	return server.isBefore(7, 0)
+}
+
+// RoutingParameter returns the name of the routing key in bulk requests
+func (server Server) RoutingParameter() string {
+	if server.isBefore(6, 0) {
+		return "_routing"
+	}
+	return "routing"
+}
+
+// TemplatePatternKey returns the name of the index pattern field in index
+// templates.
+func (server Server) TemplatePatternKey() string {
+	if server.isBefore(6, 0) {
+		return "template"
+	}
+	return "index_patterns"
+}
+
+// SupportsMaxSizeRollover returns true if the "max_size" rollover condition
+// is available.
+func (server Server) SupportsMaxSizeRollover() bool {
+	return !server.isBefore(6, 1)
+}
@@ -0,0 +1,148 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/trivago/tgo/ttesting"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// newServerStandIn mimics the root and bulk endpoints of the given server.
+// Like the real servers, typeless servers reject "_type" and "_routing".
+func newServerStandIn(distribution, version string, typeless bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/":
+			info := map[string]interface{}{"number": version}
+			if distribution != "" {
+				info["distribution"] = distribution
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"version": info})
+
+		case "/_bulk":
+			items := []map[string]interface{}{}
+			scanner := bufio.NewScanner(r.Body)
+			for scanner.Scan() {
+				action := map[string]map[string]string{}
+				if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+					continue // ### continue, document line ###
+				}
+				for name, meta := range action {
+					_, hasType := meta["_type"]
+					_, hasRouting := meta["_routing"]
+					if typeless && (hasType || hasRouting) {
+						w.WriteHeader(http.StatusBadRequest)
+						fmt.Fprint(w, `{"error":{"type":"illegal_argument_exception","reason":"Action/metadata line contains an unknown parameter"},"status":400}`)
+						return
+					}
+					items = append(items, map[string]interface{}{name: map[string]interface{}{"_index": meta["_index"], "status": 201}})
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"took": 1, "errors": false, "items": items})
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestParseServer(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	server, err := ParseServer([]byte(`{"version":{"number":"5.6.16"}}`))
+	expect.NoError(err)
+	expect.Equal(DistributionElasticsearch, server.Distribution)
+	expect.Equal(5, server.Major)
+	expect.Equal(6, server.Minor)
+	expect.True(server.SupportsTypes())
+	expect.Equal("_routing", server.RoutingParameter())
+	expect.Equal("template", server.TemplatePatternKey())
+	expect.False(server.SupportsMaxSizeRollover())
+
+	server, err = ParseServer([]byte(`{"version":{"number":"6.8.0"}}`))
+	expect.NoError(err)
+	expect.True(server.SupportsTypes())
+	expect.Equal("routing", server.RoutingParameter())
+	expect.Equal("index_patterns", server.TemplatePatternKey())
+	expect.True(server.SupportsMaxSizeRollover())
+
+	server, err = ParseServer([]byte(`{"version":{"number":"8.11.1","build_flavor":"default"}}`))
+	expect.NoError(err)
+	expect.False(server.SupportsTypes())
+	expect.Equal("elasticsearch 8.11.1", server.String())
+
+	server, err = ParseServer([]byte(`{"version":{"distribution":"opensearch","number":"1.3.0"}}`))
+	expect.NoError(err)
+	expect.True(server.IsOpenSearch())
+	expect.False(server.SupportsTypes())
+	expect.Equal("routing", server.RoutingParameter())
+	expect.True(server.SupportsMaxSizeRollover())
+
+	_, err = ParseServer([]byte(`{"version":{"number":""}}`))
+	expect.NotNil(err)
+
+	expect.Equal("unknown", Server{}.String())
+	expect.True(Server{}.SupportsTypes())
+}
+
+func TestServerBulk(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	versions := []struct {
+		distribution string
+		version      string
+		typeless     bool
+	}{
+		{"", "5.6.16", false},
+		{"", "7.17.9", true},
+		{"", "8.11.1", true},
+		{"opensearch", "1.3.0", true},
+		{"opensearch", "2.11.0", true},
+	}
+
+	for _, v := range versions {
+		standIn := newServerStandIn(v.distribution, v.version, v.typeless)
+
+		client, err := elastic.NewClient(elastic.SetURL(standIn.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+		expect.NoError(err)
+
+		ctx := context.Background()
+		server, err := DetectServer(ctx, client)
+		expect.NoError(err)
+		expect.Equal(v.version, server.Version)
+		expect.Equal(v.typeless, !server.SupportsTypes())
+
+		doc := Document{Action: ActionIndex, Index: "test", Type: "log", ID: "1", Routing: "user"}
+		request, err := NewBulkRequest(server, doc, []byte(`{"a":1}`))
+		expect.NoError(err)
+
+		response, err := client.Bulk().Add(request).Do(ctx)
+		if expect.NoError(err) {
+			expect.Equal(ItemSuccess, GetItemResult(ActionIndex, GetResponseItem(response, 0)))
+		}
+
+		standIn.Close()
+	}
+}
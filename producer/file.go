@@ -33,6 +33,9 @@ import (
 //
 // Each target file will handled with separated batch processing.
 //
+// Formatters requiring a header, like format.JSONToCSV with Header set, have
+// their header written to the beginning of every new or empty file.
+//
 // Parameters
 //
 // - File: This value contains the path to the log file to write. The wildcard character "*"
@@ -51,6 +54,10 @@ import (
 // permissions used when creating a folder.
 // By default this parameter is set to "0755".
 //
+// When partitioning is enabled (see Partition/Enable) each message is written
+// to a file named after the partition its event time belongs to, e.g.
+// "gollum_2017-07-14_02.log". Rotation settings still apply to each partition.
+//
 // Examples
 //
 // This example will write the messages from all streams to `/tmp/gollum.log`
@@ -66,6 +73,21 @@ import (
 //    	TimeoutSec: 60
 //      FlushTimeoutSec: 3
 //
+// This example writes events to hourly files based on the "time" field of
+// the payload, sending events older than a day to the "late" stream:
+//
+//  fileOut:
+//    Type: producer.File
+//    Streams: "*"
+//    File: /tmp/events.log
+//    FallbackStream: late
+//    TimeFormats: unixms
+//    Partition:
+//      Enable: true
+//      Field: time
+//      Timestamp: 2006-01-02_15
+//      LatenessMin: 1440
+//
 type File struct {
 	core.DirectProducer `gollumdoc:"embed_type"`
 
@@ -75,6 +97,7 @@ type File struct {
 	Rotate      components.RotateConfig        `gollumdoc:"embed_type"`
 	Pruner      file.Pruner                    `gollumdoc:"embed_type"`
 	BatchConfig components.BatchedWriterConfig `gollumdoc:"embed_type"`
+	Partition   components.TimePartitionConfig `gollumdoc:"embed_type"`
 
 	// configuration
 	overwriteFile     bool        `config:"FileOverwrite"`
@@ -89,6 +112,8 @@ type File struct {
 	fileExt          string
 	wildcardPath     bool
 	batchedFileGuard *sync.RWMutex
+	partitions       components.TimePartitionSet
+	header           []byte
 }
 
 func init() {
@@ -114,6 +139,8 @@ func (prod *File) Configure(conf core.PluginConfigReader) {
 	prod.fileName = prod.fileName[:len(prod.fileName)-len(prod.fileExt)]
 
 	prod.batchedFileGuard = new(sync.RWMutex)
+	prod.partitions = components.NewTimePartitionSet(prod.Partition.MaxOpen)
+	prod.header = prod.GetModulators().GetHeader()
 }
 
 // Produce writes to a buffer that is dumped to a file.
@@ -195,6 +222,90 @@ func (prod *File) getBatchedFile(streamID core.MessageStreamID, forceRotate bool
 	return batchedFile, err
 }
 
+func (prod *File) getPartitionedFile(streamID core.MessageStreamID, partition string) (*components.BatchedWriterAssembly, error) {
+	var err error
+	streamTargetFile := prod.newStreamTargetFile(streamID)
+	partitionKey := fmt.Sprintf("%s@%s", streamTargetFile.GetOriginalPath(), partition)
+
+	// fast path for open partitions that don't need to be rotated
+	prod.batchedFileGuard.RLock()
+	batchedFile, fileExists := prod.files[partitionKey]
+	if fileExists && prod.partitions.Refresh(partitionKey) {
+		if rotate, err := batchedFile.NeedsRotate(prod.Rotate, false); !rotate {
+			prod.batchedFileGuard.RUnlock()
+			return batchedFile, err // ### return, already open or error ###
+		}
+	}
+	prod.batchedFileGuard.RUnlock()
+
+	prod.batchedFileGuard.Lock()
+	defer prod.batchedFileGuard.Unlock()
+
+	if evicted := prod.partitions.Touch(partitionKey); evicted != "" {
+		prod.closePartition(evicted)
+	}
+
+	// check again to avoid race conditions
+	batchedFile, fileExists = prod.files[partitionKey]
+	if fileExists {
+		if rotate, err := batchedFile.NeedsRotate(prod.Rotate, false); !rotate {
+			return batchedFile, err // ### return, already open or error ###
+		}
+	} else {
+		batchedFile = components.NewBatchedWriterAssembly(
+			prod.BatchConfig,
+			prod,
+			prod.TryFallback,
+			prod.Logger,
+		)
+		prod.files[partitionKey] = batchedFile
+	}
+
+	// Assure directory is existing
+	if _, err = streamTargetFile.GetDir(); err != nil {
+		return nil, err // ### return, missing directory ###
+	}
+
+	// Append to the latest file of a partition unless it needs to be rotated
+	finalPath := streamTargetFile.GetPartitionPath(partition, batchedFile.HasWriter(), prod.Rotate)
+
+	if batchedFile.HasWriter() {
+		currentLog := batchedFile.GetWriterAndUnset()
+
+		prod.Logger.Info("Rotated ", currentLog.Name(), " -> ", finalPath)
+		go currentLog.Close() // close in subroutine for eventually compression in the background
+	}
+
+	fileWriter, err := prod.newFileStateWriterDisk(finalPath)
+	if err != nil {
+		return batchedFile, err // ### return error ###
+	}
+
+	batchedFile.SetWriter(fileWriter)
+
+	// Prune old logs if requested
+	go prod.Pruner.Prune(streamTargetFile.GetOriginalPath())
+
+	return batchedFile, err
+}
+
+// closePartition flushes and closes the files of a partition.
+// batchedFileGuard must be locked when calling this function.
+func (prod *File) closePartition(partitionKey string) {
+	batchedFile, fileExists := prod.files[partitionKey]
+	if !fileExists {
+		return // ### return, already closed ###
+	}
+
+	delete(prod.files, partitionKey)
+	prod.partitions.Remove(partitionKey)
+
+	if batchedFile.HasWriter() {
+		prod.Logger.Debug("Closing partition ", batchedFile.GetWriter().Name())
+		batchedFile.Close()
+	}
+}
+
 func (prod *File) createCurrentSymlink(source, target string) {
 	symLinkNameTemporary := fmt.Sprintf("%s.tmp", target)
 
@@ -215,6 +326,16 @@ func (prod *File) newFileStateWriterDisk(path string) (*file.BatchedFileWriter,
 		return nil, err // ### return error ###
 	}
 
+	// Files are started with the header of formatters like format.JSONToCSV
+	if len(prod.header) > 0 {
+		if stat, err := fileHandler.Stat(); err == nil && stat.Size() == 0 {
+			if _, err := fileHandler.Write(prod.header); err != nil {
+				fileHandler.Close()
+				return nil, err // ### return error ###
+			}
+		}
+	}
+
 	batchedFileWriter := file.NewBatchedFileWriter(fileHandler, prod.Rotate.Compress, prod.Logger)
 	return &batchedFileWriter, nil
 }
@@ -246,6 +367,16 @@ func (prod *File) newStreamTargetFile(streamID core.MessageStreamID) file.Target
 }
 
 func (prod *File) rotateLog() {
+	if prod.Partition.Enabled {
+		// Partitions are reopened with the next message
+		prod.batchedFileGuard.Lock()
+		for _, partitionKey := range prod.partitions.Partitions() {
+			prod.closePartition(partitionKey)
+		}
+		prod.batchedFileGuard.Unlock()
+		return
+	}
+
 	for streamID := range prod.filesByStream {
 		if _, err := prod.getBatchedFile(streamID, true); err != nil {
 			prod.Logger.Error("Rotate error: ", err)
@@ -254,13 +385,32 @@ func (prod *File) rotateLog() {
 }
 
 func (prod *File) writeBatchOnTimeOut() {
+	prod.batchedFileGuard.RLock()
+	defer prod.batchedFileGuard.RUnlock()
+
 	for _, batchedFile := range prod.files {
 		batchedFile.FlushOnTimeOut()
 	}
 }
 
 func (prod *File) writeMessage(msg *core.Message) {
-	batchedFile, err := prod.getBatchedFile(msg.GetStreamID(), false)
+	var (
+		batchedFile *components.BatchedWriterAssembly
+		err         error
+	)
+
+	if prod.Partition.Enabled {
+		partition, isLate := prod.Partition.GetPartition(msg)
+		if isLate {
+			prod.Logger.Debug("Message for partition ", partition, " is too late")
+			prod.TryFallback(msg)
+			return // ### return, fallback ###
+		}
+		batchedFile, err = prod.getPartitionedFile(msg.GetStreamID(), partition)
+	} else {
+		batchedFile, err = prod.getBatchedFile(msg.GetStreamID(), false)
+	}
+
 	if err != nil {
 		prod.Logger.Error("Write error: ", err)
 		prod.TryFallback(msg)
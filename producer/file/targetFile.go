@@ -56,46 +56,68 @@ func (streamFile *TargetFile) GetFinalPath(rotate components.RotateConfig) strin
 
 // GetFinalName returns the final file name with possible rotations
 func (streamFile *TargetFile) GetFinalName(rotate components.RotateConfig) string {
-	var logFileName string
-
 	// Generate the log filename based on rotation, existing files, etc.
 	if !rotate.Enabled {
-		logFileName = fmt.Sprintf("%s%s", streamFile.name, streamFile.ext)
-	} else {
-		timestamp := time.Now().Format(rotate.Timestamp)
-		signature := fmt.Sprintf("%s_%s", streamFile.name, timestamp)
-		maxSuffix := uint64(0)
-
-		files, _ := ioutil.ReadDir(streamFile.dir)
-		for _, f := range files {
-			if strings.HasPrefix(f.Name(), signature) {
-				// Special case.
-				// If there is no extension, counter stays at 0
-				// If there is an extension (and no count), parsing the "." will yield a counter of 0
-				// If there is a count, parsing it will work as intended
-				counter := uint64(0)
-				if len(f.Name()) > len(signature) {
-					counter, _ = tstrings.Btoi([]byte(f.Name()[len(signature)+1:]))
-				}
-
-				if maxSuffix <= counter {
-					maxSuffix = counter + 1
-				}
+		return fmt.Sprintf("%s%s", streamFile.name, streamFile.ext)
+	}
+
+	timestamp := time.Now().Format(rotate.Timestamp)
+	signature := fmt.Sprintf("%s_%s", streamFile.name, timestamp)
+	maxSuffix := streamFile.getMaxSuffix(signature)
+
+	return streamFile.getNameWithSuffix(signature, maxSuffix, rotate.ZeroPad)
+}
+
+// GetPartitionPath returns the file path for the given time partition. If
+// newFile is false, the most recent file of this partition is returned so that
+// data can be appended. Otherwise a new file with a numeric suffix is
+// returned.
+func (streamFile *TargetFile) GetPartitionPath(partition string, newFile bool, rotate components.RotateConfig) string {
+	signature := fmt.Sprintf("%s_%s", streamFile.name, partition)
+	suffix := streamFile.getMaxSuffix(signature)
+	if !newFile && suffix > 0 {
+		suffix--
+	}
+
+	return fmt.Sprintf("%s/%s", streamFile.dir, streamFile.getNameWithSuffix(signature, suffix, rotate.ZeroPad))
+}
+
+// getMaxSuffix returns the next free numeric suffix for files starting with
+// the given signature. If no such file exists, 0 is returned.
+func (streamFile *TargetFile) getMaxSuffix(signature string) uint64 {
+	maxSuffix := uint64(0)
+
+	files, _ := ioutil.ReadDir(streamFile.dir)
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), signature) {
+			// Special case.
+			// If there is no extension, counter stays at 0
+			// If there is an extension (and no count), parsing the "." will yield a counter of 0
+			// If there is a count, parsing it will work as intended
+			counter := uint64(0)
+			if len(f.Name()) > len(signature) {
+				counter, _ = tstrings.Btoi([]byte(f.Name()[len(signature)+1:]))
 			}
-		}
 
-		if maxSuffix == 0 {
-			logFileName = fmt.Sprintf("%s%s", signature, streamFile.ext)
-		} else {
-			formatString := "%s_%d%s"
-			if rotate.ZeroPad > 0 {
-				formatString = fmt.Sprintf("%%s_%%0%dd%%s", rotate.ZeroPad)
+			if maxSuffix <= counter {
+				maxSuffix = counter + 1
 			}
-			logFileName = fmt.Sprintf(formatString, signature, int(maxSuffix), streamFile.ext)
 		}
 	}
 
-	return logFileName
+	return maxSuffix
+}
+
+func (streamFile *TargetFile) getNameWithSuffix(signature string, suffix uint64, zeroPad int) string {
+	if suffix == 0 {
+		return fmt.Sprintf("%s%s", signature, streamFile.ext)
+	}
+
+	formatString := "%s_%d%s"
+	if zeroPad > 0 {
+		formatString = fmt.Sprintf("%%s_%%0%dd%%s", zeroPad)
+	}
+	return fmt.Sprintf(formatString, signature, int(suffix), streamFile.ext)
 }
 
 // GetDir create file directory if it not exists and returns the dir name
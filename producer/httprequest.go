@@ -71,6 +71,10 @@ import (
 // Encoding defines the payload encoding when RawData is set to false.
 // Set to "text/plain; charset=utf-8" by default.
 //
+// Requests are sent in parallel and may overtake each other. Set Ordering/Lanes
+// together with Ordering/MetadataKey or Ordering/Field to send requests with
+// the same key one after another in the order they were received.
+//
 type HTTPRequest struct {
 	core.BufferedProducer `gollumdoc:"embed_type"`
 
@@ -122,7 +126,7 @@ func (prod *HTTPRequest) healthcheckPingBackend() (int, string) {
 // Wrapper around the (*http.Response, error) values returned by HTTP clients.
 //
 // Reads the response body and code, returns (code int, body string err error).
-// If the query succeeded with HTTP 200, err == nil
+// If the query succeeded with a HTTP 2xx status, err == nil
 // If the query failed in some way, err contains a description of the error,
 // code and body are populated whenever possible.
 func httpRequestWrapper(resp *http.Response, err error) (int, string, error) {
@@ -140,7 +144,7 @@ func httpRequestWrapper(resp *http.Response, err error) (int, string, error) {
 	respBodyString := fmt.Sprintf("%s", respBody)
 
 	err = nil
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		err = fmt.Errorf("%d %s", resp.StatusCode, respBodyString)
 	}
 	return resp.StatusCode, respBodyString, err
@@ -184,7 +188,7 @@ func (prod *HTTPRequest) sendReq(msg *core.Message) {
 		return // ### return, malformed request ###
 	}
 
-	go func() {
+	send := func() {
 		_, _, err := httpRequestWrapper(http.DefaultClient.Do(req))
 		prod.lastError = err
 		if err != nil {
@@ -198,7 +202,14 @@ func (prod *HTTPRequest) sendReq(msg *core.Message) {
 		}
 		// Success
 		// TBD: health check? (ex-fuse breaker)
-	}()
+		msg.Release()
+	}
+
+	if prod.IsOrdered() {
+		send()
+	} else {
+		go send()
+	}
 }
 
 func (prod *HTTPRequest) close() {
@@ -209,5 +220,5 @@ func (prod *HTTPRequest) close() {
 // Produce writes to stdout or stderr.
 func (prod *HTTPRequest) Produce(workers *sync.WaitGroup) {
 	prod.AddMainWorker(workers)
-	prod.MessageControlLoop(prod.sendReq)
+	prod.OrderedMessageControlLoop(prod.sendReq)
 }
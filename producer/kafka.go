@@ -102,7 +102,8 @@ const (
 //
 // TimeoutMs denotes the maximum time the broker will wait for acks. This
 // setting becomes active when RequiredAcks is set to wait for multiple commits.
-// By default this is set to 10 seconds.
+// By default this is set to 10 seconds. TimoutMs is accepted as a deprecated
+// alias.
 //
 // SendRetries defines how many times to retry sending data before marking a
 // server as not reachable. By default this is set to 1.
@@ -325,7 +326,11 @@ func (prod *Kafka) Configure(conf core.PluginConfigReader) {
 
 	prod.config.Producer.MaxMessageBytes = int(conf.GetInt("Batch/SizeMaxKB", 1<<10)) << 10
 	prod.config.Producer.RequiredAcks = kafka.RequiredAcks(conf.GetInt("RequiredAcks", int64(kafka.WaitForLocal)))
-	prod.config.Producer.Timeout = time.Duration(conf.GetInt("TimoutMs", 10000)) * time.Millisecond
+	prod.config.Producer.Timeout = time.Duration(conf.GetInt("TimeoutMs", 10000)) * time.Millisecond
+	if conf.HasValue("TimoutMs") {
+		prod.Logger.Warning("TimoutMs is deprecated, use TimeoutMs")
+		prod.config.Producer.Timeout = time.Duration(conf.GetInt("TimoutMs", 10000)) * time.Millisecond
+	}
 	prod.config.Producer.Flush.Bytes = int(conf.GetInt("Batch/SizeByte", 8192))
 	prod.config.Producer.Flush.Messages = int(conf.GetInt("Batch/MinCount", 1))
 	prod.config.Producer.Flush.Frequency = time.Duration(conf.GetInt("Batch/TimeoutMs", 3000)) * time.Millisecond
@@ -389,21 +394,22 @@ func (prod *Kafka) pollResults() {
 		select {
 		case result, hasMore := <-prod.producer.Successes():
 			if hasMore {
-				if msg, hasMsg := result.Metadata.(core.Message); hasMsg {
-					prod.storeRTT(&msg)
+				if msg, hasMsg := result.Metadata.(*core.Message); hasMsg {
+					prod.storeRTT(msg)
+					msg.Release()
 				}
 			}
 
 		case err, hasMore := <-prod.producer.Errors():
 			if hasMore {
-				if msg, hasMsg := err.Msg.Metadata.(core.Message); hasMsg {
+				if msg, hasMsg := err.Msg.Metadata.(*core.Message); hasMsg {
 					prod.Logger.Warning("Kafka producer error on return: ", err)
-					prod.storeRTT(&msg)
+					prod.storeRTT(msg)
 					if err == kafka.ErrMessageTooLarge {
 						prod.Logger.Error("Message discarded as too large.")
-						core.CountMessageDiscarded()
+						core.DiscardMessage(msg)
 					} else {
-						prod.TryFallback(&msg)
+						prod.TryFallback(msg)
 					}
 				}
 			}
@@ -456,7 +462,7 @@ func (prod *Kafka) produceMessage(msg *core.Message) {
 	if !prod.nilValueAllowed && len(msg.GetPayload()) == 0 {
 		streamName := core.StreamRegistry.GetStreamName(msg.GetStreamID())
 		prod.Logger.Errorf("0 byte message detected on %s. Discarded", streamName)
-		core.CountMessageDiscarded()
+		core.DiscardMessage(msg)
 		return // ### return, invalid data ###
 	}
 
@@ -487,7 +493,7 @@ func (prod *Kafka) produceMessage(msg *core.Message) {
 	kafkaMsg := &kafka.ProducerMessage{
 		Topic:    topic.name,
 		Value:    kafka.ByteEncoder(msg.GetPayload()),
-		Metadata: &msg,
+		Metadata: msg,
 	}
 
 	kafkaKey := prod.getKafkaMsgKey(msg)
@@ -39,5 +39,5 @@ func init() {
 
 // Produce starts a control loop only
 func (prod *Null) Produce(threads *sync.WaitGroup) {
-	prod.MessageControlLoop(func(*core.Message) {})
+	prod.MessageControlLoop(func(msg *core.Message) { msg.Release() })
 }
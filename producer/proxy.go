@@ -180,6 +180,7 @@ func (prod *Proxy) sendMessage(msg *core.Message) {
 		prod.connection = nil
 		return // ### return, connection closed ###
 	}
+	defer msg.Release()
 
 	// Prepare responder function
 	enqueueResponse := tio.BufferReadCallback(nil)
@@ -18,6 +18,7 @@ import (
 	"github.com/go-redis/redis"
 	"github.com/trivago/gollum/core"
 	"github.com/trivago/tgo/tnet"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -26,50 +27,134 @@ import (
 
 // Redis producer plugin
 // This producer sends data to a redis server. Different redis storage types
-// and database indexes are supported. This producer does not implement support
-// for redis 3.0 cluster.
+// and database indexes are supported. Messages are collected in batches and
+// sent as pipelines, i.e. all commands of a batch are sent before reading the
+// replies. Single servers, redis sentinel and redis cluster are supported.
 // Configuration example
 //
 //  - "producer.Redis":
 //    Address: ":6379"
+//    Mode: "single"
+//    Servers: []
+//    Sentinel:
+//      MasterName: "mymaster"
 //    Database: 0
 //    Key: "default"
 //    Storage: "hash"
-//    KeyField: ""
+//    KeyFrom: ""
+//    FieldFrom: ""
+//    TTLSec: 0
+//    TTLFrom: ""
+//    Stream:
+//      MaxLen: 0
+//      Approximate: true
+//      PayloadField: "payload"
+//      Metadata: []
+//    Batch:
+//      MaxCount: 8192
+//      FlushCount: 4096
+//      TimeoutSec: 5
 //
 // Address stores the identifier to connect to.
 // This can either be any ip address and port like "localhost:6379" or a file
 // like "unix:///var/redis.socket". By default this is set to ":6379".
 //
-// Database defines the redis database to connect to.
-// By default this is set to 0.
+// Mode defines how to connect to redis. Valid values are "single", "sentinel"
+// and "cluster". When set to "sentinel", the current master is queried from
+// the sentinels listed in Servers. When set to "cluster", Servers contains a
+// list of cluster nodes used to discover the cluster. Batches are split by
+// the cluster node owning the key's hash slot and sent to all nodes in
+// parallel. By default this is set to "single".
+//
+// Servers defines a list of sentinel or cluster node addresses. If this list
+// is empty, Address is used. By default this is set to an empty list.
+//
+// Sentinel/MasterName defines the name of the master monitored by the
+// sentinels. By default this is set to "mymaster".
+//
+// Database defines the redis database to connect to. This setting is ignored
+// in cluster mode. By default this is set to 0.
 //
 // Key defines the redis key to store the values in.
-// This field is ignored when "KeyFormatter" is set.
+// This field is ignored when "KeyFrom" is set and the message carries a
+// non-empty value for that metadata field.
 // By default this is set to "default".
 //
 // Storage defines the type of the storage to use. Valid values are: "hash",
-// "list", "set", "sortedset", "string". By default this is set to "hash".
+// "list", "set", "sortedset", "string", "stream" and "publish".
+// "stream" appends an entry to a redis stream via XADD, "publish" sends the
+// message to the pub/sub channel named by the key.
+// By default this is set to "hash".
 //
 // KeyFrom defines the name of the metadata field used as a key for messages
-// sent to redis. If the name is an empty string no key is sent. By default
-// this value is set to an empty string.
+// sent to redis. If the name is an empty string or the metadata field is not
+// set, "Key" is used. By default this value is set to an empty string.
 //
 // FieldFrom defines the name of the metadata field used as a field for messages
 // sent to redis. If the name is an empty string no key is sent. By default
 // this value is set to an empty string.
+//
+// TTLSec defines the number of seconds after which a key expires. The expiry
+// is renewed with every message written to that key. If set to 0, keys do not
+// expire. This setting is ignored for "publish". By default this is set to 0.
+//
+// TTLFrom defines the name of the metadata field holding the number of seconds
+// after which the key expires. If the metadata field is not set or does not
+// contain a valid number, TTLSec is used. By default this is set to an empty
+// string.
+//
+// Stream/MaxLen trims the stream to the given number of entries when a new
+// entry is added. If set to 0 the stream is not trimmed.
+// By default this is set to 0.
+//
+// Stream/Approximate enables "MAXLEN ~" trimming, which is considerably
+// cheaper for redis but may keep a few more entries than configured.
+// By default this is set to true.
+//
+// Stream/PayloadField defines the name of the entry field holding the message
+// payload. By default this is set to "payload".
+//
+// Stream/Metadata defines a list of metadata fields that are added to each
+// stream entry as additional fields. Use "*" to add all metadata fields.
+// By default this is set to an empty list.
 type Redis struct {
-	core.BufferedProducer `gollumdoc:"embed_type"`
-	address               string
-	protocol              string
-	password              string `config:"Password"`
-	database              int    `config:"Database" default:"0"`
-	key                   string `config:"KeyFrom"`
-	field                 string `config:"FieldFrom"`
-	client                *redis.Client
-	store                 func(msg *core.Message)
+	core.BatchedProducer `gollumdoc:"embed_type"`
+	address              string
+	protocol             string
+	mode                 string        `config:"Mode" default:"single"`
+	servers              []string      `config:"Servers"`
+	masterName           string        `config:"Sentinel/MasterName" default:"mymaster"`
+	password             string        `config:"Password"`
+	database             int           `config:"Database" default:"0"`
+	key                  string        `config:"KeyFrom"`
+	defaultKey           string        `config:"Key" default:"default"`
+	field                string        `config:"FieldFrom"`
+	ttl                  time.Duration `config:"TTLSec" default:"0" metric:"sec"`
+	ttlFrom              string        `config:"TTLFrom"`
+	streamMaxLen         int64         `config:"Stream/MaxLen" default:"0"`
+	streamApproximate    bool          `config:"Stream/Approximate" default:"true"`
+	streamPayloadField   string        `config:"Stream/PayloadField" default:"payload"`
+	streamMetadata       []string      `config:"Stream/Metadata"`
+	client               redis.UniversalClient
+	slots                redisSlots
+	slotsGuard           *sync.RWMutex
+	store                redisStoreFunc
+	expires              bool
 }
 
+// redisStoreFunc adds the command(s) storing a message to a pipeline
+type redisStoreFunc func(pipe *redis.Pipeline, key string, msg *core.Message) (redis.Cmder, error)
+
+// redisSlots maps cluster hash slots to the address of the owning master
+type redisSlots []redis.ClusterSlot
+
+const (
+	redisModeSingle   = "single"
+	redisModeSentinel = "sentinel"
+	redisModeCluster  = "cluster"
+	redisSlotCount    = 16384
+)
+
 func init() {
 	core.TypeRegistry.Register(Redis{})
 }
@@ -77,8 +162,20 @@ func init() {
 // Configure initializes this producer with values from a plugin config.
 func (prod *Redis) Configure(conf core.PluginConfigReader) {
 	prod.SetStopCallback(prod.close)
+	prod.slotsGuard = new(sync.RWMutex)
+	prod.expires = true
 
 	prod.protocol, prod.address = tnet.ParseAddress(conf.GetString("Address", ":6379"), "tcp")
+	if len(prod.servers) == 0 {
+		prod.servers = []string{prod.address}
+	}
+
+	prod.mode = strings.ToLower(prod.mode)
+	switch prod.mode {
+	case redisModeSingle, redisModeSentinel, redisModeCluster:
+	default:
+		conf.Errors.Pushf("Unknown mode '%s'", prod.mode)
+	}
 
 	switch strings.ToLower(conf.GetString("Storage", "hash")) {
 	case "hash":
@@ -89,6 +186,11 @@ func (prod *Redis) Configure(conf core.PluginConfigReader) {
 		prod.store = prod.storeSet
 	case "sortedset":
 		prod.store = prod.storeSortedSet
+	case "stream":
+		prod.store = prod.storeStream
+	case "publish":
+		prod.store = prod.storePublish
+		prod.expires = false
 	default:
 		fallthrough
 	case "string":
@@ -96,98 +198,279 @@ func (prod *Redis) Configure(conf core.PluginConfigReader) {
 	}
 }
 
-func (prod *Redis) getValueAndKey(msg *core.Message) (v, k []byte) {
-	meta := msg.GetMetadata()
-	key := meta.GetValue(prod.key)
+func (prod *Redis) getKey(meta core.Metadata) string {
+	if prod.key != "" {
+		if key := meta.GetValue(prod.key); len(key) > 0 {
+			return string(key)
+		}
+	}
+	return prod.defaultKey
+}
 
-	return msg.GetPayload(), key
+func (prod *Redis) getTTL(meta core.Metadata) time.Duration {
+	if prod.ttlFrom != "" {
+		if ttl, err := strconv.ParseInt(meta.GetValueString(prod.ttlFrom), 10, 64); err == nil && ttl > 0 {
+			return time.Duration(ttl) * time.Second
+		}
+	}
+	return prod.ttl
 }
 
-func (prod *Redis) getValueFieldAndKey(msg *core.Message) (v, f, k []byte) {
-	meta := msg.GetMetadata()
-	key := meta.GetValue(prod.key)
-	field := meta.GetValue(prod.field)
+func (prod *Redis) storeHash(pipe *redis.Pipeline, key string, msg *core.Message) (redis.Cmder, error) {
+	field := msg.GetMetadata().GetValueString(prod.field)
+	return pipe.HSet(key, field, string(msg.GetPayload())), nil
+}
 
-	return msg.GetPayload(), field, key
+func (prod *Redis) storeList(pipe *redis.Pipeline, key string, msg *core.Message) (redis.Cmder, error) {
+	return pipe.RPush(key, string(msg.GetPayload())), nil
 }
 
-func (prod *Redis) storeHash(msg *core.Message) {
-	value, field, key := prod.getValueFieldAndKey(msg)
-	result := prod.client.HSet(string(key), string(field), string(value))
-	if result.Err() != nil {
-		prod.Logger.Error("Redis: ", result.Err())
-		prod.TryFallback(msg)
+func (prod *Redis) storeSet(pipe *redis.Pipeline, key string, msg *core.Message) (redis.Cmder, error) {
+	return pipe.SAdd(key, string(msg.GetPayload())), nil
+}
+
+func (prod *Redis) storeSortedSet(pipe *redis.Pipeline, key string, msg *core.Message) (redis.Cmder, error) {
+	score, err := strconv.ParseFloat(msg.GetMetadata().GetValueString(prod.field), 64)
+	if err != nil {
+		return nil, err // ### return, no valid score ###
+	}
+
+	return pipe.ZAdd(key,
+		redis.Z{
+			Score:  score,
+			Member: string(msg.GetPayload()),
+		}), nil
+}
+
+func (prod *Redis) storeString(pipe *redis.Pipeline, key string, msg *core.Message) (redis.Cmder, error) {
+	return pipe.Set(key, string(msg.GetPayload()), time.Duration(0)), nil
+}
+
+// getStreamArgs builds the XADD command for the given message. Metadata fields
+// are added in a stable order so that entries are comparable.
+func (prod *Redis) getStreamArgs(key string, msg *core.Message) []interface{} {
+	meta := msg.GetMetadata()
+	args := []interface{}{"XADD", key}
+
+	if prod.streamMaxLen > 0 {
+		if prod.streamApproximate {
+			args = append(args, "MAXLEN", "~", prod.streamMaxLen)
+		} else {
+			args = append(args, "MAXLEN", prod.streamMaxLen)
+		}
+	}
+	args = append(args, "*", prod.streamPayloadField, string(msg.GetPayload()))
+
+	fields := prod.streamMetadata
+	if len(fields) == 1 && fields[0] == "*" {
+		fields = make([]string, 0, len(meta))
+		for field := range meta {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
 	}
+
+	for _, field := range fields {
+		if value, isSet := meta[field]; isSet && field != prod.streamPayloadField {
+			args = append(args, field, string(value))
+		}
+	}
+
+	return args
 }
 
-func (prod *Redis) storeList(msg *core.Message) {
-	value, key := prod.getValueAndKey(msg)
+func (prod *Redis) storeStream(pipe *redis.Pipeline, key string, msg *core.Message) (redis.Cmder, error) {
+	cmd := redis.NewCmd(prod.getStreamArgs(key, msg)...)
+	return cmd, pipe.Process(cmd)
+}
+
+func (prod *Redis) storePublish(pipe *redis.Pipeline, key string, msg *core.Message) (redis.Cmder, error) {
+	return pipe.Publish(key, string(msg.GetPayload())), nil
+}
 
-	result := prod.client.RPush(string(key), string(value))
-	if result.Err() != nil {
-		prod.Logger.Error("Redis: ", result.Err())
-		prod.TryFallback(msg)
+// sendPipeline sends all messages in one pipeline. Messages whose commands
+// failed are passed to the fallback. Returns false if any command failed.
+func (prod *Redis) sendPipeline(messages []*core.Message) bool {
+	pipe := prod.client.Pipeline()
+	defer pipe.Close()
+
+	queued := make([]*core.Message, 0, len(messages))
+	commands := make([][]redis.Cmder, 0, len(messages))
+
+	for _, msg := range messages {
+		key := prod.getKey(msg.GetMetadata())
+		cmd, err := prod.store(pipe, key, msg)
+		if err != nil {
+			prod.Logger.Error("Redis: ", err)
+			prod.TryFallback(msg)
+			continue // ### continue, message cannot be stored ###
+		}
+
+		msgCommands := []redis.Cmder{cmd}
+		if ttl := prod.getTTL(msg.GetMetadata()); ttl > 0 && prod.expires {
+			msgCommands = append(msgCommands, pipe.Expire(key, ttl))
+		}
+
+		queued = append(queued, msg)
+		commands = append(commands, msgCommands)
 	}
+
+	if len(queued) == 0 {
+		return true // ### return, nothing to send ###
+	}
+
+	success := true
+	if _, err := pipe.Exec(); err != nil {
+		prod.Logger.Error("Redis: ", err)
+		success = false
+	}
+
+	for i, msg := range queued {
+		delivered := true
+		for _, cmd := range commands[i] {
+			if cmd.Err() != nil {
+				prod.TryFallback(msg)
+				delivered = false
+				break
+			}
+		}
+		if delivered {
+			msg.Release()
+		}
+	}
+	return success
 }
 
-func (prod *Redis) storeSet(msg *core.Message) {
-	value, key := prod.getValueAndKey(msg)
+// sendMessages sends a batch of messages. In cluster mode the batch is split
+// by cluster node so that each node receives one pipeline.
+func (prod *Redis) sendMessages(messages []*core.Message) {
+	if prod.mode != redisModeCluster {
+		prod.sendPipeline(messages)
+		return // ### return, single node ###
+	}
+
+	prod.slotsGuard.RLock()
+	nodes := make(map[string][]*core.Message)
+	for _, msg := range messages {
+		slot := getRedisKeySlot(prod.getKey(msg.GetMetadata()))
+		node := prod.slots.getNode(slot)
+		nodes[node] = append(nodes[node], msg)
+	}
+	prod.slotsGuard.RUnlock()
+
+	success := true
+	results := make(chan bool, len(nodes))
+	for _, nodeMessages := range nodes {
+		go func(nodeMessages []*core.Message) {
+			results <- prod.sendPipeline(nodeMessages)
+		}(nodeMessages)
+	}
+	for range nodes {
+		success = <-results && success
+	}
 
-	result := prod.client.SAdd(string(key), string(value))
-	if result.Err() != nil {
-		prod.Logger.Error("Redis: ", result.Err())
-		prod.TryFallback(msg)
+	// Errors might be caused by slots having moved
+	if !success {
+		prod.updateSlots()
 	}
 }
 
-func (prod *Redis) storeSortedSet(msg *core.Message) {
-	value, scoreValue, key := prod.getValueFieldAndKey(msg)
-	score, err := strconv.ParseFloat(string(scoreValue), 64)
+func (prod *Redis) sendBatch() core.AssemblyFunc {
+	return prod.sendMessages
+}
+
+// updateSlots fetches the current slot distribution from the cluster.
+func (prod *Redis) updateSlots() {
+	slots, err := prod.client.ClusterSlots().Result()
 	if err != nil {
-		prod.Logger.Error("Redis: ", err)
-		return // ### return, no valid score ###
+		prod.Logger.Error("Redis: failed to get cluster slots: ", err)
+		return
 	}
 
-	result := prod.client.ZAdd(string(key),
-		redis.Z{
-			Score:  score,
-			Member: string(value),
-		})
+	prod.slotsGuard.Lock()
+	prod.slots = redisSlots(slots)
+	prod.slotsGuard.Unlock()
+}
 
-	if result.Err() != nil {
-		prod.Logger.Error("Redis: ", result.Err())
-		prod.TryFallback(msg)
+// getNode returns the address of the master owning the given slot or an
+// empty string if the slot is not known.
+func (slots redisSlots) getNode(slot int) string {
+	for _, slotRange := range slots {
+		if slot >= slotRange.Start && slot <= slotRange.End && len(slotRange.Nodes) > 0 {
+			return slotRange.Nodes[0].Addr
+		}
 	}
+	return ""
 }
 
-func (prod *Redis) storeString(msg *core.Message) {
-	value, key := prod.getValueAndKey(msg)
+// getRedisKeySlot returns the cluster hash slot of a key. If the key contains
+// a hash tag, i.e. a non-empty string in curly braces, only the hash tag is
+// used to calculate the slot.
+func getRedisKeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
 
-	result := prod.client.Set(string(key), string(value), time.Duration(0))
-	if result.Err() != nil {
-		prod.Logger.Error("Redis: ", result.Err())
-		prod.TryFallback(msg)
+	// CRC16-CCITT (XMODEM) as used by redis cluster
+	crc := uint16(0)
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return int(crc) % redisSlotCount
+}
+
+func (prod *Redis) newClient() redis.UniversalClient {
+	switch prod.mode {
+	case redisModeSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    prod.masterName,
+			SentinelAddrs: prod.servers,
+			Password:      prod.password,
+			DB:            prod.database,
+		})
+
+	case redisModeCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    prod.servers,
+			Password: prod.password,
+		})
+
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:     prod.address,
+			Network:  prod.protocol,
+			Password: prod.password,
+			DB:       prod.database,
+		})
 	}
 }
 
 func (prod *Redis) close() {
-	defer prod.WorkerDone()
 	prod.DefaultClose()
+	if prod.client != nil {
+		prod.client.Close()
+	}
 }
 
-// Produce writes to stdout or stderr.
+// Produce writes to redis.
 func (prod *Redis) Produce(workers *sync.WaitGroup) {
-	prod.client = redis.NewClient(&redis.Options{
-		Addr:     prod.address,
-		Network:  prod.protocol,
-		Password: prod.password,
-		DB:       prod.database,
-	})
+	prod.client = prod.newClient()
 
 	if _, err := prod.client.Ping().Result(); err != nil {
 		prod.Logger.Error("Redis: ", err)
 	}
+	if prod.mode == redisModeCluster {
+		prod.updateSlots()
+	}
 
-	prod.AddMainWorker(workers)
-	prod.MessageControlLoop(prod.store)
+	prod.BatchMessageLoop(workers, prod.sendBatch)
 }
@@ -0,0 +1,83 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package producer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestRedisStreamArgs(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("redisStream", "producer.Redis")
+	config.Override("Storage", "stream")
+	config.Override("KeyFrom", "stream")
+	config.Override("Stream/MaxLen", 1000)
+	config.Override("Stream/Metadata", []string{"*"})
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	prod := plugin.(*Redis)
+
+	metadata := core.Metadata{"stream": []byte("events"), "host": []byte("web1")}
+	msg := core.NewMessage(nil, []byte("data"), metadata, core.InvalidStreamID)
+	expect.Equal([]interface{}{"XADD", "events", "MAXLEN", "~", int64(1000), "*", "payload", "data", "host", "web1", "stream", "events"},
+		prod.getStreamArgs(prod.getKey(msg.GetMetadata()), msg))
+
+	prod.streamApproximate = false
+	prod.streamMetadata = []string{"host", "missing"}
+	msg.GetMetadata().Delete("stream")
+	expect.Equal([]interface{}{"XADD", "default", "MAXLEN", int64(1000), "*", "payload", "data", "host", "web1"},
+		prod.getStreamArgs(prod.getKey(msg.GetMetadata()), msg))
+}
+
+func TestRedisTTL(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("redisTTL", "producer.Redis")
+	config.Override("TTLSec", 60)
+	config.Override("TTLFrom", "ttl")
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	prod := plugin.(*Redis)
+
+	expect.Equal(60*time.Second, prod.getTTL(core.Metadata{}))
+	expect.Equal(5*time.Second, prod.getTTL(core.Metadata{"ttl": []byte("5")}))
+	expect.Equal(60*time.Second, prod.getTTL(core.Metadata{"ttl": []byte("soon")}))
+}
+
+func TestRedisKeySlot(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	expect.Equal(12182, getRedisKeySlot("foo"))
+	expect.Equal(5061, getRedisKeySlot("bar"))
+	expect.Equal(12739, getRedisKeySlot("123456789"))
+	expect.Equal(getRedisKeySlot("user1000"), getRedisKeySlot("{user1000}.following"))
+	expect.Equal(getRedisKeySlot("{user1000}.following"), getRedisKeySlot("{user1000}.followers"))
+
+	slots := redisSlots{
+		{Start: 0, End: 8191, Nodes: []redis.ClusterNode{{Addr: "10.0.0.1:6379"}}},
+		{Start: 8192, End: 16383, Nodes: []redis.ClusterNode{{Addr: "10.0.0.2:6379"}}},
+	}
+	expect.Equal("10.0.0.2:6379", slots.getNode(getRedisKeySlot("foo")))
+	expect.Equal("10.0.0.1:6379", slots.getNode(getRedisKeySlot("bar")))
+	expect.Equal("", redisSlots{}.getNode(0))
+}
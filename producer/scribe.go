@@ -227,6 +227,9 @@ func (prod *Scribe) transformMessages(messages []*core.Message) {
 		resultCode, err := prod.scribe.Log(logBuffer[idxStart:idxEnd])
 
 		if resultCode == scribe.ResultCode_OK {
+			for _, msg := range messages[idxStart:idxEnd] {
+				msg.Release()
+			}
 			idxStart = idxEnd
 			if idxStart < len(logBuffer) {
 				retryCount = -1 // incremented to 0 after continue
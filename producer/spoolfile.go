@@ -15,25 +15,20 @@
 package producer
 
 import (
-	"encoding/base64"
-	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
-	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/trivago/gollum/core"
+	"github.com/trivago/gollum/producer/spooling"
 	"github.com/trivago/tgo"
-	"github.com/trivago/tgo/tio"
-	"github.com/trivago/tgo/tmath"
-	"github.com/trivago/tgo/tstrings"
 )
 
 type spoolFile struct {
 	file        *os.File
+	fileInfo    spooling.FileInfo
 	batch       core.MessageBatch
 	assembly    core.WriterAssembly
 	fileCreated time.Time
@@ -45,12 +40,8 @@ type spoolFile struct {
 	writeCount  int64
 	readWorker  *sync.WaitGroup
 	roll        chan struct{}
-	reader      *tio.BufferedReader
 }
 
-const maxSpoolFileNumber = 99999999 // maximum file number defined by %08d -> 8 digits
-const spoolFileFormatString = "%s/%08d.spl"
-
 func newSpoolFile(prod *Spooling, streamName string, source core.MessageSource) *spoolFile {
 	spool := &spoolFile{
 		file:        nil,
@@ -62,7 +53,6 @@ func newSpoolFile(prod *Spooling, streamName string, source core.MessageSource)
 		prod:        prod,
 		source:      source,
 		readWorker:  &sync.WaitGroup{},
-		reader:      tio.NewBufferedReader(prod.bufferSizeByte, tio.BufferedReaderFlagDelimiter, 0, "\n"),
 		roll:        make(chan struct{}, 1),
 	}
 
@@ -78,7 +68,10 @@ func newSpoolFile(prod *Spooling, streamName string, source core.MessageSource)
 }
 
 func (spool *spoolFile) triggerRoll() {
-	spool.roll <- struct{}{}
+	select {
+	case spool.roll <- struct{}{}:
+	default: // roll already pending
+	}
 }
 
 func (spool *spoolFile) flush() {
@@ -106,17 +99,7 @@ func (spool *spoolFile) countWrite() {
 }
 
 func (spool *spoolFile) getFileNumbering() (min int, max int) {
-	min, max = maxSpoolFileNumber+1, 0
-	files, _ := ioutil.ReadDir(spool.basePath)
-	for _, file := range files {
-		if filepath.Ext(file.Name()) == ".spl" {
-			base := filepath.Base(file.Name())
-			number, _ := tstrings.Btoi([]byte(base)) // Because we need leading zero support
-			min = tmath.MinI(min, int(number))
-			max = tmath.MaxI(max, int(number))
-		}
-	}
-	return min, max
+	return spooling.GetFileNumbering(spool.basePath)
 }
 
 func (spool *spoolFile) openOrRotate(force bool) bool {
@@ -129,19 +112,36 @@ func (spool *spoolFile) openOrRotate(force bool) bool {
 				return err // ### return, filestat error ###
 			}
 			fileSize = fileInfo.Size()
+
+			if fileSize != spool.fileInfo.Size {
+				spool.fileInfo.Size = fileSize
+				spool.fileInfo.Modified = fileInfo.ModTime()
+				spool.prod.setFile(spool.fileInfo)
+			}
 		}
 
 		if force || spool.file == nil ||
 			fileSize >= spool.prod.maxFileSize ||
-			(fileSize > 0 && time.Since(spool.fileCreated) > spool.prod.maxFileAge) {
+			(fileSize > spooling.FileHeaderSize && time.Since(spool.fileCreated) > spool.prod.maxFileAge) {
 
 			_, maxSuffix := spool.getFileNumbering()
-			spoolFileName := fmt.Sprintf(spoolFileFormatString, spool.basePath, maxSuffix+1)
+			spoolFileName := spooling.GetFileName(spool.basePath, maxSuffix+1)
 			newFile, err := os.OpenFile(spoolFileName, os.O_WRONLY|os.O_CREATE, 0600)
 			if err != nil {
 				return err // ### return, could not open file ###
 			}
 
+			// Lock the file so that "gollum spool replay" does not touch it
+			if err := spooling.LockFile(newFile); err != nil {
+				newFile.Close()
+				return err // ### return, could not lock file ###
+			}
+
+			if _, err := newFile.Write(spooling.NewFileHeader()); err != nil {
+				newFile.Close()
+				return err // ### return, could not write header ###
+			}
+
 			// Set writer and update internal state
 			spool.assembly.SetWriter(newFile)
 
@@ -151,6 +151,14 @@ func (spool *spoolFile) openOrRotate(force bool) bool {
 
 			spool.file = newFile
 			spool.fileCreated = time.Now()
+			spool.fileInfo = spooling.FileInfo{
+				Path:     spoolFileName,
+				Stream:   spool.streamName,
+				Number:   maxSuffix + 1,
+				Size:     spooling.FileHeaderSize,
+				Modified: spool.fileCreated,
+			}
+			spool.prod.setFile(spool.fileInfo)
 			spool.prod.Logger.Debug("Opened ", spoolFileName, " for writing")
 		}
 
@@ -165,21 +173,21 @@ func (spool *spoolFile) openOrRotate(force bool) bool {
 	return true
 }
 
-func (spool *spoolFile) decode(data []byte) {
-	// Base64 decode, than deserialize
-	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+func (spool *spoolFile) waitForReader() {
+	spool.readWorker.Wait()
+}
+
+// waitForRetry blocks until the reader should look for new files again
+func (spool *spoolFile) waitForRetry() {
+	spool.prod.WorkerDone() // to avoid being stuck during shutdown
 
-	if size, err := base64.StdEncoding.Decode(decoded, data); err != nil {
-		spool.prod.Logger.Error("File read: ", err)
-	} else if msg, err := core.DeserializeMessage(decoded[:size]); err != nil {
-		spool.prod.Logger.Error("File read: ", err)
-	} else {
-		spool.prod.routeToOrigin(&msg)
+	retry := time.After(spool.prod.maxFileAge / 2)
+	select {
+	case <-retry:
+	case <-spool.roll:
 	}
-}
 
-func (spool *spoolFile) waitForReader() {
-	spool.readWorker.Wait()
+	spool.prod.AddWorker() // worker done is always called at exit
 }
 
 func (spool *spoolFile) read() {
@@ -191,24 +199,16 @@ func (spool *spoolFile) read() {
 	for !spool.prod.IsStopping() {
 		minSuffix, _ := spool.getFileNumbering()
 
-		spoolFileName := fmt.Sprintf(spoolFileFormatString, spool.basePath, minSuffix)
-		if minSuffix == 0 || minSuffix > maxSpoolFileNumber || (spool.file != nil && spool.file.Name() == spoolFileName) {
-			if minSuffix > maxSpoolFileNumber {
+		spoolFileName := spooling.GetFileName(spool.basePath, minSuffix)
+		if minSuffix == 0 || minSuffix > spooling.MaxFileNumber || (spool.file != nil && spool.file.Name() == spoolFileName) {
+			if minSuffix > spooling.MaxFileNumber {
 				spool.prod.Logger.Debug("Read sleeps (no file)")
 			} else {
 				spool.prod.Logger.Debugf("Read waits for %s", spoolFileName)
 			}
 
-			spool.prod.WorkerDone() // to avoid being stuck during shutdown
-
-			retry := time.After(spool.prod.maxFileAge / 2)
-			select {
-			case <-retry:
-			case <-spool.roll:
-			}
-
-			spool.prod.AddWorker() // worker done is always called at exit
-			continue               // ### continue, try again ###
+			spool.waitForRetry()
+			continue // ### continue, try again ###
 		}
 
 		file, err := os.OpenFile(spoolFileName, os.O_RDONLY, 0600)
@@ -217,11 +217,26 @@ func (spool *spoolFile) read() {
 			continue // ### continue, try again ###
 		}
 
+		// Files written or replayed by "gollum spool" are locked
+		if err := spooling.LockFile(file); err != nil {
+			file.Close()
+			spool.prod.Logger.Debugf("Read waits for %s: %s", spoolFileName, err)
+			spool.waitForRetry()
+			continue // ### continue, try again ###
+		}
+
 		spool.prod.Logger.Debug("Opened ", spoolFileName, " for reading")
-		spool.reader.Reset(0)
 		readFailed := false
+		readDone := false
 
-		for spool.prod.IsStopping() {
+		reader, err := spooling.NewFileReader(file, spool.prod.bufferSizeByte, spool.prod.recoverCorrupt)
+		if err != nil {
+			spool.prod.Logger.Error("Read error: ", err)
+			readFailed = true
+			readDone = true
+		}
+
+		for !readDone && !spool.prod.IsStopping() {
 			// Only spool back if target is not busy
 			if spool.source != nil && spool.source.IsBlocked() {
 				time.Sleep(time.Millisecond * 100)
@@ -229,26 +244,41 @@ func (spool *spoolFile) read() {
 			}
 
 			// Any error cancels the loop
-			if err := spool.reader.ReadAll(file, spool.decode); err != nil {
+			msg, err := reader.Next()
+			if err != nil {
 				if err != io.EOF {
 					readFailed = true
 					spool.prod.Logger.Error("Read error: ", err)
 				}
+				readDone = true
 				break // ### break, read error or EOF ###
 			}
+			spool.prod.routeToOrigin(msg)
+		}
+
+		if reader != nil && reader.Corrupt() > 0 {
+			spool.prod.Logger.Warningf("Skipped %d corrupt records in %s", reader.Corrupt(), spoolFileName)
+			tgo.Metric.Add(spoolingMetricCorrupt+spool.prod.GetID(), int64(reader.Corrupt()))
 		}
 
 		// Close and remove file
-		spool.prod.Logger.Debug("Removing ", spoolFileName)
 		file.Close()
-		if readFailed {
+		switch {
+		case !readDone:
+			// Stopped while reading, keep file for the next run
+			spool.prod.Logger.Debug("Keeping ", spoolFileName)
+
+		case readFailed:
 			// Rename file for future processing
 			spool.prod.Logger.Debug("Renaming ", spoolFileName)
-			os.Rename(spoolFileName, spoolFileName+".failed")
-		} else {
+			os.Rename(spoolFileName, spoolFileName+spooling.FailedExtension)
+			spool.prod.removeFile(spoolFileName)
+
+		default:
 			// Delete file
 			spool.prod.Logger.Debug("Removing ", spoolFileName)
 			os.Remove(spoolFileName)
+			spool.prod.removeFile(spoolFileName)
 		}
 	}
 }
@@ -15,6 +15,7 @@
 package producer
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -23,7 +24,9 @@ import (
 
 	"github.com/trivago/gollum/core"
 	"github.com/trivago/gollum/core/components"
+	"github.com/trivago/gollum/producer/spooling"
 	"github.com/trivago/tgo"
+	"github.com/trivago/tgo/thealthcheck"
 )
 
 // Spooling producer plugin
@@ -47,6 +50,11 @@ import (
 //    RespoolDelaySec: 10
 //    MaxMessagesSec: 100
 //    RevertStreamOnDrop: false
+//    RecoverCorrupt: true
+//    Budget:
+//      MaxSizeMB: 0
+//      MaxAgeMin: 0
+//      Overflow: "dropnewest"
 //
 // Path sets the output directory for spooling files. Spooling files will
 // Files will be stored as "<path>/<stream>/<number>.spl". By default this is
@@ -83,6 +91,42 @@ import (
 // the message. This can be useful if you e.g. want to write messages that
 // could not be spooled to stream separated files on disk. Set to false by
 // default.
+//
+// Spool files start with a header containing the format version. Each message
+// is stored as a record with a length prefix and a CRC32C checksum. Files
+// written by older versions (one base64 encoded message per line) can still
+// be read.
+//
+// RecoverCorrupt enables skipping corrupt or incomplete records, e.g. caused
+// by a crash while writing. Skipped records are logged and counted by the
+// metric "Spooling:Corrupt-<id>". If set to false, a corrupt record stops
+// reading and the file is renamed to "<number>.spl.failed". Set to true by
+// default.
+//
+// Budget/MaxSizeMB sets the maximum size in MB of all spool files stored below
+// Path, i.e. the budget is shared by all streams. If a message would exceed
+// this size, Budget/Overflow is applied. The size is tracked while files are
+// written and removed, files changed by other processes are picked up by a
+// rescan of Path once per minute. By default this is set to 0, which disables
+// the limit.
+//
+// Budget/MaxAgeMin sets the maximum age in minutes of a spool file. Older
+// files are removed without being respooled. The file currently written for a
+// stream is never removed. By default this is set to 0, which disables the
+// limit.
+//
+// Budget/Overflow defines what happens if Budget/MaxSizeMB is exceeded.
+// "dropnewest" sends new messages to the fallback, "dropoldest" removes the
+// oldest spool files until the message fits and "block" waits until enough
+// files have been respooled. Note that "block" stalls all streams routed to
+// this producer. By default this is set to "dropnewest".
+//
+// Spool statistics are available as the metrics "Spooling:Files-<id>",
+// "Spooling:SizeByte-<id>", "Spooling:OldestSec-<id>", "Spooling:Dropped-<id>"
+// and "Spooling:DroppedFiles-<id>". A health check at "/<id>" reports an error
+// while the budget is exceeded.
+//
+// Existing spool files can be inspected and replayed with "gollum spool".
 type Spooling struct {
 	core.BufferedProducer `gollumdoc:"embed_type"`
 	outfile               map[core.MessageStreamID]*spoolFile
@@ -93,9 +137,17 @@ type Spooling struct {
 	batchMaxCount         int                     `config:"Batch/MaxCount" default:"100"`
 	bufferSizeByte        int                     `config:"BufferSizeByte" default:"8192"`
 	revertOnDrop          bool                    `config:"RevertStreamOnDrop"`
+	recoverCorrupt        bool                    `config:"RecoverCorrupt" default:"true"`
 	respoolDuration       time.Duration           `config:"RespoolDelaySec" default:"10" metric:"sec"`
 	maxFileAge            time.Duration           `config:"MaxFileAgeMin" default:"1" metric:"min"`
 	batchTimeout          time.Duration           `config:"Batch/TimeoutSec" default:"5" metric:"sec"`
+	budgetSize            int64                   `config:"Budget/MaxSizeMB" default:"0" metric:"mb"`
+	budgetAge             time.Duration           `config:"Budget/MaxAgeMin" default:"0" metric:"min"`
+	budget                spooling.Budget
+	overflow              spooling.OverflowPolicy
+	index                 *spooling.Index
+	usageGuard            *sync.Mutex
+	lastScan              time.Time
 	readDelay             time.Duration
 	spoolCheck            *time.Timer
 	serialze              core.Formatter
@@ -106,6 +158,18 @@ const (
 	spoolingMetricRead     = "Spooling:Read-"
 	spoolingMetricWriteSec = "Spooling:WriteSec-"
 	spoolingMetricReadSec  = "Spooling:ReadSec-"
+
+	spoolingMetricFiles        = "Spooling:Files-"
+	spoolingMetricSize         = "Spooling:SizeByte-"
+	spoolingMetricOldest       = "Spooling:OldestSec-"
+	spoolingMetricDropped      = "Spooling:Dropped-"
+	spoolingMetricDroppedFiles = "Spooling:DroppedFiles-"
+	spoolingMetricCorrupt      = "Spooling:Corrupt-"
+)
+
+const (
+	spoolingBlockInterval = 100 * time.Millisecond
+	spoolingScanInterval  = time.Minute
 )
 
 func init() {
@@ -140,13 +204,45 @@ func (prod *Spooling) Configure(conf core.PluginConfigReader) {
 	prod.rotation.Enabled = true
 	prod.rotation.Timeout = prod.maxFileAge
 	prod.rotation.SizeByte = prod.maxFileSize
+
+	prod.usageGuard = new(sync.Mutex)
+	prod.index = spooling.NewIndex(nil)
+	prod.budget = spooling.Budget{
+		MaxBytes: prod.budgetSize,
+		MaxAge:   prod.budgetAge,
+	}
+	prod.overflow, err = spooling.ParseOverflowPolicy(conf.GetString("Budget/Overflow", "dropnewest"))
+	conf.Errors.Push(err)
+
+	tgo.Metric.New(spoolingMetricFiles + prod.GetID())
+	tgo.Metric.New(spoolingMetricSize + prod.GetID())
+	tgo.Metric.New(spoolingMetricOldest + prod.GetID())
+	tgo.Metric.New(spoolingMetricDropped + prod.GetID())
+	tgo.Metric.New(spoolingMetricDroppedFiles + prod.GetID())
+	tgo.Metric.New(spoolingMetricCorrupt + prod.GetID())
+
+	prod.AddHealthCheck(prod.healthcheckBudget)
+}
+
+func (prod *Spooling) healthcheckBudget() (int, string) {
+	prod.usageGuard.Lock()
+	usage := prod.index.Usage()
+	prod.usageGuard.Unlock()
+
+	if prod.budget.Exceeds(usage, 0) || (prod.budget.MaxAge > 0 && usage.Age() > prod.budget.MaxAge) {
+		return thealthcheck.StatusServiceUnavailable, fmt.Sprintf("BUDGET EXCEEDED: %s", usage)
+	}
+	return thealthcheck.StatusOK, fmt.Sprintf("OK: %s", usage)
 }
 
-// Modulate enforces the serialize formatter at the end of the modulation chain
-func (prod *Spooling) Modulate(msg *core.Message) core.ModulateResult {
-	result := prod.BufferedProducer.Modulate(msg)
-	prod.serialze.ApplyFormatter(msg) // Ignore result
-	return result
+// serialize applies the serialize formatter after all other modulators have
+// been applied and converts the result into a spool file line.
+func (prod *Spooling) serialize(msg *core.Message) error {
+	if err := prod.serialze.ApplyFormatter(msg); err != nil {
+		return err
+	}
+	msg.StorePayload(spooling.EncodeMessage(msg.GetPayload()))
+	return nil
 }
 
 // TryFallback reverts the message stream before dropping
@@ -167,7 +263,6 @@ func (prod *Spooling) writeToFile(msg *core.Message) {
 
 	if !exists {
 		streamName := core.StreamRegistry.GetStreamName(streamID)
-		spool = newSpoolFile(prod, streamName, msg.GetSource())
 
 		prod.outfileGuard.Lock()
 		// Recheck to avoid races
@@ -190,6 +285,18 @@ func (prod *Spooling) writeToFile(msg *core.Message) {
 		return // ### return, could not spool to disk ###
 	}
 
+	if err := prod.serialize(msg); err != nil {
+		prod.Logger.WithError(err).Error("Failed to serialize message")
+		prod.TryFallback(msg)
+		return // ### return, cannot serialize ###
+	}
+
+	if !prod.reserveBudget(int64(len(msg.GetPayload()))) {
+		tgo.Metric.Inc(spoolingMetricDropped + prod.GetID())
+		prod.TryFallback(msg)
+		return // ### return, budget exceeded ###
+	}
+
 	// Append to buffer
 	spool.batch.AppendOrFlush(msg, spool.flush, prod.IsActiveOrStopping, prod.TryFallback)
 	spool.countWrite()
@@ -211,6 +318,119 @@ func (prod *Spooling) flush(force bool) {
 		}
 		spool.openOrRotate(force)
 	}
+
+	// All sizes have been updated by openOrRotate
+	prod.usageGuard.Lock()
+	prod.index.Commit()
+	prod.usageGuard.Unlock()
+
+	prod.updateUsage()
+}
+
+// scanFiles rebuilds the index of spool files from the spooling path. This
+// catches files written or removed by other instances or "gollum spool".
+func (prod *Spooling) scanFiles() {
+	files, err := spooling.ListFiles(prod.path)
+	if err != nil && !os.IsNotExist(err) {
+		prod.Logger.WithError(err).Warning("Failed to scan spool files")
+	}
+
+	prod.usageGuard.Lock()
+	prod.index.Reset(files)
+	prod.lastScan = time.Now()
+	prod.usageGuard.Unlock()
+}
+
+// setFile adds a spool file to the index or updates its size
+func (prod *Spooling) setFile(file spooling.FileInfo) {
+	prod.usageGuard.Lock()
+	prod.index.Set(file)
+	prod.usageGuard.Unlock()
+}
+
+// removeFile removes a spool file from the index
+func (prod *Spooling) removeFile(path string) {
+	prod.usageGuard.Lock()
+	prod.index.Remove(path)
+	prod.usageGuard.Unlock()
+}
+
+// updateUsage removes expired files and updates the spool metrics. Usage is
+// tracked incrementally, the spooling path is rescanned every
+// spoolingScanInterval. All known files are returned.
+func (prod *Spooling) updateUsage() []spooling.FileInfo {
+	if time.Since(prod.lastScan) >= spoolingScanInterval {
+		prod.scanFiles()
+	}
+
+	prod.usageGuard.Lock()
+	files := prod.index.Files()
+	prod.usageGuard.Unlock()
+
+	if expired := prod.budget.GetExpired(spooling.GetRemovable(files)); len(expired) > 0 {
+		for _, file := range expired {
+			prod.dropFile(file, "expired")
+		}
+	}
+
+	prod.usageGuard.Lock()
+	files = prod.index.Files()
+	usage := prod.index.Usage()
+	prod.usageGuard.Unlock()
+
+	tgo.Metric.SetI(spoolingMetricFiles+prod.GetID(), usage.Files)
+	tgo.Metric.Set(spoolingMetricSize+prod.GetID(), usage.Bytes)
+	tgo.Metric.Set(spoolingMetricOldest+prod.GetID(), int64(usage.Age()/time.Second))
+	return files
+}
+
+// reserveBudget returns true if the given number of bytes may be spooled.
+// If the budget is exceeded the configured overflow policy is applied.
+func (prod *Spooling) reserveBudget(size int64) bool {
+	if prod.budget.MaxBytes <= 0 {
+		return true // ### return, no size limit ###
+	}
+
+	for {
+		prod.usageGuard.Lock()
+		if !prod.budget.Exceeds(prod.index.Usage(), size) {
+			prod.index.Reserve(size) // estimate until the file size is known
+			prod.usageGuard.Unlock()
+			return true // ### return, message fits ###
+		}
+		prod.usageGuard.Unlock()
+
+		switch prod.overflow {
+		case spooling.OverflowDropOldest:
+			removable := spooling.GetRemovable(prod.updateUsage())
+			if len(removable) == 0 {
+				return false // ### return, nothing left to remove ###
+			}
+			prod.dropFile(removable[0], "over budget")
+			prod.updateUsage()
+
+		case spooling.OverflowBlock:
+			if !prod.IsActive() {
+				return false // ### return, shutting down ###
+			}
+			time.Sleep(spoolingBlockInterval)
+			prod.flush(false) // rotate files so they can be respooled
+
+		default:
+			return false
+		}
+	}
+}
+
+// dropFile removes a spool file without respooling it
+func (prod *Spooling) dropFile(file spooling.FileInfo, reason string) {
+	prod.Logger.WithField("file", file.Path).Warningf("Removing spool file (%s)", reason)
+	if err := os.Remove(file.Path); err != nil && !os.IsNotExist(err) {
+		prod.Logger.WithError(err).Error("Failed to remove spool file")
+		return
+	}
+	prod.removeFile(file.Path)
+	tgo.Metric.Inc(spoolingMetricDroppedFiles + prod.GetID())
 }
 
 func (prod *Spooling) writeBatchOnTimeOut() {
@@ -254,6 +474,7 @@ func (prod *Spooling) waitForReader() {
 
 	outfiles := prod.outfile
 	for _, spool := range outfiles {
+		spool.triggerRoll() // wake up sleeping readers
 		spool.waitForReader()
 	}
 }
@@ -304,5 +525,6 @@ func (prod *Spooling) openExistingFiles() {
 func (prod *Spooling) Produce(workers *sync.WaitGroup) {
 	prod.AddMainWorker(workers)
 	prod.spoolCheck = time.AfterFunc(prod.respoolDuration, prod.openExistingFiles)
+	prod.updateUsage()
 	prod.TickerMessageControlLoop(prod.writeToFile, prod.batchTimeout, prod.writeBatchOnTimeOut)
 }
@@ -0,0 +1,150 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spooling
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OverflowPolicy defines what happens if the spool budget is exceeded
+type OverflowPolicy int
+
+const (
+	// OverflowDropNewest rejects new messages while the budget is exceeded
+	OverflowDropNewest = OverflowPolicy(iota)
+	// OverflowDropOldest removes the oldest spool files to make room
+	OverflowDropOldest = OverflowPolicy(iota)
+	// OverflowBlock waits until enough spool files have been respooled
+	OverflowBlock = OverflowPolicy(iota)
+)
+
+// ParseOverflowPolicy converts a policy name into an OverflowPolicy.
+// Valid names are "dropnewest", "dropoldest" and "block".
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch strings.ToLower(name) {
+	case "dropnewest", "":
+		return OverflowDropNewest, nil
+	case "dropoldest":
+		return OverflowDropOldest, nil
+	case "block":
+		return OverflowBlock, nil
+	default:
+		return OverflowDropNewest, fmt.Errorf("Unknown overflow policy '%s'", name)
+	}
+}
+
+// String implements the stringer interface
+func (policy OverflowPolicy) String() string {
+	switch policy {
+	case OverflowDropOldest:
+		return "dropoldest"
+	case OverflowBlock:
+		return "block"
+	default:
+		return "dropnewest"
+	}
+}
+
+// Usage holds statistics about a set of spool files
+type Usage struct {
+	Files  int
+	Bytes  int64
+	Oldest time.Time
+}
+
+// GetUsage returns the combined usage of the given files
+func GetUsage(files []FileInfo) Usage {
+	usage := Usage{}
+	for _, file := range files {
+		usage.Files++
+		usage.Bytes += file.Size
+		if usage.Oldest.IsZero() || file.Modified.Before(usage.Oldest) {
+			usage.Oldest = file.Modified
+		}
+	}
+	return usage
+}
+
+// Age returns the age of the oldest file or 0 if there are no files
+func (usage Usage) Age() time.Duration {
+	if usage.Oldest.IsZero() {
+		return 0
+	}
+	return time.Since(usage.Oldest)
+}
+
+// String implements the stringer interface
+func (usage Usage) String() string {
+	return fmt.Sprintf("files=%d bytes=%d oldestSec=%d", usage.Files, usage.Bytes, int64(usage.Age()/time.Second))
+}
+
+// Budget defines the limits for all spool files below a spooling path.
+// A value of 0 disables the corresponding limit.
+type Budget struct {
+	MaxBytes int64
+	MaxAge   time.Duration
+}
+
+// IsEnabled returns true if any limit is set
+func (budget Budget) IsEnabled() bool {
+	return budget.MaxBytes > 0 || budget.MaxAge > 0
+}
+
+// Exceeds returns true if adding the given number of bytes would exceed the
+// size limit.
+func (budget Budget) Exceeds(usage Usage, additionalBytes int64) bool {
+	return budget.MaxBytes > 0 && usage.Bytes+additionalBytes > budget.MaxBytes
+}
+
+// GetExpired returns all files older than the age limit
+func (budget Budget) GetExpired(files []FileInfo) []FileInfo {
+	expired := []FileInfo{}
+	if budget.MaxAge <= 0 {
+		return expired
+	}
+	for _, file := range files {
+		if time.Since(file.Modified) > budget.MaxAge {
+			expired = append(expired, file)
+		}
+	}
+	return expired
+}
+
+// GetRemovable returns all files that may be removed to free up space,
+// ordered from oldest to newest. The file with the highest number of each
+// stream is excluded as it might still be open for writing.
+func GetRemovable(files []FileInfo) []FileInfo {
+	newest := make(map[string]int)
+	for _, file := range files {
+		if file.Number > newest[file.Stream] {
+			newest[file.Stream] = file.Number
+		}
+	}
+
+	removable := []FileInfo{}
+	for _, file := range files {
+		if file.Number < newest[file.Stream] {
+			removable = append(removable, file)
+		}
+	}
+
+	sort.Slice(removable, func(i, j int) bool {
+		return removable[i].Modified.Before(removable[j].Modified)
+	})
+	return removable
+}
@@ -0,0 +1,146 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spooling
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tio"
+)
+
+// Spool files start with a header of FileHeaderSize bytes: the 4 byte magic
+// "\x89GSP", a format version byte and 3 reserved bytes. The header is followed
+// by records framed by core.EncodeMessageFrame.
+// Files without this header are read as unframed files, i.e. one base64
+// encoded message per line.
+const (
+	// FileHeaderSize is the size of the spool file header in bytes
+	FileHeaderSize = 8
+	// FileFormatVersion is the current spool file format version
+	FileFormatVersion = 1
+	fileMagic         = "\x89GSP"
+)
+
+// NewFileHeader returns the header written at the start of each spool file
+func NewFileHeader() []byte {
+	header := make([]byte, FileHeaderSize)
+	copy(header, fileMagic)
+	header[len(fileMagic)] = FileFormatVersion
+	return header
+}
+
+// FileReader reads messages from a spool file. Both framed files and
+// unframed (legacy) files are supported.
+type FileReader struct {
+	frames         *core.MessageFrameReader
+	lines          *tio.BufferedReader
+	reader         io.Reader
+	corrupt        int
+	recoverCorrupt bool
+	Version        int
+}
+
+// NewFileReader reads the file header and returns a reader for the
+// remaining records. Version is set to 0 for unframed files. If recoverCorrupt
+// is set, corrupt records are skipped and counted.
+func NewFileReader(reader io.Reader, bufferSize int, recoverCorrupt bool) (*FileReader, error) {
+	header := make([]byte, FileHeaderSize)
+	n, err := io.ReadFull(reader, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+
+	fileReader := &FileReader{
+		recoverCorrupt: recoverCorrupt,
+	}
+
+	if n == FileHeaderSize && bytes.HasPrefix(header, []byte(fileMagic)) {
+		fileReader.Version = int(header[len(fileMagic)])
+		if fileReader.Version > FileFormatVersion {
+			return nil, fmt.Errorf("Unsupported spool file version %d", fileReader.Version)
+		}
+		fileReader.frames = core.NewMessageFrameReader(reader, bufferSize, recoverCorrupt)
+		return fileReader, nil
+	}
+
+	// Unframed file, the header is part of the first line
+	fileReader.reader = io.MultiReader(bytes.NewReader(header[:n]), reader)
+	fileReader.lines = tio.NewBufferedReader(bufferSize, tio.BufferedReaderFlagDelimiter, 0, "\n")
+	return fileReader, nil
+}
+
+// Corrupt returns the number of corrupt records skipped so far
+func (r *FileReader) Corrupt() int {
+	if r.frames != nil {
+		return r.corrupt + r.frames.Corrupt()
+	}
+	return r.corrupt
+}
+
+// Next returns the next message or io.EOF if the file is exhausted.
+func (r *FileReader) Next() (*core.Message, error) {
+	for {
+		msg, err := r.next()
+		if err == nil || err == io.EOF {
+			return msg, err
+		}
+		if _, isRecordErr := err.(recordError); !isRecordErr || !r.recoverCorrupt {
+			return nil, err
+		}
+		r.corrupt++
+	}
+}
+
+// recordError marks errors that affect a single record only
+type recordError struct {
+	error
+}
+
+func (r *FileReader) next() (*core.Message, error) {
+	if r.frames != nil {
+		record, err := r.frames.Next()
+		if err != nil {
+			return nil, err
+		}
+		msg, err := core.DeserializeMessage(record)
+		if err != nil {
+			return nil, recordError{err}
+		}
+		return &msg, nil
+	}
+
+	for {
+		data, more, err := r.lines.ReadOne(r.reader)
+		if data != nil {
+			msg, err := decodeLegacyMessage(data)
+			if err != nil {
+				return nil, recordError{err}
+			}
+			return msg, nil
+		}
+
+		switch {
+		case err == io.EOF && more:
+			// Incomplete last line, i.e. a torn write
+			r.lines.Reset(0)
+			return nil, recordError{io.ErrUnexpectedEOF}
+		case err != nil:
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spooling
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// Index keeps track of the spool files below a spooling path so that the
+// path does not need to be scanned whenever a file is created, written or
+// removed. Files are identified by their cleaned path. Index is not thread
+// safe.
+type Index struct {
+	files   map[string]FileInfo
+	usage   Usage
+	pending int64
+}
+
+// NewIndex creates an index holding the given files.
+func NewIndex(files []FileInfo) *Index {
+	index := &Index{}
+	index.Reset(files)
+	return index
+}
+
+// Reset replaces all files known to the index, e.g. after scanning the
+// spooling path.
+func (index *Index) Reset(files []FileInfo) {
+	index.files = make(map[string]FileInfo, len(files))
+	for _, file := range files {
+		index.files[filepath.Clean(file.Path)] = file
+	}
+	index.usage = GetUsage(files)
+	index.pending = 0
+}
+
+// Set adds a file to the index or updates the size and modification time of
+// a known file.
+func (index *Index) Set(file FileInfo) {
+	path := filepath.Clean(file.Path)
+	known, exists := index.files[path]
+	index.files[path] = file
+
+	if !exists {
+		index.usage.Files++
+		index.usage.Bytes += file.Size
+		if index.usage.Oldest.IsZero() || file.Modified.Before(index.usage.Oldest) {
+			index.usage.Oldest = file.Modified
+		}
+		return // ### return, new file ###
+	}
+
+	index.usage.Bytes += file.Size - known.Size
+	if known.Modified.Equal(index.usage.Oldest) {
+		index.updateOldest()
+	}
+}
+
+// Remove removes a file from the index.
+func (index *Index) Remove(path string) {
+	path = filepath.Clean(path)
+	known, exists := index.files[path]
+	if !exists {
+		return // ### return, unknown file ###
+	}
+
+	delete(index.files, path)
+	index.usage.Files--
+	index.usage.Bytes -= known.Size
+	if known.Modified.Equal(index.usage.Oldest) {
+		index.updateOldest()
+	}
+}
+
+// Reserve adds the given number of bytes to the usage before they are
+// written to a file. Reserved bytes are counted until Commit is called.
+func (index *Index) Reserve(bytes int64) {
+	index.pending += bytes
+}
+
+// Commit discards all reserved bytes after the sizes of all files being
+// written have been updated by Set.
+func (index *Index) Commit() {
+	index.pending = 0
+}
+
+// Usage returns the combined usage of all files including reserved bytes.
+func (index *Index) Usage() Usage {
+	usage := index.usage
+	usage.Bytes += index.pending
+	return usage
+}
+
+// Files returns all known files in the order returned by ListFiles.
+func (index *Index) Files() []FileInfo {
+	files := make([]FileInfo, 0, len(index.files))
+	for _, file := range index.files {
+		files = append(files, file)
+	}
+	sortFiles(files)
+	return files
+}
+
+func (index *Index) updateOldest() {
+	index.usage.Oldest = time.Time{}
+	for _, file := range index.files {
+		if index.usage.Oldest.IsZero() || file.Modified.Before(index.usage.Oldest) {
+			index.usage.Oldest = file.Modified
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spooling
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tstrings"
+)
+
+const (
+	// FileExtension is the extension used by all spool files
+	FileExtension = ".spl"
+	// FailedExtension is appended to spool files that could not be read
+	FailedExtension = ".failed"
+	// MaxFileNumber is the maximum file number defined by %08d -> 8 digits
+	MaxFileNumber = 99999999
+	// fileFormatString is used to generate spool file names
+	fileFormatString = "%s/%08d" + FileExtension
+)
+
+// ErrFileLocked is returned by LockFile if a spool file is currently read or
+// written by a running producer.
+var ErrFileLocked = errors.New("Spool file is in use by a running producer")
+
+// FileInfo describes a spool file stored as "<path>/<stream>/<number>.spl"
+type FileInfo struct {
+	Path     string
+	Stream   string
+	Number   int
+	Size     int64
+	Modified time.Time
+}
+
+// GetFileName returns the path of the spool file with the given number
+func GetFileName(streamPath string, number int) string {
+	return fmt.Sprintf(fileFormatString, streamPath, number)
+}
+
+// ListFiles returns all spool files found below the given spooling path.
+// If streams are given, only the directories of these streams are scanned.
+// Files are sorted by stream name and number, i.e. in the order they would
+// be respooled.
+func ListFiles(basePath string, streams ...string) ([]FileInfo, error) {
+	if len(streams) == 0 {
+		dirs, err := ioutil.ReadDir(basePath)
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			if dir.IsDir() {
+				streams = append(streams, dir.Name())
+			}
+		}
+	}
+
+	spoolFiles := []FileInfo{}
+	for _, streamName := range streams {
+		streamPath := filepath.Join(basePath, streamName)
+		files, err := ioutil.ReadDir(streamPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // ### continue, nothing spooled ###
+			}
+			return spoolFiles, err
+		}
+
+		for _, file := range files {
+			if file.IsDir() || filepath.Ext(file.Name()) != FileExtension {
+				continue // ### continue, not a spool file ###
+			}
+			number, _ := tstrings.Btoi([]byte(file.Name())) // Because we need leading zero support
+			spoolFiles = append(spoolFiles, FileInfo{
+				Path:     filepath.Join(streamPath, file.Name()),
+				Stream:   streamName,
+				Number:   int(number),
+				Size:     file.Size(),
+				Modified: file.ModTime(),
+			})
+		}
+	}
+
+	sortFiles(spoolFiles)
+	return spoolFiles, nil
+}
+
+// sortFiles sorts files by stream name and number
+func sortFiles(files []FileInfo) {
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Stream != files[j].Stream {
+			return files[i].Stream < files[j].Stream
+		}
+		return files[i].Number < files[j].Number
+	})
+}
+
+// GetFileNumbering returns the lowest and highest spool file number of the
+// given stream directory. If no file exists, min will be MaxFileNumber+1
+// and max will be 0.
+func GetFileNumbering(streamPath string) (min int, max int) {
+	min, max = MaxFileNumber+1, 0
+	files, _ := ioutil.ReadDir(streamPath)
+	for _, file := range files {
+		if filepath.Ext(file.Name()) == FileExtension {
+			number, _ := tstrings.Btoi([]byte(file.Name())) // Because we need leading zero support
+			if int(number) < min {
+				min = int(number)
+			}
+			if int(number) > max {
+				max = int(number)
+			}
+		}
+	}
+	return min, max
+}
+
+// EncodeMessage returns the spool file representation of an already
+// serialized message, i.e. a checksummed frame as created by
+// core.EncodeMessageFrame.
+func EncodeMessage(serialized []byte) []byte {
+	return core.EncodeMessageFrame(serialized)
+}
+
+// decodeLegacyMessage restores a message from a line read from an unframed
+// spool file. The line is expected to be stripped of its delimiter.
+func decodeLegacyMessage(data []byte) (*core.Message, error) {
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	size, err := base64.StdEncoding.Decode(decoded, data)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := core.DeserializeMessage(decoded[:size])
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// ReadFile decodes all messages stored in the given spool file and passes
+// them to onMessage. If recoverCorrupt is set, corrupt records are skipped
+// and counted. Otherwise the first corrupt record stops reading and an error
+// is returned.
+func ReadFile(path string, bufferSize int, recoverCorrupt bool, onMessage func(*core.Message)) (corrupt int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return Read(file, bufferSize, recoverCorrupt, onMessage)
+}
+
+// Read decodes all messages stored in an already opened spool file. See
+// ReadFile for details.
+func Read(file io.Reader, bufferSize int, recoverCorrupt bool, onMessage func(*core.Message)) (corrupt int, err error) {
+	reader, err := NewFileReader(file, bufferSize, recoverCorrupt)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		msg, err := reader.Next()
+		if err == io.EOF {
+			return reader.Corrupt(), nil // ### return, done ###
+		}
+		if err != nil {
+			return reader.Corrupt(), err
+		}
+		onMessage(msg)
+	}
+}
+
+// WriteFile stores the given messages as a new spool file in the directory
+// of the given stream. The file number is chosen so that the file is read
+// after all files currently present. The stream directory is created if
+// necessary. The file is locked while being written so that a running
+// producer does not read it before it is complete. The path of the new file
+// is returned.
+func WriteFile(basePath string, streamName string, messages []*core.Message) (string, error) {
+	streamPath := filepath.Join(basePath, streamName)
+	if err := os.MkdirAll(streamPath, 0700); err != nil {
+		return "", err
+	}
+
+	_, maxNumber := GetFileNumbering(streamPath)
+	for number := maxNumber + 1; number <= MaxFileNumber; number++ {
+		fileName := GetFileName(streamPath, number)
+		file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue // ### continue, created concurrently ###
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if err := LockFile(file); err != nil {
+			file.Close()
+			return fileName, err
+		}
+
+		if _, err := file.Write(NewFileHeader()); err != nil {
+			file.Close()
+			return fileName, err
+		}
+
+		for _, msg := range messages {
+			data, err := msg.Serialize()
+			if err == nil {
+				_, err = file.Write(EncodeMessage(data))
+			}
+			if err != nil {
+				file.Close()
+				return fileName, err
+			}
+		}
+		return fileName, file.Close()
+	}
+
+	return "", fmt.Errorf("No spool file number left in %s", streamPath)
+}
+
+// SetOrigin changes the stream a spooled message will be routed to when it
+// is respooled.
+func SetOrigin(msg *core.Message, streamID core.MessageStreamID) {
+	msg.SetStreamID(streamID)
+	msg.SetPrevStreamID(streamID) // the previous stream is the respool target
+}
@@ -0,0 +1,220 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spooling
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestSpoolFileWriteRead(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	basePath, err := ioutil.TempDir("", "gollum-spooling")
+	expect.NoError(err)
+	defer os.RemoveAll(basePath)
+
+	originID := core.GetStreamID("origin")
+	targetID := core.GetStreamID("target")
+
+	msg := core.NewMessage(nil, []byte("hello\nworld"), core.Metadata{"key": []byte("value")}, originID)
+	msg.SetStreamID(core.GetStreamID("spooling"))
+	expect.Equal(originID, msg.GetPrevStreamID())
+
+	SetOrigin(msg, targetID)
+	expect.Equal(targetID, msg.GetPrevStreamID())
+
+	fileName, err := WriteFile(basePath, "target", []*core.Message{msg, msg})
+	expect.NoError(err)
+	expect.Equal(GetFileName(filepath.Join(basePath, "target"), 1), fileName)
+
+	fileName, err = WriteFile(basePath, "target", []*core.Message{msg})
+	expect.NoError(err)
+	expect.Equal(GetFileName(filepath.Join(basePath, "target"), 2), fileName)
+
+	min, max := GetFileNumbering(filepath.Join(basePath, "target"))
+	expect.Equal(1, min)
+	expect.Equal(2, max)
+
+	files, err := ListFiles(basePath)
+	expect.NoError(err)
+	expect.Equal(2, len(files))
+	expect.Equal("target", files[0].Stream)
+	expect.Equal(1, files[0].Number)
+
+	messages := []*core.Message{}
+	corrupt, err := ReadFile(files[0].Path, 4, false, func(msg *core.Message) {
+		messages = append(messages, msg)
+	})
+	expect.NoError(err)
+	expect.Equal(0, corrupt)
+	expect.Equal(2, len(messages))
+	expect.Equal("hello\nworld", string(messages[0].GetPayload()))
+	expect.Equal("value", messages[0].GetMetadata().GetValueString("key"))
+	expect.Equal(targetID, messages[0].GetPrevStreamID())
+
+	files, err = ListFiles(basePath, "unknown")
+	expect.NoError(err)
+	expect.Equal(0, len(files))
+}
+
+func TestSpoolFileLegacy(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	file, err := ioutil.TempFile("", "gollum-spooling")
+	expect.NoError(err)
+	defer os.Remove(file.Name())
+
+	msg := core.NewMessage(nil, []byte("test"), nil, core.InvalidStreamID)
+	data, err := msg.Serialize()
+	expect.NoError(err)
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	file.WriteString("not base64!\n")
+	file.WriteString(encoded + "\n")
+	file.WriteString(encoded[:4])
+	file.Close()
+
+	numMessages := 0
+	corrupt, err := ReadFile(file.Name(), 16, true, func(*core.Message) { numMessages++ })
+	expect.NoError(err)
+	expect.Equal(1, numMessages)
+	expect.Equal(2, corrupt)
+
+	_, err = ReadFile(file.Name(), 16, false, func(*core.Message) {})
+	expect.NotNil(err)
+}
+
+func TestSpoolFileRecover(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	basePath, err := ioutil.TempDir("", "gollum-spooling")
+	expect.NoError(err)
+	defer os.RemoveAll(basePath)
+
+	messages := []*core.Message{
+		core.NewMessage(nil, []byte("first"), nil, core.InvalidStreamID),
+		core.NewMessage(nil, []byte("second"), nil, core.InvalidStreamID),
+		core.NewMessage(nil, []byte("third"), nil, core.InvalidStreamID),
+	}
+	fileName, err := WriteFile(basePath, "test", messages)
+	expect.NoError(err)
+
+	data, err := ioutil.ReadFile(fileName)
+	expect.NoError(err)
+	expect.Equal(NewFileHeader(), data[:FileHeaderSize])
+
+	// Damage the second record and append a torn write
+	first, _ := messages[0].Serialize()
+	data[FileHeaderSize+len(EncodeMessage(first))+core.MessageFrameHeaderSize+1] ^= 0xFF
+	data = append(data, EncodeMessage(first)[:5]...)
+	expect.NoError(ioutil.WriteFile(fileName, data, 0600))
+
+	payloads := []string{}
+	corrupt, err := ReadFile(fileName, 16, true, func(msg *core.Message) {
+		payloads = append(payloads, msg.String())
+	})
+	expect.NoError(err)
+	expect.Equal([]string{"first", "third"}, payloads)
+	expect.Equal(2, corrupt)
+
+	_, err = ReadFile(fileName, 16, false, func(*core.Message) {})
+	expect.NotNil(err)
+
+	// Unknown versions are rejected
+	data[len(fileMagic)] = FileFormatVersion + 1
+	expect.NoError(ioutil.WriteFile(fileName, data, 0600))
+	_, err = ReadFile(fileName, 16, true, func(*core.Message) {})
+	expect.NotNil(err)
+}
+
+func TestSpoolBudget(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	now := time.Now()
+	files := []FileInfo{
+		{Stream: "a", Number: 1, Size: 10, Modified: now.Add(-3 * time.Hour)},
+		{Stream: "a", Number: 2, Size: 10, Modified: now.Add(-2 * time.Hour)},
+		{Stream: "b", Number: 7, Size: 10, Modified: now.Add(-4 * time.Hour)},
+		{Stream: "b", Number: 8, Size: 10, Modified: now},
+	}
+
+	usage := GetUsage(files)
+	expect.Equal(4, usage.Files)
+	expect.Equal(int64(40), usage.Bytes)
+	expect.Equal(files[2].Modified, usage.Oldest)
+
+	removable := GetRemovable(files)
+	expect.Equal(2, len(removable))
+	expect.Equal(7, removable[0].Number)
+	expect.Equal(1, removable[1].Number)
+
+	budget := Budget{MaxBytes: 45, MaxAge: 150 * time.Minute}
+	expect.True(budget.IsEnabled())
+	expect.False(budget.Exceeds(usage, 5))
+	expect.True(budget.Exceeds(usage, 6))
+
+	expired := budget.GetExpired(removable)
+	expect.Equal(2, len(expired))
+
+	policy, err := ParseOverflowPolicy("DropOldest")
+	expect.NoError(err)
+	expect.Equal(OverflowDropOldest, policy)
+
+	_, err = ParseOverflowPolicy("unknown")
+	expect.NotNil(err)
+}
+
+func TestSpoolIndex(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	now := time.Now()
+
+	index := NewIndex([]FileInfo{
+		{Path: "/spool/a/00000001.spl", Stream: "a", Number: 1, Size: 100, Modified: now.Add(-time.Hour)},
+		{Path: "/spool/b/00000001.spl", Stream: "b", Number: 1, Size: 50, Modified: now},
+	})
+	expect.Equal(2, index.Usage().Files)
+	expect.Equal(int64(150), index.Usage().Bytes)
+
+	index.Set(FileInfo{Path: "/spool//a/00000002.spl", Stream: "a", Number: 2, Size: 8, Modified: now})
+	index.Set(FileInfo{Path: "/spool/a/00000002.spl", Stream: "a", Number: 2, Size: 20, Modified: now})
+	expect.Equal(3, index.Usage().Files)
+	expect.Equal(int64(170), index.Usage().Bytes)
+
+	index.Reserve(30)
+	expect.Equal(int64(200), index.Usage().Bytes)
+	index.Commit()
+	expect.Equal(int64(170), index.Usage().Bytes)
+
+	// removing the oldest file updates the age
+	expect.Geq(int64(index.Usage().Age()), int64(time.Hour))
+	index.Remove("/spool/a/00000001.spl")
+	index.Remove("/spool/a/00000001.spl")
+	expect.Equal(2, index.Usage().Files)
+	expect.Equal(int64(70), index.Usage().Bytes)
+	expect.Less(int64(index.Usage().Age()), int64(time.Hour))
+
+	files := index.Files()
+	expect.Equal(2, len(files))
+	expect.Equal("a", files[0].Stream)
+	expect.Equal("b", files[1].Stream)
+}
@@ -162,6 +162,10 @@ func (prod *Statsd) transformMessages(messages []*core.Message) {
 			prod.client.Incr(metric, val)
 		}
 	}
+
+	for _, msg := range messages {
+		msg.Release()
+	}
 }
 
 func (prod *Statsd) close() {
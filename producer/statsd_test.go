@@ -0,0 +1,91 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package producer
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/trivago/gollum/consumer"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestStatsdDeliverSpan(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	expect.NoError(err)
+	defer server.Close()
+
+	config := core.NewPluginConfig("statsdTrace", "producer.Statsd")
+	config.Override("Server", server.LocalAddr().String())
+	config.Override("Prefix", "test.")
+	config.Override("Streams", "statsdTrace")
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	prod := plugin.(*Statsd)
+
+	config = core.NewPluginConfig("statsdTraceSource", "consumer.Profiler")
+	config.Override("Streams", "statsdTrace")
+	config.Override("Trace/SampleRate", 1)
+	plugin, err = core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	cons := plugin.(*consumer.Profiler)
+
+	streamID := core.GetStreamID("statsdTrace")
+	core.StreamRegistry.GetRouterOrFallback(streamID).AddProducer(prod)
+
+	tap := core.TapStream(core.TraceInternalStreamID, 64)
+	defer tap.Close()
+
+	workers := new(sync.WaitGroup)
+	go prod.Produce(workers)
+	expect.NonBlocking(5*time.Second, func() {
+		for prod.GetState() != core.PluginStateActive {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	cons.Enqueue([]byte("metric"))
+
+	// Stopping the producer flushes the batch
+	prod.Control() <- core.PluginControlStopProducer
+	expect.NonBlocking(5*time.Second, workers.Wait)
+
+	packet := make([]byte, 256)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := server.ReadFrom(packet)
+	expect.NoError(err)
+	expect.Equal("test.statsdTrace:1|c", string(packet[:n]))
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case spanMsg := <-tap.Messages():
+			span := core.TraceSpan{}
+			expect.NoError(json.Unmarshal(spanMsg.GetPayload(), &span))
+			if span.Name == core.TraceSpanDeliver {
+				expect.Equal("statsdTrace", span.Tags["stream"])
+				return // ### return, delivered ###
+			}
+		case <-timeout:
+			t.Fatal("No deliver span received")
+		}
+	}
+}
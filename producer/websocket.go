@@ -138,6 +138,7 @@ func (prod *Websocket) pushMessage(msg *core.Message) {
 			i--
 		}
 	}
+	msg.Release()
 }
 
 func (prod *Websocket) upgrade(w http.ResponseWriter, r *http.Request) {
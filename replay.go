@@ -0,0 +1,410 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tos"
+)
+
+// replayTracer prints the path of a message through the configured plugins.
+// Messages are processed synchronously, so the nesting depth of the output
+// reflects the routing hierarchy.
+type replayTracer struct {
+	out   io.Writer
+	depth int
+}
+
+// replayRouter wraps a configured router so that its filters are traced.
+// Routers are registered with core.StreamRegistry in place of the original,
+// so that routers forwarding to other streams are traced, too.
+type replayRouter struct {
+	core.Router
+	id       string
+	typename string
+	filters  core.ModulatorArray
+	tracer   *replayTracer
+}
+
+// replayProducer stands in for a configured producer. It applies the
+// producer's modulators but never writes to the producer's sink.
+type replayProducer struct {
+	id       string
+	typename string
+	streams  []core.MessageStreamID
+	fallback string
+	mods     core.ModulatorArray
+	control  chan core.PluginControl
+	tracer   *replayTracer
+}
+
+// replayCommand implements "gollum -replay"
+func replayCommand(configFile string, inputFile string, consumerID string, out io.Writer) int {
+	config, err := core.ReadConfigFromFile(configFile)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to read config")
+		return tos.ExitError // ### return, invalid config ###
+	}
+	if err := config.Validate(); err != nil {
+		logrus.WithError(err).Error("Config validation failed")
+		return tos.ExitError // ### return, invalid config ###
+	}
+
+	consumerConfig, err := getReplayConsumer(config, consumerID)
+	if err != nil {
+		logrus.Error(err)
+		return tos.ExitError // ### return, no consumer ###
+	}
+
+	input := io.Reader(os.Stdin)
+	if inputFile != "" && inputFile != "-" {
+		file, err := os.Open(inputFile)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to open input")
+			return tos.ExitError // ### return, no input ###
+		}
+		defer file.Close()
+		input = file
+	}
+
+	tracer := &replayTracer{out: out}
+	consumerReader := core.NewPluginConfigReader(&consumerConfig)
+	streams := consumerReader.GetStreamArray("Streams", []core.MessageStreamID{})
+	modulators := consumerReader.GetModulatorArray("Modulators", logrus.WithField("PluginID", consumerConfig.ID), core.ModulatorArray{})
+	if consumerReader.Errors.Len() > 0 {
+		logrus.WithError(consumerReader.Errors.OrNil()).Errorf("Failed to configure consumer '%s'", consumerConfig.ID)
+		return tos.ExitError // ### return, invalid consumer ###
+	}
+
+	if err := tracer.configure(config, streams); err != nil {
+		logrus.WithError(err).Error("Config validation failed")
+		return tos.ExitError // ### return, invalid config ###
+	}
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for count := 1; scanner.Scan(); count++ {
+		fmt.Fprintf(out, "Message %d: %q\n", count, scanner.Text())
+		tracer.enqueue(consumerConfig, streams, modulators, scanner.Bytes())
+		fmt.Fprintln(out)
+	}
+
+	if err := scanner.Err(); err != nil {
+		logrus.WithError(err).Error("Failed to read input")
+		return tos.ExitError
+	}
+	return tos.ExitSuccess
+}
+
+// getReplayConsumer returns the consumer with the given ID. If no ID is given
+// and exactly one consumer is configured, this consumer is returned.
+func getReplayConsumer(config *core.Config, consumerID string) (core.PluginConfig, error) {
+	consumers := config.GetConsumers()
+	names := make([]string, 0, len(consumers))
+
+	for _, consumer := range consumers {
+		if consumer.ID == consumerID || (consumerID == "" && len(consumers) == 1) {
+			return consumer, nil
+		}
+		names = append(names, consumer.ID)
+	}
+
+	if consumerID == "" {
+		return core.PluginConfig{}, fmt.Errorf("Please select a consumer with -consumer. Available consumers: %s", strings.Join(names, ", "))
+	}
+	return core.PluginConfig{}, fmt.Errorf("Consumer '%s' not found. Available consumers: %s", consumerID, strings.Join(names, ", "))
+}
+
+// configure instantiates all routers and registers stand-ins for all
+// producers in the same way the coordinator does.
+func (tracer *replayTracer) configure(config *core.Config, consumerStreams []core.MessageStreamID) error {
+	routers := []*replayRouter{}
+	targetStreams := []core.MessageStreamID{}
+	for _, routerConfig := range config.GetRouters() {
+		plugin, err := core.NewPluginWithConfig(routerConfig)
+		if err != nil {
+			return fmt.Errorf("Failed to instantiate router '%s': %s", routerConfig.ID, err)
+		}
+
+		router := plugin.(core.Router)
+		reader := core.NewPluginConfigReader(&routerConfig)
+		filters := reader.GetFilterArray("Filters", logrus.WithField("PluginID", routerConfig.ID), core.FilterArray{})
+
+		wrapper := &replayRouter{
+			Router:   router,
+			id:       routerConfig.ID,
+			typename: routerConfig.Typename,
+			tracer:   tracer,
+		}
+		for _, filter := range filters {
+			wrapper.filters = append(wrapper.filters, core.NewFilterModulator(filter))
+		}
+		routers = append(routers, wrapper)
+		core.StreamRegistry.Register(wrapper, router.GetStreamID())
+		targetStreams = append(targetStreams, reader.GetStreamArray("TargetStreams", []core.MessageStreamID{})...)
+	}
+
+	for _, streamID := range targetStreams {
+		tracer.ensureRouter(streamID)
+	}
+
+	producers := []*replayProducer{}
+	for _, producerConfig := range config.GetProducers() {
+		reader := core.NewPluginConfigReader(&producerConfig)
+		producer := &replayProducer{
+			id:       producerConfig.ID,
+			typename: producerConfig.Typename,
+			streams:  reader.GetStreamArray("Streams", []core.MessageStreamID{}),
+			fallback: reader.GetString("FallbackStream", ""),
+			mods:     reader.GetModulatorArray("Modulators", logrus.WithField("PluginID", producerConfig.ID), core.ModulatorArray{}),
+			control:  make(chan core.PluginControl, 1),
+			tracer:   tracer,
+		}
+		if reader.Errors.Len() > 0 {
+			return fmt.Errorf("Failed to configure producer '%s': %s", producerConfig.ID, reader.Errors.OrNil())
+		}
+		producers = append(producers, producer)
+
+		for _, streamID := range producer.streams {
+			tracer.ensureRouter(streamID)
+		}
+		if producer.fallback != "" {
+			tracer.ensureRouter(core.StreamRegistry.GetStreamID(producer.fallback))
+		}
+	}
+
+	for _, streamID := range consumerStreams {
+		tracer.ensureRouter(streamID)
+	}
+
+	for _, producer := range producers {
+		for _, streamID := range producer.streams {
+			if streamID == core.WildcardStreamID {
+				core.StreamRegistry.RegisterWildcardProducer(producer)
+			} else {
+				core.StreamRegistry.GetRouterOrFallback(streamID).AddProducer(producer)
+			}
+		}
+	}
+	core.StreamRegistry.AddAllWildcardProducersToAllRouters()
+
+	for _, router := range routers {
+		if err := router.Start(); err != nil {
+			return fmt.Errorf("Failed to start router '%s': %s", router.id, err)
+		}
+	}
+	return nil
+}
+
+// ensureRouter registers a traced default router for streams that do not
+// have a router configured.
+func (tracer *replayTracer) ensureRouter(streamID core.MessageStreamID) {
+	if streamID == core.WildcardStreamID || core.StreamRegistry.IsStreamRegistered(streamID) {
+		return // ### return, nothing to do ###
+	}
+
+	streamName := core.StreamRegistry.GetStreamName(streamID)
+	config := core.NewPluginConfig(core.GeneratedRouterPrefix+streamName, "router.Broadcast")
+	config.Override("stream", streamName)
+
+	plugin, err := core.NewPluginWithConfig(config)
+	if err != nil {
+		panic(err) // this has to always work, see core.StreamRegistry
+	}
+
+	core.StreamRegistry.Register(&replayRouter{
+		Router:   plugin.(core.Router),
+		id:       config.ID,
+		typename: config.Typename,
+		tracer:   tracer,
+	}, streamID)
+}
+
+// enqueue mimics core.SimpleConsumer for the given consumer
+func (tracer *replayTracer) enqueue(config core.PluginConfig, streams []core.MessageStreamID, modulators core.ModulatorArray, data []byte) {
+	msg := core.NewMessage(nil, data, nil, core.InvalidStreamID)
+
+	tracer.printf("consumer %s (%s)", config.ID, config.Typename)
+	tracer.depth++
+	defer func() { tracer.depth-- }()
+
+	switch tracer.modulate(modulators, msg) {
+	case core.ModulateResultDiscard:
+		return
+
+	case core.ModulateResultFallback:
+		tracer.printError(core.RouteOriginal(msg, msg.GetRouter()))
+		return
+	}
+
+	for idx, streamID := range streams {
+		streamMsg := msg
+		if idx < len(streams)-1 {
+			streamMsg = msg.Clone()
+		}
+		streamMsg.SetStreamID(streamID)
+		streamMsg.FreezeOriginal()
+		tracer.printError(core.Route(streamMsg, core.StreamRegistry.GetRouterOrFallback(streamID)))
+	}
+}
+
+// modulate applies all modulators one by one and prints the result of each
+// step.
+func (tracer *replayTracer) modulate(modulators core.ModulatorArray, msg *core.Message) core.ModulateResult {
+	for _, modulator := range modulators {
+		result := modulator.Modulate(msg)
+
+		switch result {
+		case core.ModulateResultDiscard:
+			tracer.printf("%s: discarded", getReplayModulatorName(modulator))
+			return result
+
+		case core.ModulateResultFallback:
+			tracer.printf("%s: fallback to stream %s", getReplayModulatorName(modulator), core.StreamRegistry.GetStreamName(msg.GetStreamID()))
+			return result
+
+		default:
+			tracer.printf("%s: %q %s", getReplayModulatorName(modulator), msg.String(), getReplayMetadata(msg))
+		}
+	}
+	return core.ModulateResultContinue
+}
+
+func (tracer *replayTracer) printError(err error) {
+	if err != nil {
+		tracer.printf("error: %s", err)
+	}
+}
+
+func (tracer *replayTracer) printf(format string, args ...interface{}) {
+	fmt.Fprintf(tracer.out, "%s%s\n", strings.Repeat("  ", tracer.depth+1), fmt.Sprintf(format, args...))
+}
+
+// Modulate traces the router's filters
+func (router *replayRouter) Modulate(msg *core.Message) core.ModulateResult {
+	router.tracer.printf("stream %s: router %s (%s)", core.StreamRegistry.GetStreamName(router.GetStreamID()), router.id, router.typename)
+	router.tracer.depth++
+	defer func() { router.tracer.depth-- }()
+
+	return router.tracer.modulate(router.filters, msg)
+}
+
+// Enqueue forwards the message to the configured router
+func (router *replayRouter) Enqueue(msg *core.Message) error {
+	router.tracer.depth++
+	defer func() { router.tracer.depth-- }()
+
+	return router.Router.Enqueue(msg)
+}
+
+// Configure is not used as the producer is never instantiated
+func (prod *replayProducer) Configure(conf core.PluginConfigReader) {
+}
+
+// GetState always returns active
+func (prod *replayProducer) GetState() core.PluginState {
+	return core.PluginStateActive
+}
+
+// IsActive always returns true
+func (prod *replayProducer) IsActive() bool {
+	return true
+}
+
+// IsBlocked always returns false
+func (prod *replayProducer) IsBlocked() bool {
+	return false
+}
+
+// Enqueue applies the producer's modulators and prints the result
+func (prod *replayProducer) Enqueue(msg *core.Message, timeout time.Duration) {
+	prod.tracer.printf("producer %s (%s)", prod.id, prod.typename)
+	prod.tracer.depth++
+	defer func() { prod.tracer.depth-- }()
+
+	switch prod.tracer.modulate(prod.mods, msg) {
+	case core.ModulateResultDiscard:
+		return
+
+	case core.ModulateResultFallback:
+		prod.tracer.printError(core.RouteOriginal(msg, msg.GetRouter()))
+		return
+	}
+
+	if prod.fallback != "" {
+		prod.tracer.printf("sent (fallback stream %s)", prod.fallback)
+	} else {
+		prod.tracer.printf("sent")
+	}
+}
+
+// Produce does nothing, nothing is sent during a replay
+func (prod *replayProducer) Produce(workers *sync.WaitGroup) {
+}
+
+// Streams returns the streams configured for the producer
+func (prod *replayProducer) Streams() []core.MessageStreamID {
+	return prod.streams
+}
+
+// Control returns an unused control channel
+func (prod *replayProducer) Control() chan<- core.PluginControl {
+	return prod.control
+}
+
+// GetShutdownTimeout returns 0 as there is nothing to shut down
+func (prod *replayProducer) GetShutdownTimeout() time.Duration {
+	return 0
+}
+
+// getReplayModulatorName returns the type name of a modulator, filter or
+// formatter as used in the config.
+func getReplayModulatorName(modulator core.Modulator) string {
+	var plugin interface{} = modulator
+	switch wrapper := modulator.(type) {
+	case *core.FilterModulator:
+		plugin = wrapper.Filter
+	case *core.FormatterModulator:
+		plugin = wrapper.Formatter
+	}
+
+	pluginType := reflect.TypeOf(plugin)
+	if pluginType.Kind() == reflect.Ptr {
+		pluginType = pluginType.Elem()
+	}
+	return pluginType.String()
+}
+
+// getReplayMetadata returns the metadata of a message as JSON
+func getReplayMetadata(msg *core.Message) string {
+	metadata := make(map[string]string, len(msg.GetMetadata()))
+	for key, value := range msg.GetMetadata() {
+		metadata[key] = string(value)
+	}
+
+	data, _ := json.Marshal(metadata)
+	return string(data)
+}
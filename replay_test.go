@@ -0,0 +1,104 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/trivago/tgo/tos"
+	"github.com/trivago/tgo/ttesting"
+)
+
+const replayTestConfig = `
+StdIn:
+  Type: consumer.Console
+  Streams: input
+  Modulators:
+    - filter.RegExp:
+        Expression: "^keep"
+        FilteredStream: rejected
+    - format.Envelope:
+        Prefix: "["
+        Postfix: "]"
+Out:
+  Type: producer.File
+  Streams: input
+  File: {{path}}/out.log
+  Modulators:
+    - filter.RegExp:
+        ExpressionNot: "secret"
+    - format.Base64Encode
+Rejected:
+  Type: producer.File
+  Streams: rejected
+  File: {{path}}/rejected.log
+`
+
+func TestReplayCommand(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	path, err := ioutil.TempDir("", "gollum-replay")
+	expect.NoError(err)
+	defer os.RemoveAll(path)
+
+	configFile := filepath.Join(path, "config.yaml")
+	config := strings.Replace(replayTestConfig, "{{path}}", path, -1)
+	expect.NoError(ioutil.WriteFile(configFile, []byte(config), 0600))
+
+	inputFile := filepath.Join(path, "input.txt")
+	expect.NoError(ioutil.WriteFile(inputFile, []byte("keep me\ndrop me\nkeep secret\n"), 0600))
+
+	out := bytes.NewBuffer(nil)
+	expect.Equal(tos.ExitSuccess, replayCommand(configFile, inputFile, "", out))
+
+	expected := `Message 1: "keep me"
+  consumer StdIn (consumer.Console)
+    filter.RegExp: "keep me" {}
+    format.Envelope: "[keep me]" {}
+    stream input: router _GENERATED_input (router.Broadcast)
+      producer Out (producer.File)
+        filter.RegExp: "[keep me]" {}
+        format.Base64Encode: "W2tlZXAgbWVd" {}
+        sent
+
+Message 2: "drop me"
+  consumer StdIn (consumer.Console)
+    filter.RegExp: fallback to stream rejected
+    stream rejected: router _GENERATED_rejected (router.Broadcast)
+      producer Rejected (producer.File)
+        sent
+
+Message 3: "keep secret"
+  consumer StdIn (consumer.Console)
+    filter.RegExp: "keep secret" {}
+    format.Envelope: "[keep secret]" {}
+    stream input: router _GENERATED_input (router.Broadcast)
+      producer Out (producer.File)
+        filter.RegExp: discarded
+
+`
+	expect.Equal(expected, out.String())
+
+	// Producers are never started, i.e. nothing is written
+	_, err = os.Stat(filepath.Join(path, "out.log"))
+	expect.True(os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(path, "rejected.log"))
+	expect.True(os.IsNotExist(err))
+}
@@ -70,6 +70,22 @@ func (router *Distribute) Start() error {
 	return nil
 }
 
+// IsSaturated returns true if one of the target streams is saturated
+func (router *Distribute) IsSaturated() bool {
+	for _, targetRouter := range router.routers {
+		if router.GetStreamID() == targetRouter.GetStreamID() {
+			if router.Broadcast.IsSaturated() {
+				return true
+			}
+			continue // ### continue, own producers ###
+		}
+		if source, isSource := targetRouter.(core.BackpressureSource); isSource && source.IsSaturated() {
+			return true
+		}
+	}
+	return false
+}
+
 func (router *Distribute) route(msg *core.Message, targetRouter core.Router) {
 	if router.GetStreamID() == targetRouter.GetStreamID() {
 		router.Broadcast.Enqueue(msg)
@@ -0,0 +1,288 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/gollum/producer/spooling"
+	"github.com/trivago/tgo/tos"
+)
+
+const spoolUsage = `Usage: gollum spool COMMAND [OPTIONS] [FILES]
+
+Inspect and replay files written by producer.Spooling.
+If no files are given, all files below -path are used.
+
+Commands:
+  list    List spool files with size and modification time.
+  count   Print the number of files, messages, corrupt records and bytes per stream.
+  dump    Print all spooled messages as JSON, one message per line.
+  replay  Rewrite spooled messages so that they are respooled into -stream.
+          Files of -stream are skipped. Replay is refused if any file is
+          read or written by a running producer.
+
+Options:`
+
+// spoolDumpEntry is the JSON representation of a message printed by
+// "gollum spool dump"
+type spoolDumpEntry struct {
+	File     string            `json:"file"`
+	Stream   string            `json:"stream"`
+	Time     string            `json:"time"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Payload  string            `json:"payload"`
+}
+
+// spoolCommand implements the "gollum spool" subcommand
+func spoolCommand(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("spool", flag.ContinueOnError)
+	flags.SetOutput(out)
+	path := flags.String("path", "/var/run/gollum/spooling", "Spooling path as configured for producer.Spooling.")
+	stream := flags.String("stream", "", "Only use files of this stream. Required for replay, where it defines the target stream.")
+	from := flags.String("from", "", "Only replay files of this stream.")
+	keep := flags.Bool("keep", false, "Do not remove files after replaying them.")
+	bufferSize := flags.Int("buffer", 8192, "Initial read buffer size in bytes.")
+	strict := flags.Bool("strict", false, "Stop at the first corrupt record instead of skipping it.")
+	flags.Usage = func() {
+		fmt.Fprintln(out, spoolUsage)
+		flags.PrintDefaults()
+	}
+
+	if len(args) == 0 {
+		flags.Usage()
+		return tos.ExitError // ### return, no command ###
+	}
+
+	command := args[0]
+	if err := flags.Parse(args[1:]); err != nil {
+		return tos.ExitError // ### return, invalid flags ###
+	}
+
+	filter := *stream
+	switch command {
+	case "list", "count", "dump":
+	case "replay":
+		filter = *from
+	default:
+		flags.Usage()
+		return tos.ExitError // ### return, unknown command ###
+	}
+
+	files, err := getSpoolFiles(*path, filter, flags.Args())
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return tos.ExitError // ### return, cannot list files ###
+	}
+
+	switch command {
+	case "list":
+		for _, file := range files {
+			fmt.Fprintf(out, "%s\t%d\t%s\n", file.Path, file.Size, file.Modified.Format(time.RFC3339))
+		}
+
+	case "count":
+		err = countSpoolFiles(files, *bufferSize, !*strict, out)
+
+	case "dump":
+		err = dumpSpoolFiles(files, *bufferSize, !*strict, out)
+
+	case "replay":
+		if *stream == "" {
+			fmt.Fprintln(out, "replay requires -stream")
+			return tos.ExitError // ### return, no target ###
+		}
+		err = replaySpoolFiles(files, *path, *stream, *keep, *bufferSize, !*strict, out)
+	}
+
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return tos.ExitError
+	}
+	return tos.ExitSuccess
+}
+
+// getSpoolFiles returns the given files or all files of the given stream
+// (or all streams) below path.
+func getSpoolFiles(path string, stream string, fileNames []string) ([]spooling.FileInfo, error) {
+	if len(fileNames) == 0 {
+		if stream == "" {
+			return spooling.ListFiles(path)
+		}
+		return spooling.ListFiles(path, stream)
+	}
+
+	files := make([]spooling.FileInfo, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		stat, err := os.Stat(fileName)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, spooling.FileInfo{
+			Path:     fileName,
+			Stream:   filepath.Base(filepath.Dir(fileName)),
+			Size:     stat.Size(),
+			Modified: stat.ModTime(),
+		})
+	}
+	return files, nil
+}
+
+func countSpoolFiles(files []spooling.FileInfo, bufferSize int, recoverCorrupt bool, out io.Writer) error {
+	type streamCount struct {
+		files, messages, corrupt int
+		bytes                    int64
+	}
+
+	counts := make(map[string]*streamCount)
+	streams := []string{}
+	total := streamCount{}
+
+	for _, file := range files {
+		count, exists := counts[file.Stream]
+		if !exists {
+			count = &streamCount{}
+			counts[file.Stream] = count
+			streams = append(streams, file.Stream)
+		}
+
+		messages := 0
+		corrupt, err := spooling.ReadFile(file.Path, bufferSize, recoverCorrupt,
+			func(*core.Message) { messages++ })
+		if err != nil {
+			return fmt.Errorf("%s: %s", file.Path, err)
+		}
+
+		count.files++
+		count.messages += messages
+		count.corrupt += corrupt
+		count.bytes += file.Size
+		total.files++
+		total.messages += messages
+		total.corrupt += corrupt
+		total.bytes += file.Size
+	}
+
+	fmt.Fprintf(out, "%-24s %8s %10s %8s %12s\n", "STREAM", "FILES", "MESSAGES", "CORRUPT", "BYTES")
+	for _, stream := range streams {
+		count := counts[stream]
+		fmt.Fprintf(out, "%-24s %8d %10d %8d %12d\n", stream, count.files, count.messages, count.corrupt, count.bytes)
+	}
+	fmt.Fprintf(out, "%-24s %8d %10d %8d %12d\n", "TOTAL", total.files, total.messages, total.corrupt, total.bytes)
+	return nil
+}
+
+func dumpSpoolFiles(files []spooling.FileInfo, bufferSize int, recoverCorrupt bool, out io.Writer) error {
+	encoder := json.NewEncoder(out)
+	for _, file := range files {
+		var writeErr error
+		corrupt, err := spooling.ReadFile(file.Path, bufferSize, recoverCorrupt,
+			func(msg *core.Message) {
+				entry := spoolDumpEntry{
+					File:    file.Path,
+					Stream:  file.Stream,
+					Time:    msg.GetCreationTime().Format(time.RFC3339Nano),
+					Payload: string(msg.GetPayload()),
+				}
+				if metadata := msg.GetMetadata(); len(metadata) > 0 {
+					entry.Metadata = make(map[string]string, len(metadata))
+					for key, value := range metadata {
+						entry.Metadata[key] = string(value)
+					}
+				}
+				if writeErr == nil {
+					writeErr = encoder.Encode(entry)
+				}
+			})
+
+		if corrupt > 0 {
+			fmt.Fprintf(out, "%s: skipped %d corrupt records\n", file.Path, corrupt)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", file.Path, err)
+		}
+		if writeErr != nil {
+			return writeErr
+		}
+	}
+	return nil
+}
+
+func replaySpoolFiles(files []spooling.FileInfo, path string, stream string, keep bool, bufferSize int, recoverCorrupt bool, out io.Writer) error {
+	streamID := core.GetStreamID(stream)
+
+	// Lock all files before changing anything so that files in use by a
+	// running producer are not replayed partially.
+	replayFiles := make([]spooling.FileInfo, 0, len(files))
+	handles := make([]*os.File, 0, len(files))
+	defer func() {
+		for _, handle := range handles {
+			handle.Close()
+		}
+	}()
+
+	for _, file := range files {
+		if file.Stream == stream {
+			fmt.Fprintf(out, "%s: skipped, already spooled for %s\n", file.Path, stream)
+			continue // ### continue, would replay into itself ###
+		}
+
+		handle, err := os.Open(file.Path)
+		if err != nil {
+			return err
+		}
+		handles = append(handles, handle)
+
+		if err := spooling.LockFile(handle); err != nil {
+			return fmt.Errorf("%s: %s", file.Path, err)
+		}
+		replayFiles = append(replayFiles, file)
+	}
+
+	for i, file := range replayFiles {
+		messages := []*core.Message{}
+		corrupt, err := spooling.Read(handles[i], bufferSize, recoverCorrupt,
+			func(msg *core.Message) {
+				spooling.SetOrigin(msg, streamID)
+				messages = append(messages, msg)
+			})
+		if corrupt > 0 {
+			fmt.Fprintf(out, "%s: skipped %d corrupt records\n", file.Path, corrupt)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", file.Path, err)
+		}
+
+		target, err := spooling.WriteFile(path, stream, messages)
+		if err != nil {
+			return err
+		}
+
+		if !keep {
+			if err := os.Remove(file.Path); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(out, "%s -> %s (%d messages)\n", file.Path, target, len(messages))
+	}
+	return nil
+}
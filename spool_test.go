@@ -0,0 +1,204 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/gollum/producer/spooling"
+	"github.com/trivago/tgo/tos"
+	"github.com/trivago/tgo/ttesting"
+)
+
+// newTestSpool writes two files for stream "spoolA" and one file for stream
+// "spoolB". The second file of "spoolA" ends with a corrupt record.
+func newTestSpool(t *testing.T) string {
+	expect := ttesting.NewExpect(t)
+
+	path, err := ioutil.TempDir("", "gollum-spool")
+	expect.NoError(err)
+
+	writeMessages := func(stream string, payloads ...string) string {
+		streamID := core.GetStreamID(stream)
+		messages := []*core.Message{}
+		for _, payload := range payloads {
+			msg := core.NewMessage(nil, []byte(payload), nil, streamID)
+			msg.GetMetadata().SetValue("source", []byte(stream))
+			messages = append(messages, msg)
+		}
+		fileName, err := spooling.WriteFile(path, stream, messages)
+		expect.NoError(err)
+		return fileName
+	}
+
+	writeMessages("spoolA", "a1", "a2")
+	corruptFile := writeMessages("spoolA", "a3")
+	writeMessages("spoolB", "b1")
+
+	file, err := os.OpenFile(corruptFile, os.O_APPEND|os.O_WRONLY, 0)
+	expect.NoError(err)
+	_, err = file.Write([]byte("not a message\n"))
+	expect.NoError(err)
+	expect.NoError(file.Close())
+
+	return path
+}
+
+func runTestSpoolCommand(args ...string) (int, string) {
+	out := bytes.NewBuffer(nil)
+	result := spoolCommand(args, out)
+	return result, out.String()
+}
+
+func TestSpoolCount(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	path := newTestSpool(t)
+	defer os.RemoveAll(path)
+
+	result, out := runTestSpoolCommand("count", "-path", path)
+	expect.Equal(tos.ExitSuccess, result)
+
+	counts := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n")[1:] {
+		fields := strings.Fields(line)
+		counts[fields[0]] = fields[1:4]
+	}
+	expect.Equal([]string{"2", "3", "1"}, counts["spoolA"])
+	expect.Equal([]string{"1", "1", "0"}, counts["spoolB"])
+	expect.Equal([]string{"3", "4", "1"}, counts["TOTAL"])
+
+	result, out = runTestSpoolCommand("count", "-path", path, "-stream", "spoolB")
+	expect.Equal(tos.ExitSuccess, result)
+	expect.False(strings.Contains(out, "spoolA"))
+
+	// Strict mode fails on the corrupt record
+	result, _ = runTestSpoolCommand("count", "-path", path, "-strict")
+	expect.Equal(tos.ExitError, result)
+}
+
+func TestSpoolDump(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	path := newTestSpool(t)
+	defer os.RemoveAll(path)
+
+	result, out := runTestSpoolCommand("dump", "-path", path, "-stream", "spoolA")
+	expect.Equal(tos.ExitSuccess, result)
+
+	payloads := []string{}
+	corruptNotices := 0
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if strings.HasSuffix(line, "skipped 1 corrupt records") {
+			corruptNotices++
+			continue
+		}
+		entry := spoolDumpEntry{}
+		expect.NoError(json.Unmarshal([]byte(line), &entry))
+		expect.Equal("spoolA", entry.Stream)
+		expect.Equal("spoolA", entry.Metadata["source"])
+		payloads = append(payloads, entry.Payload)
+	}
+	expect.Equal([]string{"a1", "a2", "a3"}, payloads)
+	expect.Equal(1, corruptNotices)
+}
+
+// readTestSpoolStream returns the payloads of all messages spooled for the
+// given stream and checks that they are routed to that stream.
+func readTestSpoolStream(t *testing.T, path string, stream string) []string {
+	expect := ttesting.NewExpect(t)
+
+	files, err := spooling.ListFiles(path, stream)
+	expect.NoError(err)
+
+	payloads := []string{}
+	for _, file := range files {
+		_, err := spooling.ReadFile(file.Path, 1024, true, func(msg *core.Message) {
+			expect.Equal(core.GetStreamID(stream), msg.GetStreamID())
+			payloads = append(payloads, msg.String())
+		})
+		expect.NoError(err)
+	}
+	return payloads
+}
+
+func TestSpoolReplay(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	for _, keep := range []bool{true, false} {
+		path := newTestSpool(t)
+		defer os.RemoveAll(path)
+
+		args := []string{"replay", "-path", path, "-from", "spoolA", "-stream", "spoolC"}
+		if keep {
+			args = append(args, "-keep")
+		}
+
+		result, out := runTestSpoolCommand(args...)
+		expect.Equal(tos.ExitSuccess, result)
+		expect.True(strings.Contains(out, "skipped 1 corrupt records"))
+		expect.Equal([]string{"a1", "a2", "a3"}, readTestSpoolStream(t, path, "spoolC"))
+
+		sources, err := spooling.ListFiles(path, "spoolA")
+		expect.NoError(err)
+		if keep {
+			expect.Equal(2, len(sources))
+		} else {
+			expect.Equal(0, len(sources))
+		}
+
+		// Other streams are not touched
+		expect.Equal([]string{"b1"}, readTestSpoolStream(t, path, "spoolB"))
+	}
+}
+
+func TestSpoolReplaySkipsTarget(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	path := newTestSpool(t)
+	defer os.RemoveAll(path)
+
+	targetFiles, err := spooling.ListFiles(path, "spoolA")
+	expect.NoError(err)
+
+	// Without -from all streams are replayed, except for the target itself
+	result, out := runTestSpoolCommand("replay", "-path", path, "-stream", "spoolA")
+	expect.Equal(tos.ExitSuccess, result)
+	for _, file := range targetFiles {
+		expect.True(strings.Contains(out, file.Path+": skipped, already spooled for spoolA"))
+	}
+
+	payloads := readTestSpoolStream(t, path, "spoolA")
+	expect.Equal([]string{"a1", "a2", "a3", "b1"}, payloads)
+
+	sources, err := spooling.ListFiles(path, "spoolB")
+	expect.NoError(err)
+	expect.Equal(0, len(sources))
+
+	// Files given explicitly are skipped, too
+	result, out = runTestSpoolCommand("replay", "-path", path, "-stream", "spoolA", targetFiles[0].Path)
+	expect.Equal(tos.ExitSuccess, result)
+	expect.True(strings.Contains(out, "skipped, already spooled"))
+	_, err = os.Stat(targetFiles[0].Path)
+	expect.NoError(err)
+
+	// The target stream is required
+	result, out = runTestSpoolCommand("replay", "-path", path)
+	expect.Equal(tos.ExitError, result)
+	expect.True(strings.Contains(out, "replay requires -stream"))
+}
@@ -0,0 +1,55 @@
+// Copyright 2015-2017 trivago GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/trivago/gollum/producer/spooling"
+	"github.com/trivago/tgo/tos"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestSpoolReplayLocked(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	path := newTestSpool(t)
+	defer os.RemoveAll(path)
+
+	files, err := spooling.ListFiles(path, "spoolB")
+	expect.NoError(err)
+
+	// Simulates a running producer reading the file
+	file, err := os.Open(files[0].Path)
+	expect.NoError(err)
+	defer file.Close()
+	expect.NoError(spooling.LockFile(file))
+
+	result, out := runTestSpoolCommand("replay", "-path", path, "-stream", "spoolC")
+	expect.Equal(tos.ExitError, result)
+	expect.True(strings.Contains(out, files[0].Path))
+
+	// Nothing has been replayed or removed
+	expect.Equal(0, len(readTestSpoolStream(t, path, "spoolC")))
+	remaining, err := spooling.ListFiles(path)
+	expect.NoError(err)
+	expect.Equal(3, len(remaining))
+	_, err = os.Stat(filepath.Join(path, "spoolA"))
+	expect.NoError(err)
+}